@@ -81,9 +81,10 @@ func commandServer(container *do.Injector) *cli.Command {
 		Action: func(c *cli.Context) error {
 			vs := do.MustInvokeNamed[map[string]string](container, "envs")
 			router, err := handler.New(&handler.Config{
-				Container: container,
-				Mode:      vs["API_MODE"],
-				Origins:   strings.Split(vs["API_ORIGINS"], ","),
+				Container:   container,
+				Mode:        vs["API_MODE"],
+				Origins:     strings.Split(vs["API_ORIGINS"], ","),
+				AdminAPIKey: vs["ADMIN_API_KEY"],
 			})
 			if err != nil {
 				fmt.Println("111")
@@ -127,6 +128,8 @@ func NewContainer(vs map[string]string) *do.Injector {
 	vs["API_MODE"] = os.Getenv("API_MODE")
 	vs["API_ORIGINS"] = os.Getenv("API_ORIGINS")
 	vs["TON_APP_DOMAIN"] = os.Getenv("TON_APP_DOMAIN")
+	vs["ADMIN_API_KEY"] = os.Getenv("ADMIN_API_KEY")
+	vs["QUESTION_TOKEN_SECRET"] = os.Getenv("QUESTION_TOKEN_SECRET")
 
 	if vs["API_MODE"] == "" {
 		vs["API_MODE"] = "production"
@@ -340,5 +343,53 @@ func NewContainer(vs map[string]string) *do.Injector {
 		return services.NewServiceArena(injector)
 	})
 
+	do.Provide(injector, func(i *do.Injector) (*services.ServiceIdentity, error) {
+		return services.NewServiceIdentity(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServiceRateLimit, error) {
+		return services.NewServiceRateLimit(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServiceIdempotency, error) {
+		return services.NewServiceIdempotency(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServiceAntiCheat, error) {
+		return services.NewServiceAntiCheat(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServiceModeration, error) {
+		return services.NewServiceModeration(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServiceSeason, error) {
+		return services.NewServiceSeason(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServicePrize, error) {
+		return services.NewServicePrize(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServiceReferral, error) {
+		return services.NewServiceReferral(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServiceKolCampaign, error) {
+		return services.NewServiceKolCampaign(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServiceDeepLink, error) {
+		return services.NewServiceDeepLink(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServicePartnerWebhook, error) {
+		return services.NewServicePartnerWebhook(injector)
+	})
+
+	do.Provide(injector, func(i *do.Injector) (*services.ServicePartnerStats, error) {
+		return services.NewServicePartnerStats(injector)
+	})
+
 	return injector
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+	"millionaire/internal/services"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func handleAntiCheatCommands(b *tele.Bot) {
+	b.Handle("/flags", commandAntiCheatFlags)
+	b.Handle("/flag", commandAntiCheatFlag)
+	b.Handle("/resolveflag", commandResolveAntiCheatFlag)
+}
+
+func commandAntiCheatFlags(c tele.Context) error {
+	if !AuthRequire(c, chatId) {
+		return nil
+	}
+
+	postgresDb, err := getContextPostgres(c)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	limit := 20
+	if len(c.Args()) >= 1 {
+		limit, _ = strconv.Atoi(c.Args()[0])
+		if limit <= 0 || limit > 100 {
+			limit = 20
+		}
+	}
+
+	flags, err := datastore.GetOpenAntiCheatFlags(context.Background(), postgresDb, limit)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	if len(flags) == 0 {
+		return c.Send("No open flags")
+	}
+
+	msg := "Open flags:\n"
+	for _, flag := range flags {
+		msg += fmt.Sprintf("- %s: score %d, %d signals, actions [%s]\n", flag.UserID, flag.Score, len(flag.Signals), strings.Join(flag.Actions, ","))
+	}
+
+	return c.Send(msg)
+}
+
+func commandAntiCheatFlag(c tele.Context) error {
+	if !AuthRequire(c, chatId) {
+		return nil
+	}
+
+	if len(c.Args()) < 1 {
+		return c.Send("Usage: /flag <userID>")
+	}
+
+	postgresDb, err := getContextPostgres(c)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	ctx := context.Background()
+	userID := c.Args()[0]
+
+	flag, err := datastore.GetLatestAntiCheatFlag(ctx, postgresDb, userID)
+	if err != nil {
+		return c.Send(fmt.Sprintf("No flag found for %s", userID))
+	}
+
+	msg := fmt.Sprintf("User %s\nStatus: %s\nScore: %d\nActions: [%s]\n", flag.UserID, flag.Status, flag.Score, strings.Join(flag.Actions, ","))
+	if flag.ResolvedAt != nil {
+		msg += fmt.Sprintf("Resolved by %s at %s: %s\n", flag.ResolvedBy, flag.ResolvedAt.Format("2006-01-02 15:04"), flag.Note)
+	}
+
+	msg += "Signals:\n"
+	for _, signal := range flag.Signals {
+		msg += fmt.Sprintf("- %s +%d %s (%s %s)\n", signal.Name, signal.Score, signal.Detail, signal.GameSlug, signal.At.Format("2006-01-02 15:04"))
+	}
+
+	events, err := datastore.GetUserSuspiciousEvents(ctx, postgresDb, userID, 10)
+	if err == nil && len(events) > 0 {
+		msg += "Recent events:\n"
+		for _, event := range events {
+			msg += fmt.Sprintf("- %s %s %s\n", event.CreatedAt.Format("2006-01-02 15:04"), event.Kind, event.GameSlug)
+		}
+	}
+
+	return c.Send(msg)
+}
+
+func commandResolveAntiCheatFlag(c tele.Context) error {
+	if !AuthRequire(c, chatId) {
+		return nil
+	}
+
+	if len(c.Args()) < 2 {
+		return c.Send("Usage: /resolveflag <userID> <clear|confirm> [note]")
+	}
+
+	postgresDb, err := getContextPostgres(c)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	dbRedis, err := getContextRedis(c)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	ctx := context.Background()
+	userID := c.Args()[0]
+	note := strings.Join(c.Args()[2:], " ")
+
+	status := ""
+	switch c.Args()[1] {
+	case "clear":
+		status = models.AntiCheatFlagStatusCleared
+	case "confirm":
+		status = models.AntiCheatFlagStatusConfirmed
+	default:
+		return c.Send("Resolution must be clear or confirm")
+	}
+
+	resolved, err := datastore.ResolveAntiCheatFlag(ctx, postgresDb, userID, status, c.Sender().Username, note)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	if resolved == 0 {
+		return c.Send(fmt.Sprintf("No open flag for %s", userID))
+	}
+
+	if status == models.AntiCheatFlagStatusCleared {
+		for _, action := range models.AntiCheatActions {
+			if err := redis_store.RemoveAntiCheatRestriction(ctx, dbRedis, action, userID); err != nil {
+				return c.Send(fmt.Sprintf("error %s", err.Error()))
+			}
+		}
+
+		if err := services.RestoreUserLeaderboards(ctx, postgresDb, dbRedis, userID); err != nil {
+			return c.Send(fmt.Sprintf("error %s", err.Error()))
+		}
+
+		return c.Send(fmt.Sprintf("Flag of %s cleared, restrictions lifted", userID))
+	}
+
+	for _, action := range []string{models.AntiCheatActionExcludeLeaderboard, models.AntiCheatActionHoldRewards} {
+		if err := redis_store.AddAntiCheatRestriction(ctx, dbRedis, action, userID); err != nil {
+			return c.Send(fmt.Sprintf("error %s", err.Error()))
+		}
+	}
+
+	if err := services.RemoveUserFromLeaderboards(ctx, postgresDb, dbRedis, userID); err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	return c.Send(fmt.Sprintf("Flag of %s confirmed, user removed from leaderboards and rewards held", userID))
+}
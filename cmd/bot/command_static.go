@@ -45,6 +45,11 @@ func commandList(c tele.Context) error {
 /kol <username> <ref link> - Add ref link for KOL
 /stats - Get total users
 /kolstats <all/kol> [limit] - Get top invited KOLs
+/flags [limit] - List open anti-cheat flags
+/flag <userID> - Show the anti-cheat flag of a user
+/resolveflag <userID> <clear/confirm> [note] - Resolve an anti-cheat flag
+/moderate <userID> <active/shadow_banned/banned> [hours] [reason] - Change the moderation status of a user
+/moderation <userID> - Show the moderation status and history of a user
 `)
 }
 
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/services"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func handleKolCampaignCommands(b *tele.Bot) {
+	b.Handle("/kolcampaigns", commandKolCampaigns)
+	b.Handle("/kolcampaign", commandKolCampaignStats)
+}
+
+func commandKolCampaigns(c tele.Context) error {
+	if !AuthRequire(c, chatId) {
+		return nil
+	}
+
+	postgresDb, err := getContextPostgres(c)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	campaigns, err := datastore.GetKolCampaigns(context.Background(), postgresDb)
+	if err != nil {
+		return c.Send("Error when get KOL campaigns: " + err.Error())
+	}
+
+	if len(campaigns) == 0 {
+		return c.Send("No KOL campaign")
+	}
+
+	now := time.Now()
+	msg := "KOL campaigns:"
+	for _, campaign := range campaigns {
+		status := "inactive"
+		if campaign.IsActive(now) {
+			status = "active"
+		}
+		msg += fmt.Sprintf("\n- %s (%s) owner: %s, spent: %d/%d", campaign.Slug, status, campaign.OwnerID, campaign.Spent, campaign.Budget)
+	}
+
+	return c.Send(msg)
+}
+
+func commandKolCampaignStats(c tele.Context) error {
+	if !AuthRequire(c, chatId) {
+		return nil
+	}
+
+	if len(c.Args()) < 1 {
+		return c.Send("Usage: /kolcampaign <slug> [from yyyy-mm-dd] [to yyyy-mm-dd]")
+	}
+
+	var from, to *time.Time
+	for i, arg := range c.Args()[1:] {
+		t, err := time.Parse("2006-01-02", arg)
+		if err != nil {
+			return c.Send("Dates must be yyyy-mm-dd")
+		}
+		if i == 0 {
+			from = &t
+		} else {
+			to = &t
+		}
+	}
+
+	postgresDb, err := getContextPostgres(c)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	ctx := context.Background()
+	campaign, err := datastore.GetKolCampaignBySlug(ctx, postgresDb, c.Args()[0])
+	if err == sql.ErrNoRows {
+		return c.Send("Campaign not found")
+	}
+	if err != nil {
+		return c.Send("Error when get KOL campaign: " + err.Error())
+	}
+
+	stats, err := services.GetKolCampaignStats(ctx, postgresDb, campaign, from, to)
+	if err != nil {
+		return c.Send("Error when get KOL campaign stats: " + err.Error())
+	}
+
+	msg := fmt.Sprintf("Campaign %s from %s to %s\nJoins: %d\nActivated: %d\nPending: %d\nRejected: %d\nGems earned by joiners: %d\nBonus spent: %d/%d",
+		campaign.Slug, stats.From.Format("2006-01-02"), stats.To.Format("2006-01-02"),
+		stats.Joins, stats.Activated, stats.Pending, stats.Rejected, stats.Gems, campaign.Spent, campaign.Budget)
+
+	content, err := services.KolCampaignStatsCSV(stats)
+	if err != nil {
+		return c.Send(msg)
+	}
+
+	return c.Send(&tele.Document{
+		File:     tele.FromReader(bytes.NewReader(content)),
+		FileName: campaign.Slug + ".csv",
+		Caption:  msg,
+	})
+}
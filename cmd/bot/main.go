@@ -413,6 +413,15 @@ func action(c *cli.Context) error {
 	b.Handle("/list_task", commandGetAllTaskSlug)
 	b.Handle("/task", commandGetTaskCount)
 
+	// anti-cheat review queue
+	handleAntiCheatCommands(b)
+
+	// moderation
+	handleModerationCommands(b)
+
+	// KOL campaigns
+	handleKolCampaignCommands(b)
+
 	b.Start()
 
 	return nil
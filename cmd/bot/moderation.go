@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/models"
+	"millionaire/internal/services"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func handleModerationCommands(b *tele.Bot) {
+	b.Handle("/moderate", commandModerateUser)
+	b.Handle("/moderation", commandGetUserModeration)
+}
+
+func commandModerateUser(c tele.Context) error {
+	if !AuthRequire(c, chatId) {
+		return nil
+	}
+
+	if len(c.Args()) < 2 {
+		return c.Send("Usage: /moderate <userID> <active|shadow_banned|banned> [hours] [reason]")
+	}
+
+	userID := c.Args()[0]
+	status := c.Args()[1]
+	if !slices.Contains(models.ModerationStatuses, status) {
+		return c.Send("Status must be active, shadow_banned or banned")
+	}
+
+	reasonArgs := c.Args()[2:]
+	moderation := &models.UserModeration{
+		UserID:   userID,
+		Status:   status,
+		Operator: fmt.Sprintf("bot:%s", c.Sender().Username),
+	}
+
+	if len(reasonArgs) > 0 {
+		if hours, err := strconv.Atoi(reasonArgs[0]); err == nil {
+			reasonArgs = reasonArgs[1:]
+			if hours > 0 && status != models.ModerationStatusActive {
+				expiresAt := time.Now().Add(time.Duration(hours) * time.Hour)
+				moderation.ExpiresAt = &expiresAt
+			}
+		}
+	}
+	moderation.Reason = strings.Join(reasonArgs, " ")
+
+	postgresDb, err := getContextPostgres(c)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	dbRedis, err := getContextRedis(c)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	dbRedisCache, err := getContextRedisCache(c)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	ctx := context.Background()
+	if err := services.ModerateUser(ctx, postgresDb, dbRedis, moderation); err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	dbRedisCache.Del(ctx, services.DBKeyUser(userID), services.DBKeyMe(userID))
+
+	msg := fmt.Sprintf("User %s: %s -> %s", userID, moderation.PreviousStatus, moderation.Status)
+	if moderation.ExpiresAt != nil {
+		msg += fmt.Sprintf(" until %s", moderation.ExpiresAt.Format("2006-01-02 15:04"))
+	}
+
+	return c.Send(msg)
+}
+
+func commandGetUserModeration(c tele.Context) error {
+	if !AuthRequire(c, chatId) {
+		return nil
+	}
+
+	if len(c.Args()) < 1 {
+		return c.Send("Usage: /moderation <userID>")
+	}
+
+	postgresDb, err := getContextPostgres(c)
+	if err != nil {
+		return c.Send(fmt.Sprintf("error %s", err.Error()))
+	}
+
+	ctx := context.Background()
+	userID := c.Args()[0]
+
+	user, err := datastore.FindUserByID(ctx, postgresDb, userID)
+	if err != nil {
+		return c.Send(fmt.Sprintf("User %s not found", userID))
+	}
+
+	msg := fmt.Sprintf("User %s\nStatus: %s\n", user.ID, user.EffectiveModerationStatus())
+	if user.EffectiveModerationStatus() != models.ModerationStatusActive {
+		msg += fmt.Sprintf("Reason: %s\n", user.ModerationReason)
+		if user.ModerationExpiresAt != nil {
+			msg += fmt.Sprintf("Expires at: %s\n", user.ModerationExpiresAt.Format("2006-01-02 15:04"))
+		}
+	}
+
+	history, err := datastore.GetUserModerations(ctx, postgresDb, userID, services.MODERATION_HISTORY_LIMIT)
+	if err == nil && len(history) > 0 {
+		msg += "History:\n"
+		for _, moderation := range history {
+			msg += fmt.Sprintf("- %s %s -> %s by %s: %s\n", moderation.CreatedAt.Format("2006-01-02 15:04"), moderation.PreviousStatus, moderation.Status, moderation.Operator, moderation.Reason)
+		}
+	}
+
+	return c.Send(msg)
+}
@@ -7,7 +7,6 @@ import (
 	"millionaire/internal/datastore"
 	"millionaire/internal/datastore/redis_store"
 	"millionaire/internal/models"
-	"millionaire/internal/pkg"
 	"millionaire/internal/services"
 	"time"
 
@@ -42,18 +41,47 @@ func (j *LeaderboardJob) Start(cronRunner *cron.Cron) {
 
 	_, err = cronRunner.AddFunc(timeline.Value, j.runScheduledTask)
 	log.Println("Leaderboard Cronjob start at:", time.Now().Format("2006-01-02 15:04:05"), "cron:", timeline.Value, err)
+
+	// the season is also rolled over right at its configured end
+	cronRunner.Schedule(&seasonSchedule{j.Db}, cron.FuncJob(j.runScheduledTask))
+
+	j.rolloverSeason()
 	j.initLeaderboard()
 }
 
 func (j *LeaderboardJob) runScheduledTask() {
+	if j.rolloverSeason() {
+		// scores earned since the new season started are loaded back into the new sorted set
+		j.initLeaderboard()
+	}
+}
+
+// rolloverSeason archives the live season once it's over, it returns true when a season was archived
+func (j *LeaderboardJob) rolloverSeason() bool {
 	ctx := context.Background()
-	log.Println("Start cleaning weekly leaderboard ...")
-	err := redis_store.ClearLeaderboard(ctx, j.Redis, services.LEADERBOARD_OVERALL_WEEKLY)
+	cfg := services.LoadSeasonConfig(ctx, j.Db)
+
+	season, err := services.RolloverSeason(ctx, j.Db, j.Redis, services.LEADERBOARD_OVERALL_WEEKLY, cfg)
 	if err != nil {
-		fmt.Println(err)
-		return
+		log.Println("Rollover season error:", err)
+		return false
+	}
+
+	if season == nil {
+		return false
 	}
-	log.Println("Weekly leaderboard cleaned")
+
+	log.Println("Season archived:", season.Key, "participants:", season.Participants)
+	return true
+}
+
+// seasonSchedule fires at the end of the current season, the settings are read again for every run
+type seasonSchedule struct {
+	db *bun.DB
+}
+
+func (s *seasonSchedule) Next(t time.Time) time.Time {
+	return services.GetSeasonEnd(services.LoadSeasonConfig(context.Background(), s.db), t)
 }
 
 func (j *LeaderboardJob) initLeaderboard() {
@@ -62,7 +90,7 @@ func (j *LeaderboardJob) initLeaderboard() {
 	limit := 100
 	offset := 0
 
-	startTimeOfWeek := pkg.GetFirstTimeOfCurrentWeek()
+	startTimeOfWeek := services.GetSeasonStart(services.LoadSeasonConfig(ctx, j.Db), time.Now())
 	log.Println("Start loading user gem from time:", startTimeOfWeek)
 
 	for {
@@ -80,10 +108,24 @@ func (j *LeaderboardJob) initLeaderboard() {
 		}
 
 		for _, userGem := range userGems {
-			_, err := redis_store.SetLeaderboard(ctx, j.Redis, services.LEADERBOARD_OVERALL_WEEKLY, &models.LeaderboardItem{
-				UserId: userGem.UserID,
-				Score:  float64(userGem.TotalGems),
-			})
+			item := &models.LeaderboardItem{
+				UserId:    userGem.UserID,
+				Score:     float64(userGem.TotalGems),
+				ReachedAt: userGem.LastEarnedAt,
+			}
+			_, err := redis_store.SetLeaderboard(ctx, j.Redis, services.LEADERBOARD_OVERALL_WEEKLY, item)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			user, err := datastore.FindUserByID(ctx, j.Db, userGem.UserID)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			err = services.SetRegionalLeaderboard(ctx, j.Redis, user.LeaderboardRegion(), services.LEADERBOARD_OVERALL_WEEKLY, item)
 			if err != nil {
 				log.Println(err)
 			}
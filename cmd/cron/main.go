@@ -13,6 +13,7 @@ import (
 	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/driver/pgdriver"
 	"github.com/urfave/cli/v2"
+	tele "gopkg.in/telebot.v3"
 )
 
 const gameID = "catia"
@@ -62,6 +63,25 @@ func commandCronjob() *cli.Command {
 
 			leaderboardJob := NewLeaderboardJob(redis, db)
 			leaderboardJob.Start(cronRunner)
+
+			botClient, err := tele.NewBot(tele.Settings{Token: os.Getenv("BOT_TOKEN"), Offline: true})
+			if err != nil {
+				log.Println("Prize notifications disabled:", err)
+			}
+			prizeJob := NewPrizeJob(redis, db, botClient)
+			prizeJob.Start(cronRunner)
+
+			referralJob := NewReferralJob(redis, db)
+			referralJob.Start(cronRunner)
+
+			partnerWebhookJob := NewPartnerWebhookJob(db)
+			partnerWebhookJob.Start(cronRunner)
+
+			socialReverifyJob := NewSocialReverifyJob(redis, db, botClient)
+			socialReverifyJob.Start(cronRunner)
+
+			socialTaskViewJob := NewSocialTaskViewJob(redis, db)
+			socialTaskViewJob.Start(cronRunner)
 			log.Println("Start cronjob")
 			cronRunner.Run()
 			return nil
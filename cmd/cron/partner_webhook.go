@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"millionaire/internal/datastore"
+	"millionaire/internal/services"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/uptrace/bun"
+)
+
+type PartnerWebhookJob struct {
+	Db *bun.DB
+}
+
+func NewPartnerWebhookJob(db *bun.DB) *PartnerWebhookJob {
+	return &PartnerWebhookJob{
+		Db: db,
+	}
+}
+
+func (j *PartnerWebhookJob) Start(cronRunner *cron.Cron) {
+	timeline, err := datastore.GetConfigByKey(context.Background(), j.Db, "CRONJOB_TIME_PARTNER_WEBHOOK")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if timeline == nil || timeline.Value == "" {
+		fmt.Println("No timeline found")
+		return
+	}
+
+	_, err = cronRunner.AddFunc(timeline.Value, j.runScheduledTask)
+	log.Println("Partner webhook Cronjob start at:", time.Now().Format("2006-01-02 15:04:05"), "cron:", timeline.Value, err)
+}
+
+func (j *PartnerWebhookJob) runScheduledTask() {
+	ctx := context.Background()
+
+	queued, err := services.ScanPartnerGemMilestones(ctx, j.Db)
+	if err != nil {
+		log.Println("Scan partner gem milestones error:", err)
+	}
+
+	delivered, failed, err := services.DeliverPartnerWebhooks(ctx, j.Db)
+	if err != nil {
+		log.Println("Deliver partner webhooks error:", err)
+	}
+
+	if queued > 0 || delivered > 0 || failed > 0 {
+		log.Println("Partner webhooks queued:", queued, "delivered:", delivered, "failed:", failed)
+	}
+}
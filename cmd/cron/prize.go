@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"millionaire/internal/datastore"
+	"millionaire/internal/models"
+	"millionaire/internal/services"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+	"github.com/uptrace/bun"
+	tele "gopkg.in/telebot.v3"
+)
+
+const PRIZE_NOTIFY_CONTENT = "PRIZE_NOTIFY_CONTENT"
+
+type PrizeJob struct {
+	Redis     redis.UniversalClient
+	Db        *bun.DB
+	BotClient *tele.Bot
+}
+
+func NewPrizeJob(redis redis.UniversalClient, db *bun.DB, botClient *tele.Bot) *PrizeJob {
+	return &PrizeJob{
+		Redis:     redis,
+		Db:        db,
+		BotClient: botClient,
+	}
+}
+
+func (j *PrizeJob) Start(cronRunner *cron.Cron) {
+	timeline, err := datastore.GetConfigByKey(context.Background(), j.Db, "CRONJOB_TIME_PRIZE")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if timeline == nil || timeline.Value == "" {
+		fmt.Println("No timeline found")
+		return
+	}
+
+	_, err = cronRunner.AddFunc(timeline.Value, j.runScheduledTask)
+	log.Println("Prize Cronjob start at:", time.Now().Format("2006-01-02 15:04:05"), "cron:", timeline.Value, err)
+}
+
+func (j *PrizeJob) runScheduledTask() {
+	ctx := context.Background()
+
+	arenas, err := datastore.GetEndedArenas(ctx, j.Db)
+	if err != nil {
+		log.Println("Get ended arenas error:", err)
+	}
+
+	for i := range arenas {
+		arena := arenas[i]
+		distribution, created, err := services.DistributeArenaPrizes(ctx, j.Db, j.Redis, &arena)
+		if err != nil {
+			log.Println("Distribute arena prizes error:", err, "arena:", arena.Slug)
+			continue
+		}
+
+		if created {
+			log.Println("Arena prizes distributed:", arena.Slug, "winners:", len(distribution.Winners))
+			j.notifyWinners(ctx, arena.Name, distribution)
+		}
+	}
+
+	seasons, err := datastore.GetArchivedSeasonsWithPrizes(ctx, j.Db)
+	if err != nil {
+		log.Println("Get archived seasons error:", err)
+	}
+
+	for i := range seasons {
+		season := seasons[i]
+		distribution, created, err := services.DistributeSeasonPrizes(ctx, j.Db, j.Redis, &season)
+		if err != nil {
+			log.Println("Distribute season prizes error:", err, "season:", season.Key)
+			continue
+		}
+
+		if created {
+			log.Println("Season prizes distributed:", season.Key, "winners:", len(distribution.Winners))
+			j.notifyWinners(ctx, fmt.Sprintf("season %s", season.Key), distribution)
+		}
+	}
+}
+
+func (j *PrizeJob) notifyWinners(ctx context.Context, name string, distribution *models.PrizeDistribution) {
+	if j.BotClient == nil {
+		return
+	}
+
+	content := "Congratulations! You finished #%d in %s. Your prize is waiting for you in the app."
+	msgConfig, _ := datastore.GetConfigByKey(ctx, j.Db, PRIZE_NOTIFY_CONTENT)
+	if msgConfig != nil && msgConfig.Value != "" {
+		content = msgConfig.Value
+	}
+
+	for _, winner := range distribution.Winners {
+		// only telegram users can be reached by the bot
+		chatID, err := strconv.ParseInt(winner.UserID, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		_, err = j.BotClient.Send(tele.ChatID(chatID), fmt.Sprintf(content, winner.Rank, name), &tele.SendOptions{
+			ParseMode: tele.ModeHTML,
+			ReplyMarkup: &tele.ReplyMarkup{
+				InlineKeyboard: [][]tele.InlineButton{
+					{{Text: "🎁 Claim now", WebApp: &tele.WebApp{URL: os.Getenv("TELEGRAM_WEB_APP_URL")}}},
+				},
+			},
+		})
+		if err != nil {
+			fmt.Println("User:", winner.UserID, "error sending prize message:", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
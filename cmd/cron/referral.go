@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"millionaire/internal/datastore"
+	"millionaire/internal/services"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+	"github.com/uptrace/bun"
+)
+
+type ReferralJob struct {
+	Redis redis.UniversalClient
+	Db    *bun.DB
+}
+
+func NewReferralJob(redis redis.UniversalClient, db *bun.DB) *ReferralJob {
+	return &ReferralJob{
+		Redis: redis,
+		Db:    db,
+	}
+}
+
+func (j *ReferralJob) Start(cronRunner *cron.Cron) {
+	timeline, err := datastore.GetConfigByKey(context.Background(), j.Db, "CRONJOB_TIME_REFERRAL_VALIDATION")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if timeline == nil || timeline.Value == "" {
+		fmt.Println("No timeline found")
+		return
+	}
+
+	_, err = cronRunner.AddFunc(timeline.Value, j.runScheduledTask)
+	log.Println("Referral Cronjob start at:", time.Now().Format("2006-01-02 15:04:05"), "cron:", timeline.Value, err)
+}
+
+func (j *ReferralJob) runScheduledTask() {
+	validated, rejected, err := services.ValidateReferrals(context.Background(), j.Db, j.Redis)
+	if err != nil {
+		log.Println("Validate referrals error:", err)
+	}
+
+	log.Println("Referrals validated:", validated, "rejected:", rejected)
+}
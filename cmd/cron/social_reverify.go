@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"millionaire/internal/datastore"
+	"millionaire/internal/models"
+	"millionaire/internal/services"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+	"github.com/uptrace/bun"
+	tele "gopkg.in/telebot.v3"
+)
+
+const SOCIAL_CLAWBACK_NOTIFY_CONTENT = "SOCIAL_CLAWBACK_NOTIFY_CONTENT"
+
+type SocialReverifyJob struct {
+	Redis     redis.UniversalClient
+	Db        *bun.DB
+	BotClient *tele.Bot
+}
+
+func NewSocialReverifyJob(redis redis.UniversalClient, db *bun.DB, botClient *tele.Bot) *SocialReverifyJob {
+	return &SocialReverifyJob{
+		Redis:     redis,
+		Db:        db,
+		BotClient: botClient,
+	}
+}
+
+func (j *SocialReverifyJob) Start(cronRunner *cron.Cron) {
+	timeline, err := datastore.GetConfigByKey(context.Background(), j.Db, "CRONJOB_TIME_SOCIAL_REVERIFY")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if timeline == nil || timeline.Value == "" {
+		fmt.Println("No timeline found")
+		return
+	}
+
+	_, err = cronRunner.AddFunc(timeline.Value, j.runScheduledTask)
+	log.Println("Social reverify Cronjob start at:", time.Now().Format("2006-01-02 15:04:05"), "cron:", timeline.Value, err)
+}
+
+func (j *SocialReverifyJob) runScheduledTask() {
+	ctx := context.Background()
+
+	checked, left, clawbacks, err := services.ReverifyTelegramTasks(ctx, j.Db, j.Redis)
+	if err != nil {
+		log.Println("Reverify telegram tasks error:", err)
+	}
+
+	log.Println("Telegram tasks reverified:", checked, "left:", left, "clawed back:", len(clawbacks))
+	j.notifyClawbacks(ctx, clawbacks)
+}
+
+func (j *SocialReverifyJob) notifyClawbacks(ctx context.Context, clawbacks []models.SocialTaskClawback) {
+	if j.BotClient == nil {
+		return
+	}
+
+	content := "You left %s, so the %d gems of the task \"%s\" were taken back. Join again to complete the task."
+	msgConfig, _ := datastore.GetConfigByKey(ctx, j.Db, SOCIAL_CLAWBACK_NOTIFY_CONTENT)
+	if msgConfig != nil && msgConfig.Value != "" {
+		content = msgConfig.Value
+	}
+
+	for _, clawback := range clawbacks {
+		chatID, err := strconv.ParseInt(clawback.UserID, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		_, err = j.BotClient.Send(tele.ChatID(chatID), fmt.Sprintf(content, clawback.Url, clawback.Gems, clawback.Title), &tele.SendOptions{
+			DisableWebPagePreview: true,
+		})
+		if err != nil {
+			fmt.Println("User:", clawback.UserID, "error sending clawback message:", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"millionaire/internal/datastore"
+	"millionaire/internal/services"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+	"github.com/uptrace/bun"
+)
+
+type SocialTaskViewJob struct {
+	Redis redis.UniversalClient
+	Db    *bun.DB
+}
+
+func NewSocialTaskViewJob(redis redis.UniversalClient, db *bun.DB) *SocialTaskViewJob {
+	return &SocialTaskViewJob{
+		Redis: redis,
+		Db:    db,
+	}
+}
+
+func (j *SocialTaskViewJob) Start(cronRunner *cron.Cron) {
+	timeline, err := datastore.GetConfigByKey(context.Background(), j.Db, "CRONJOB_TIME_SOCIAL_TASK_VIEW")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if timeline == nil || timeline.Value == "" {
+		fmt.Println("No timeline found")
+		return
+	}
+
+	_, err = cronRunner.AddFunc(timeline.Value, j.runScheduledTask)
+	log.Println("Social task view Cronjob start at:", time.Now().Format("2006-01-02 15:04:05"), "cron:", timeline.Value, err)
+}
+
+func (j *SocialTaskViewJob) runScheduledTask() {
+	flushed, err := services.FlushSocialTaskViews(context.Background(), j.Db, j.Redis)
+	if err != nil {
+		log.Println("Flush social task views error:", err)
+	}
+
+	if flushed > 0 {
+		log.Println("Social task views flushed:", flushed)
+	}
+}
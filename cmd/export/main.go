@@ -168,6 +168,24 @@ func commandExport() *cli.Command {
 						fmt.Println(err)
 						continue
 					}
+
+					// the all-time records of the live hall of fame are keyed by user id
+					records := map[string]int{
+						models.GameRecordLongestStreak:     longestStreak,
+						models.GameRecordMostPlayedSession: len(gameListSession),
+						models.GameRecordMostBonusPoint:    bonusPoint,
+						models.GameRecordMostMinusPoint:    minusPoint,
+					}
+					for record, value := range records {
+						if value == 0 {
+							continue
+						}
+
+						err = redis_store.SetGameRecord(ctx, dbRedis, "catia", record, "", user.ID, float64(value))
+						if err != nil {
+							fmt.Println(err)
+						}
+					}
 				}
 
 				fmt.Println("done", offset, limit)
@@ -5,11 +5,13 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"math"
 	"millionaire/internal/datastore"
 	"millionaire/internal/datastore/redis_store"
 	"millionaire/internal/models"
 	"millionaire/internal/services"
 	"os"
+	"time"
 
 	"github.com/hiendaovinh/toolkit/pkg/db"
 	"github.com/hiendaovinh/toolkit/pkg/env"
@@ -39,6 +41,8 @@ func main() {
 			commandReferralLeaderboard(),
 			commandGamesLeaderboard(),
 			commandCatiaArenaLeaderboard(),
+			commandRegionalLeaderboard(),
+			commandRebuildTieBreak(),
 		},
 	}
 
@@ -111,19 +115,26 @@ func commandOverallLeaderboard() *cli.Command {
 
 				for _, user := range users {
 					point := float64(0)
+					var reachedAt *time.Time
 					for _, game := range games {
-						redisScore, err := redis_store.GetRankWithScore(ctx, dbRedis, game.Slug, user)
-						if err != nil && err != redis.Nil {
-							fmt.Println(err)
+						item, err := redis_store.GetLeaderboardItem(ctx, dbRedis, game.Slug, user.ID)
+						if err != nil {
+							if err != redis.Nil {
+								fmt.Println(err)
+							}
 							continue
 						}
 
-						point += redisScore.Score
+						point += item.Score
+						if reachedAt == nil || item.ReachedAt.After(*reachedAt) {
+							reachedAt = item.ReachedAt
+						}
 					}
 
 					leaderboardItem := &models.LeaderboardItem{
-						UserId: user.ID,
-						Score:  point,
+						UserId:    user.ID,
+						Score:     point,
+						ReachedAt: reachedAt,
 					}
 
 					_, err = redis_store.SetLeaderboard(ctx, dbRedis, services.LEADERBOARD_OVERALL, leaderboardItem)
@@ -222,8 +233,9 @@ func commandGamesLeaderboard() *cli.Command {
 
 					if (gameSessions.TotalScore) > 0 {
 						leaderboardItem := &models.LeaderboardItem{
-							UserId: user.ID,
-							Score:  float64(gameSessions.TotalScore),
+							UserId:    user.ID,
+							Score:     float64(gameSessions.TotalScore),
+							ReachedAt: gameSessions.LastEndedAt,
 						}
 
 						_, err = redis_store.SetLeaderboard(ctx, dbRedis, game.Slug, leaderboardItem)
@@ -299,9 +311,15 @@ func commandReferralLeaderboard() *cli.Command {
 				}
 
 				for _, user := range users {
+					lastInviteAt, err := datastore.GetLastReferralValidatedAt(ctx, db, user.ID)
+					if err != nil {
+						fmt.Println(err)
+					}
+
 					referralLeaderboard := &models.LeaderboardItem{
-						UserId: user.ID,
-						Score:  float64(user.TotalInvites),
+						UserId:    user.ID,
+						Score:     float64(user.TotalInvites),
+						ReachedAt: lastInviteAt,
 					}
 
 					_, err = redis_store.SetLeaderboard(ctx, dbRedis, services.LEADERBOARD_REFERRAL, referralLeaderboard)
@@ -400,6 +418,250 @@ func commandCatiaArenaLeaderboard() *cli.Command {
 	}
 }
 
+func commandRegionalLeaderboard() *cli.Command {
+	return &cli.Command{
+		Name:        "regional-leaderboard",
+		Description: "Backfill regional leaderboards from the global overall, weekly and game leaderboards",
+		Action: func(c *cli.Context) error {
+			_, err := env.EnvsRequired(
+				"DB_DSN",
+				"DB_PASSWORD",
+				"REDIS_QUESTIONNAIRE",
+			)
+			if err != nil {
+				return err
+			}
+
+			dbRedis, err := getRedis()
+			if err != nil {
+				return err
+			}
+
+			sqldb := sql.OpenDB(pgdriver.NewConnector(
+				pgdriver.WithDSN(os.Getenv("DB_DSN")),
+				pgdriver.WithPassword(os.Getenv("DB_PASSWORD")),
+			))
+			db := bun.NewDB(sqldb, pgdialect.New())
+
+			ctx := context.Background()
+
+			games, err := datastore.GetEnabledGames(ctx, db)
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+
+			boards := []string{services.LEADERBOARD_OVERALL, services.LEADERBOARD_OVERALL_WEEKLY}
+			for _, game := range games {
+				boards = append(boards, game.Slug)
+			}
+
+			limit := 100
+			offset := 0
+
+			for {
+				fmt.Println("start", offset, limit)
+				users, err := datastore.GetUsersSortedByCreatedAt(ctx, db, limit, offset)
+				offset += limit
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				if len(users) == 0 {
+					fmt.Println("no new user, DONE")
+					break
+				}
+
+				for _, user := range users {
+					region := user.LeaderboardRegion()
+					if region == "" {
+						continue
+					}
+
+					for _, board := range boards {
+						item, err := redis_store.GetLeaderboardItem(ctx, dbRedis, board, user.ID)
+						if err != nil {
+							if err != redis.Nil {
+								fmt.Println(err)
+							}
+							continue
+						}
+
+						err = services.SetRegionalLeaderboard(ctx, dbRedis, region, board, item)
+						if err != nil {
+							fmt.Println(err)
+						}
+					}
+				}
+			}
+
+			fmt.Println("done", offset, limit)
+
+			return nil
+		},
+	}
+}
+
+func commandRebuildTieBreak() *cli.Command {
+	return &cli.Command{
+		Name:        "rebuild-tie-break",
+		Description: "Re-encode existing leaderboard entries with the time their score was reached so ties are broken by time",
+		Action: func(c *cli.Context) error {
+			_, err := env.EnvsRequired(
+				"DB_DSN",
+				"DB_PASSWORD",
+				"REDIS_QUESTIONNAIRE",
+			)
+			if err != nil {
+				return err
+			}
+
+			dbRedis, err := getRedis()
+			if err != nil {
+				return err
+			}
+
+			sqldb := sql.OpenDB(pgdriver.NewConnector(
+				pgdriver.WithDSN(os.Getenv("DB_DSN")),
+				pgdriver.WithPassword(os.Getenv("DB_PASSWORD")),
+			))
+			db := bun.NewDB(sqldb, pgdialect.New())
+
+			ctx := context.Background()
+
+			games, err := datastore.GetEnabledGames(ctx, db)
+			if err != nil {
+				return err
+			}
+
+			arenas, err := datastore.GetEnabledArenas(ctx, db)
+			if err != nil {
+				return err
+			}
+
+			// the time a score was reached is looked up per board kind, regional boards are rebuilt from the global ones
+			reachedAtFuncs := map[string]func(userID string) *time.Time{
+				services.LEADERBOARD_OVERALL: func(userID string) *time.Time {
+					t, _ := datastore.GetUserLastGemAt(ctx, db, userID)
+					return t
+				},
+				services.LEADERBOARD_REFERRAL: func(userID string) *time.Time {
+					t, _ := datastore.GetLastReferralValidatedAt(ctx, db, userID)
+					return t
+				},
+			}
+			reachedAtFuncs[services.LEADERBOARD_OVERALL_WEEKLY] = reachedAtFuncs[services.LEADERBOARD_OVERALL]
+
+			for _, game := range games {
+				gameSlug := game.Slug
+				reachedAtFuncs[gameSlug] = func(userID string) *time.Time {
+					summary, err := datastore.GetUserGameSessionSumary(ctx, db, gameSlug, userID)
+					if err != nil {
+						return nil
+					}
+					return summary.LastEndedAt
+				}
+			}
+
+			for _, arena := range arenas {
+				gameSlug := arena.GameSlug
+				reachedAtFuncs[services.DBKeyArena(arena.Slug)] = func(userID string) *time.Time {
+					summary, err := datastore.GetUserGameSessionSumary(ctx, db, gameSlug, userID)
+					if err != nil {
+						return nil
+					}
+					return summary.LastEndedAt
+				}
+			}
+
+			for board, reachedAtFunc := range reachedAtFuncs {
+				count, err := rebuildTieBreak(ctx, dbRedis, board, reachedAtFunc)
+				if err != nil {
+					fmt.Println(board, err)
+					continue
+				}
+				fmt.Println("rebuilt", board, count)
+			}
+
+			regions, err := redis_store.GetLeaderboardRegions(ctx, dbRedis)
+			if err != nil {
+				return err
+			}
+
+			for _, region := range regions {
+				for board := range reachedAtFuncs {
+					regional := services.RegionalLeaderboardName(board, region)
+					count, err := rebuildTieBreak(ctx, dbRedis, regional, func(userID string) *time.Time {
+						item, err := redis_store.GetLeaderboardItem(ctx, dbRedis, board, userID)
+						if err != nil {
+							return nil
+						}
+						return item.ReachedAt
+					})
+					if err != nil {
+						fmt.Println(regional, err)
+						continue
+					}
+					if count > 0 {
+						fmt.Println("rebuilt", regional, count)
+					}
+				}
+			}
+
+			fmt.Println("done")
+
+			return nil
+		},
+	}
+}
+
+// rebuildTieBreak re-encodes the entries still stored as a plain score, they are collected first
+// because rewriting them while paging would shift the ranks under the cursor
+func rebuildTieBreak(ctx context.Context, dbRedis redis.UniversalClient, board string, reachedAtFunc func(userID string) *time.Time) (int, error) {
+	limit := 1000
+	offset := 0
+	legacy := []*models.LeaderboardItem{}
+
+	for {
+		items, err := redis_store.GetLeaderboardRaw(ctx, dbRedis, board, offset, limit)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, item := range items {
+			if item.Score != math.Floor(item.Score) {
+				continue
+			}
+
+			legacy = append(legacy, &models.LeaderboardItem{
+				UserId: item.Member.(string),
+				Score:  item.Score,
+			})
+		}
+
+		offset += limit
+		if len(items) < limit {
+			break
+		}
+	}
+
+	for _, item := range legacy {
+		reachedAt := reachedAtFunc(item.UserId)
+		if reachedAt == nil {
+			// unknown history sorts after every known one
+			now := time.Now()
+			reachedAt = &now
+		}
+		item.ReachedAt = reachedAt
+
+		if _, err := redis_store.SetLeaderboard(ctx, dbRedis, board, item); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(legacy), nil
+}
+
 func getRedis() (redis.UniversalClient, error) {
 	var dbRedis redis.UniversalClient
 	var err error
@@ -165,6 +165,81 @@ func commandMigration() *cli.Command {
 				log.Fatal(err)
 			}
 
+			err = datastore.CreateTableUserIdentity(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableUserMerge(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableSuspiciousEvent(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableAntiCheatFlag(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableUserModeration(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableSeason(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTablePrizeDistribution(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableReferralCommission(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableReferralValidation(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableKolCampaign(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableDeepLinkClick(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTablePartnerWebhook(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTablePartnerKey(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableSocialTaskVerification(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = datastore.CreateTableSocialTaskCompletion(ctx, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+
 			fmt.Println("Migration success")
 
 			return nil
@@ -266,6 +341,13 @@ Meet Catia - an asset publishing protocol for premium web3 projects. Crafted by
 				{Key: services.CONFIG_REFERRAL_LEADERBOARD_LIMIT, Value: "53"},
 				{Key: services.CONFIG_ARENA_LEADERBOARD_LIMIT, Value: "53"},
 				{Key: "CRONJOB_TIME_LEADERBOARD", Value: "0 0 * * 1"},
+				{Key: "CRONJOB_TIME_REFERRAL_VALIDATION", Value: "@every 1h"},
+				{Key: services.CONFIG_DEEP_LINK_TELEGRAM_URL, Value: ""},
+				{Key: services.CONFIG_DEEP_LINK_LINE_URL, Value: ""},
+				{Key: "CRONJOB_TIME_PARTNER_WEBHOOK", Value: "@every 1m"},
+				{Key: "CRONJOB_TIME_SOCIAL_REVERIFY", Value: "@every 10m"},
+				{Key: "CRONJOB_TIME_SOCIAL_TASK_VIEW", Value: "@every 1m"},
+				{Key: services.CONFIG_SOCIAL_REVERIFY_CLAWBACK, Value: "false"},
 				{Key: "ADMIN_CHAT_ID", Value: ""},
 			}
 
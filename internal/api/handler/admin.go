@@ -0,0 +1,433 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"millionaire/internal/models"
+	"millionaire/internal/services"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/hiendaovinh/toolkit/pkg/httpx-echo"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+type groupAdmin struct {
+	container *do.Injector
+}
+
+func (gr *groupAdmin) MergeUsers(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var payload models.MergeUsersPayload
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	serviceIdentity, err := do.Invoke[*services.ServiceIdentity](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	merge, err := serviceIdentity.MergeUsers(ctx, &payload, ResolveAdminOperator(ctx))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, merge, nil)
+}
+
+func (gr *groupAdmin) GetUserModeration(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	serviceModeration, err := do.Invoke[*services.ServiceModeration](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	moderation, err := serviceModeration.GetUserModeration(ctx, c.Param("id"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, moderation, nil)
+}
+
+func (gr *groupAdmin) ModerateUser(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var payload models.ModerateUserPayload
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	serviceModeration, err := do.Invoke[*services.ServiceModeration](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	moderation, err := serviceModeration.Moderate(ctx, c.Param("id"), &payload, ResolveAdminOperator(ctx))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, moderation, nil)
+}
+
+func (gr *groupAdmin) SetArenaPrizes(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var payload models.PrizeSchedule
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	servicePrize, err := do.Invoke[*services.ServicePrize](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	arena, err := servicePrize.SetArenaPrizes(ctx, c.Param("slug"), payload)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, arena, nil)
+}
+
+func (gr *groupAdmin) PreviewArenaPrizes(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	servicePrize, err := do.Invoke[*services.ServicePrize](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	distribution, err := servicePrize.PreviewArenaPrizes(ctx, c.Param("slug"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, distribution, nil)
+}
+
+func (gr *groupAdmin) DistributeArenaPrizes(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	servicePrize, err := do.Invoke[*services.ServicePrize](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	distribution, err := servicePrize.DistributeArenaPrizes(ctx, c.Param("slug"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, distribution, nil)
+}
+
+func (gr *groupAdmin) PreviewSeasonPrizes(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	seasonID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid season"), errorx.Invalid))
+	}
+
+	servicePrize, err := do.Invoke[*services.ServicePrize](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	distribution, err := servicePrize.PreviewSeasonPrizes(ctx, seasonID)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, distribution, nil)
+}
+
+func (gr *groupAdmin) DistributeSeasonPrizes(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	seasonID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid season"), errorx.Invalid))
+	}
+
+	servicePrize, err := do.Invoke[*services.ServicePrize](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	distribution, err := servicePrize.DistributeSeasonPrizes(ctx, seasonID)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, distribution, nil)
+}
+
+func (gr *groupAdmin) GetKolCampaigns(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	serviceKolCampaign, err := do.Invoke[*services.ServiceKolCampaign](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	campaigns, err := serviceKolCampaign.GetCampaigns(ctx)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	return httpx.RestAbort(c, campaigns, nil)
+}
+
+func (gr *groupAdmin) CreateKolCampaign(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var payload models.KolCampaignPayload
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	serviceKolCampaign, err := do.Invoke[*services.ServiceKolCampaign](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	campaign, err := serviceKolCampaign.CreateCampaign(ctx, &payload)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, campaign, nil)
+}
+
+func (gr *groupAdmin) UpdateKolCampaign(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var payload models.KolCampaignPayload
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	serviceKolCampaign, err := do.Invoke[*services.ServiceKolCampaign](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	campaign, err := serviceKolCampaign.UpdateCampaign(ctx, c.Param("slug"), &payload)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, campaign, nil)
+}
+
+// GetKolCampaignStats answers in JSON, or in CSV with format=csv
+func (gr *groupAdmin) GetKolCampaignStats(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	from, err := parseTimeParam(c.QueryParam("from"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid from"), errorx.Invalid))
+	}
+
+	to, err := parseTimeParam(c.QueryParam("to"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid to"), errorx.Invalid))
+	}
+
+	serviceKolCampaign, err := do.Invoke[*services.ServiceKolCampaign](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	stats, err := serviceKolCampaign.GetStats(ctx, c.Param("slug"), from, to)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	if c.QueryParam("format") != "csv" {
+		return httpx.RestAbort(c, stats, nil)
+	}
+
+	content, err := services.KolCampaignStatsCSV(stats)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", stats.Campaign.Slug+".csv"))
+	return c.Blob(http.StatusOK, "text/csv", content)
+}
+
+// parseTimeParam accepts RFC 3339 or a plain date, an empty value is nil
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (gr *groupAdmin) GetAttributionReport(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	from, err := parseTimeParam(c.QueryParam("from"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid from"), errorx.Invalid))
+	}
+
+	to, err := parseTimeParam(c.QueryParam("to"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid to"), errorx.Invalid))
+	}
+
+	serviceDeepLink, err := do.Invoke[*services.ServiceDeepLink](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	report, err := serviceDeepLink.GetAttributionReport(ctx, from, to, limit)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, report, nil)
+}
+
+func (gr *groupAdmin) GetPartners(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	servicePartner, err := do.Invoke[*services.ServicePartner](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	partners, err := servicePartner.GetPartners(ctx)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, partners, nil)
+}
+
+func (gr *groupAdmin) UpdatePartner(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var payload models.PartnerSettingsPayload
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	servicePartner, err := do.Invoke[*services.ServicePartner](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	partner, err := servicePartner.UpdatePartner(ctx, c.Param("slug"), &payload)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, partner, nil)
+}
+
+func (gr *groupAdmin) GetPartnerKeys(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	servicePartner, err := do.Invoke[*services.ServicePartner](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	keys, err := servicePartner.GetKeys(ctx, c.Param("slug"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, keys, nil)
+}
+
+func (gr *groupAdmin) CreatePartnerKey(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var payload models.PartnerKeyPayload
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	servicePartner, err := do.Invoke[*services.ServicePartner](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	key, err := servicePartner.CreateKey(ctx, c.Param("slug"), &payload)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, key, nil)
+}
+
+// ExpirePartnerKey only reads expires_at from the payload, null keeps the key working
+func (gr *groupAdmin) ExpirePartnerKey(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid key"), errorx.Invalid))
+	}
+
+	var payload models.PartnerKeyPayload
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	servicePartner, err := do.Invoke[*services.ServicePartner](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	if err := servicePartner.ExpireKey(ctx, c.Param("slug"), keyID, payload.ExpiresAt); err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, true, nil)
+}
+
+func (gr *groupAdmin) RevokePartnerKey(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid key"), errorx.Invalid))
+	}
+
+	servicePartner, err := do.Invoke[*services.ServicePartner](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	if err := servicePartner.RevokeKey(ctx, c.Param("slug"), keyID); err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, true, nil)
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net/http"
+
+	"millionaire/internal/services"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/hiendaovinh/toolkit/pkg/httpx-echo"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+type groupDeepLink struct {
+	container *do.Injector
+}
+
+// Click counts a click on a shared link then redirects to the mini app with the start parameter
+func (gr *groupDeepLink) Click(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	serviceDeepLink, err := do.Invoke[*services.ServiceDeepLink](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	target, err := serviceDeepLink.RecordClick(ctx, c.Param("param"), c.QueryParam("via"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return c.Redirect(http.StatusFound, target)
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"millionaire/internal/models"
 	"millionaire/internal/services"
+	"strconv"
+	"strings"
 
 	"github.com/hiendaovinh/toolkit/pkg/errorx"
 	"github.com/hiendaovinh/toolkit/pkg/httpx-echo"
@@ -176,6 +178,32 @@ func (gr *groupGame) End(c echo.Context) error {
 	return httpx.RestAbort(c, session, nil)
 }
 
+func (gr *groupGame) Records(c echo.Context) error {
+	serviceLeaderboard, err := do.Invoke[*services.ServiceLeaderboard](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	game := c.Param("game")
+	if game == "" || game == "undefined" {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("game is required"), errorx.Invalid))
+	}
+
+	ctx := c.Request().Context()
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	records, err := serviceLeaderboard.GetGameRecords(ctx, user, strings.ToLower(game), c.QueryParam("window"), limit)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, records, nil)
+}
+
 func (gr *groupGame) Scores(c echo.Context) error {
 	serviceGame, err := do.Invoke[*services.ServiceGame](gr.container)
 	if err != nil {
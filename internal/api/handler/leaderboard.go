@@ -3,6 +3,7 @@ package handler
 import (
 	"errors"
 	"millionaire/internal/services"
+	"strconv"
 	"strings"
 
 	"github.com/hiendaovinh/toolkit/pkg/errorx"
@@ -102,3 +103,233 @@ func (gr *groupLeaderboard) GetGameLeaderboard(c echo.Context) error {
 
 	return httpx.RestAbort(c, gameLeaderboard, nil)
 }
+
+func (gr *groupLeaderboard) GetSeasons(c echo.Context) error {
+	serviceSeason, err := do.Invoke[*services.ServiceSeason](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	ctx := c.Request().Context()
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	seasons, err := serviceSeason.GetSeasons(ctx, limit)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	return httpx.RestAbort(c, seasons, nil)
+}
+
+func (gr *groupLeaderboard) GetPreviousSeasonLeaderboard(c echo.Context) error {
+	serviceSeason, err := do.Invoke[*services.ServiceSeason](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	leaderboard, err := serviceSeason.GetPreviousSeasonLeaderboard(ctx, user)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, leaderboard, nil)
+}
+
+func (gr *groupLeaderboard) GetSeasonLeaderboard(c echo.Context) error {
+	serviceSeason, err := do.Invoke[*services.ServiceSeason](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	ctx := c.Request().Context()
+
+	seasonID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid season"), errorx.Invalid))
+	}
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	leaderboard, err := serviceSeason.GetSeasonLeaderboard(ctx, user, seasonID)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, leaderboard, nil)
+}
+
+func (gr *groupLeaderboard) GetAroundMe(c echo.Context) error {
+	leaderboardName, err := services.ResolveLeaderboardName(c.Param("board"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return gr.aroundMe(c, leaderboardName)
+}
+
+func (gr *groupLeaderboard) GetLeaderboardPage(c echo.Context) error {
+	leaderboardName, err := services.ResolveLeaderboardName(c.Param("board"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return gr.page(c, leaderboardName)
+}
+
+func (gr *groupLeaderboard) GetGameAroundMe(c echo.Context) error {
+	game := c.Param("game")
+	if game == "" || game == "undefined" {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("game is required"), errorx.Invalid))
+	}
+
+	return gr.aroundMe(c, strings.ToLower(game))
+}
+
+func (gr *groupLeaderboard) GetGameLeaderboardPage(c echo.Context) error {
+	game := c.Param("game")
+	if game == "" || game == "undefined" {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("game is required"), errorx.Invalid))
+	}
+
+	return gr.page(c, strings.ToLower(game))
+}
+
+func (gr *groupLeaderboard) GetArenaAroundMe(c echo.Context) error {
+	return gr.aroundMe(c, services.DBKeyArena(c.Param("slug")))
+}
+
+func (gr *groupLeaderboard) GetArenaLeaderboardPage(c echo.Context) error {
+	return gr.page(c, services.DBKeyArena(c.Param("slug")))
+}
+
+func (gr *groupLeaderboard) GetFriendsLeaderboard(c echo.Context) error {
+	leaderboardName, err := services.ResolveLeaderboardName(c.Param("board"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return gr.friends(c, leaderboardName)
+}
+
+func (gr *groupLeaderboard) GetGameFriendsLeaderboard(c echo.Context) error {
+	game := c.Param("game")
+	if game == "" || game == "undefined" {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("game is required"), errorx.Invalid))
+	}
+
+	return gr.friends(c, strings.ToLower(game))
+}
+
+func (gr *groupLeaderboard) GetRegionalLeaderboard(c echo.Context) error {
+	leaderboardName, err := services.ResolveLeaderboardName(c.Param("board"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return gr.regional(c, leaderboardName)
+}
+
+func (gr *groupLeaderboard) GetGameRegionalLeaderboard(c echo.Context) error {
+	game := c.Param("game")
+	if game == "" || game == "undefined" {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("game is required"), errorx.Invalid))
+	}
+
+	return gr.regional(c, strings.ToLower(game))
+}
+
+func (gr *groupLeaderboard) regional(c echo.Context, leaderboardName string) error {
+	serviceLeaderboard, err := do.Invoke[*services.ServiceLeaderboard](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	leaderboard, err := serviceLeaderboard.GetRegionalLeaderboard(ctx, user, leaderboardName, c.QueryParam("region"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, leaderboard, nil)
+}
+
+func (gr *groupLeaderboard) friends(c echo.Context, leaderboardName string) error {
+	serviceLeaderboard, err := do.Invoke[*services.ServiceLeaderboard](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	leaderboard, err := serviceLeaderboard.GetFriendsLeaderboard(ctx, user, leaderboardName)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, leaderboard, nil)
+}
+
+func (gr *groupLeaderboard) aroundMe(c echo.Context, leaderboardName string) error {
+	serviceLeaderboard, err := do.Invoke[*services.ServiceLeaderboard](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	size, _ := strconv.Atoi(c.QueryParam("size"))
+	leaderboard, err := serviceLeaderboard.GetAroundMe(ctx, user, leaderboardName, size)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, leaderboard, nil)
+}
+
+func (gr *groupLeaderboard) page(c echo.Context, leaderboardName string) error {
+	serviceLeaderboard, err := do.Invoke[*services.ServiceLeaderboard](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	leaderboard, err := serviceLeaderboard.GetLeaderboardPage(ctx, user, leaderboardName, c.QueryParam("cursor"), limit)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, leaderboard, nil)
+}
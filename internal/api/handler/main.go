@@ -13,9 +13,10 @@ import (
 )
 
 type Config struct {
-	Container *do.Injector
-	Mode      string
-	Origins   []string
+	Container   *do.Injector
+	Mode        string
+	Origins     []string
+	AdminAPIKey string
 }
 
 func New(cfg *Config) (http.Handler, error) {
@@ -36,6 +37,34 @@ func New(cfg *Config) (http.Handler, error) {
 		return c.String(http.StatusOK, "🤖")
 	})
 
+	dl := groupDeepLink{cfg.Container}
+	r.GET("/l/:param", dl.Click, RateLimit(cfg.Container))
+
+	routesAdmin := r.Group("/admin/v1")
+	{
+		routesAdmin.Use(AuthnAdmin(cfg.AdminAPIKey))
+		ad := groupAdmin{cfg.Container}
+		routesAdmin.POST("/users/merge", ad.MergeUsers)
+		routesAdmin.GET("/users/:id/moderation", ad.GetUserModeration)
+		routesAdmin.POST("/users/:id/moderation", ad.ModerateUser)
+		routesAdmin.PUT("/arenas/:slug/prizes", ad.SetArenaPrizes)
+		routesAdmin.GET("/arenas/:slug/prizes/preview", ad.PreviewArenaPrizes)
+		routesAdmin.POST("/arenas/:slug/prizes/distribute", ad.DistributeArenaPrizes)
+		routesAdmin.GET("/seasons/:id/prizes/preview", ad.PreviewSeasonPrizes)
+		routesAdmin.POST("/seasons/:id/prizes/distribute", ad.DistributeSeasonPrizes)
+		routesAdmin.GET("/kol-campaigns", ad.GetKolCampaigns)
+		routesAdmin.POST("/kol-campaigns", ad.CreateKolCampaign)
+		routesAdmin.PUT("/kol-campaigns/:slug", ad.UpdateKolCampaign)
+		routesAdmin.GET("/kol-campaigns/:slug/stats", ad.GetKolCampaignStats)
+		routesAdmin.GET("/attribution", ad.GetAttributionReport)
+		routesAdmin.GET("/partners", ad.GetPartners)
+		routesAdmin.PUT("/partners/:slug", ad.UpdatePartner)
+		routesAdmin.GET("/partners/:slug/keys", ad.GetPartnerKeys)
+		routesAdmin.POST("/partners/:slug/keys", ad.CreatePartnerKey)
+		routesAdmin.PUT("/partners/:slug/keys/:id", ad.ExpirePartnerKey)
+		routesAdmin.DELETE("/partners/:slug/keys/:id", ad.RevokePartnerKey)
+	}
+
 	routesAPIv1 := r.Group("/api/v1")
 	{
 		bot, err := do.Invoke[*services.Bot](cfg.Container)
@@ -48,7 +77,7 @@ func New(cfg *Config) (http.Handler, error) {
 		}
 		cors := middleware.CORSWithConfig(middleware.CORSConfig{
 			AllowOrigins:     cfg.Origins,
-			AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+			AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "Idempotency-Key"},
 			AllowCredentials: true,
 			MaxAge:           60 * 60,
 		})
@@ -57,22 +86,32 @@ func New(cfg *Config) (http.Handler, error) {
 
 		routesAPIv1Me := routesAPIv1.Group("/user/me")
 		routesAPIv1Me.Use(Authn(bot))
+		routesAPIv1Me.Use(RateLimit(cfg.Container))
 		{
 			m := groupUser{cfg.Container}
 			routesAPIv1Me.GET("", m.Me)
 		}
 
 		routesAPIv1.Use(Authn(authentication)) // Authn will NOT terminate unauthenticated request.
+		routesAPIv1.Use(RateLimit(cfg.Container))
 		routesAPIv1.GET("", Hello)
 
 		routesAPIv1User := routesAPIv1.Group("/user")
 		{
 			u := groupUser{cfg.Container}
 			// routesAPIv1User.GET("/me", u.Me)
-			routesAPIv1User.POST("/boost/claim/:source", u.ClaimUserBoost)
+			routesAPIv1User.POST("/boost/claim/:source", u.ClaimUserBoost, Idempotency(cfg.Container))
 			routesAPIv1User.GET("/friends", u.GetFriendList)
-			routesAPIv1User.POST("/boost/claim-all", u.ClaimAllBoosts)
-			routesAPIv1User.POST("/connect/ton", u.ConnectTonWallet)
+			routesAPIv1User.GET("/referral/earnings", u.GetReferralEarnings)
+			routesAPIv1User.GET("/referral/status", u.GetReferralStatus)
+			routesAPIv1User.POST("/boost/claim-all", u.ClaimAllBoosts, Idempotency(cfg.Container))
+			routesAPIv1User.POST("/connect/ton", u.ConnectTonWallet, Idempotency(cfg.Container))
+			routesAPIv1User.PUT("/region", u.SetRegion, Idempotency(cfg.Container))
+			routesAPIv1User.GET("/identities", u.GetIdentities)
+			routesAPIv1User.POST("/identities/link", u.LinkIdentity, Idempotency(cfg.Container))
+			routesAPIv1User.GET("/rewards", u.GetRewards)
+			routesAPIv1User.POST("/rewards/claim-all", u.ClaimAllRewards, Idempotency(cfg.Container))
+			routesAPIv1User.POST("/rewards/:id/claim", u.ClaimReward, Idempotency(cfg.Container))
 		}
 
 		g := groupGame{cfg.Container}
@@ -81,12 +120,24 @@ func New(cfg *Config) (http.Handler, error) {
 		routesAPIv1.GET("/game/:game", g.Show)
 
 		routesAPIv1.GET("/game/:game/scores", g.Scores)
+		routesAPIv1.GET("/game/:game/records", g.Records)
 
 		l := groupLeaderboard{cfg.Container}
 		routesAPIv1.GET("/leaderboard/referral", l.GetTopReferralLeaderboard)
 		routesAPIv1.GET("/leaderboard/overall", l.GetOverallLeaderboard)
 		routesAPIv1.GET("/leaderboard/overall_weekly", l.GetWeeklyOverallLeaderboard)
+		routesAPIv1.GET("/leaderboard/seasons", l.GetSeasons)
+		routesAPIv1.GET("/leaderboard/seasons/previous", l.GetPreviousSeasonLeaderboard)
+		routesAPIv1.GET("/leaderboard/seasons/:id", l.GetSeasonLeaderboard)
+		routesAPIv1.GET("/leaderboard/:board/around-me", l.GetAroundMe)
+		routesAPIv1.GET("/leaderboard/:board/page", l.GetLeaderboardPage)
+		routesAPIv1.GET("/leaderboard/:board/friends", l.GetFriendsLeaderboard)
+		routesAPIv1.GET("/leaderboard/:board/region", l.GetRegionalLeaderboard)
 		routesAPIv1.GET("/game/:game/leaderboard", l.GetGameLeaderboard)
+		routesAPIv1.GET("/game/:game/leaderboard/around-me", l.GetGameAroundMe)
+		routesAPIv1.GET("/game/:game/leaderboard/page", l.GetGameLeaderboardPage)
+		routesAPIv1.GET("/game/:game/leaderboard/friends", l.GetGameFriendsLeaderboard)
+		routesAPIv1.GET("/game/:game/leaderboard/region", l.GetGameRegionalLeaderboard)
 
 		routesAPIv1Game := routesAPIv1.Group("/game")
 		{
@@ -97,11 +148,11 @@ func New(cfg *Config) (http.Handler, error) {
 			routesAPIv1Game.GET("/:game/current_session", g.FindOrCreateSession)
 			routesAPIv1Game.GET("/:game/next", g.Next)
 			routesAPIv1Game.GET("/:game/me", g.Me)
-			routesAPIv1Game.POST("/:game/current_session/end", g.End)
-			routesAPIv1Game.POST("/:game/current_session/answer", g.Answer)
-			routesAPIv1Game.POST("/:game/current_session/assistance", g.BurnAssistance)
-			routesAPIv1Game.POST("/:game/reduce-countdown", g.ReduceCountdown)
-			routesAPIv1Game.POST("/:game/convert-lifeline", g.ConvertBoostToLifeline)
+			routesAPIv1Game.POST("/:game/current_session/end", g.End, Idempotency(cfg.Container))
+			routesAPIv1Game.POST("/:game/current_session/answer", g.Answer, Idempotency(cfg.Container))
+			routesAPIv1Game.POST("/:game/current_session/assistance", g.BurnAssistance, Idempotency(cfg.Container))
+			routesAPIv1Game.POST("/:game/reduce-countdown", g.ReduceCountdown, Idempotency(cfg.Container))
+			routesAPIv1Game.POST("/:game/convert-lifeline", g.ConvertBoostToLifeline, Idempotency(cfg.Container))
 			routesAPIv1Game.GET("/:game/last_session_score", g.GetLastUserSessionScore)
 			routesAPIv1Game.GET("/game-list", g.GetUserGameList)
 		}
@@ -113,7 +164,7 @@ func New(cfg *Config) (http.Handler, error) {
 
 		uf := groupUserFreebies{cfg.Container}
 		routesAPIv1.GET("/user/freebies", uf.GetUserFreebies)
-		routesAPIv1.POST("/user/freebies/claim/:action", uf.ClaimFreebies)
+		routesAPIv1.POST("/user/freebies/claim/:action", uf.ClaimFreebies, Idempotency(cfg.Container))
 
 		routesAPIv1Parter := routesAPIv1.Group("/3rd")
 
@@ -124,18 +175,30 @@ func New(cfg *Config) (http.Handler, error) {
 			}
 
 			routesAPIv1Parter.Use(AuthnPartner(partner))
+			routesAPIv1Parter.Use(RateLimit(cfg.Container))
 			p := groupPartner{cfg.Container}
 			routesAPIv1Parter.GET("/verify-user", p.CheckUserJoined)
+			routesAPIv1Parter.GET("/webhooks", p.GetWebhooks)
+			routesAPIv1Parter.POST("/webhooks", p.CreateWebhook)
+			routesAPIv1Parter.DELETE("/webhooks/:id", p.DeleteWebhook)
+			routesAPIv1Parter.POST("/webhooks/:id/replay", p.ReplayWebhook)
+			routesAPIv1Parter.GET("/webhooks/deliveries", p.GetWebhookDeliveries)
+			routesAPIv1Parter.POST("/webhooks/deliveries/:id/replay", p.ReplayWebhookDelivery)
+			routesAPIv1Parter.GET("/stats/tasks", p.GetTaskStats)
+			routesAPIv1Parter.GET("/stats/arenas", p.GetArenas)
+			routesAPIv1Parter.GET("/stats/arenas/:slug", p.GetArenaStats)
 		}
 
 		m := groupMoon{cfg.Container}
 		routesAPIv1.GET("/moon", m.GetMoon)
-		routesAPIv1.POST("/moon/spin", m.SpinGacha)
+		routesAPIv1.POST("/moon/spin", m.SpinGacha, Idempotency(cfg.Container))
 
 		a := groupArena{cfg.Container}
 		routesAPIv1.GET("/arenas", a.GetArenas)
 		routesAPIv1.GET("/arena/:slug", a.GetArena)
 		routesAPIv1.GET("/arena/:slug/leaderboard", a.GetArenaLeaderboard)
+		routesAPIv1.GET("/arena/:slug/leaderboard/around-me", l.GetArenaAroundMe)
+		routesAPIv1.GET("/arena/:slug/leaderboard/page", l.GetArenaLeaderboardPage)
 	}
 
 	return r, nil
@@ -1,12 +1,24 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"millionaire/internal/models"
+	"millionaire/internal/pkg/limiter"
 	"millionaire/internal/services"
 
 	"github.com/hiendaovinh/toolkit/pkg/errorx"
@@ -19,6 +31,9 @@ type ctxKey string
 
 var ctxKeyAuthUser ctxKey = "AUTH_USER"
 var ctxKeyAuthPartner ctxKey = "AUTH_PARTNER"
+var ctxKeyAuthAdmin ctxKey = "AUTH_ADMIN"
+
+const echoKeyRateLimitApplied = "RATE_LIMIT_APPLIED"
 
 func Authn(verifier interface {
 	Validate(dataStr string) (*models.UserFromAuth, error)
@@ -68,7 +83,21 @@ func ResolveValidUser(ctx context.Context, container *do.Injector) (*models.User
 		return nil, err
 	}
 
-	return serviceUser.FindOrCreateUser(ctx, userAuth)
+	user, err := serviceUser.FindOrCreateUser(ctx, userAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceModeration, err := do.Invoke[*services.ServiceModeration](container)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := serviceModeration.CheckUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
 }
 
 func middlewareTimeEndedGameContext(container *do.Injector) echo.MiddlewareFunc {
@@ -104,26 +133,78 @@ func middlewareTimeEndedGameContext(container *do.Injector) echo.MiddlewareFunc
 	}
 }
 
-// middleware function to check valid partner via api key header, save partner to context
+// partnerRouteScopes maps the /3rd routes to the key scope they require, a route without scope is refused
+var partnerRouteScopes = []struct {
+	prefix string
+	scope  string
+}{
+	{"/api/v1/3rd/verify-user", models.PartnerScopeVerifyUser},
+	{"/api/v1/3rd/stats", models.PartnerScopeStats},
+	{"/api/v1/3rd/webhooks", models.PartnerScopeWebhooks},
+}
+
+func partnerRouteScope(path string) string {
+	for _, route := range partnerRouteScopes {
+		if path == route.prefix || strings.HasPrefix(path, route.prefix+"/") {
+			return route.scope
+		}
+	}
+
+	return ""
+}
+
+// middleware function to check valid partner via api key header, save partner to context. The key must hold
+// the scope of the route, the request is signed when the partner requires it or sends a signature, and the
+// partner is throttled to its own rate
 func AuthnPartner(verifier interface {
-	ValidateAPIKey(apiKey string) (*models.Partner, error)
+	Authenticate(ctx context.Context, apiKey string) (*models.Partner, *models.PartnerKey, error)
+	VerifySignature(ctx context.Context, key *models.PartnerKey, timestamp string, signature string, method string, uri string, body []byte) error
+	Allow(ctx context.Context, partner *models.Partner) error
 },
 ) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+
 			header := c.Request().Header.Get("X-Api-Key")
 			if header == "" {
 				httpx.Abort(c, errorx.Wrap(errors.New("unauthorized"), errorx.Authn), -1)
 				return nil
 			}
 
-			partner, err := verifier.ValidateAPIKey(header)
+			partner, key, err := verifier.Authenticate(ctx, header)
 			if err != nil {
 				httpx.Abort(c, errorx.Wrap(errors.New("unauthorized"), errorx.Authn), -1)
 				return nil
 			}
 
-			ctx := c.Request().Context()
+			scope := partnerRouteScope(c.Path())
+			if scope == "" || !key.HasScope(scope) {
+				httpx.Abort(c, errorx.Wrap(errors.New("the api key is missing the scope of this route"), errorx.Authz), -1)
+				return nil
+			}
+
+			signature := c.Request().Header.Get("X-Signature")
+			if partner.RequireSignature || signature != "" {
+				body, err := io.ReadAll(c.Request().Body)
+				if err != nil {
+					httpx.Abort(c, errorx.Wrap(err, errorx.Invalid), -1)
+					return nil
+				}
+				c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+				err = verifier.VerifySignature(ctx, key, c.Request().Header.Get("X-Timestamp"), signature, c.Request().Method, c.Request().RequestURI, body)
+				if err != nil {
+					httpx.Abort(c, errorx.Wrap(err, errorx.Authn), -1)
+					return nil
+				}
+			}
+
+			if err := verifier.Allow(ctx, partner); err != nil {
+				httpx.Abort(c, err, -1)
+				return nil
+			}
+
 			ctx = context.WithValue(ctx, ctxKeyAuthPartner, partner)
 			c.SetRequest(c.Request().WithContext(ctx))
 			return next(c)
@@ -139,3 +220,211 @@ func ResolveValidPartner(ctx context.Context, container *do.Injector) (*models.P
 
 	return userAuth, nil
 }
+
+// middleware function to check the admin api key header, the operator header is only kept for the audit trail
+func AuthnAdmin(apiKey string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get("X-Admin-Key")
+			if apiKey == "" || header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(apiKey)) != 1 {
+				httpx.Abort(c, errorx.Wrap(errors.New("unauthorized"), errorx.Authn), -1)
+				return nil
+			}
+
+			operator := c.Request().Header.Get("X-Admin-Operator")
+			if operator == "" {
+				operator = "admin-api"
+			}
+
+			ctx := c.Request().Context()
+			ctx = context.WithValue(ctx, ctxKeyAuthAdmin, operator)
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+func ResolveAdminOperator(ctx context.Context) string {
+	operator, ok := ctx.Value(ctxKeyAuthAdmin).(string)
+	if !ok {
+		return ""
+	}
+
+	return operator
+}
+
+// RateLimit throttles the matched route with its policy from ServiceRateLimit. It must be registered after the
+// authentication middlewares; a route keyed by partner is skipped until the partner is resolved so the middleware
+// can be used again after AuthnPartner. Limiter errors other than rate limited let the request through.
+func RateLimit(container *do.Injector) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if applied, _ := c.Get(echoKeyRateLimitApplied).(bool); applied {
+				return next(c)
+			}
+
+			serviceRateLimit, err := do.Invoke[*services.ServiceRateLimit](container)
+			if err != nil {
+				return next(c)
+			}
+
+			ctx := c.Request().Context()
+			route := c.Request().Method + " " + c.Path()
+			policy, ok := serviceRateLimit.GetPolicy(ctx, c.Request().Method, c.Path())
+			if !ok {
+				return next(c)
+			}
+
+			subject := resolveRateLimitSubject(c, policy.Key)
+			if subject == "" {
+				return next(c)
+			}
+			c.Set(echoKeyRateLimitApplied, true)
+
+			res, err := serviceRateLimit.Allow(ctx, route, subject, policy)
+			if res != nil {
+				header := c.Response().Header()
+				header.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit.Burst))
+				header.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+				header.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.ResetAfter.Seconds()))))
+			}
+
+			if err != nil {
+				if errors.Is(err, limiter.ErrRateLimited) {
+					if res != nil {
+						c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+					}
+					httpx.Abort(c, errorx.Wrap(err, errorx.RateLimiting), -1)
+					return nil
+				}
+
+				log.Println("RateLimit error:", err, "route:", route)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func resolveRateLimitSubject(c echo.Context, key string) string {
+	ctx := c.Request().Context()
+
+	switch key {
+	case models.RateLimitKeyPartner:
+		partner, ok := ctx.Value(ctxKeyAuthPartner).(*models.Partner)
+		if !ok {
+			return ""
+		}
+		return "partner:" + partner.Slug
+	case models.RateLimitKeyUser:
+		userAuth, ok := ctx.Value(ctxKeyAuthUser).(*models.UserFromAuth)
+		if ok {
+			return "user:" + userAuth.Provider + ":" + userAuth.ID
+		}
+	}
+
+	return "ip:" + c.RealIP()
+}
+
+// Idempotency replays the stored response of a request sent again with the same Idempotency-Key header.
+// Keys are scoped by caller and route; a key reused with another body is rejected. Only successful responses
+// are stored, any other releases the key so the client can retry. Requests without the header are not affected.
+func Idempotency(container *do.Injector) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := strings.TrimSpace(c.Request().Header.Get("Idempotency-Key"))
+			if key == "" {
+				return next(c)
+			}
+
+			if len(key) > 255 {
+				httpx.Abort(c, errorx.Wrap(errors.New("idempotency key is too long"), errorx.Invalid), -1)
+				return nil
+			}
+
+			serviceIdempotency, err := do.Invoke[*services.ServiceIdempotency](container)
+			if err != nil {
+				return next(c)
+			}
+
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				httpx.Abort(c, errorx.Wrap(err, errorx.Invalid), -1)
+				return nil
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.New()
+			hash.Write([]byte(c.Request().Method + " " + c.Request().URL.RequestURI() + "\n"))
+			hash.Write(body)
+			fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+			ctx := c.Request().Context()
+			scope := resolveRateLimitSubject(c, models.RateLimitKeyUser) + ":" + c.Request().Method + " " + c.Path()
+
+			record, err := serviceIdempotency.Begin(ctx, scope, key, fingerprint)
+			if err != nil {
+				httpx.Abort(c, err, -1)
+				return nil
+			}
+
+			if record != nil {
+				c.Response().Header().Set("Idempotent-Replayed", "true")
+				return c.Blob(record.Status, record.ContentType, record.Body)
+			}
+
+			recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+
+			err = next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			// only a success is replayed, a failure such as a lock conflict or a rate limit can be retried
+			status := c.Response().Status
+			if status < http.StatusOK || status >= http.StatusMultipleChoices {
+				if err := serviceIdempotency.Release(ctx, scope, key); err != nil {
+					log.Println("Idempotency release error:", err)
+				}
+				return nil
+			}
+
+			err = serviceIdempotency.Complete(ctx, scope, key, &models.IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Status:      status,
+				ContentType: c.Response().Header().Get(echo.HeaderContentType),
+				Body:        recorder.body.Bytes(),
+			})
+			if err != nil {
+				log.Println("Idempotency complete error:", err)
+			}
+
+			return nil
+		}
+	}
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	return hijacker.Hijack()
+}
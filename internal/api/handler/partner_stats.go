@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"millionaire/internal/services"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/hiendaovinh/toolkit/pkg/httpx-echo"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+// GetTaskStats answers in JSON, or in CSV with format=csv
+func (gr *groupPartner) GetTaskStats(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	partner, err := ResolveValidPartner(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	from, err := parseTimeParam(c.QueryParam("from"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid from"), errorx.Invalid))
+	}
+
+	to, err := parseTimeParam(c.QueryParam("to"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid to"), errorx.Invalid))
+	}
+
+	servicePartnerStats, err := do.Invoke[*services.ServicePartnerStats](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	report, err := servicePartnerStats.GetTaskStats(ctx, partner, from, to)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	if c.QueryParam("format") != "csv" {
+		return httpx.RestAbort(c, report, nil)
+	}
+
+	content, err := services.PartnerTaskStatsCSV(report)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", partner.Slug+"-tasks.csv"))
+	return c.Blob(http.StatusOK, "text/csv", content)
+}
+
+func (gr *groupPartner) GetArenas(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	partner, err := ResolveValidPartner(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	servicePartnerStats, err := do.Invoke[*services.ServicePartnerStats](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	arenas, err := servicePartnerStats.GetArenas(ctx, partner)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, arenas, nil)
+}
+
+// GetArenaStats answers in JSON, or in CSV with format=csv and report=participants, scores or retention
+func (gr *groupPartner) GetArenaStats(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	partner, err := ResolveValidPartner(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	from, err := parseTimeParam(c.QueryParam("from"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid from"), errorx.Invalid))
+	}
+
+	to, err := parseTimeParam(c.QueryParam("to"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid to"), errorx.Invalid))
+	}
+
+	servicePartnerStats, err := do.Invoke[*services.ServicePartnerStats](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	stats, err := servicePartnerStats.GetArenaStats(ctx, partner, c.Param("slug"), from, to)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	if c.QueryParam("format") != "csv" {
+		return httpx.RestAbort(c, stats, nil)
+	}
+
+	report := c.QueryParam("report")
+	content, err := services.PartnerArenaStatsCSV(stats, report)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	if report == "" {
+		report = services.PARTNER_ARENA_REPORT_PARTICIPANTS
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", stats.Arena.Slug+"-"+report+".csv"))
+	return c.Blob(http.StatusOK, "text/csv", content)
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"millionaire/internal/models"
+	"millionaire/internal/services"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/hiendaovinh/toolkit/pkg/httpx-echo"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+func (gr *groupPartner) GetWebhooks(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	partner, err := ResolveValidPartner(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	servicePartnerWebhook, err := do.Invoke[*services.ServicePartnerWebhook](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	webhooks, err := servicePartnerWebhook.GetWebhooks(ctx, partner)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, webhooks, nil)
+}
+
+func (gr *groupPartner) CreateWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	partner, err := ResolveValidPartner(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	var payload models.PartnerWebhookPayload
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	servicePartnerWebhook, err := do.Invoke[*services.ServicePartnerWebhook](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	webhook, err := servicePartnerWebhook.CreateWebhook(ctx, partner, &payload)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, webhook, nil)
+}
+
+func (gr *groupPartner) DeleteWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	partner, err := ResolveValidPartner(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	webhookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid webhook"), errorx.Invalid))
+	}
+
+	servicePartnerWebhook, err := do.Invoke[*services.ServicePartnerWebhook](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	if err := servicePartnerWebhook.DeleteWebhook(ctx, partner, webhookID); err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, true, nil)
+}
+
+// ReplayWebhook drains the dead-letter queue of the webhook
+func (gr *groupPartner) ReplayWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	partner, err := ResolveValidPartner(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	webhookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid webhook"), errorx.Invalid))
+	}
+
+	servicePartnerWebhook, err := do.Invoke[*services.ServicePartnerWebhook](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	replayed, err := servicePartnerWebhook.ReplayDeadDeliveries(ctx, partner, webhookID)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, map[string]int{"replayed": replayed}, nil)
+}
+
+func (gr *groupPartner) GetWebhookDeliveries(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	partner, err := ResolveValidPartner(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	webhookID, _ := strconv.ParseInt(c.QueryParam("webhook_id"), 10, 64)
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	offset, _ := strconv.Atoi(c.QueryParam("offset"))
+
+	servicePartnerWebhook, err := do.Invoke[*services.ServicePartnerWebhook](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	deliveries, err := servicePartnerWebhook.GetDeliveries(ctx, partner, webhookID, c.QueryParam("status"), limit, offset)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, deliveries, nil)
+}
+
+func (gr *groupPartner) ReplayWebhookDelivery(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	partner, err := ResolveValidPartner(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	deliveryID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid delivery"), errorx.Invalid))
+	}
+
+	servicePartnerWebhook, err := do.Invoke[*services.ServicePartnerWebhook](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	if err := servicePartnerWebhook.ReplayDelivery(ctx, partner, deliveryID); err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, true, nil)
+}
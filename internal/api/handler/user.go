@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"millionaire/internal/models"
 	"millionaire/internal/services"
 	"os"
@@ -32,7 +33,27 @@ func (gr *groupUser) Me(c echo.Context) error {
 		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
 	}
 
-	refCodeParam := c.QueryParam("refCode")
+	serviceDeepLink, err := do.Invoke[*services.ServiceDeepLink](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	// the signed Telegram start_param wins, LINE LIFF forwards it in the query, refCode is what older clients send
+	startParam := c.QueryParam("start_param")
+	if userAuth, ok := ctx.Value(ctxKeyAuthUser).(*models.UserFromAuth); ok && userAuth.StartParam != "" {
+		startParam = userAuth.StartParam
+	}
+	if startParam == "" {
+		startParam = c.QueryParam("refCode")
+	}
+
+	route := &models.StartRoute{Screen: models.StartRouteHome}
+	refCodeParam := ""
+	if param := serviceDeepLink.Touch(ctx, user, startParam); param != nil {
+		route = param.Route()
+		refCodeParam = param.InviteCode()
+	}
+
 	user, err = serviceUser.Me(ctx, user, refCodeParam)
 	if err != nil {
 		return httpx.RestAbort(c, nil, err)
@@ -55,6 +76,7 @@ func (gr *groupUser) Me(c echo.Context) error {
 	return httpx.RestAbort(c, map[string]interface{}{
 		"token": tokenString,
 		"user":  user,
+		"route": route,
 	}, nil)
 }
 
@@ -195,3 +217,211 @@ func (gr *groupUser) ConnectTonWallet(c echo.Context) error {
 
 	return httpx.RestAbort(c, "success", nil)
 }
+
+func (gr *groupUser) SetRegion(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	var payload models.SetRegionPayload
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	serviceLeaderboard, err := do.Invoke[*services.ServiceLeaderboard](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	user, err = serviceLeaderboard.SetUserRegion(ctx, user, payload.Region)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, user, nil)
+}
+
+func (gr *groupUser) GetReferralEarnings(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	serviceReferral, err := do.Invoke[*services.ServiceReferral](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	earnings, err := serviceReferral.GetEarnings(ctx, user)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	return httpx.RestAbort(c, earnings, nil)
+}
+
+func (gr *groupUser) GetIdentities(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	serviceIdentity, err := do.Invoke[*services.ServiceIdentity](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	identities, err := serviceIdentity.GetIdentities(ctx, user.ID)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	return httpx.RestAbort(c, identities, nil)
+}
+
+func (gr *groupUser) LinkIdentity(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	var payload models.LinkIdentityPayload
+	if err := c.Bind(&payload); err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Invalid))
+	}
+
+	serviceIdentity, err := do.Invoke[*services.ServiceIdentity](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	identity, err := serviceIdentity.LinkIdentity(ctx, user, &payload)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, identity, nil)
+}
+
+func (gr *groupUser) GetRewards(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	pageStr := c.QueryParam("page")
+	limitStr := c.QueryParam("limit")
+
+	page := 0
+	limit := 10
+	//if page or limit is empty, set default value
+	if limitStr != "" {
+		limit, _ = strconv.Atoi(limitStr)
+
+		if limit <= 0 {
+			limit = 10
+		}
+
+		if limit > 100 {
+			limit = 100
+		}
+	}
+
+	page, _ = strconv.Atoi(pageStr)
+	if page < 0 {
+		page = 0
+	}
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	serviceReward, err := do.Invoke[*services.ServiceReward](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	inbox, err := serviceReward.GetUserRewards(ctx, user.ID, c.QueryParam("status"), page, limit)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	return httpx.RestAbort(c, inbox, nil)
+}
+
+func (gr *groupUser) ClaimReward(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	rewardID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(errors.New("invalid reward"), errorx.Invalid))
+	}
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	serviceReward, err := do.Invoke[*services.ServiceReward](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	reward, err := serviceReward.ClaimReward(ctx, user, rewardID)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, reward, nil)
+}
+
+func (gr *groupUser) ClaimAllRewards(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	serviceReward, err := do.Invoke[*services.ServiceReward](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	rewards, err := serviceReward.ClaimAllRewards(ctx, user)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, rewards, nil)
+}
+
+func (gr *groupUser) GetReferralStatus(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	user, err := ResolveValidUser(ctx, gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	serviceReferral, err := do.Invoke[*services.ServiceReferral](gr.container)
+	if err != nil {
+		return httpx.RestAbort(c, nil, errorx.Wrap(err, errorx.Service))
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	offset, _ := strconv.Atoi(c.QueryParam("offset"))
+	breakdown, err := serviceReferral.GetStatusBreakdown(ctx, user, c.QueryParam("status"), limit, offset)
+	if err != nil {
+		return httpx.RestAbort(c, nil, err)
+	}
+
+	return httpx.RestAbort(c, breakdown, nil)
+}
@@ -0,0 +1,119 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+func CreateTableAntiCheatFlag(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.AntiCheatFlag)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewRaw(`
+		create unique index if not exists index_anti_cheat_flag_open_user_id
+			on anti_cheat_flag (user_id) where status = 'open';`).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.AntiCheatFlag)(nil)).Index("index_anti_cheat_flag_status_score").IfNotExists().Column("status", "score").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpsertOpenAntiCheatFlag adds the signals to the open flag of the user or opens a new one
+func UpsertOpenAntiCheatFlag(ctx context.Context, db *bun.DB, flag *models.AntiCheatFlag) error {
+	flag.Status = models.AntiCheatFlagStatusOpen
+	flag.UpdatedAt = time.Now()
+	_, err := db.NewInsert().Model(flag).
+		On("CONFLICT (user_id) WHERE status = 'open' DO UPDATE").
+		Set("score = GREATEST(anti_cheat_flag.score, EXCLUDED.score)").
+		Set("signals = anti_cheat_flag.signals || EXCLUDED.signals").
+		Set("actions = EXCLUDED.actions").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	return err
+}
+
+func GetOpenAntiCheatFlags(ctx context.Context, db *bun.DB, limit int) ([]models.AntiCheatFlag, error) {
+	var flags []models.AntiCheatFlag
+	err := db.NewSelect().Model(&flags).
+		Where("status = ?", models.AntiCheatFlagStatusOpen).
+		Order("score DESC", "updated_at DESC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return flags, nil
+}
+
+func GetLatestAntiCheatFlag(ctx context.Context, db *bun.DB, userID string) (*models.AntiCheatFlag, error) {
+	var flag models.AntiCheatFlag
+	err := db.NewSelect().Model(&flag).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(1).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &flag, nil
+}
+
+func ResolveAntiCheatFlag(ctx context.Context, db *bun.DB, userID string, status string, resolvedBy string, note string) (int64, error) {
+	res, err := db.NewUpdate().Model((*models.AntiCheatFlag)(nil)).
+		Set("status = ?", status).
+		Set("resolved_by = ?", resolvedBy).
+		Set("resolved_at = current_timestamp").
+		Set("note = ?", note).
+		Set("updated_at = current_timestamp").
+		Where("user_id = ?", userID).
+		Where("status = ?", models.AntiCheatFlagStatusOpen).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// CountFlaggedInvitees counts the users invited by inviterID that are waiting for review or confirmed cheaters
+func CountFlaggedInvitees(ctx context.Context, db *bun.DB, inviterID string) (int, error) {
+	return db.NewSelect().
+		TableExpr("anti_cheat_flag AS flag").
+		Join(`JOIN "user" AS invitee ON invitee.id = flag.user_id`).
+		ColumnExpr("DISTINCT flag.user_id").
+		Where("invitee.inviter_id = ?", inviterID).
+		Where("flag.status IN (?)", bun.In([]string{models.AntiCheatFlagStatusOpen, models.AntiCheatFlagStatusConfirmed})).
+		Count(ctx)
+}
+
+// GetUserSessionStats counts the ended sessions of a game since a time and how many of them answered every question
+func GetUserSessionStats(ctx context.Context, db *bun.DB, userID string, gameSlug string, from time.Time, perfectCount int) (*models.UserSessionStats, error) {
+	var stats models.UserSessionStats
+	err := db.NewSelect().
+		TableExpr("game_session").
+		ColumnExpr("count(*) AS total").
+		ColumnExpr("count(*) FILTER (WHERE correct_answer_count >= ?) AS perfect", perfectCount).
+		Where("user_id = ?", userID).
+		Where("game_slug = ?", gameSlug).
+		Where("ended_at >= ?", from).
+		Scan(ctx, &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
@@ -2,6 +2,7 @@ package datastore
 
 import (
 	"context"
+	"encoding/json"
 	"millionaire/internal/models"
 
 	"github.com/uptrace/bun"
@@ -24,6 +25,12 @@ func CreateTableArena(ctx context.Context, db *bun.DB) error {
 		
 		alter table "arena"
 			add if not exists priority int default 0;
+
+		alter table "arena"
+			add if not exists prizes jsonb;
+
+		alter table "arena"
+			add if not exists partner_id bigint;
 		`).Exec(ctx)
 	if err != nil {
 		return err
@@ -63,3 +70,28 @@ func GetArenaByGameSlug(ctx context.Context, db *bun.DB, gameSlug string) (*mode
 	}
 	return &arena, nil
 }
+
+// GetEndedArenas returns the enabled arenas ended before now whose prizes are not distributed yet
+func GetEndedArenas(ctx context.Context, db *bun.DB) ([]models.Arena, error) {
+	var arenas []models.Arena
+	err := db.NewSelect().Model(&arenas).
+		Where("enabled = ?", true).
+		Where("end_date < current_timestamp").
+		Where("CASE WHEN jsonb_typeof(prizes) = 'array' THEN jsonb_array_length(prizes) ELSE 0 END > 0").
+		Where("NOT EXISTS (SELECT 1 FROM prize_distribution WHERE prize_distribution.source = ? AND prize_distribution.source_ref = arena.slug)", models.PrizeSourceArena).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return arenas, nil
+}
+
+func SetArenaPrizes(ctx context.Context, db *bun.DB, arenaID int64, prizes models.PrizeSchedule) error {
+	value, err := json.Marshal(prizes)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewUpdate().Model((*models.Arena)(nil)).Set("prizes = ?::jsonb", string(value)).Where("id = ?", arenaID).Exec(ctx)
+	return err
+}
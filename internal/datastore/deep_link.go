@@ -0,0 +1,62 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+func CreateTableDeepLinkClick(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.DeepLinkClick)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.User)(nil)).Index("index_user_first_touch_param").IfNotExists().Column("first_touch_param").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.User)(nil)).Index("index_user_last_touch_param").IfNotExists().Column("last_touch_param").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func IncrDeepLinkClick(ctx context.Context, db *bun.DB, param string, day time.Time) error {
+	_, err := db.NewInsert().Model(&models.DeepLinkClick{Param: param, Day: day, Clicks: 1}).
+		On("CONFLICT (param, day) DO UPDATE").
+		Set("clicks = deep_link_click.clicks + 1").
+		Exec(ctx)
+	return err
+}
+
+// GetAttributionReport compares, per start parameter, the link clicks with the users it brought first (signups)
+// and the users who came back through it last (touches)
+func GetAttributionReport(ctx context.Context, db *bun.DB, from time.Time, to time.Time, limit int) ([]*models.AttributionReportRow, error) {
+	rows := []*models.AttributionReportRow{}
+	err := db.NewRaw(`
+		WITH link_clicks AS (
+			SELECT param, SUM(clicks) AS clicks FROM deep_link_click WHERE day >= date(?) AND day < date(?) GROUP BY param
+		), link_signups AS (
+			SELECT first_touch_param AS param, count(*) AS signups FROM "user"
+			WHERE first_touch_param IS NOT NULL AND created_at >= ? AND created_at < ? GROUP BY first_touch_param
+		), link_touches AS (
+			SELECT last_touch_param AS param, count(*) AS touches FROM "user"
+			WHERE last_touch_param IS NOT NULL AND last_touch_at >= ? AND last_touch_at < ? GROUP BY last_touch_param
+		)
+		SELECT param, COALESCE(clicks, 0) AS clicks, COALESCE(signups, 0) AS signups, COALESCE(touches, 0) AS touches
+		FROM link_clicks FULL JOIN link_signups USING (param) FULL JOIN link_touches USING (param)
+		ORDER BY 2 DESC, 3 DESC
+		LIMIT ?`, from, to, from, to, from, to, limit).Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
@@ -139,6 +139,7 @@ func GetUserGameSessionSumary(ctx context.Context, db *bun.DB, gameSlug string,
 		ColumnExpr("sum(correct_answer_count) correct_answer_count").
 		ColumnExpr("max(correct_answer_count) max_correct_answer_count").
 		ColumnExpr("count(*) session_count").
+		ColumnExpr("max(ended_at) last_ended_at").
 		TableExpr("game_session").
 		Where("user_id = ?", userID).
 		Where("game_slug = ?", gameSlug).
@@ -0,0 +1,232 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+func CreateTableKolCampaign(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.KolCampaign)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewRaw(`create index if not exists index_kol_campaign_ref_codes on kol_campaign using gin (ref_codes);`).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*models.KolCampaignJoin)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.KolCampaignJoin)(nil)).Index("index_kol_campaign_join_campaign_joined_at").IfNotExists().Column("campaign_id", "joined_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func InsertKolCampaign(ctx context.Context, db *bun.DB, campaign *models.KolCampaign) error {
+	_, err := db.NewInsert().Model(campaign).Returning("*").Exec(ctx)
+	return err
+}
+
+func UpdateKolCampaign(ctx context.Context, db *bun.DB, campaign *models.KolCampaign) error {
+	_, err := db.NewUpdate().Model(campaign).
+		Column("name", "owner_id", "ref_codes", "start_at", "end_at", "budget", "joiner_bonus", "kol_bonus", "enabled").
+		Set("updated_at = current_timestamp").
+		WherePK().
+		Exec(ctx)
+	return err
+}
+
+func GetKolCampaignBySlug(ctx context.Context, db *bun.DB, slug string) (*models.KolCampaign, error) {
+	var campaign models.KolCampaign
+	err := db.NewSelect().Model(&campaign).Where("slug = ?", slug).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &campaign, nil
+}
+
+func GetKolCampaignByID(ctx context.Context, db *bun.DB, id int64) (*models.KolCampaign, error) {
+	var campaign models.KolCampaign
+	err := db.NewSelect().Model(&campaign).Where("id = ?", id).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &campaign, nil
+}
+
+func GetKolCampaigns(ctx context.Context, db *bun.DB) ([]models.KolCampaign, error) {
+	campaigns := []models.KolCampaign{}
+	err := db.NewSelect().Model(&campaigns).Order("created_at DESC").Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return campaigns, nil
+}
+
+func FindKolCampaignByRefCode(ctx context.Context, db *bun.DB, refCode string) (*models.KolCampaign, error) {
+	var campaign models.KolCampaign
+	err := db.NewSelect().Model(&campaign).Where("ref_codes @> ARRAY[?]::varchar[]", refCode).Limit(1).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &campaign, nil
+}
+
+// InsertKolCampaignJoin records the first campaign a user joined with, it returns false when the user was already attributed
+func InsertKolCampaignJoin(ctx context.Context, db *bun.DB, join *models.KolCampaignJoin) (bool, error) {
+	res, err := db.NewInsert().Model(join).On("CONFLICT (user_id) DO NOTHING").Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func GetKolCampaignJoin(ctx context.Context, db *bun.DB, userID string) (*models.KolCampaignJoin, error) {
+	var join models.KolCampaignJoin
+	err := db.NewSelect().Model(&join).Where("user_id = ?", userID).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &join, nil
+}
+
+// CreditKolCampaignBonus pays a campaign bonus out of its budget, it returns false when the budget is spent
+// or the bonus was already paid for this action
+func CreditKolCampaignBonus(ctx context.Context, db *bun.DB, campaignID int64, userID string, gems int, action string) (bool, error) {
+	credited := false
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var campaign models.KolCampaign
+		err := tx.NewSelect().Model(&campaign).Where("id = ?", campaignID).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		if campaign.Budget > 0 && campaign.Spent+gems > campaign.Budget {
+			return nil
+		}
+
+		res, err := tx.NewInsert().Model(&models.UserGem{
+			UserID: userID,
+			Gems:   gems,
+			Action: action,
+		}).On("CONFLICT (user_id, action) DO NOTHING").Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil || affected == 0 {
+			return err
+		}
+
+		_, err = tx.NewUpdate().Model((*models.KolCampaign)(nil)).
+			Set("spent = spent + ?", gems).
+			Where("id = ?", campaignID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		credited = true
+		return nil
+	})
+
+	return credited, err
+}
+
+// CountKolCampaignJoinsByStatus counts the joiners in the range per referral validation status
+func CountKolCampaignJoinsByStatus(ctx context.Context, db *bun.DB, campaignID int64, from time.Time, to time.Time) (map[string]int, error) {
+	var rows []struct {
+		Status sql.NullString `bun:"status"`
+		Count  int            `bun:"count"`
+	}
+	err := db.NewSelect().
+		TableExpr("kol_campaign_join AS j").
+		Join("LEFT JOIN referral_validation AS rv ON rv.invitee_id = j.user_id").
+		ColumnExpr("rv.status").
+		ColumnExpr("count(*) AS count").
+		Where("j.campaign_id = ?", campaignID).
+		Where("j.joined_at >= ?", from).
+		Where("j.joined_at < ?", to).
+		GroupExpr("rv.status").
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[row.Status.String] += row.Count
+	}
+
+	return counts, nil
+}
+
+func GetKolCampaignDailyJoins(ctx context.Context, db *bun.DB, campaignID int64, from time.Time, to time.Time) ([]models.KolCampaignDailyValue, error) {
+	var rows []models.KolCampaignDailyValue
+	err := db.NewSelect().
+		TableExpr("kol_campaign_join AS j").
+		ColumnExpr("to_char(date(j.joined_at), 'YYYY-MM-DD') AS day").
+		ColumnExpr("count(*) AS value").
+		Where("j.campaign_id = ?", campaignID).
+		Where("j.joined_at >= ?", from).
+		Where("j.joined_at < ?", to).
+		GroupExpr("day").
+		Scan(ctx, &rows)
+	return rows, err
+}
+
+func GetKolCampaignDailyActivations(ctx context.Context, db *bun.DB, campaignID int64, from time.Time, to time.Time) ([]models.KolCampaignDailyValue, error) {
+	var rows []models.KolCampaignDailyValue
+	err := db.NewSelect().
+		TableExpr("kol_campaign_join AS j").
+		Join("JOIN referral_validation AS rv ON rv.invitee_id = j.user_id").
+		ColumnExpr("to_char(date(rv.resolved_at), 'YYYY-MM-DD') AS day").
+		ColumnExpr("count(*) AS value").
+		Where("j.campaign_id = ?", campaignID).
+		Where("rv.status = ?", models.ReferralStatusValidated).
+		Where("rv.resolved_at >= ?", from).
+		Where("rv.resolved_at < ?", to).
+		GroupExpr("day").
+		Scan(ctx, &rows)
+	return rows, err
+}
+
+// GetKolCampaignDailyGems sums the gems earned by the joiners of the campaign per day
+func GetKolCampaignDailyGems(ctx context.Context, db *bun.DB, campaignID int64, from time.Time, to time.Time) ([]models.KolCampaignDailyValue, error) {
+	var rows []models.KolCampaignDailyValue
+	err := db.NewSelect().
+		TableExpr("kol_campaign_join AS j").
+		Join("JOIN user_gem AS ug ON ug.user_id = j.user_id").
+		ColumnExpr("to_char(date(ug.created_at), 'YYYY-MM-DD') AS day").
+		ColumnExpr("COALESCE(SUM(ug.gems), 0) AS value").
+		Where("j.campaign_id = ?", campaignID).
+		Where("ug.created_at >= ?", from).
+		Where("ug.created_at < ?", to).
+		GroupExpr("day").
+		Scan(ctx, &rows)
+	return rows, err
+}
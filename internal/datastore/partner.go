@@ -3,8 +3,10 @@ package datastore
 import (
 	"context"
 	"millionaire/internal/models"
+	"time"
 
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
 )
 
 func CreateTablePartner(ctx context.Context, db *bun.DB) error {
@@ -23,6 +25,19 @@ func CreateTablePartner(ctx context.Context, db *bun.DB) error {
 		return err
 	}
 
+	_, err = db.NewRaw(`
+		alter table partner
+			add if not exists ref_code varchar;
+
+		alter table partner
+			add if not exists rate_limit_per_minute integer not null default 0;
+
+		alter table partner
+			add if not exists require_signature bool not null default false;`).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -43,14 +58,21 @@ func GetPartner(ctx context.Context, db *bun.DB, slug string) (*models.Partner,
 	return &partner, nil
 }
 
-func FindPartnerByAPIKey(ctx context.Context, db *bun.DB, apiKey string) (*models.Partner, error) {
-	var partner models.Partner
-	err := db.NewSelect().Model(&partner).Where("api_key = ?", apiKey).Scan(ctx)
+func GetPartners(ctx context.Context, db *bun.DB) ([]models.Partner, error) {
+	partners := []models.Partner{}
+	err := db.NewSelect().Model(&partners).Order("id ASC").Scan(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return partners, nil
+}
 
-	return &partner, nil
+func UpdatePartnerSettings(ctx context.Context, db *bun.DB, partner *models.Partner) error {
+	_, err := db.NewUpdate().Model(partner).
+		Column("enabled", "ref_code", "rate_limit_per_minute", "require_signature").
+		WherePK().
+		Exec(ctx)
+	return err
 }
 
 func GetEnabledPartner(ctx context.Context, db *bun.DB) ([]models.Partner, error) {
@@ -61,3 +83,81 @@ func GetEnabledPartner(ctx context.Context, db *bun.DB) ([]models.Partner, error
 	}
 	return partners, nil
 }
+
+func GetPartnerByID(ctx context.Context, db *bun.DB, id int64) (*models.Partner, error) {
+	var partner models.Partner
+	err := db.NewSelect().Model(&partner).Where("id = ?", id).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &partner, nil
+}
+
+// CreateTablePartnerKey moves the legacy plaintext keys of the partner table to hashed keys with every scope
+func CreateTablePartnerKey(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.PartnerKey)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.PartnerKey)(nil)).Index("index_partner_key_partner_id").IfNotExists().Column("partner_id").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewRaw(`
+		INSERT INTO partner_key (partner_id, name, prefix, hash, scopes)
+		SELECT id, 'legacy', left(api_key, 4), encode(sha256(convert_to(api_key, 'UTF8')), 'hex'), ?
+		FROM partner
+		WHERE api_key IS NOT NULL AND api_key <> ''
+		ON CONFLICT (hash) DO NOTHING;
+
+		UPDATE partner SET api_key = NULL WHERE api_key IS NOT NULL;`, pgdialect.Array(models.PartnerScopes)).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func InsertPartnerKey(ctx context.Context, db *bun.DB, key *models.PartnerKey) error {
+	_, err := db.NewInsert().Model(key).Returning("*").Exec(ctx)
+	return err
+}
+
+func FindPartnerKeyByHash(ctx context.Context, db *bun.DB, hash string) (*models.PartnerKey, error) {
+	var key models.PartnerKey
+	err := db.NewSelect().Model(&key).Where("hash = ?", hash).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func GetPartnerKeys(ctx context.Context, db *bun.DB, partnerID int64) ([]models.PartnerKey, error) {
+	keys := []models.PartnerKey{}
+	err := db.NewSelect().Model(&keys).Where("partner_id = ?", partnerID).Order("id ASC").Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func GetPartnerKey(ctx context.Context, db *bun.DB, partnerID int64, id int64) (*models.PartnerKey, error) {
+	var key models.PartnerKey
+	err := db.NewSelect().Model(&key).Where("id = ?", id).Where("partner_id = ?", partnerID).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func SetPartnerKeyExpiry(ctx context.Context, db *bun.DB, id int64, expiresAt *time.Time) error {
+	_, err := db.NewUpdate().Model((*models.PartnerKey)(nil)).Set("expires_at = ?", expiresAt).Where("id = ?", id).Exec(ctx)
+	return err
+}
+
+func RevokePartnerKey(ctx context.Context, db *bun.DB, id int64, at time.Time) error {
+	_, err := db.NewUpdate().Model((*models.PartnerKey)(nil)).Set("revoked_at = ?", at).Where("id = ?", id).Where("revoked_at IS NULL").Exec(ctx)
+	return err
+}
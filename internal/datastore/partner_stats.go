@@ -0,0 +1,292 @@
+package datastore
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func CreateTableSocialTaskVerification(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.SocialTaskVerification)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.SocialTaskVerification)(nil)).Index("index_social_task_verification_task_id_created_at").IfNotExists().Column("task_id", "created_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*models.SocialTaskView)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.SocialTask)(nil)).Index("index_social_task_partner_id").IfNotExists().Column("partner_id").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.Arena)(nil)).Index("index_arena_partner_id").IfNotExists().Column("partner_id").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func InsertSocialTaskVerification(ctx context.Context, db *bun.DB, verification *models.SocialTaskVerification) error {
+	_, err := db.NewInsert().Model(verification).Exec(ctx)
+	return err
+}
+
+// AddSocialTaskViews adds the views counted in redis to the day of the task
+func AddSocialTaskViews(ctx context.Context, db *bun.DB, view *models.SocialTaskView) error {
+	_, err := db.NewInsert().Model(view).
+		On("CONFLICT (task_id, day) DO UPDATE").
+		Set("views = social_task_view.views + EXCLUDED.views").
+		Exec(ctx)
+	return err
+}
+
+func GetSocialTasksByPartner(ctx context.Context, db *bun.DB, partnerID int64) ([]models.SocialTask, error) {
+	tasks := []models.SocialTask{}
+	err := db.NewSelect().Model(&tasks).Where("partner_id = ?", partnerID).Order("id ASC").Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func GetArenasByPartner(ctx context.Context, db *bun.DB, partnerID int64) ([]models.Arena, error) {
+	arenas := []models.Arena{}
+	err := db.NewSelect().Model(&arenas).Where("partner_id = ?", partnerID).Order("id ASC").Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return arenas, nil
+}
+
+func GetPartnerArena(ctx context.Context, db *bun.DB, partnerID int64, slug string) (*models.Arena, error) {
+	var arena models.Arena
+	err := db.NewSelect().Model(&arena).Where("slug = ?", slug).Where("partner_id = ?", partnerID).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &arena, nil
+}
+
+// CountSocialTaskViews sums the views of the tasks by task id
+func CountSocialTaskViews(ctx context.Context, db *bun.DB, taskIDs []int64, from time.Time, to time.Time) (map[int64]int, error) {
+	counts := map[int64]int{}
+	if len(taskIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		TaskID int64 `bun:"task_id"`
+		Views  int   `bun:"views"`
+	}
+	err := db.NewSelect().
+		Model((*models.SocialTaskView)(nil)).
+		Column("task_id").
+		ColumnExpr("SUM(views) AS views").
+		Where("task_id IN (?)", bun.In(taskIDs)).
+		Where("day >= date(?)", from).
+		Where("day < date(?)", to).
+		Group("task_id").
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		counts[row.TaskID] = row.Views
+	}
+
+	return counts, nil
+}
+
+// GetSocialTaskVerificationStats counts per link the verification attempts and the users verified
+func GetSocialTaskVerificationStats(ctx context.Context, db *bun.DB, taskID int64, from time.Time, to time.Time) ([]*models.PartnerTaskLinkStats, error) {
+	rows := []*models.PartnerTaskLinkStats{}
+	err := db.NewSelect().
+		Model((*models.SocialTaskVerification)(nil)).
+		Column("link_id").
+		ColumnExpr("count(*) AS attempts").
+		ColumnExpr("count(DISTINCT user_id) FILTER (WHERE verified) AS completions").
+		Where("task_id = ?", taskID).
+		Where("created_at >= ?", from).
+		Where("created_at < ?", to).
+		Group("link_id").
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SumUserGemsByActions sums the gems credited under each action
+func SumUserGemsByActions(ctx context.Context, db *bun.DB, actions []string, from time.Time, to time.Time) (map[string]int, error) {
+	sums := map[string]int{}
+	if len(actions) == 0 {
+		return sums, nil
+	}
+
+	// the actions of a recurring task end with their period, a link completed again after a clawback with its count
+	patterns := make([]string, len(actions))
+	for i, action := range actions {
+		patterns[i] = likeEscaper.Replace(action) + ":%"
+	}
+
+	var rows []struct {
+		Action string `bun:"action"`
+		Gems   int    `bun:"gems"`
+	}
+	err := db.NewSelect().
+		Model((*models.UserGem)(nil)).
+		Column("action").
+		ColumnExpr("COALESCE(SUM(gems), 0) AS gems").
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.
+				Where("action IN (?)", bun.In(actions)).
+				WhereOr("action LIKE ANY (?)", pgdialect.Array(patterns))
+		}).
+		Where("created_at >= ?", from).
+		Where("created_at < ?", to).
+		Group("action").
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	bases := map[string]bool{}
+	for _, action := range actions {
+		bases[action] = true
+	}
+	// the period and the rejoin count are trimmed off until the base action is found
+	for _, row := range rows {
+		action := row.Action
+		for !bases[action] {
+			i := strings.LastIndex(action, ":")
+			if i < 0 {
+				break
+			}
+			action = action[:i]
+		}
+		if bases[action] {
+			sums[action] += row.Gems
+		}
+	}
+
+	return sums, nil
+}
+
+// CountArenaSessions counts the finished sessions of the arena game started in the range and their players
+func CountArenaSessions(ctx context.Context, db *bun.DB, gameSlug string, from time.Time, to time.Time) (int, int, error) {
+	var row struct {
+		Participants int `bun:"participants"`
+		Sessions     int `bun:"sessions"`
+	}
+	err := db.NewSelect().
+		Model((*models.GameSession)(nil)).
+		ColumnExpr("count(DISTINCT user_id) AS participants").
+		ColumnExpr("count(*) AS sessions").
+		Where("game_slug = ?", gameSlug).
+		Where("ended_at IS NOT NULL").
+		Where("started_at >= ?", from).
+		Where("started_at < ?", to).
+		Scan(ctx, &row)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return row.Participants, row.Sessions, nil
+}
+
+// GetArenaDailyParticipants counts per day the players, the ones playing the arena for the first time and the
+// finished sessions
+func GetArenaDailyParticipants(ctx context.Context, db *bun.DB, gameSlug string, from time.Time, to time.Time) ([]*models.PartnerArenaDay, error) {
+	rows := []*models.PartnerArenaDay{}
+	err := db.NewRaw(`
+		WITH sessions AS (
+			SELECT user_id, started_at FROM game_session
+			WHERE game_slug = ? AND ended_at IS NOT NULL AND started_at >= ? AND started_at < ?
+		), firsts AS (
+			SELECT user_id, MIN(started_at) AS first_at FROM game_session
+			WHERE game_slug = ? AND ended_at IS NOT NULL AND user_id IN (SELECT user_id FROM sessions)
+			GROUP BY user_id
+		)
+		SELECT to_char(date(s.started_at), 'YYYY-MM-DD') AS day,
+			count(DISTINCT s.user_id) AS participants,
+			count(DISTINCT s.user_id) FILTER (WHERE date(f.first_at) = date(s.started_at)) AS new_participants,
+			count(*) AS sessions
+		FROM sessions s
+		JOIN firsts f USING (user_id)
+		GROUP BY day
+		ORDER BY day`, gameSlug, from, to, gameSlug).Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// GetArenaScoreDistribution splits the scores of the finished sessions in buckets of equal width from 0 to the
+// best score, empty buckets are left out
+func GetArenaScoreDistribution(ctx context.Context, db *bun.DB, gameSlug string, from time.Time, to time.Time, buckets int) ([]*models.PartnerArenaScoreBucket, error) {
+	rows := []*models.PartnerArenaScoreBucket{}
+	err := db.NewRaw(`
+		WITH scores AS (
+			SELECT GREATEST(total_score, 0)::bigint AS score FROM game_session
+			WHERE game_slug = ? AND ended_at IS NOT NULL AND started_at >= ? AND started_at < ?
+		), best AS (
+			SELECT COALESCE(MAX(score), 0) + 1 AS top FROM scores
+		), bucketed AS (
+			SELECT score * ? / best.top AS bucket, best.top FROM scores, best
+		)
+		SELECT (bucket * top + ? - 1) / ? AS min_score, ((bucket + 1) * top + ? - 1) / ? - 1 AS max_score, count(*) AS sessions
+		FROM bucketed
+		GROUP BY bucket, top
+		ORDER BY bucket`, gameSlug, from, to, buckets, buckets, buckets, buckets, buckets).Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// GetArenaRetention follows the players whose first finished session of the arena started in the range
+func GetArenaRetention(ctx context.Context, db *bun.DB, gameSlug string, from time.Time, to time.Time) (*models.PartnerArenaRetention, error) {
+	var retention models.PartnerArenaRetention
+	err := db.NewRaw(`
+		WITH firsts AS (
+			SELECT user_id, date(MIN(started_at)) AS first_day FROM game_session
+			WHERE game_slug = ? AND ended_at IS NOT NULL
+			GROUP BY user_id
+			HAVING MIN(started_at) >= ? AND MIN(started_at) < ?
+		), days AS (
+			SELECT DISTINCT gs.user_id, date(gs.started_at) AS day FROM game_session gs
+			JOIN firsts USING (user_id)
+			WHERE gs.game_slug = ? AND gs.ended_at IS NOT NULL
+		)
+		SELECT count(DISTINCT f.user_id) AS participants,
+			count(DISTINCT d.user_id) FILTER (WHERE d.day > f.first_day) AS returned,
+			count(DISTINCT d.user_id) FILTER (WHERE d.day = f.first_day + 1) AS day1,
+			count(DISTINCT d.user_id) FILTER (WHERE d.day = f.first_day + 7) AS day7
+		FROM firsts f
+		LEFT JOIN days d USING (user_id)`, gameSlug, from, to, gameSlug).Scan(ctx, &retention)
+	if err != nil {
+		return nil, err
+	}
+
+	return &retention, nil
+}
@@ -0,0 +1,260 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+func CreateTablePartnerWebhook(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.PartnerWebhook)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.PartnerWebhook)(nil)).Index("index_partner_webhook_partner_id").IfNotExists().Column("partner_id").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*models.PartnerWebhookDelivery)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.PartnerWebhookDelivery)(nil)).Index("index_partner_webhook_delivery_webhook_id_event_key").IfNotExists().Unique().Column("webhook_id", "event_key").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.PartnerWebhookDelivery)(nil)).Index("index_partner_webhook_delivery_status_next_attempt_at").IfNotExists().Column("status", "next_attempt_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.PartnerWebhookDelivery)(nil)).Index("index_partner_webhook_delivery_partner_id_created_at").IfNotExists().Column("partner_id", "created_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func InsertPartnerWebhook(ctx context.Context, db *bun.DB, webhook *models.PartnerWebhook) error {
+	_, err := db.NewInsert().Model(webhook).Returning("*").Exec(ctx)
+	return err
+}
+
+func GetPartnerWebhooks(ctx context.Context, db *bun.DB, partnerID int64) ([]models.PartnerWebhook, error) {
+	webhooks := []models.PartnerWebhook{}
+	err := db.NewSelect().Model(&webhooks).Where("partner_id = ?", partnerID).Order("id ASC").Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func GetPartnerWebhook(ctx context.Context, db *bun.DB, partnerID int64, id int64) (*models.PartnerWebhook, error) {
+	var webhook models.PartnerWebhook
+	err := db.NewSelect().Model(&webhook).Where("id = ?", id).Where("partner_id = ?", partnerID).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// GetPartnerWebhooksByEvent returns the enabled webhooks of the partner subscribed to the event
+func GetPartnerWebhooksByEvent(ctx context.Context, db bun.IDB, partnerID int64, event string) ([]models.PartnerWebhook, error) {
+	webhooks := []models.PartnerWebhook{}
+	err := db.NewSelect().Model(&webhooks).
+		Where("partner_id = ?", partnerID).
+		Where("enabled = ?", true).
+		Where("? = ANY(events)", event).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetEnabledPartnerWebhooksByEvent returns the enabled webhooks of every enabled partner subscribed to the event
+func GetEnabledPartnerWebhooksByEvent(ctx context.Context, db *bun.DB, event string) ([]models.PartnerWebhook, error) {
+	webhooks := []models.PartnerWebhook{}
+	err := db.NewSelect().Model(&webhooks).
+		Where("enabled = ?", true).
+		Where("? = ANY(events)", event).
+		Where("EXISTS (SELECT 1 FROM partner WHERE partner.id = partner_webhook.partner_id AND partner.enabled)").
+		Order("id ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func CountPartnerWebhooks(ctx context.Context, db *bun.DB, partnerID int64) (int, error) {
+	return db.NewSelect().Model((*models.PartnerWebhook)(nil)).Where("partner_id = ?", partnerID).Count(ctx)
+}
+
+// DeletePartnerWebhook removes the webhook with its delivery log, it returns false when the webhook is not found
+func DeletePartnerWebhook(ctx context.Context, db *bun.DB, partnerID int64, id int64) (bool, error) {
+	deleted := false
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().Model((*models.PartnerWebhook)(nil)).Where("id = ?", id).Where("partner_id = ?", partnerID).Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil || n == 0 {
+			return err
+		}
+		deleted = true
+
+		_, err = tx.NewDelete().Model((*models.PartnerWebhookDelivery)(nil)).Where("webhook_id = ?", id).Exec(ctx)
+		return err
+	})
+
+	return deleted, err
+}
+
+// InsertPartnerWebhookDeliveries queues the deliveries, an event already queued for a webhook is skipped
+func InsertPartnerWebhookDeliveries(ctx context.Context, db bun.IDB, deliveries []*models.PartnerWebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	_, err := db.NewInsert().Model(&deliveries).On("CONFLICT (webhook_id, event_key) DO NOTHING").Exec(ctx)
+	return err
+}
+
+// ClaimDuePartnerWebhookDeliveries picks the pending deliveries due at now and pushes their next attempt
+// by lease, so a concurrent run does not send them again while they are in flight
+func ClaimDuePartnerWebhookDeliveries(ctx context.Context, db *bun.DB, now time.Time, lease time.Duration, limit int) ([]models.PartnerWebhookDelivery, error) {
+	deliveries := []models.PartnerWebhookDelivery{}
+	err := db.NewRaw(`
+		UPDATE partner_webhook_delivery SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM partner_webhook_delivery
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at ASC
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, now.Add(lease), models.PartnerWebhookDeliveryPending, now, limit).Scan(ctx, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func MarkPartnerWebhookDelivered(ctx context.Context, db *bun.DB, id int64, responseCode int, at time.Time) error {
+	_, err := db.NewUpdate().Model((*models.PartnerWebhookDelivery)(nil)).
+		Set("status = ?", models.PartnerWebhookDeliveryDelivered).
+		Set("attempts = attempts + 1").
+		Set("response_code = ?", responseCode).
+		Set("last_error = NULL").
+		Set("delivered_at = ?", at).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+// MarkPartnerWebhookFailed records a failed attempt, the delivery is retried at next or moved to the
+// dead-letter queue when dead is set
+func MarkPartnerWebhookFailed(ctx context.Context, db *bun.DB, id int64, responseCode int, lastError string, next time.Time, dead bool) error {
+	status := models.PartnerWebhookDeliveryPending
+	if dead {
+		status = models.PartnerWebhookDeliveryDead
+	}
+
+	_, err := db.NewUpdate().Model((*models.PartnerWebhookDelivery)(nil)).
+		Set("status = ?", status).
+		Set("attempts = attempts + 1").
+		Set("response_code = ?", responseCode).
+		Set("last_error = ?", lastError).
+		Set("next_attempt_at = ?", next).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+func GetPartnerWebhookDeliveries(ctx context.Context, db *bun.DB, partnerID int64, webhookID int64, status string, limit int, offset int) ([]models.PartnerWebhookDelivery, error) {
+	deliveries := []models.PartnerWebhookDelivery{}
+	q := db.NewSelect().Model(&deliveries).Where("partner_id = ?", partnerID)
+	if webhookID > 0 {
+		q = q.Where("webhook_id = ?", webhookID)
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+
+	err := q.Order("id DESC").Limit(limit).Offset(offset).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ReplayPartnerWebhookDelivery queues a delivery again with a fresh attempt budget, it returns false when
+// the delivery is not found or is still pending
+func ReplayPartnerWebhookDelivery(ctx context.Context, db *bun.DB, partnerID int64, id int64, now time.Time) (bool, error) {
+	res, err := db.NewUpdate().Model((*models.PartnerWebhookDelivery)(nil)).
+		Set("status = ?", models.PartnerWebhookDeliveryPending).
+		Set("attempts = 0").
+		Set("next_attempt_at = ?", now).
+		Where("id = ?", id).
+		Where("partner_id = ?", partnerID).
+		Where("status <> ?", models.PartnerWebhookDeliveryPending).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ReplayDeadPartnerWebhookDeliveries drains the dead-letter queue of a webhook back to pending
+func ReplayDeadPartnerWebhookDeliveries(ctx context.Context, db *bun.DB, partnerID int64, webhookID int64, now time.Time) (int, error) {
+	res, err := db.NewUpdate().Model((*models.PartnerWebhookDelivery)(nil)).
+		Set("status = ?", models.PartnerWebhookDeliveryPending).
+		Set("attempts = 0").
+		Set("next_attempt_at = ?", now).
+		Where("webhook_id = ?", webhookID).
+		Where("partner_id = ?", partnerID).
+		Where("status = ?", models.PartnerWebhookDeliveryDead).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// GetPartnerInviteesReachingGems returns the users invited with refCode since the given time whose total gems
+// reached minGems and who are not queued yet for the webhook under keyPrefix || user id
+func GetPartnerInviteesReachingGems(ctx context.Context, db *bun.DB, webhookID int64, keyPrefix string, refCode string, since time.Time, minGems int, limit int) ([]*models.TotalGem, error) {
+	rows := []*models.TotalGem{}
+	err := db.NewRaw(`
+		SELECT u.id AS user_id, SUM(g.gems) AS total_gems, MAX(g.created_at) AS last_earned_at
+		FROM "user" u
+		JOIN user_gem g ON g.user_id = u.id
+		WHERE u.inviter_id = ? AND u.created_at >= ?
+			AND NOT EXISTS (
+				SELECT 1 FROM partner_webhook_delivery d WHERE d.webhook_id = ? AND d.event_key = ? || u.id
+			)
+		GROUP BY u.id
+		HAVING SUM(g.gems) >= ?
+		LIMIT ?`, refCode, since, webhookID, keyPrefix, minGems, limit).Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
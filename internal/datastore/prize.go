@@ -0,0 +1,103 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+func CreateTablePrizeDistribution(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.PrizeDistribution)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	// prize rewards are created once per campaign and user, hand made rewards are not constrained
+	_, err = db.NewRaw(`
+		create unique index if not exists index_reward_prize_campaign_user_id
+			on reward (campaign, user_id) where campaign like 'prize:%';`).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetPrizeDistribution(ctx context.Context, db *bun.DB, campaign string) (*models.PrizeDistribution, error) {
+	var distribution models.PrizeDistribution
+	err := db.NewSelect().Model(&distribution).Where("campaign = ?", campaign).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &distribution, nil
+}
+
+// DistributePrizes freezes the winners and creates their rewards, a campaign already distributed is returned untouched
+func DistributePrizes(ctx context.Context, db *bun.DB, distribution *models.PrizeDistribution) (bool, error) {
+	created := false
+	err := db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewInsert().Model(distribution).On("CONFLICT (campaign) DO NOTHING").Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			return tx.NewSelect().Model(distribution).Where("campaign = ?", distribution.Campaign).Scan(ctx)
+		}
+
+		created = true
+		for _, winner := range distribution.Winners {
+			reward := &models.Reward{
+				Campaign: distribution.Campaign,
+				UserID:   winner.UserID,
+				Gem:      winner.Gem,
+				Star:     winner.Star,
+				Lifeline: winner.Lifeline,
+				Metadata: map[string]interface{}{
+					"rank":       winner.Rank,
+					"score":      winner.Score,
+					"source":     distribution.Source,
+					"source_ref": distribution.SourceRef,
+				},
+				UpdatedAt: time.Now(),
+			}
+			if winner.Payout != nil {
+				reward.Metadata["payout"] = winner.Payout
+			}
+
+			_, err := tx.NewInsert().Model(reward).On("CONFLICT (campaign, user_id) WHERE campaign LIKE 'prize:%' DO NOTHING").Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("reward of %s: %w", winner.UserID, err)
+			}
+		}
+
+		return nil
+	})
+
+	return created, err
+}
+
+func GetArchivedSeasonsWithPrizes(ctx context.Context, db *bun.DB) ([]models.Season, error) {
+	var seasons []models.Season
+	err := db.NewSelect().Model(&seasons).
+		Where("status = ?", models.SeasonStatusArchived).
+		Where("CASE WHEN jsonb_typeof(prizes) = 'array' THEN jsonb_array_length(prizes) ELSE 0 END > 0").
+		Where("NOT EXISTS (SELECT 1 FROM prize_distribution WHERE prize_distribution.source = ? AND prize_distribution.source_ref = season.id::text)", models.PrizeSourceSeason).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return seasons, nil
+}
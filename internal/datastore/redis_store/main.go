@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -63,6 +64,14 @@ func dbKeyMostMinusPoint(gameId string) string {
 	return fmt.Sprintf("game:%s:most_minus_point", gameId)
 }
 
+// dbKeyGameRecord is keyed by user id, apart from the record keys above keyed by username
+func dbKeyGameRecord(gameId string, record string, bucket string) string {
+	if bucket == "" {
+		return fmt.Sprintf("game:%s:records:%s", gameId, record)
+	}
+	return fmt.Sprintf("game:%s:records:%s:%s", gameId, record, bucket)
+}
+
 func dbKeyLeaderboard(gameSlug string) string {
 	return fmt.Sprintf("leaderboard:%s", strings.ToLower(gameSlug))
 }
@@ -245,9 +254,59 @@ func GetUserLastNotify(ctx context.Context, cmd redis.Cmdable, userID string) (t
 	return time.Parse(time.RFC3339, result)
 }
 
+// Leaderboard members are stored as score + tie-break fraction, the fraction shrinks as time passes
+// so at equal scores whoever reached the score first ranks higher instead of the member order deciding
+var leaderboardEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const leaderboardTieBreakSpan = 1 << 31 // seconds
+
+func EncodeLeaderboardScore(score float64, reachedAt time.Time) float64 {
+	elapsed := int64(reachedAt.Sub(leaderboardEpoch) / time.Second)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if elapsed > leaderboardTieBreakSpan {
+		elapsed = leaderboardTieBreakSpan
+	}
+
+	return math.Floor(score) + float64(leaderboardTieBreakSpan-elapsed)/float64(leaderboardTieBreakSpan+1)
+}
+
+func DecodeLeaderboardScore(value float64) (float64, time.Time) {
+	score := math.Floor(value)
+	elapsed := leaderboardTieBreakSpan - int64(math.Round((value-score)*float64(leaderboardTieBreakSpan+1)))
+
+	return score, leaderboardEpoch.Add(time.Duration(elapsed) * time.Second)
+}
+
+func decodeLeaderboardItem(member string, value float64, rank int) *models.LeaderboardItem {
+	score, reachedAt := DecodeLeaderboardScore(value)
+	return &models.LeaderboardItem{
+		UserId:    member,
+		Score:     score,
+		Rank:      rank,
+		ReachedAt: &reachedAt,
+	}
+}
+
+// SetLeaderboard keeps the time already recorded when the score did not change, v.ReachedAt defaults to now
+// and is set to the time stored so callers can mirror the entry into other boards
 func SetLeaderboard(ctx context.Context, cmd redis.Cmdable, gameSlug string, v *models.LeaderboardItem) (*models.LeaderboardItem, error) {
-	err := cmd.ZAdd(ctx, dbKeyLeaderboard(gameSlug), redis.Z{
-		Score:  v.Score,
+	current, err := cmd.ZScore(ctx, dbKeyLeaderboard(gameSlug), v.UserId).Result()
+	if err == nil && math.Floor(current) == math.Floor(v.Score) && v.ReachedAt == nil {
+		_, reachedAt := DecodeLeaderboardScore(current)
+		v.ReachedAt = &reachedAt
+		return v, nil
+	}
+
+	reachedAt := time.Now()
+	if v.ReachedAt != nil {
+		reachedAt = *v.ReachedAt
+	}
+	v.ReachedAt = &reachedAt
+
+	err = cmd.ZAdd(ctx, dbKeyLeaderboard(gameSlug), redis.Z{
+		Score:  EncodeLeaderboardScore(v.Score, reachedAt),
 		Member: v.UserId,
 	}).Err()
 
@@ -276,12 +335,7 @@ func GetLeaderboard(ctx context.Context, cmd redis.Cmdable, gameSlug string, num
 
 	var results []*models.LeaderboardItem
 	for i, item := range items {
-		id := item.Member.(string)
-		results = append(results, &models.LeaderboardItem{
-			UserId: id,
-			Score:  item.Score,
-			Rank:   i + 1,
-		})
+		results = append(results, decodeLeaderboardItem(item.Member.(string), item.Score, i+1))
 	}
 
 	return results, nil
@@ -289,7 +343,7 @@ func GetLeaderboard(ctx context.Context, cmd redis.Cmdable, gameSlug string, num
 
 func GetLeaderboardWithScoreThreshold(ctx context.Context, cmd redis.Cmdable, gameSlug string, scoreThreshold int) (int, error) {
 	items, err := cmd.ZRangeByScore(ctx, dbKeyLeaderboard(gameSlug), &redis.ZRangeBy{
-		Min: fmt.Sprintf("(%d", scoreThreshold),
+		Min: fmt.Sprintf("%d", scoreThreshold+1),
 		Max: "+inf",
 	}).Result()
 
@@ -345,15 +399,17 @@ func GetRankWithScore(ctx context.Context, cmd redis.Cmdable, gameSlug string, u
 		return redis.RankScore{}, err
 	}
 
+	rank.Score, _ = DecodeLeaderboardScore(rank.Score)
 	return rank, nil
 }
 
 func GetScore(ctx context.Context, cmd redis.Cmdable, gameSlug string, user *models.User) (float64, error) {
-	score, err := cmd.ZScore(ctx, dbKeyLeaderboard(gameSlug), user.ID).Result()
+	value, err := cmd.ZScore(ctx, dbKeyLeaderboard(gameSlug), user.ID).Result()
 	if err != nil {
 		return -1, err
 	}
 
+	score, _ := DecodeLeaderboardScore(value)
 	return score, nil
 
 }
@@ -376,8 +432,8 @@ func GetJoinSocial(ctx context.Context, cmd redis.Cmdable, userID string, social
 	return true, err
 }
 
-func SetJoinSocial(ctx context.Context, cmd redis.Cmdable, userID string, socialLink string) error {
-	err := cmd.Set(ctx, dbKeyJoinedSocialLink(userID, socialLink), true, 0).Err()
+func SetJoinSocial(ctx context.Context, cmd redis.Cmdable, userID string, socialLink string, expiration time.Duration) error {
+	err := cmd.Set(ctx, dbKeyJoinedSocialLink(userID, socialLink), true, expiration).Err()
 	if err != nil {
 		return err
 	}
@@ -385,6 +441,10 @@ func SetJoinSocial(ctx context.Context, cmd redis.Cmdable, userID string, social
 	return err
 }
 
+func DelJoinSocial(ctx context.Context, cmd redis.Cmdable, userID string, socialLink string) error {
+	return cmd.Del(ctx, dbKeyJoinedSocialLink(userID, socialLink)).Err()
+}
+
 func SetMostPlayedSession(ctx context.Context, cmd redis.Cmdable, gameSlug string, v *models.MostSessions) error {
 	err := cmd.ZAdd(ctx, dbKeyMostPlayedSession(gameSlug), redis.Z{
 		Score:  float64(v.TotalSessions),
@@ -501,6 +561,67 @@ func GetMostMinusPoint(ctx context.Context, cmd redis.Cmdable, gameSlug string,
 	return results, nil
 }
 
+const (
+	GameRecordModeMax  = "max"
+	GameRecordModeMin  = "min"
+	GameRecordModeIncr = "incr"
+)
+
+// UpdateGameRecord keeps the best value per user, or accumulates it for incr records
+func UpdateGameRecord(ctx context.Context, cmd redis.Cmdable, gameSlug string, record string, bucket string, mode string, userID string, value float64, ttl time.Duration) error {
+	key := dbKeyGameRecord(gameSlug, record, bucket)
+
+	var err error
+	switch mode {
+	case GameRecordModeIncr:
+		err = cmd.ZIncrBy(ctx, key, value, userID).Err()
+	case GameRecordModeMin:
+		err = cmd.ZAddLT(ctx, key, redis.Z{Score: value, Member: userID}).Err()
+	default:
+		err = cmd.ZAddGT(ctx, key, redis.Z{Score: value, Member: userID}).Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	if ttl > 0 {
+		return cmd.Expire(ctx, key, ttl).Err()
+	}
+
+	return nil
+}
+
+// SetGameRecord overwrites the value of the user, for a backfill
+func SetGameRecord(ctx context.Context, cmd redis.Cmdable, gameSlug string, record string, bucket string, userID string, value float64) error {
+	return cmd.ZAdd(ctx, dbKeyGameRecord(gameSlug, record, bucket), redis.Z{Score: value, Member: userID}).Err()
+}
+
+func GetGameRecord(ctx context.Context, cmd redis.Cmdable, gameSlug string, record string, bucket string, ascending bool, num int) ([]*models.LeaderboardItem, error) {
+	key := dbKeyGameRecord(gameSlug, record, bucket)
+
+	var items []redis.Z
+	var err error
+	if ascending {
+		items, err = cmd.ZRangeWithScores(ctx, key, 0, int64(num-1)).Result()
+	} else {
+		items, err = cmd.ZRevRangeWithScores(ctx, key, 0, int64(num-1)).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*models.LeaderboardItem{}
+	for i, item := range items {
+		results = append(results, &models.LeaderboardItem{
+			UserId: item.Member.(string),
+			Score:  item.Score,
+			Rank:   i + 1,
+		})
+	}
+
+	return results, nil
+}
+
 func CheckSocialTaskLimit(ctx context.Context, cmd redis.Cmdable, userID string) (bool, error) {
 	number, err := cmd.Get(ctx, dbKeyCheckSocialTaskCount(userID)).Result()
 	if err != nil && err != redis.Nil {
@@ -678,3 +799,246 @@ func GetSendMessageUser(ctx context.Context, cmd redis.Cmdable, userId string) (
 
 	return true, nil
 }
+
+func RemoveFromLeaderboard(ctx context.Context, cmd redis.Cmdable, gameSlug string, userID string) error {
+	return cmd.ZRem(ctx, dbKeyLeaderboard(gameSlug), userID).Err()
+}
+
+func dbKeyIdempotency(scope string, key string) string {
+	return fmt.Sprintf("idempotency:%s:%s", scope, key)
+}
+
+// ReserveIdempotencyKey stores an in-flight record if the key is unused, it returns false when the key already exists
+func ReserveIdempotencyKey(ctx context.Context, cmd redis.Cmdable, scope string, key string, v *models.IdempotencyRecord, expiration time.Duration) (bool, error) {
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+
+	return cmd.SetNX(ctx, dbKeyIdempotency(scope, key), b, expiration).Result()
+}
+
+func SetIdempotencyRecord(ctx context.Context, cmd redis.Cmdable, scope string, key string, v *models.IdempotencyRecord, expiration time.Duration) error {
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return cmd.Set(ctx, dbKeyIdempotency(scope, key), b, expiration).Err()
+}
+
+func GetIdempotencyRecord(ctx context.Context, cmd redis.Cmdable, scope string, key string) (*models.IdempotencyRecord, error) {
+	var v *models.IdempotencyRecord
+	b, err := cmd.Get(ctx, dbKeyIdempotency(scope, key)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = msgpack.Unmarshal(b, &v)
+	return v, err
+}
+
+func DeleteIdempotencyRecord(ctx context.Context, cmd redis.Cmdable, scope string, key string) error {
+	return cmd.Del(ctx, dbKeyIdempotency(scope, key)).Err()
+}
+
+func dbKeyAntiCheatTiming(gameSlug string, fingerprint string) string {
+	return fmt.Sprintf("anti_cheat:timing:%s:%s", gameSlug, fingerprint)
+}
+
+func dbKeyAntiCheatRestriction(action string) string {
+	return fmt.Sprintf("anti_cheat:restriction:%s", action)
+}
+
+// AddAntiCheatTiming registers the user under an answer timing fingerprint and returns every user sharing it
+func AddAntiCheatTiming(ctx context.Context, cmd redis.Cmdable, gameSlug string, fingerprint string, userID string, expiration time.Duration) ([]string, error) {
+	key := dbKeyAntiCheatTiming(gameSlug, fingerprint)
+	err := cmd.SAdd(ctx, key, userID).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Expire(ctx, key, expiration).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return cmd.SMembers(ctx, key).Result()
+}
+
+func AddAntiCheatRestriction(ctx context.Context, cmd redis.Cmdable, action string, userID string) error {
+	return cmd.SAdd(ctx, dbKeyAntiCheatRestriction(action), userID).Err()
+}
+
+func RemoveAntiCheatRestriction(ctx context.Context, cmd redis.Cmdable, action string, userID string) error {
+	return cmd.SRem(ctx, dbKeyAntiCheatRestriction(action), userID).Err()
+}
+
+func IsAntiCheatRestricted(ctx context.Context, cmd redis.Cmdable, action string, userID string) (bool, error) {
+	return cmd.SIsMember(ctx, dbKeyAntiCheatRestriction(action), userID).Result()
+}
+
+func dbKeyShadowBannedUsers() string {
+	return "moderation:shadow_banned"
+}
+
+func AddShadowBannedUser(ctx context.Context, cmd redis.Cmdable, userID string) error {
+	return cmd.SAdd(ctx, dbKeyShadowBannedUsers(), userID).Err()
+}
+
+func RemoveShadowBannedUser(ctx context.Context, cmd redis.Cmdable, userID string) error {
+	return cmd.SRem(ctx, dbKeyShadowBannedUsers(), userID).Err()
+}
+
+func GetShadowBannedUsers(ctx context.Context, cmd redis.Cmdable) ([]string, error) {
+	return cmd.SMembers(ctx, dbKeyShadowBannedUsers()).Result()
+}
+
+// GetRanks looks up the zero-based ranks of many users in one pipeline, unranked users are omitted
+func GetRanks(ctx context.Context, cmd redis.Cmdable, gameSlug string, userIDs []string) (map[string]int64, error) {
+	ranks := map[string]int64{}
+	if len(userIDs) == 0 {
+		return ranks, nil
+	}
+
+	pipe := cmd.Pipeline()
+	cmds := make([]*redis.IntCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.ZRevRank(ctx, dbKeyLeaderboard(gameSlug), userID)
+	}
+
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for i, c := range cmds {
+		rank, err := c.Result()
+		if err != nil {
+			continue
+		}
+		ranks[userIDs[i]] = rank
+	}
+
+	return ranks, nil
+}
+
+// GetScores looks up the raw values of many users with a single ZMSCORE, members missing from the board score 0.
+// The values keep the tie-break, DecodeLeaderboardScore gives the score
+func GetScores(ctx context.Context, cmd redis.Cmdable, gameSlug string, userIDs []string) ([]float64, error) {
+	if len(userIDs) == 0 {
+		return []float64{}, nil
+	}
+
+	return cmd.ZMScore(ctx, dbKeyLeaderboard(gameSlug), userIDs...).Result()
+}
+
+// GetLeaderboardItem returns the user's decoded entry including the time the score was reached
+func GetLeaderboardItem(ctx context.Context, cmd redis.Cmdable, gameSlug string, userID string) (*models.LeaderboardItem, error) {
+	value, err := cmd.ZScore(ctx, dbKeyLeaderboard(gameSlug), userID).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeLeaderboardItem(userID, value, 0), nil
+}
+
+// GetLeaderboardRaw returns a page of members with their stored values, used to rebuild boards
+func GetLeaderboardRaw(ctx context.Context, cmd redis.Cmdable, gameSlug string, offset int, num int) ([]redis.Z, error) {
+	return cmd.ZRevRangeWithScores(ctx, dbKeyLeaderboard(gameSlug), int64(offset), int64(offset+num-1)).Result()
+}
+
+func dbKeyLeaderboardRegions() string {
+	return "leaderboard:regions"
+}
+
+func AddLeaderboardRegion(ctx context.Context, cmd redis.Cmdable, region string) error {
+	return cmd.SAdd(ctx, dbKeyLeaderboardRegions(), region).Err()
+}
+
+func GetLeaderboardRegions(ctx context.Context, cmd redis.Cmdable) ([]string, error) {
+	return cmd.SMembers(ctx, dbKeyLeaderboardRegions()).Result()
+}
+
+func dbKeySeasonStart(leaderboard string) string {
+	return fmt.Sprintf("season:%s:start", leaderboard)
+}
+
+func GetLeaderboardPage(ctx context.Context, cmd redis.Cmdable, gameSlug string, offset int, num int) ([]*models.LeaderboardItem, error) {
+	items, err := cmd.ZRevRangeWithScores(ctx, dbKeyLeaderboard(gameSlug), int64(offset), int64(offset+num-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*models.LeaderboardItem
+	for i, item := range items {
+		results = append(results, decodeLeaderboardItem(item.Member.(string), item.Score, offset+i+1))
+	}
+
+	return results, nil
+}
+
+// GetSeasonStart returns the start of the season the live sorted set belongs to, zero when unknown
+func GetSeasonStart(ctx context.Context, cmd redis.Cmdable, leaderboard string) (time.Time, error) {
+	value, err := cmd.Get(ctx, dbKeySeasonStart(leaderboard)).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(value, 0), nil
+}
+
+func SetSeasonStart(ctx context.Context, cmd redis.Cmdable, leaderboard string, start time.Time) error {
+	return cmd.Set(ctx, dbKeySeasonStart(leaderboard), start.Unix(), 0).Err()
+}
+
+func dbKeySocialTaskViews(taskID int64, day string) string {
+	return fmt.Sprintf("social_task:views:%d:%s", taskID, day)
+}
+
+// IncrSocialTaskView counts a view of the task on the day, the counts are flushed to the database by the cron
+func IncrSocialTaskView(ctx context.Context, cmd redis.Cmdable, taskID int64, day time.Time) error {
+	return cmd.Incr(ctx, dbKeySocialTaskViews(taskID, day.Format("2006-01-02"))).Err()
+}
+
+// PopSocialTaskViews takes the counted views out of redis, a view counted meanwhile starts a new key
+func PopSocialTaskViews(ctx context.Context, cmd redis.Cmdable) ([]*models.SocialTaskView, error) {
+	var views []*models.SocialTaskView
+	iter := cmd.Scan(ctx, 0, "social_task:views:*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		parts := strings.Split(strings.TrimPrefix(key, "social_task:views:"), ":")
+		if len(parts) != 2 {
+			continue
+		}
+
+		taskID, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", parts[1])
+		if err != nil {
+			continue
+		}
+
+		count, err := cmd.GetDel(ctx, key).Int()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return views, err
+		}
+
+		views = append(views, &models.SocialTaskView{TaskID: taskID, Day: day, Views: count})
+	}
+
+	return views, iter.Err()
+}
+
+// RestoreSocialTaskView puts back views that could not be flushed
+func RestoreSocialTaskView(ctx context.Context, cmd redis.Cmdable, view *models.SocialTaskView) error {
+	return cmd.IncrBy(ctx, dbKeySocialTaskViews(view.TaskID, view.Day.Format("2006-01-02")), int64(view.Views)).Err()
+}
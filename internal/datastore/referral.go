@@ -0,0 +1,364 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+func CreateTableReferralCommission(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.ReferralCommission)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.ReferralCommission)(nil)).Index("index_referral_commission_inviter_source").IfNotExists().Unique().Column("inviter_id", "source_action").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.ReferralCommission)(nil)).Index("index_referral_commission_inviter_created_at").IfNotExists().Column("inviter_id", "created_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewRaw(`
+		alter table referral_commission
+			add if not exists status varchar not null default 'paid';`).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.ReferralCommission)(nil)).Index("index_referral_commission_invitee_status").IfNotExists().Column("invitee_id", "status").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CreateTableReferralValidation(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.ReferralValidation)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.ReferralValidation)(nil)).Index("index_referral_validation_inviter_status").IfNotExists().Column("inviter_id", "status").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.ReferralValidation)(nil)).Index("index_referral_validation_status_created_at").IfNotExists().Column("status", "created_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	// invitees referred before the validation existed keep their credit
+	_, err = db.NewRaw(`
+		insert into referral_validation (invitee_id, inviter_id, status, created_at, resolved_at)
+			select id, inviter_id, ?, created_at, created_at from "user" where inviter_id is not null
+			on conflict do nothing;`, models.ReferralStatusValidated).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertReferralCommission records the commission and credits the gems in one transaction,
+// it returns false when the commission for this source was already recorded.
+// A pending commission is only credited once the referral is validated
+func InsertReferralCommission(ctx context.Context, db *bun.DB, commission *models.ReferralCommission) (bool, error) {
+	inserted := false
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewInsert().Model(commission).On("CONFLICT (inviter_id, source_action) DO NOTHING").Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil || affected == 0 {
+			return err
+		}
+
+		if commission.Status == models.ReferralCommissionStatusPaid {
+			if err := creditReferralCommission(ctx, tx, commission); err != nil {
+				return err
+			}
+		}
+
+		inserted = true
+		return nil
+	})
+
+	return inserted, err
+}
+
+func creditReferralCommission(ctx context.Context, tx bun.Tx, commission *models.ReferralCommission) error {
+	_, err := tx.NewInsert().Model(&models.UserGem{
+		UserID: commission.InviterID,
+		Gems:   commission.Gems,
+		Action: commission.GemAction(),
+	}).On("CONFLICT (user_id, action) DO NOTHING").Exec(ctx)
+	return err
+}
+
+func SumReferralCommissionByInvitee(ctx context.Context, db *bun.DB, inviterID string, inviteeID string) (int, error) {
+	var total int
+	err := db.NewSelect().Model((*models.ReferralCommission)(nil)).
+		ColumnExpr("COALESCE(SUM(gems), 0)").
+		Where("inviter_id = ?", inviterID).
+		Where("invitee_id = ?", inviteeID).
+		Where("status != ?", models.ReferralCommissionStatusVoid).
+		Scan(ctx, &total)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func SumReferralCommissionFromTime(ctx context.Context, db *bun.DB, inviterID string, from time.Time) (int, error) {
+	var total int
+	err := db.NewSelect().Model((*models.ReferralCommission)(nil)).
+		ColumnExpr("COALESCE(SUM(gems), 0)").
+		Where("inviter_id = ?", inviterID).
+		Where("created_at >= ?", from).
+		Where("status != ?", models.ReferralCommissionStatusVoid).
+		Scan(ctx, &total)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func GetReferralEarningsByLevel(ctx context.Context, db *bun.DB, inviterID string) ([]*models.ReferralEarningLevel, error) {
+	levels := []*models.ReferralEarningLevel{}
+	err := db.NewSelect().Model((*models.ReferralCommission)(nil)).
+		ColumnExpr("level").
+		ColumnExpr("SUM(gems) AS gems").
+		ColumnExpr("COUNT(DISTINCT invitee_id) AS invitees").
+		Where("inviter_id = ?", inviterID).
+		Where("status = ?", models.ReferralCommissionStatusPaid).
+		GroupExpr("level").
+		OrderExpr("level ASC").
+		Scan(ctx, &levels)
+	if err != nil {
+		return nil, err
+	}
+
+	return levels, nil
+}
+
+func GetReferralValidation(ctx context.Context, db *bun.DB, inviteeID string) (*models.ReferralValidation, error) {
+	var validation models.ReferralValidation
+	err := db.NewSelect().Model(&validation).Where("invitee_id = ?", inviteeID).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &validation, nil
+}
+
+// GetPendingReferralValidations pages through the pending referrals oldest first, after the given one when set
+func GetPendingReferralValidations(ctx context.Context, db *bun.DB, after *models.ReferralValidation, limit int) ([]models.ReferralValidation, error) {
+	var validations []models.ReferralValidation
+	q := db.NewSelect().Model(&validations).
+		Where("status = ?", models.ReferralStatusPending)
+	if after != nil {
+		q = q.Where("(created_at, invitee_id) > (?, ?)", after.CreatedAt, after.InviteeID)
+	}
+
+	err := q.Order("created_at ASC", "invitee_id ASC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return validations, nil
+}
+
+// GetReferralActivity counts the ended sessions, the distinct days played and the gems of the invitee
+func GetReferralActivity(ctx context.Context, db *bun.DB, inviteeID string) (*models.ReferralActivity, error) {
+	var activity models.ReferralActivity
+	err := db.NewSelect().
+		TableExpr("game_session").
+		ColumnExpr("count(*) AS sessions").
+		ColumnExpr("count(DISTINCT date(ended_at)) AS active_days").
+		ColumnExpr("(SELECT COALESCE(SUM(gems), 0) FROM user_gem WHERE user_id = ?) AS gems", inviteeID).
+		Where("user_id = ?", inviteeID).
+		Where("ended_at IS NOT NULL").
+		Scan(ctx, &activity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &activity, nil
+}
+
+// ValidateReferral credits the invite to the inviter and pays the commissions held for the invitee,
+// it returns the commissions paid or nil when the referral was not pending anymore
+func ValidateReferral(ctx context.Context, db *bun.DB, inviteeID string) ([]models.ReferralCommission, error) {
+	var commissions []models.ReferralCommission
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var validation models.ReferralValidation
+		err := tx.NewSelect().Model(&validation).
+			Where("invitee_id = ?", inviteeID).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		if validation.Status != models.ReferralStatusPending {
+			return nil
+		}
+
+		if _, err := tx.NewUpdate().Model((*models.ReferralValidation)(nil)).
+			Set("status = ?", models.ReferralStatusValidated).
+			Set("resolved_at = current_timestamp").
+			Where("invitee_id = ?", inviteeID).
+			Exec(ctx); err != nil {
+			return err
+		}
+
+		if _, err := tx.NewUpdate().
+			Model((*models.User)(nil)).
+			Set("total_invites = total_invites + 1").
+			Where("id = ?", validation.InviterID).
+			Exec(ctx); err != nil {
+			return err
+		}
+
+		err = tx.NewSelect().Model(&commissions).
+			Where("invitee_id = ?", inviteeID).
+			Where("status = ?", models.ReferralCommissionStatusPending).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := range commissions {
+			if err := creditReferralCommission(ctx, tx, &commissions[i]); err != nil {
+				return err
+			}
+			commissions[i].Status = models.ReferralCommissionStatusPaid
+		}
+
+		if len(commissions) > 0 {
+			_, err = tx.NewUpdate().Model((*models.ReferralCommission)(nil)).
+				Set("status = ?", models.ReferralCommissionStatusPaid).
+				Where("invitee_id = ?", inviteeID).
+				Where("status = ?", models.ReferralCommissionStatusPending).
+				Exec(ctx)
+		}
+
+		if commissions == nil {
+			commissions = []models.ReferralCommission{}
+		}
+
+		return err
+	})
+
+	return commissions, err
+}
+
+// RejectReferral voids the referral and the commissions held for the invitee, it returns false when the referral was not pending anymore
+func RejectReferral(ctx context.Context, db *bun.DB, inviteeID string, reason string) (bool, error) {
+	rejected := false
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().Model((*models.ReferralValidation)(nil)).
+			Set("status = ?", models.ReferralStatusRejected).
+			Set("reason = ?", reason).
+			Set("resolved_at = current_timestamp").
+			Where("invitee_id = ?", inviteeID).
+			Where("status = ?", models.ReferralStatusPending).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil || affected == 0 {
+			return err
+		}
+
+		_, err = tx.NewUpdate().Model((*models.ReferralCommission)(nil)).
+			Set("status = ?", models.ReferralCommissionStatusVoid).
+			Where("invitee_id = ?", inviteeID).
+			Where("status = ?", models.ReferralCommissionStatusPending).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		rejected = true
+		return nil
+	})
+
+	return rejected, err
+}
+
+// GetLastReferralValidatedAt is when the last invite of the inviter was credited
+func GetLastReferralValidatedAt(ctx context.Context, db *bun.DB, inviterID string) (*time.Time, error) {
+	var resolvedAt *time.Time
+	err := db.NewSelect().Model((*models.ReferralValidation)(nil)).
+		ColumnExpr("MAX(resolved_at)").
+		Where("inviter_id = ?", inviterID).
+		Where("status = ?", models.ReferralStatusValidated).
+		Scan(ctx, &resolvedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolvedAt, nil
+}
+
+func CountReferralsByStatus(ctx context.Context, db *bun.DB, inviterID string) (map[string]int, error) {
+	var rows []struct {
+		Status string `bun:"status"`
+		Count  int    `bun:"count"`
+	}
+	err := db.NewSelect().Model((*models.ReferralValidation)(nil)).
+		ColumnExpr("status").
+		ColumnExpr("count(*) AS count").
+		Where("inviter_id = ?", inviterID).
+		GroupExpr("status").
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+
+	return counts, nil
+}
+
+func GetReferralFriends(ctx context.Context, db *bun.DB, inviterID string, status string, limit, offset int) ([]*models.ReferralFriend, error) {
+	friends := []*models.ReferralFriend{}
+	q := db.NewSelect().
+		TableExpr("referral_validation AS rv").
+		Join(`JOIN "user" AS u ON u.id = rv.invitee_id`).
+		ColumnExpr("u.id, u.first_name, u.last_name, u.username").
+		ColumnExpr("rv.status, rv.reason, rv.created_at, rv.resolved_at").
+		Where("rv.inviter_id = ?", inviterID)
+	if status != "" {
+		q = q.Where("rv.status = ?", status)
+	}
+
+	err := q.Order("rv.created_at DESC").Limit(limit).Offset(offset).Scan(ctx, &friends)
+	if err != nil {
+		return nil, err
+	}
+
+	return friends, nil
+}
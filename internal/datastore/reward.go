@@ -2,7 +2,10 @@ package datastore
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"millionaire/internal/models"
+	"time"
 
 	"github.com/uptrace/bun"
 )
@@ -18,6 +21,17 @@ func CreateTableReward(ctx context.Context, db *bun.DB) error {
 		return err
 	}
 
+	_, err = db.NewRaw(`
+		alter table "reward"
+			add if not exists claimed_at timestamptz default null;
+		alter table "reward"
+			add if not exists expires_at timestamptz default null;
+		alter table "reward"
+			add if not exists requires_wallet varchar default '';`).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -25,7 +39,10 @@ func GetAvaiableRewardByUserID(ctx context.Context, db *bun.DB, userID string) (
 	var rewards []models.Reward
 	err := db.NewSelect().Model(&rewards).
 		Where("user_id = ?", userID).
-		Where("claimed = ?", false).Scan(ctx)
+		Where("claimed = ?", false).
+		Where("expires_at IS NULL OR expires_at > current_timestamp").
+		Order("created_at DESC").
+		Scan(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -33,13 +50,102 @@ func GetAvaiableRewardByUserID(ctx context.Context, db *bun.DB, userID string) (
 	return rewards, nil
 }
 
-func ClaimReward(ctx context.Context, db *bun.DB, rewardId int) error {
-	_, err := db.NewUpdate().Model((*models.Reward)(nil)).
-		Set("claimed = ?", true).
-		Where("id=?", rewardId).Exec(ctx)
+func GetUserRewardsPaging(ctx context.Context, db *bun.DB, userID string, status string, limit int, offset int) ([]models.Reward, int, error) {
+	var rewards []models.Reward
+	query := db.NewSelect().Model(&rewards).Where("user_id = ?", userID)
+
+	switch status {
+	case models.RewardStatusClaimed:
+		query = query.Where("claimed = ?", true).Order("claimed_at DESC")
+	case models.RewardStatusExpired:
+		query = query.Where("claimed = ?", false).Where("expires_at <= current_timestamp").Order("expires_at DESC")
+	default:
+		query = query.Where("claimed = ?", false).Where("expires_at IS NULL OR expires_at > current_timestamp").Order("created_at DESC")
+	}
+
+	count, err := query.Limit(limit).Offset(offset).ScanAndCount(ctx)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
-	return nil
+	return rewards, count, nil
+}
+
+func GetReward(ctx context.Context, db *bun.DB, rewardID int) (*models.Reward, error) {
+	var reward models.Reward
+	err := db.NewSelect().Model(&reward).Where("id = ?", rewardID).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reward, nil
+}
+
+// ClaimUserReward marks the reward claimed and credits it through the gem, boost and lifeline ledgers in one transaction.
+// sql.ErrNoRows is returned when the reward is not claimable anymore.
+func ClaimUserReward(ctx context.Context, db *bun.DB, userID string, rewardID int) (*models.Reward, error) {
+	var reward models.Reward
+	err := db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewUpdate().Model(&reward).
+			Set("claimed = ?", true).
+			Set("claimed_at = current_timestamp").
+			Set("updated_at = current_timestamp").
+			Where("id = ?", rewardID).
+			Where("user_id = ?", userID).
+			Where("claimed = ?", false).
+			Where("expires_at IS NULL OR expires_at > current_timestamp").
+			Returning("*").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		action := fmt.Sprintf("reward:%d", reward.ID)
+		if reward.Gem != 0 {
+			_, err = tx.NewInsert().Model(&models.UserGem{UserID: userID, Gems: reward.Gem, Action: action}).Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if reward.Star > 0 {
+			now := time.Now()
+			boosts := make([]*models.UserBoost, 0, reward.Star)
+			for i := 0; i < reward.Star; i++ {
+				boosts = append(boosts, &models.UserBoost{
+					UserID:    userID,
+					Source:    fmt.Sprintf("%s:%d", action, i),
+					CreatedAt: now,
+					Validated: true,
+				})
+			}
+
+			_, err = tx.NewInsert().Model(&boosts).Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if reward.Lifeline != 0 {
+			_, err = tx.NewUpdate().Model((*models.User)(nil)).
+				Set("lifeline_balance = lifeline_balance + ?", reward.Lifeline).
+				Where("id = ?", userID).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.NewInsert().Model(&models.LifelineHistory{UserID: userID, Change: reward.Lifeline, Action: action}).Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &reward, nil
 }
@@ -0,0 +1,114 @@
+package datastore
+
+import (
+	"context"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+func CreateTableSeason(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.Season)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewRaw(`
+		alter table "season"
+			add if not exists prizes jsonb;`).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateTable().Model((*models.SeasonStanding)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.SeasonStanding)(nil)).Index("index_season_standing_rank").IfNotExists().Column("season_id", "rank").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertSeason returns the existing season when the rollover is resumed
+func InsertSeason(ctx context.Context, db *bun.DB, season *models.Season) error {
+	_, err := db.NewInsert().Model(season).On("CONFLICT (leaderboard, key) DO NOTHING").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return db.NewSelect().Model(season).Where("leaderboard = ?", season.Leaderboard).Where("key = ?", season.Key).Scan(ctx)
+}
+
+func InsertSeasonStandings(ctx context.Context, db *bun.DB, standings []models.SeasonStanding) error {
+	if len(standings) == 0 {
+		return nil
+	}
+
+	_, err := db.NewInsert().Model(&standings).On("CONFLICT (season_id, user_id) DO NOTHING").Exec(ctx)
+	return err
+}
+
+func ArchiveSeason(ctx context.Context, db *bun.DB, seasonID int64, participants int) error {
+	_, err := db.NewUpdate().Model((*models.Season)(nil)).
+		Set("status = ?", models.SeasonStatusArchived).
+		Set("participants = ?", participants).
+		Set("archived_at = current_timestamp").
+		Where("id = ?", seasonID).
+		Exec(ctx)
+	return err
+}
+
+func GetSeason(ctx context.Context, db *bun.DB, seasonID int64) (*models.Season, error) {
+	var season models.Season
+	err := db.NewSelect().Model(&season).Where("id = ?", seasonID).Where("status = ?", models.SeasonStatusArchived).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &season, nil
+}
+
+func GetArchivedSeasons(ctx context.Context, db *bun.DB, leaderboard string, limit int) ([]models.Season, error) {
+	var seasons []models.Season
+	err := db.NewSelect().Model(&seasons).
+		Where("leaderboard = ?", leaderboard).
+		Where("status = ?", models.SeasonStatusArchived).
+		Order("start_at DESC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return seasons, nil
+}
+
+func GetSeasonStandings(ctx context.Context, db *bun.DB, seasonID int64, offset int, limit int) ([]models.SeasonStanding, error) {
+	var standings []models.SeasonStanding
+	err := db.NewSelect().Model(&standings).
+		Where("season_id = ?", seasonID).
+		Order("rank ASC").
+		Offset(offset).
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return standings, nil
+}
+
+func GetSeasonStanding(ctx context.Context, db *bun.DB, seasonID int64, userID string) (*models.SeasonStanding, error) {
+	var standing models.SeasonStanding
+	err := db.NewSelect().Model(&standing).Where("season_id = ?", seasonID).Where("user_id = ?", userID).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &standing, nil
+}
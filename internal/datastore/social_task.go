@@ -2,6 +2,10 @@ package datastore
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"millionaire/internal/models"
 
 	"github.com/uptrace/bun"
@@ -36,7 +40,19 @@ func CreateTableSocialTask(ctx context.Context, db *bun.DB) error {
 		alter table social_task 
 			add if not exists is_public bool default true;
 		alter table social_task
-			add if not exists description varchar;`).Exec(ctx)
+			add if not exists description varchar;
+
+		alter table social_task
+			add if not exists partner_id bigint;
+
+		alter table social_task
+			add if not exists recurrence varchar,
+			add if not exists start_at timestamptz,
+			add if not exists end_at timestamptz,
+			add if not exists max_completions integer default 0;
+
+		alter table social_task
+			add if not exists verifier_params jsonb;`).Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -64,6 +80,16 @@ func GetSocialTask(ctx context.Context, db *bun.DB, gameSlug string) (*models.So
 	return &socialTask, nil
 }
 
+func GetSocialTaskByID(ctx context.Context, db *bun.DB, id int64) (*models.SocialTask, error) {
+	var socialTask models.SocialTask
+	err := db.NewSelect().Model(&socialTask).Where("id = ?", id).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &socialTask, nil
+}
+
 func CreateSocialTask(ctx context.Context, db *bun.DB, socialTask *models.SocialTask) error {
 	_, err := db.NewInsert().Model(socialTask).Exec(ctx)
 	if err != nil {
@@ -92,3 +118,240 @@ func GetAllSocialTasks(ctx context.Context, db *bun.DB) ([]models.SocialTask, er
 
 	return socialTasks, nil
 }
+
+func CreateTableSocialTaskCompletion(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.SocialTaskCompletion)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.SocialTaskCompletion)(nil)).Index("index_social_task_completion_user_id_created_at").IfNotExists().Column("user_id", "created_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewRaw(`
+		alter table social_task_completion
+			add if not exists checked_at timestamptz,
+			add if not exists left_at timestamptz,
+			add if not exists revoked_at timestamptz;`).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.SocialTaskCompletion)(nil)).Index("index_social_task_completion_created_at").IfNotExists().Column("created_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a completion clawed back doesn't keep the user from completing the link again
+	_, err = db.NewRaw(`drop index if exists index_social_task_completion_task_id_link_id_user_id_period;`).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.SocialTaskCompletion)(nil)).Index("index_social_task_completion_active").Unique().IfNotExists().Column("task_id", "link_id", "user_id", "period").Where("revoked_at IS NULL").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CountSocialTaskCompletions counts the completions of every link of the tasks in the period given by task, by task
+// then link
+func CountSocialTaskCompletions(ctx context.Context, db *bun.DB, periods map[int64]string) (map[int64]map[int]int, error) {
+	counts := map[int64]map[int]int{}
+	if len(periods) == 0 {
+		return counts, nil
+	}
+
+	taskPeriods := make([][]any, 0, len(periods))
+	for taskID, period := range periods {
+		taskPeriods = append(taskPeriods, []any{taskID, period})
+	}
+
+	var rows []struct {
+		TaskID int64 `bun:"task_id"`
+		LinkID int   `bun:"link_id"`
+		Count  int   `bun:"count"`
+	}
+	err := db.NewSelect().
+		Model((*models.SocialTaskCompletion)(nil)).
+		Column("task_id", "link_id").
+		ColumnExpr("count(*) AS count").
+		Where("(task_id, period) IN (?)", bun.In(taskPeriods)).
+		Where("revoked_at IS NULL").
+		Group("task_id", "link_id").
+		Scan(ctx, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		if counts[row.TaskID] == nil {
+			counts[row.TaskID] = map[int]int{}
+		}
+		counts[row.TaskID][row.LinkID] = row.Count
+	}
+
+	return counts, nil
+}
+
+var ErrSocialTaskFullyClaimed = errors.New("task is fully claimed")
+
+// CompleteSocialTask records the completion of a link and pays its gems under the action, it returns false when
+// the link was already completed in the period and ErrSocialTaskFullyClaimed when it reached the max completions of
+// the task in the period. A link completed again after a clawback is paid under the action suffixed with the number of clawbacks
+func CompleteSocialTask(ctx context.Context, db *bun.DB, task *models.SocialTask, completion *models.SocialTaskCompletion, action string) (bool, error) {
+	credited := false
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		userCompletions := func() *bun.SelectQuery {
+			return tx.NewSelect().Model((*models.SocialTaskCompletion)(nil)).
+				Where("task_id = ?", completion.TaskID).
+				Where("link_id = ?", completion.LinkID).
+				Where("user_id = ?", completion.UserID).
+				Where("period = ?", completion.Period)
+		}
+
+		if task.MaxCompletions > 0 {
+			// the task row serializes the completions counted against the max
+			_, err := tx.NewSelect().Model((*models.SocialTask)(nil)).Column("id").Where("id = ?", task.ID).For("UPDATE").Exec(ctx)
+			if err != nil {
+				return err
+			}
+
+			done, err := userCompletions().Where("revoked_at IS NULL").Exists(ctx)
+			if err != nil || done {
+				return err
+			}
+
+			count, err := tx.NewSelect().Model((*models.SocialTaskCompletion)(nil)).
+				Where("task_id = ?", completion.TaskID).
+				Where("link_id = ?", completion.LinkID).
+				Where("period = ?", completion.Period).
+				Where("revoked_at IS NULL").
+				Count(ctx)
+			if err != nil {
+				return err
+			}
+			if count >= task.MaxCompletions {
+				return ErrSocialTaskFullyClaimed
+			}
+		}
+
+		revoked, err := userCompletions().Where("revoked_at IS NOT NULL").Count(ctx)
+		if err != nil {
+			return err
+		}
+		if revoked > 0 {
+			action = fmt.Sprintf("%s:rejoin:%d", action, revoked)
+		}
+
+		res, err := tx.NewInsert().Model(completion).On("CONFLICT (task_id, link_id, user_id, period) WHERE revoked_at IS NULL DO NOTHING").Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil || affected == 0 {
+			return err
+		}
+
+		// a link completed before the completions were recorded was already paid under the action
+		res, err = tx.NewInsert().Model(&models.UserGem{
+			UserID: completion.UserID,
+			Gems:   completion.Gems,
+			Action: action,
+		}).On("CONFLICT (user_id, action) DO NOTHING").Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		affected, err = res.RowsAffected()
+		credited = affected > 0
+		return err
+	})
+
+	return credited, err
+}
+
+// GetSocialTaskCompletionsToReverify picks the completions made since the time by the links checked with the verifiers,
+// the least recently checked first. A member is checked again after the recheck time, a user who left after the grace
+func GetSocialTaskCompletionsToReverify(ctx context.Context, db *bun.DB, verifiers []string, since time.Time, recheckBefore time.Time, graceBefore time.Time, limit int) ([]models.SocialTaskCompletion, error) {
+	completions := []models.SocialTaskCompletion{}
+	err := db.NewSelect().
+		Model(&completions).
+		ModelTableExpr("social_task_completion AS stc").
+		ColumnExpr("stc.*").
+		Join("JOIN social_task AS t ON t.id = stc.task_id").
+		Where("stc.revoked_at IS NULL").
+		Where("stc.created_at >= ?", since).
+		Where(`EXISTS (
+			SELECT 1 FROM jsonb_array_elements(t.links) AS l
+			WHERE (l->>'id')::int = stc.link_id AND COALESCE(NULLIF(l->>'verifier', ''), l->>'link_type') IN (?)
+		)`, bun.In(verifiers)).
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.
+				Where("stc.left_at IS NULL AND (stc.checked_at IS NULL OR stc.checked_at < ?)", recheckBefore).
+				WhereOr("stc.left_at IS NOT NULL AND stc.checked_at < ?", graceBefore)
+		}).
+		OrderExpr("stc.checked_at ASC NULLS FIRST, stc.id ASC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return completions, nil
+}
+
+// MarkSocialTaskCompletionChecked records a check, the first time the user is found out of the chat starts the grace
+func MarkSocialTaskCompletionChecked(ctx context.Context, db *bun.DB, id int64, member bool) error {
+	_, err := db.NewUpdate().
+		Model((*models.SocialTaskCompletion)(nil)).
+		Set("checked_at = current_timestamp").
+		Set("left_at = CASE WHEN ? THEN NULL ELSE COALESCE(left_at, current_timestamp) END", member).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+// ClawbackSocialTaskCompletion revokes the completion with a negative entry of its gems under the action,
+// it returns false when it was already revoked
+func ClawbackSocialTaskCompletion(ctx context.Context, db *bun.DB, completion *models.SocialTaskCompletion, action string) (bool, error) {
+	revoked := false
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().
+			Model((*models.SocialTaskCompletion)(nil)).
+			Set("revoked_at = current_timestamp").
+			Set("checked_at = current_timestamp").
+			Where("id = ?", completion.ID).
+			Where("revoked_at IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil || affected == 0 {
+			return err
+		}
+
+		if completion.Gems > 0 {
+			_, err = tx.NewInsert().Model(&models.UserGem{
+				UserID: completion.UserID,
+				Gems:   -completion.Gems,
+				Action: action,
+			}).On("CONFLICT (user_id, action) DO NOTHING").Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		revoked = true
+		return nil
+	})
+
+	return revoked, err
+}
@@ -0,0 +1,285 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// scriptedResult answers a statement: the rows of a query, or the rows affected by an exec
+type scriptedResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+}
+
+// scriptedDB is a database/sql driver answering the statements with the first result whose match they contain,
+// it keeps the statements it got including the transaction ends
+type scriptedDB struct {
+	mu         sync.Mutex
+	statements []string
+	results    []struct {
+		match  string
+		result scriptedResult
+	}
+}
+
+func newScriptedDB(t *testing.T) (*bun.DB, *scriptedDB) {
+	t.Helper()
+
+	script := &scriptedDB{}
+	sqldb := sql.OpenDB(script)
+	t.Cleanup(func() { sqldb.Close() })
+
+	return bun.NewDB(sqldb, pgdialect.New()), script
+}
+
+// on answers the statements containing match
+func (d *scriptedDB) on(match string, result scriptedResult) {
+	d.results = append(d.results, struct {
+		match  string
+		result scriptedResult
+	}{match, result})
+}
+
+func (d *scriptedDB) answer(statement string) scriptedResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.statements = append(d.statements, statement)
+	for _, r := range d.results {
+		if strings.Contains(statement, r.match) {
+			return r.result
+		}
+	}
+
+	return scriptedResult{}
+}
+
+// find returns the statements containing match
+func (d *scriptedDB) find(match string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	found := []string{}
+	for _, statement := range d.statements {
+		if strings.Contains(statement, match) {
+			found = append(found, statement)
+		}
+	}
+	return found
+}
+
+func (d *scriptedDB) Connect(ctx context.Context) (driver.Conn, error) { return scriptedConn{d}, nil }
+func (d *scriptedDB) Driver() driver.Driver                            { return d }
+func (d *scriptedDB) Open(name string) (driver.Conn, error)            { return scriptedConn{d}, nil }
+
+type scriptedConn struct{ db *scriptedDB }
+
+func (c scriptedConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+func (c scriptedConn) Close() error { return nil }
+
+func (c scriptedConn) Begin() (driver.Tx, error) {
+	c.db.answer("BEGIN")
+	return scriptedTx{c.db}, nil
+}
+
+func (c scriptedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.db.answer(query)
+	return &scriptedRows{columns: result.columns, values: result.rows}, nil
+}
+
+func (c scriptedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(c.db.answer(query).affected), nil
+}
+
+type scriptedTx struct{ db *scriptedDB }
+
+func (tx scriptedTx) Commit() error {
+	tx.db.answer("COMMIT")
+	return nil
+}
+
+func (tx scriptedTx) Rollback() error {
+	tx.db.answer("ROLLBACK")
+	return nil
+}
+
+type scriptedRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *scriptedRows) Columns() []string { return r.columns }
+func (r *scriptedRows) Close() error      { return nil }
+
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func countResult(count int64) scriptedResult {
+	return scriptedResult{columns: []string{"count"}, rows: [][]driver.Value{{count}}}
+}
+
+func existsResult(exists bool) scriptedResult {
+	return scriptedResult{columns: []string{"exists"}, rows: [][]driver.Value{{exists}}}
+}
+
+// insertedResult answers an insert returning the id of the new row
+func insertedResult(id int64) scriptedResult {
+	return scriptedResult{columns: []string{"id"}, rows: [][]driver.Value{{id}}, affected: 1}
+}
+
+func newDailyCompletion() (*models.SocialTask, *models.SocialTaskCompletion) {
+	task := &models.SocialTask{ID: 7, Recurrence: models.SocialRecurrenceDaily, MaxCompletions: 2}
+	completion := &models.SocialTaskCompletion{TaskID: 7, LinkID: 1, UserID: "42", Period: "2026-10-19", Gems: 50}
+	return task, completion
+}
+
+func TestCompleteSocialTaskCapIsPerPeriod(t *testing.T) {
+	db, script := newScriptedDB(t)
+	script.on("SELECT EXISTS", existsResult(false))
+	script.on("count(*)", countResult(2))
+
+	task, completion := newDailyCompletion()
+	credited, err := CompleteSocialTask(context.Background(), db, task, completion, "social:catia:https://t.me/channel:2026-10-19")
+	if !errors.Is(err, ErrSocialTaskFullyClaimed) {
+		t.Fatalf("err = %v, want ErrSocialTaskFullyClaimed", err)
+	}
+	if credited {
+		t.Fatal("a fully claimed link was credited")
+	}
+
+	counts := script.find("count(*)")
+	if len(counts) != 1 || !strings.Contains(counts[0], "period = '2026-10-19'") || !strings.Contains(counts[0], "revoked_at IS NULL") {
+		t.Fatalf("completions are not counted in the period: %q", counts)
+	}
+	if inserts := script.find("INSERT"); len(inserts) != 0 {
+		t.Fatalf("fully claimed link was inserted: %q", inserts)
+	}
+	if len(script.find("ROLLBACK")) != 1 {
+		t.Fatal("transaction was not rolled back")
+	}
+}
+
+func TestCompleteSocialTaskUnderCap(t *testing.T) {
+	db, script := newScriptedDB(t)
+	script.on("SELECT EXISTS", existsResult(false))
+	script.on("revoked_at IS NOT NULL", countResult(0))
+	script.on("count(*)", countResult(1))
+	script.on(`INSERT INTO "social_task_completion"`, insertedResult(1))
+	script.on(`INSERT INTO "user_gem"`, insertedResult(1))
+
+	task, completion := newDailyCompletion()
+	credited, err := CompleteSocialTask(context.Background(), db, task, completion, "social:catia:https://t.me/channel:2026-10-19")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !credited {
+		t.Fatal("completion was not credited")
+	}
+
+	gems := script.find(`INSERT INTO "user_gem"`)
+	if len(gems) != 1 || !strings.Contains(gems[0], "'social:catia:https://t.me/channel:2026-10-19'") {
+		t.Fatalf("gems are not paid under the action: %q", gems)
+	}
+	if len(script.find("COMMIT")) != 1 {
+		t.Fatal("transaction was not committed")
+	}
+}
+
+func TestCompleteSocialTaskDoesNotCreditTwice(t *testing.T) {
+	t.Run("completed in the period", func(t *testing.T) {
+		db, script := newScriptedDB(t)
+		script.on("SELECT EXISTS", existsResult(true))
+
+		task, completion := newDailyCompletion()
+		credited, err := CompleteSocialTask(context.Background(), db, task, completion, "social:catia:https://t.me/channel:2026-10-19")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credited {
+			t.Fatal("a link completed in the period was credited again")
+		}
+		if inserts := script.find("INSERT"); len(inserts) != 0 {
+			t.Fatalf("a link completed in the period was inserted again: %q", inserts)
+		}
+	})
+
+	t.Run("completion raced", func(t *testing.T) {
+		db, script := newScriptedDB(t)
+		script.on("count(*)", countResult(0))
+
+		// the unique index left the insert without a row
+		task, completion := newDailyCompletion()
+		task.MaxCompletions = 0
+		credited, err := CompleteSocialTask(context.Background(), db, task, completion, "social:catia:https://t.me/channel:2026-10-19")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credited {
+			t.Fatal("a raced completion was credited")
+		}
+		if gems := script.find(`INSERT INTO "user_gem"`); len(gems) != 0 {
+			t.Fatalf("a raced completion paid gems: %q", gems)
+		}
+	})
+
+	t.Run("paid before the completions were recorded", func(t *testing.T) {
+		db, script := newScriptedDB(t)
+		script.on("count(*)", countResult(0))
+		script.on(`INSERT INTO "social_task_completion"`, insertedResult(1))
+
+		task, completion := newDailyCompletion()
+		task.MaxCompletions = 0
+		credited, err := CompleteSocialTask(context.Background(), db, task, completion, "social:catia:https://t.me/channel:2026-10-19")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credited {
+			t.Fatal("an action already paid was credited")
+		}
+		if gems := script.find(`INSERT INTO "user_gem"`); len(gems) != 1 || !strings.Contains(gems[0], "ON CONFLICT (user_id, action) DO NOTHING") {
+			t.Fatalf("gems are not paid once per action: %q", gems)
+		}
+	})
+}
+
+func TestCompleteSocialTaskAfterClawback(t *testing.T) {
+	db, script := newScriptedDB(t)
+	script.on("revoked_at IS NOT NULL", countResult(1))
+	script.on(`INSERT INTO "social_task_completion"`, insertedResult(2))
+	script.on(`INSERT INTO "user_gem"`, insertedResult(2))
+
+	task, completion := newDailyCompletion()
+	task.MaxCompletions = 0
+	credited, err := CompleteSocialTask(context.Background(), db, task, completion, "social:catia:https://t.me/channel:2026-10-19")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !credited {
+		t.Fatal("a link completed again after a clawback was not credited")
+	}
+
+	gems := script.find(`INSERT INTO "user_gem"`)
+	if len(gems) != 1 || !strings.Contains(gems[0], "'social:catia:https://t.me/channel:2026-10-19:rejoin:1'") {
+		t.Fatalf("gems are not paid under a new action: %q", gems)
+	}
+}
@@ -0,0 +1,47 @@
+package datastore
+
+import (
+	"context"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+func CreateTableSuspiciousEvent(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.SuspiciousEvent)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.SuspiciousEvent)(nil)).Index("index_suspicious_event_user_id").IfNotExists().Column("user_id").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.SuspiciousEvent)(nil)).Index("index_suspicious_event_created_at").IfNotExists().Column("created_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func InsertSuspiciousEvent(ctx context.Context, db *bun.DB, event *models.SuspiciousEvent) error {
+	_, err := db.NewInsert().Model(event).Exec(ctx)
+	return err
+}
+
+func GetUserSuspiciousEvents(ctx context.Context, db *bun.DB, userID string, limit int) ([]models.SuspiciousEvent, error) {
+	var events []models.SuspiciousEvent
+	err := db.NewSelect().Model(&events).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -39,7 +39,25 @@ func CreateTableUser(ctx context.Context, db *bun.DB) error {
 		alter table "user"
 			add if not exists chat_status varchar default null;
 		alter table "user"
-    		add if not exists avatar varchar;`).Exec(ctx)
+    		add if not exists avatar varchar;
+		alter table "user"
+			add if not exists merged_into varchar default null;
+		alter table "user"
+			add if not exists moderation_status varchar default 'active';
+		alter table "user"
+			add if not exists moderation_reason varchar default '';
+		alter table "user"
+			add if not exists moderation_expires_at timestamptz default null;
+		alter table "user"
+			add if not exists region varchar default null;
+		alter table "user"
+			add if not exists first_touch_param varchar default null;
+		alter table "user"
+			add if not exists first_touch_at timestamptz default null;
+		alter table "user"
+			add if not exists last_touch_param varchar default null;
+		alter table "user"
+			add if not exists last_touch_at timestamptz default null;`).Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -61,6 +79,36 @@ func FindUserByID(ctx context.Context, db *bun.DB, userID string) (*models.User,
 	return &user, nil
 }
 
+func FindUsersByIDs(ctx context.Context, db *bun.DB, userIDs []string) ([]*models.User, error) {
+	var users []*models.User
+	if len(userIDs) == 0 {
+		return users, nil
+	}
+
+	err := db.NewSelect().Model(&users).Where("id IN (?)", bun.In(userIDs)).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SetUserTouch stores the start parameter as the last touch, and as the first touch when the user had none
+func SetUserTouch(ctx context.Context, db *bun.DB, userID string, param string) error {
+	_, err := db.NewUpdate().Model((*models.User)(nil)).
+		Set("first_touch_param = COALESCE(first_touch_param, ?)", param).
+		Set("first_touch_at = COALESCE(first_touch_at, current_timestamp)").
+		Set("last_touch_param = ?", param).
+		Set("last_touch_at = current_timestamp").
+		Where("id = ?", userID).
+		Exec(ctx)
+	return err
+}
+
+func SetUserRegion(ctx context.Context, db *bun.DB, userID string, region *string) error {
+	_, err := db.NewUpdate().Model((*models.User)(nil)).Set("region = ?", region).Where("id = ?", userID).Exec(ctx)
+	return err
+}
+
 func CheckUserExists(ctx context.Context, db *bun.DB, userID string) (bool, error) {
 	var user models.User
 	err := db.NewSelect().Model(&user).Where("id = ?", userID).Scan(ctx)
@@ -132,6 +180,20 @@ func GetUsersByInviter(ctx context.Context, db *bun.DB, inviterID int64) ([]*mod
 	return users, nil
 }
 
+func GetInviteeIDs(ctx context.Context, db *bun.DB, inviterIDs []string, limit int) ([]string, error) {
+	var userIDs []string
+	if len(inviterIDs) == 0 {
+		return userIDs, nil
+	}
+
+	err := db.NewSelect().Model((*models.User)(nil)).Column("id").Where("inviter_id IN (?)", bun.In(inviterIDs)).Limit(limit).Scan(ctx, &userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
 func CountInviteesByUserId(ctx context.Context, db *bun.DB, userID string) (int, error) {
 	count, err := db.NewSelect().Model((*models.User)(nil)).Where("inviter_id = ?", userID).Count(ctx)
 	if err != nil {
@@ -245,24 +307,29 @@ func GetUsersByLimit(ctx context.Context, db *bun.DB, limit, offset int) ([]*mod
 	return users, nil
 }
 
+// AddInviteRef links the invitee to the inviter, the invite is only credited once the referral is validated
 func AddInviteRef(ctx context.Context, db *bun.DB, inviteeID string, inviterID string) error {
 	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
-		if _, err := tx.NewUpdate().
+		res, err := tx.NewUpdate().
 			Model((*models.User)(nil)).
 			Set("inviter_id = ?", inviterID).
 			Where("id=?", inviteeID).
-			Where("inviter_id is null").Exec(ctx); err != nil {
+			Where("inviter_id is null").Exec(ctx)
+		if err != nil {
 			return err
 		}
-		if _, err := tx.NewUpdate().
-			Model((*models.User)(nil)).
-			Set("total_invites = total_invites + 1").
-			Where("id = ?", inviterID).
-			Exec(ctx); err != nil {
+
+		affected, err := res.RowsAffected()
+		if err != nil || affected == 0 {
 			return err
 		}
 
-		return nil
+		_, err = tx.NewInsert().Model(&models.ReferralValidation{
+			InviteeID: inviteeID,
+			InviterID: inviterID,
+			Status:    models.ReferralStatusPending,
+		}).On("CONFLICT (invitee_id) DO NOTHING").Exec(ctx)
+		return err
 	})
 }
 
@@ -296,9 +363,10 @@ func GetUserFriendListPaging(ctx context.Context, db *bun.DB, userID string, lim
 	var friends []*models.Friend
 
 	err := db.NewSelect().
-		ColumnExpr("ur.id, ur.first_name, ur.last_name, ur.username, us.gems, (us.gems IS NOT NULL AND us.gems > 15) as validated, ub.id is not null as claimed").TableExpr("\"user\" ur").
+		ColumnExpr("ur.id, ur.first_name, ur.last_name, ur.username, us.gems, COALESCE(rv.status = 'validated', false) as validated, ub.id is not null as claimed").TableExpr("\"user\" ur").
 		Join("LEFT JOIN (SELECT u.id, SUM(ug.gems) gems FROM \"user\" u LEFT JOIN user_gem ug ON u.id = ug.user_id WHERE ug.user_id IN (SELECT u.id FROM \"user\" u WHERE u.inviter_id=?) GROUP BY u.id) us ON ur.id = us.id", userID).
 		Join("LEFT JOIN user_boost ub ON ur.inviter_id = ub.user_id AND ur.id::varchar = ub.source").
+		Join("LEFT JOIN referral_validation rv ON rv.invitee_id = ur.id").
 		Where("ur.inviter_id = ?", userID).
 		Where("us.gems >= 5").
 		Order("validated desc").
@@ -321,8 +389,9 @@ func GetOnlyClaimableFriends(ctx context.Context, db *bun.DB, userID string) ([]
 		ColumnExpr("ur.id, ur.first_name, ur.last_name, ur.username, us.gems").TableExpr("\"user\" ur").
 		Join("LEFT JOIN (SELECT u.id, SUM(ug.gems) gems FROM \"user\" u LEFT JOIN user_gem ug ON u.id = ug.user_id WHERE ug.user_id IN (SELECT u.id FROM \"user\" u WHERE u.inviter_id=?) GROUP BY u.id) us ON ur.id = us.id", userID).
 		Join("LEFT JOIN user_boost ub ON ur.inviter_id = ub.user_id AND ur.id::varchar = ub.source").
+		Join("JOIN referral_validation rv ON rv.invitee_id = ur.id").
 		Where("ur.inviter_id = ?", userID).
-		Where("us.gems > 15").
+		Where("rv.status = ?", models.ReferralStatusValidated).
 		Where("ub.id is null").
 		Scan(ctx, &friends)
 	if err != nil {
@@ -82,6 +82,7 @@ func GetUserTotalGemListFromTime(ctx context.Context, db *bun.DB, from *time.Tim
 	var totalGem []*models.TotalGem
 	err := db.NewSelect().
 		ColumnExpr("SUM(gems) as total_gems").
+		ColumnExpr("MAX(created_at) as last_earned_at").
 		ColumnExpr("user_id").
 		TableExpr("user_gem").
 		Where("created_at >=?", from).
@@ -97,6 +98,20 @@ func GetUserTotalGemListFromTime(ctx context.Context, db *bun.DB, from *time.Tim
 	return totalGem, nil
 }
 
+func GetUserLastGemAt(ctx context.Context, db *bun.DB, userID string) (*time.Time, error) {
+	var lastEarnedAt *time.Time
+	err := db.NewSelect().
+		ColumnExpr("MAX(created_at)").
+		TableExpr("user_gem").
+		Where("user_id = ?", userID).
+		Scan(ctx, &lastEarnedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return lastEarnedAt, nil
+}
+
 func GetUserGemByAction(ctx context.Context, db *bun.DB, userID string, action string) (*models.UserGem, error) {
 	var userGem models.UserGem
 	err := db.NewSelect().Model(&userGem).Where("user_id = ? AND action = ?", userID, action).Scan(ctx)
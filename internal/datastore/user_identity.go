@@ -0,0 +1,173 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+func CreateTableUserIdentity(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.UserIdentity)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.UserIdentity)(nil)).Index("index_user_identity_user_id").IfNotExists().Column("user_id").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CreateTableUserMerge(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.UserMerge)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.UserMerge)(nil)).Index("index_user_merge_source_user_id").IfNotExists().Column("source_user_id").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func FindUserIdentity(ctx context.Context, db *bun.DB, provider string, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := db.NewSelect().Model(&identity).
+		Where("provider = ?", provider).
+		Where("subject = ?", subject).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func GetUserIdentities(ctx context.Context, db *bun.DB, userID string) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := db.NewSelect().Model(&identities).Where("user_id = ?", userID).Order("created_at ASC").Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// CreateUserIdentity does nothing when the identity is already mapped, callers should re-read it to know the owner
+func CreateUserIdentity(ctx context.Context, db *bun.DB, identity *models.UserIdentity) error {
+	_, err := db.NewInsert().Model(identity).On("CONFLICT (provider, subject) DO NOTHING").Exec(ctx)
+	return err
+}
+
+// MergeUsers moves every ledger row owned by merge.SourceUserID to merge.TargetUserID in one transaction
+// and records the audit row. Rows that would break a unique constraint on the target are kept under a
+// ":merged:<source>" suffix so no gem or star is lost.
+func MergeUsers(ctx context.Context, db *bun.DB, merge *models.UserMerge) error {
+	source := merge.SourceUserID
+	target := merge.TargetUserID
+	if merge.Summary == nil {
+		merge.Summary = map[string]int64{}
+	}
+
+	return db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		var sourceUser models.User
+		err := tx.NewSelect().Model(&sourceUser).Where("id = ?", source).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		if sourceUser.MergedInto != nil {
+			return errors.New("source user is already merged")
+		}
+
+		var targetUser models.User
+		err = tx.NewSelect().Model(&targetUser).Where("id = ?", target).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		if targetUser.MergedInto != nil {
+			return errors.New("target user is already merged")
+		}
+
+		exec := func(name string, query string, args ...interface{}) error {
+			res, err := tx.NewRaw(query, args...).Exec(ctx)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			merge.Summary[name] += n
+			return nil
+		}
+
+		steps := []struct {
+			name  string
+			query string
+			args  []interface{}
+		}{
+			{"user_gem", `UPDATE user_gem SET user_id = ?, action = action || ':merged:' || ? WHERE user_id = ? AND action IN (SELECT action FROM user_gem WHERE user_id = ?)`, []interface{}{target, source, source, target}},
+			{"user_gem", `UPDATE user_gem SET user_id = ? WHERE user_id = ?`, []interface{}{target, source}},
+			{"user_boost", `UPDATE user_boost SET user_id = ?, source = source || ':merged:' || ? WHERE user_id = ? AND source IN (SELECT source FROM user_boost WHERE user_id = ?)`, []interface{}{target, source, source, target}},
+			{"user_boost", `UPDATE user_boost SET user_id = ? WHERE user_id = ?`, []interface{}{target, source}},
+			{"lifeline_history", `UPDATE lifeline_history SET user_id = ? WHERE user_id = ?`, []interface{}{target, source}},
+			{"game_session", `UPDATE game_session SET user_id = ? WHERE user_id = ?`, []interface{}{target, source}},
+			// the target keeps its own progress on games both accounts played, sessions are merged above
+			{"user_game", `UPDATE user_game SET user_id = ? WHERE user_id = ? AND game_slug NOT IN (SELECT game_slug FROM user_game WHERE user_id = ?)`, []interface{}{target, source, target}},
+			{"user_game_dropped", `DELETE FROM user_game WHERE user_id = ?`, []interface{}{source}},
+			{"reward", `UPDATE reward SET user_id = ? WHERE user_id = ?`, []interface{}{target, source}},
+			{"user_wallet", `UPDATE user_wallet SET id = ? WHERE id = ? AND NOT EXISTS (SELECT 1 FROM user_wallet WHERE id = ?)`, []interface{}{target, source, target}},
+			{"user_identity", `UPDATE user_identity SET user_id = ? WHERE user_id = ?`, []interface{}{target, source}},
+			{"referral", `UPDATE "user" SET inviter_id = ? WHERE inviter_id = ? AND id <> ?`, []interface{}{target, source, target}},
+			{"referral_validation", `UPDATE referral_validation SET inviter_id = ? WHERE inviter_id = ? AND invitee_id <> ?`, []interface{}{target, source, target}},
+		}
+
+		for _, step := range steps {
+			if err := exec(step.name, step.query, step.args...); err != nil {
+				return err
+			}
+		}
+
+		// a user cannot be invited by itself after the merge
+		inviterID := targetUser.InviterID
+		if inviterID != nil && *inviterID == source {
+			inviterID = nil
+		}
+		if inviterID == nil && sourceUser.InviterID != nil && *sourceUser.InviterID != target {
+			inviterID = sourceUser.InviterID
+		}
+
+		_, err = tx.NewUpdate().Model((*models.User)(nil)).
+			Set("lifeline_balance = lifeline_balance + ?", sourceUser.LifelineBalance).
+			Set("inviter_id = ?", inviterID).
+			Set("total_invites = (SELECT count(*) FROM \"user\" AS invitee JOIN referral_validation AS rv ON rv.invitee_id = invitee.id WHERE invitee.inviter_id = ? AND rv.status = ?)", target, models.ReferralStatusValidated).
+			Set("updated_at = current_timestamp").
+			Where("id = ?", target).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		merge.Summary["lifeline_balance"] = int64(sourceUser.LifelineBalance)
+
+		_, err = tx.NewUpdate().Model((*models.User)(nil)).
+			Set("merged_into = ?", target).
+			Set("lifeline_balance = 0").
+			Set("total_invites = 0").
+			Set("updated_at = current_timestamp").
+			Where("id = ?", source).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewInsert().Model(merge).Exec(ctx)
+		return err
+	})
+}
@@ -0,0 +1,68 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+
+	"millionaire/internal/models"
+
+	"github.com/uptrace/bun"
+)
+
+func CreateTableUserModeration(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().Model((*models.UserModeration)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NewCreateIndex().Model((*models.UserModeration)(nil)).Index("index_user_moderation_user_id").IfNotExists().Column("user_id", "created_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ModerateUser changes the moderation state of the user and records it in the audit trail
+func ModerateUser(ctx context.Context, db *bun.DB, moderation *models.UserModeration) error {
+	return db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		var user models.User
+		err := tx.NewSelect().Model(&user).Where("id = ?", moderation.UserID).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		// the stored status, an expired ban being lifted is still a ban to undo
+		moderation.PreviousStatus = user.ModerationStatus
+		if moderation.PreviousStatus == "" {
+			moderation.PreviousStatus = models.ModerationStatusActive
+		}
+
+		_, err = tx.NewUpdate().Model((*models.User)(nil)).
+			Set("moderation_status = ?", moderation.Status).
+			Set("moderation_reason = ?", moderation.Reason).
+			Set("moderation_expires_at = ?", moderation.ExpiresAt).
+			Where("id = ?", moderation.UserID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewInsert().Model(moderation).Exec(ctx)
+		return err
+	})
+}
+
+func GetUserModerations(ctx context.Context, db *bun.DB, userID string, limit int) ([]models.UserModeration, error) {
+	var moderations []models.UserModeration
+	err := db.NewSelect().Model(&moderations).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return moderations, nil
+}
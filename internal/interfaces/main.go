@@ -8,4 +8,5 @@ import (
 
 type Limiter interface {
 	Allow(ctx context.Context, key string, limit redis_rate.Limit) error
+	AllowWithResult(ctx context.Context, key string, limit redis_rate.Limit) (*redis_rate.Result, error)
 }
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	AntiCheatFlagStatusOpen      = "open"
+	AntiCheatFlagStatusCleared   = "cleared"
+	AntiCheatFlagStatusConfirmed = "confirmed"
+
+	AntiCheatActionExcludeLeaderboard = "exclude_leaderboard"
+	AntiCheatActionHoldRewards        = "hold_rewards"
+	AntiCheatActionShadowBan          = "shadow_ban"
+
+	AntiCheatSignalLatency         = "latency"
+	AntiCheatSignalPerfectRun      = "perfect_run"
+	AntiCheatSignalIdenticalTiming = "identical_timing"
+	AntiCheatSignalReferralCluster = "referral_cluster"
+
+	SuspiciousEventSessionScored = "session_scored"
+)
+
+var AntiCheatActions = []string{AntiCheatActionExcludeLeaderboard, AntiCheatActionHoldRewards, AntiCheatActionShadowBan}
+
+type AntiCheatSignal struct {
+	Name      string    `json:"name"`
+	Score     int       `json:"score"`
+	Detail    string    `json:"detail"`
+	GameSlug  string    `json:"game_slug"`
+	SessionID string    `json:"session_id"`
+	At        time.Time `json:"at"`
+}
+
+// AntiCheatFlag is an entry of the review queue, a user has at most one open flag
+type AntiCheatFlag struct {
+	bun.BaseModel `bun:"table:anti_cheat_flag"`
+	ID            int64             `bun:"id,pk,autoincrement" json:"id"`
+	UserID        string            `bun:"user_id" json:"user_id"`
+	Status        string            `bun:"status" json:"status"`
+	Score         int               `bun:"score" json:"score"`
+	Signals       []AntiCheatSignal `bun:"signals,type:jsonb" json:"signals"`
+	Actions       []string          `bun:"actions,type:jsonb" json:"actions"`
+	Note          string            `bun:"note" json:"note"`
+	ResolvedBy    string            `bun:"resolved_by" json:"resolved_by"`
+	ResolvedAt    *time.Time        `bun:"resolved_at" json:"resolved_at"`
+	CreatedAt     time.Time         `bun:"created_at,default:current_timestamp" json:"created_at"`
+	UpdatedAt     time.Time         `bun:"updated_at,default:current_timestamp" json:"updated_at"`
+}
+
+type UserSessionStats struct {
+	Total   int `bun:"total" json:"total"`
+	Perfect int `bun:"perfect" json:"perfect"`
+}
@@ -282,8 +282,9 @@ type GameConfig struct {
 }
 
 type GameAnswer struct {
-	Answer        int `json:"answer"`
-	QuestionIndex int `json:"question"`
+	Answer        int    `json:"answer"`
+	QuestionIndex int    `json:"question"`
+	Token         string `json:"token"`
 }
 
 var GameDefault = Game{
@@ -0,0 +1,25 @@
+package models
+
+const (
+	GameRecordLongestStreak     = "longest_streak"
+	GameRecordMostPlayedSession = "most_played_session"
+	GameRecordMostBonusPoint    = "most_bonus_point"
+	GameRecordMostMinusPoint    = "most_minus_point"
+	GameRecordFastestPerfectRun = "fastest_perfect_run"
+	GameRecordMostExtraSessions = "most_extra_sessions"
+)
+
+const (
+	GameRecordWindowAllTime = "all_time"
+	GameRecordWindowDaily   = "daily"
+	GameRecordWindowWeekly  = "weekly"
+)
+
+var GameRecordWindows = []string{GameRecordWindowAllTime, GameRecordWindowDaily, GameRecordWindowWeekly}
+
+// GameRecords is the hall of fame of a game, the score of fastest_perfect_run is in milliseconds
+type GameRecords struct {
+	Game    string                        `json:"game"`
+	Window  string                        `json:"window"`
+	Records map[string][]*LeaderboardItem `json:"records"`
+}
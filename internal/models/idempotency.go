@@ -0,0 +1,10 @@
+package models
+
+// IdempotencyRecord is the stored outcome of a request sent with an Idempotency-Key header
+type IdempotencyRecord struct {
+	Fingerprint string `msgpack:"fingerprint"`
+	Completed   bool   `msgpack:"completed"`
+	Status      int    `msgpack:"status"`
+	ContentType string `msgpack:"content_type"`
+	Body        []byte `msgpack:"body"`
+}
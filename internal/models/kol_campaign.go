@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// KolCampaign groups the ref codes a KOL shares for a promotion, joiners are credited to the owner
+type KolCampaign struct {
+	bun.BaseModel `bun:"table:kol_campaign"`
+	ID            int64      `bun:"id,pk,autoincrement" json:"id"`
+	Slug          string     `bun:"slug,unique,notnull" json:"slug"`
+	Name          string     `bun:"name" json:"name"`
+	OwnerID       string     `bun:"owner_id,notnull" json:"owner_id"`
+	RefCodes      []string   `bun:"ref_codes,array" json:"ref_codes"`
+	StartAt       *time.Time `bun:"start_at" json:"start_at"`
+	EndAt         *time.Time `bun:"end_at" json:"end_at"`
+	Budget        int        `bun:"budget" json:"budget"`
+	Spent         int        `bun:"spent,notnull,default:0" json:"spent"`
+	JoinerBonus   int        `bun:"joiner_bonus" json:"joiner_bonus"`
+	KolBonus      int        `bun:"kol_bonus" json:"kol_bonus"`
+	Enabled       bool       `bun:"enabled,notnull,default:true" json:"enabled"`
+	CreatedAt     time.Time  `bun:"created_at,default:current_timestamp" json:"created_at"`
+	UpdatedAt     time.Time  `bun:"updated_at,default:current_timestamp" json:"updated_at"`
+}
+
+// IsActive tells whether joiners can still be attributed to the campaign at t
+func (campaign *KolCampaign) IsActive(t time.Time) bool {
+	if !campaign.Enabled {
+		return false
+	}
+	if campaign.StartAt != nil && t.Before(*campaign.StartAt) {
+		return false
+	}
+	if campaign.EndAt != nil && !t.Before(*campaign.EndAt) {
+		return false
+	}
+
+	return true
+}
+
+// KolCampaignJoin attributes a new user to the campaign whose ref code they started with
+type KolCampaignJoin struct {
+	bun.BaseModel `bun:"table:kol_campaign_join"`
+	UserID        string    `bun:"user_id,pk" json:"user_id"`
+	CampaignID    int64     `bun:"campaign_id,notnull" json:"campaign_id"`
+	RefCode       string    `bun:"ref_code" json:"ref_code"`
+	JoinedAt      time.Time `bun:"joined_at,default:current_timestamp" json:"joined_at"`
+}
+
+type KolCampaignPayload struct {
+	Slug        string     `json:"slug"`
+	Name        string     `json:"name"`
+	OwnerID     string     `json:"owner_id"`
+	RefCodes    []string   `json:"ref_codes"`
+	StartAt     *time.Time `json:"start_at"`
+	EndAt       *time.Time `json:"end_at"`
+	Budget      int        `json:"budget"`
+	JoinerBonus int        `json:"joiner_bonus"`
+	KolBonus    int        `json:"kol_bonus"`
+	Enabled     *bool      `json:"enabled"`
+}
+
+type KolCampaignDailyValue struct {
+	Day   string `bun:"day"`
+	Value int    `bun:"value"`
+}
+
+type KolCampaignDay struct {
+	Date      string `json:"date"`
+	Joins     int    `json:"joins"`
+	Activated int    `json:"activated"`
+	Gems      int    `json:"gems"`
+}
+
+type KolCampaignStats struct {
+	Campaign  *KolCampaign      `json:"campaign"`
+	From      time.Time         `json:"from"`
+	To        time.Time         `json:"to"`
+	Joins     int               `json:"joins"`
+	Pending   int               `json:"pending"`
+	Activated int               `json:"activated"`
+	Rejected  int               `json:"rejected"`
+	Gems      int               `json:"gems"`
+	Days      []*KolCampaignDay `json:"days"`
+}
@@ -1,14 +1,18 @@
 package models
 
+import "time"
+
 type LeaderboardItem struct {
-	Username string  `json:"username"`
-	UserId   string  `json:"user_id"`
-	Score    float64 `json:"score"`
-	Rank     int     `json:"rank,omitempty"`
-	Avatar   *string `json:"avatar"`
+	Username  string     `json:"username"`
+	UserId    string     `json:"user_id"`
+	Score     float64    `json:"score"`
+	Rank      int        `json:"rank,omitempty"`
+	Avatar    *string    `json:"avatar"`
+	ReachedAt *time.Time `json:"reached_at,omitempty"` // when the score was first reached, earlier wins a tie
 }
 
 type LeaderboardResponse struct {
 	Leaderboard []*LeaderboardItem `json:"leaderboard"`
 	Me          *LeaderboardItem   `json:"me"`
+	NextCursor  string             `json:"next_cursor,omitempty"`
 }
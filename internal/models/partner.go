@@ -1,14 +1,93 @@
 package models
 
-import "github.com/uptrace/bun"
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Scopes of a partner key, each guards a group of the /3rd routes
+const (
+	PartnerScopeVerifyUser = "verify-user"
+	PartnerScopeStats      = "stats"
+	PartnerScopeWebhooks   = "webhooks"
+)
+
+var PartnerScopes = []string{PartnerScopeVerifyUser, PartnerScopeStats, PartnerScopeWebhooks}
+
+func IsPartnerScope(scope string) bool {
+	for _, s := range PartnerScopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
 
 type Partner struct {
-	bun.BaseModel `bun:"table:partner"`
-	ID            int64  `bun:"id,pk,autoincrement" json:"id"`
-	Name          string `bun:"name" json:"name"`
-	APIKey        string `bun:"api_key" json:"api_key"`
-	Slug          string `bun:"slug" json:"slug"`
-	Enabled       bool   `bun:"enabled" json:"enabled"`
+	bun.BaseModel      `bun:"table:partner"`
+	ID                 int64   `bun:"id,pk,autoincrement" json:"id"`
+	Name               string  `bun:"name" json:"name"`
+	APIKey             string  `bun:"api_key,nullzero" json:"-"` // legacy plaintext key, moved to partner_key by the migration
+	Slug               string  `bun:"slug" json:"slug"`
+	Enabled            bool    `bun:"enabled" json:"enabled"`
+	RefCode            *string `bun:"ref_code" json:"ref_code"` // joiners through this code are reported to the webhooks
+	RateLimitPerMinute int     `bun:"rate_limit_per_minute,notnull,default:0" json:"rate_limit_per_minute"`
+	RequireSignature   bool    `bun:"require_signature,notnull,default:false" json:"require_signature"`
+}
+
+// PartnerKey is an API key of a partner, only its SHA-256 is stored and the prefix identifies it in listings.
+// A partner rotates its keys by creating a new one and letting the old one expire
+type PartnerKey struct {
+	bun.BaseModel `bun:"table:partner_key"`
+	ID            int64      `bun:"id,pk,autoincrement" json:"id"`
+	PartnerID     int64      `bun:"partner_id,notnull" json:"partner_id"`
+	Name          string     `bun:"name" json:"name"`
+	Prefix        string     `bun:"prefix,notnull" json:"prefix"`
+	Hash          string     `bun:"hash,notnull,unique" json:"-"`
+	SigningSecret string     `bun:"signing_secret,nullzero" json:"signing_secret,omitempty"`
+	Scopes        []string   `bun:"scopes,array" json:"scopes"`
+	ExpiresAt     *time.Time `bun:"expires_at" json:"expires_at"`
+	RevokedAt     *time.Time `bun:"revoked_at" json:"revoked_at"`
+	CreatedAt     time.Time  `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+func (key *PartnerKey) IsActive(t time.Time) bool {
+	if key.RevokedAt != nil {
+		return false
+	}
+
+	return key.ExpiresAt == nil || t.Before(*key.ExpiresAt)
+}
+
+func (key *PartnerKey) HasScope(scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PartnerKeyCreated carries the plaintext key, it is only returned once at creation
+type PartnerKeyCreated struct {
+	*PartnerKey
+	Key string `json:"key"`
+}
+
+type PartnerKeyPayload struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type PartnerSettingsPayload struct {
+	Enabled            *bool   `json:"enabled"`
+	RefCode            *string `json:"ref_code"`
+	RateLimitPerMinute *int    `json:"rate_limit_per_minute"`
+	RequireSignature   *bool   `json:"require_signature"`
 }
 
 type PartnerResponse struct {
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// SocialTaskVerification records every check of a task link, whether the user was verified or not
+type SocialTaskVerification struct {
+	bun.BaseModel `bun:"table:social_task_verification"`
+	ID            int64     `bun:"id,pk,autoincrement" json:"id"`
+	TaskID        int64     `bun:"task_id,notnull" json:"task_id"`
+	LinkID        int       `bun:"link_id,notnull" json:"link_id"`
+	UserID        string    `bun:"user_id,notnull" json:"user_id"`
+	Verified      bool      `bun:"verified,notnull" json:"verified"`
+	CreatedAt     time.Time `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// SocialTaskView counts the views of a task per day
+type SocialTaskView struct {
+	bun.BaseModel `bun:"table:social_task_view"`
+	TaskID        int64     `bun:"task_id,pk" json:"task_id"`
+	Day           time.Time `bun:"day,pk,type:date" json:"day"`
+	Views         int       `bun:"views,notnull,default:0" json:"views"`
+}
+
+type PartnerTaskLinkStats struct {
+	LinkID      int    `bun:"link_id" json:"link_id"`
+	Url         string `bun:"-" json:"url"`
+	Attempts    int    `bun:"attempts" json:"attempts"`
+	Completions int    `bun:"completions" json:"completions"`
+	Gems        int    `bun:"-" json:"gems"`
+}
+
+type PartnerTaskStats struct {
+	TaskID      int64                   `json:"task_id"`
+	GameSlug    string                  `json:"game_slug"`
+	Title       string                  `json:"title"`
+	Views       int                     `json:"views"`
+	Attempts    int                     `json:"attempts"`
+	Completions int                     `json:"completions"`
+	Gems        int                     `json:"gems"`
+	Links       []*PartnerTaskLinkStats `json:"links"`
+}
+
+type PartnerTaskStatsReport struct {
+	From  time.Time           `json:"from"`
+	To    time.Time           `json:"to"`
+	Tasks []*PartnerTaskStats `json:"tasks"`
+}
+
+type PartnerArenaDay struct {
+	Date            string `bun:"day" json:"date"`
+	Participants    int    `bun:"participants" json:"participants"`
+	NewParticipants int    `bun:"new_participants" json:"new_participants"`
+	Sessions        int    `bun:"sessions" json:"sessions"`
+}
+
+// PartnerArenaScoreBucket counts the finished sessions scoring from MinScore to MaxScore included
+type PartnerArenaScoreBucket struct {
+	MinScore int `bun:"min_score" json:"min_score"`
+	MaxScore int `bun:"max_score" json:"max_score"`
+	Sessions int `bun:"sessions" json:"sessions"`
+}
+
+// PartnerArenaRetention follows the users who first played the arena in the range, Returned played on a later day
+// and Day1 / Day7 played again one / seven days after their first day
+type PartnerArenaRetention struct {
+	Participants int     `bun:"participants" json:"participants"`
+	Returned     int     `bun:"returned" json:"returned"`
+	Day1         int     `bun:"day1" json:"day1"`
+	Day7         int     `bun:"day7" json:"day7"`
+	ReturnedRate float64 `bun:"-" json:"returned_rate"`
+	Day1Rate     float64 `bun:"-" json:"day1_rate"`
+	Day7Rate     float64 `bun:"-" json:"day7_rate"`
+}
+
+type PartnerArenaStats struct {
+	Arena             *Arena                     `json:"arena"`
+	From              time.Time                  `json:"from"`
+	To                time.Time                  `json:"to"`
+	Participants      int                        `json:"participants"`
+	Sessions          int                        `json:"sessions"`
+	Days              []*PartnerArenaDay         `json:"days"`
+	ScoreDistribution []*PartnerArenaScoreBucket `json:"score_distribution"`
+	Retention         *PartnerArenaRetention     `json:"retention"`
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Events a partner can subscribe a webhook to, each is only about the partner's own users, tasks and arenas
+const (
+	PartnerEventUserJoined          = "user.joined"
+	PartnerEventUserGemsReached     = "user.gems_reached"
+	PartnerEventSocialTaskCompleted = "social_task.completed"
+	PartnerEventArenaFinished       = "arena.finished"
+
+	PartnerWebhookDeliveryPending   = "pending"
+	PartnerWebhookDeliveryDelivered = "delivered"
+	PartnerWebhookDeliveryDead      = "dead"
+)
+
+var PartnerEvents = []string{
+	PartnerEventUserJoined,
+	PartnerEventUserGemsReached,
+	PartnerEventSocialTaskCompleted,
+	PartnerEventArenaFinished,
+}
+
+func IsPartnerEvent(event string) bool {
+	for _, e := range PartnerEvents {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PartnerWebhook is an endpoint of a partner, the secret signs every delivery and is only shown at creation
+type PartnerWebhook struct {
+	bun.BaseModel `bun:"table:partner_webhook"`
+	ID            int64     `bun:"id,pk,autoincrement" json:"id"`
+	PartnerID     int64     `bun:"partner_id,notnull" json:"-"`
+	URL           string    `bun:"url,notnull" json:"url"`
+	Secret        string    `bun:"secret,notnull" json:"secret,omitempty"`
+	Events        []string  `bun:"events,array" json:"events"`
+	MinGems       int       `bun:"min_gems,notnull,default:0" json:"min_gems"`
+	Enabled       bool      `bun:"enabled,notnull,default:true" json:"enabled"`
+	CreatedAt     time.Time `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+func (webhook *PartnerWebhook) Subscribes(event string) bool {
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PartnerWebhookDelivery is the delivery log of an event to a webhook, the dead ones are the dead-letter queue.
+// EventKey identifies the event so it is queued once per webhook
+type PartnerWebhookDelivery struct {
+	bun.BaseModel `bun:"table:partner_webhook_delivery"`
+	ID            int64           `bun:"id,pk,autoincrement" json:"id"`
+	WebhookID     int64           `bun:"webhook_id,notnull" json:"webhook_id"`
+	PartnerID     int64           `bun:"partner_id,notnull" json:"-"`
+	Event         string          `bun:"event,notnull" json:"event"`
+	EventKey      string          `bun:"event_key,notnull" json:"event_key"`
+	Payload       json.RawMessage `bun:"payload,type:jsonb" json:"payload"`
+	Status        string          `bun:"status,notnull,default:'pending'" json:"status"`
+	Attempts      int             `bun:"attempts,notnull,default:0" json:"attempts"`
+	NextAttemptAt time.Time       `bun:"next_attempt_at,default:current_timestamp" json:"next_attempt_at"`
+	ResponseCode  int             `bun:"response_code" json:"response_code,omitempty"`
+	LastError     string          `bun:"last_error" json:"last_error,omitempty"`
+	CreatedAt     time.Time       `bun:"created_at,default:current_timestamp" json:"created_at"`
+	DeliveredAt   *time.Time      `bun:"delivered_at" json:"delivered_at,omitempty"`
+}
+
+// PartnerWebhookEvent is the body posted to the webhook
+type PartnerWebhookEvent struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	Partner    string    `json:"partner"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+type PartnerWebhookPayload struct {
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	MinGems int      `json:"min_gems"`
+}
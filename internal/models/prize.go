@@ -0,0 +1,101 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	PrizeSourceArena  = "arena"
+	PrizeSourceSeason = "season"
+
+	PrizeDistributionStatusPreview     = "preview"
+	PrizeDistributionStatusDistributed = "distributed"
+)
+
+// PrizePayout is a prize paid outside of the game, e.g. tokens sent by operations
+type PrizePayout struct {
+	Asset  string `json:"asset"`
+	Amount string `json:"amount"`
+}
+
+// PrizeTier gives the same prize to every rank from FromRank to ToRank, both included
+type PrizeTier struct {
+	FromRank int          `json:"from_rank"`
+	ToRank   int          `json:"to_rank"`
+	Gem      int          `json:"gem"`
+	Star     int          `json:"star"`
+	Lifeline int          `json:"lifeline"`
+	Payout   *PrizePayout `json:"payout,omitempty"`
+}
+
+type PrizeSchedule []PrizeTier
+
+func (schedule PrizeSchedule) Validate() error {
+	tiers := make([]PrizeTier, len(schedule))
+	copy(tiers, schedule)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].FromRank < tiers[j].FromRank })
+
+	for i, tier := range tiers {
+		if tier.FromRank < 1 || tier.ToRank < tier.FromRank {
+			return fmt.Errorf("invalid rank range %d-%d", tier.FromRank, tier.ToRank)
+		}
+
+		if i > 0 && tier.FromRank <= tiers[i-1].ToRank {
+			return fmt.Errorf("rank range %d-%d overlaps %d-%d", tier.FromRank, tier.ToRank, tiers[i-1].FromRank, tiers[i-1].ToRank)
+		}
+
+		if tier.Gem < 0 || tier.Star < 0 || tier.Lifeline < 0 {
+			return errors.New("prize amounts must not be negative")
+		}
+	}
+
+	return nil
+}
+
+func (schedule PrizeSchedule) TierForRank(rank int) *PrizeTier {
+	for i := range schedule {
+		if rank >= schedule[i].FromRank && rank <= schedule[i].ToRank {
+			return &schedule[i]
+		}
+	}
+
+	return nil
+}
+
+func (schedule PrizeSchedule) MaxRank() int {
+	maxRank := 0
+	for _, tier := range schedule {
+		if tier.ToRank > maxRank {
+			maxRank = tier.ToRank
+		}
+	}
+
+	return maxRank
+}
+
+type PrizeWinner struct {
+	UserID   string       `json:"user_id"`
+	Rank     int          `json:"rank"`
+	Score    float64      `json:"score"`
+	Gem      int          `json:"gem"`
+	Star     int          `json:"star"`
+	Lifeline int          `json:"lifeline"`
+	Payout   *PrizePayout `json:"payout,omitempty"`
+}
+
+// PrizeDistribution freezes the winners of an arena or a season, one row per campaign
+type PrizeDistribution struct {
+	bun.BaseModel `bun:"table:prize_distribution"`
+	ID            int64         `bun:"id,pk,autoincrement" json:"id"`
+	Campaign      string        `bun:"campaign,unique" json:"campaign"`
+	Source        string        `bun:"source" json:"source"`
+	SourceRef     string        `bun:"source_ref" json:"source_ref"`
+	Status        string        `bun:"status" json:"status"`
+	Winners       []PrizeWinner `bun:"winners,type:jsonb" json:"winners"`
+	CreatedAt     time.Time     `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
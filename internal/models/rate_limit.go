@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/go-redis/redis_rate/v10"
+)
+
+const (
+	RateLimitKeyUser    = "user"
+	RateLimitKeyPartner = "partner"
+	RateLimitKeyIP      = "ip"
+)
+
+// RateLimitPolicy is the throttling rule of one route, routes are named "<METHOD> <path pattern>"
+type RateLimitPolicy struct {
+	Rate   int    `json:"rate"`
+	Burst  int    `json:"burst"`
+	Period string `json:"period"` // time.ParseDuration format, default 1m
+	Key    string `json:"key"`    // user, partner or ip
+}
+
+func (policy RateLimitPolicy) Limit() redis_rate.Limit {
+	period, err := time.ParseDuration(policy.Period)
+	if err != nil || period <= 0 {
+		period = time.Minute
+	}
+
+	burst := policy.Burst
+	if burst <= 0 {
+		burst = policy.Rate
+	}
+
+	return redis_rate.Limit{
+		Rate:   policy.Rate,
+		Burst:  burst,
+		Period: period,
+	}
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	ReferralStatusPending   = "pending"
+	ReferralStatusValidated = "validated"
+	ReferralStatusRejected  = "rejected"
+
+	ReferralCommissionStatusPending = "pending"
+	ReferralCommissionStatusPaid    = "paid"
+	ReferralCommissionStatusVoid    = "void"
+
+	ReferralRejectedFlagged  = "flagged"
+	ReferralRejectedBanned   = "banned"
+	ReferralRejectedInactive = "inactive"
+)
+
+// ReferralCommissionTier pays Percent of the invitee's quiz gems to the inviter Level steps up the referral chain
+type ReferralCommissionTier struct {
+	Level   int     `json:"level"`
+	Percent float64 `json:"percent"`
+}
+
+type ReferralCommissionTiers []ReferralCommissionTier
+
+func (tiers ReferralCommissionTiers) Validate() error {
+	seen := map[int]bool{}
+	for _, tier := range tiers {
+		if tier.Level < 1 {
+			return errors.New("commission level starts at 1")
+		}
+		if tier.Percent < 0 || tier.Percent > 100 {
+			return errors.New("commission percent must be between 0 and 100")
+		}
+		if seen[tier.Level] {
+			return errors.New("duplicated commission level")
+		}
+		seen[tier.Level] = true
+	}
+
+	return nil
+}
+
+type ReferralCommission struct {
+	bun.BaseModel `bun:"table:referral_commission"`
+	ID            int64     `bun:"id,pk,autoincrement" json:"id"`
+	InviterID     string    `bun:"inviter_id,notnull" json:"inviter_id"`
+	InviteeID     string    `bun:"invitee_id,notnull" json:"invitee_id"`
+	Level         int       `bun:"level,notnull" json:"level"`
+	SourceAction  string    `bun:"source_action,notnull" json:"source_action"`
+	SourceGems    int       `bun:"source_gems" json:"source_gems"`
+	Gems          int       `bun:"gems" json:"gems"`
+	Status        string    `bun:"status,notnull,default:'paid'" json:"status"`
+	CreatedAt     time.Time `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// GemAction is the user_gem action crediting the commission to the inviter
+func (commission *ReferralCommission) GemAction() string {
+	return fmt.Sprintf("referral:l%d:%s:%s", commission.Level, commission.InviteeID, commission.SourceAction)
+}
+
+// ReferralValidation holds the referral credit of an invitee until the validator decides on it
+type ReferralValidation struct {
+	bun.BaseModel `bun:"table:referral_validation"`
+	InviteeID     string     `bun:"invitee_id,pk" json:"invitee_id"`
+	InviterID     string     `bun:"inviter_id,notnull" json:"inviter_id"`
+	Status        string     `bun:"status,notnull,default:'pending'" json:"status"`
+	Reason        string     `bun:"reason" json:"reason,omitempty"`
+	CreatedAt     time.Time  `bun:"created_at,default:current_timestamp" json:"created_at"`
+	ResolvedAt    *time.Time `bun:"resolved_at" json:"resolved_at"`
+}
+
+// ReferralActivity is what the invitee did since joining, used to validate the referral
+type ReferralActivity struct {
+	Sessions   int `bun:"sessions"`
+	ActiveDays int `bun:"active_days"`
+	Gems       int `bun:"gems"`
+}
+
+type ReferralFriend struct {
+	ID         string     `bun:"id" json:"id"`
+	FirstName  string     `bun:"first_name" json:"first_name"`
+	LastName   string     `bun:"last_name" json:"last_name"`
+	Username   string     `bun:"username" json:"username"`
+	Status     string     `bun:"status" json:"status"`
+	Reason     string     `bun:"reason" json:"reason,omitempty"`
+	CreatedAt  time.Time  `bun:"created_at" json:"created_at"`
+	ResolvedAt *time.Time `bun:"resolved_at" json:"resolved_at"`
+}
+
+type ReferralStatusBreakdown struct {
+	Pending   int               `json:"pending"`
+	Validated int               `json:"validated"`
+	Rejected  int               `json:"rejected"`
+	Friends   []*ReferralFriend `json:"friends"`
+}
+
+type ReferralEarningLevel struct {
+	Level    int `bun:"level" json:"level"`
+	Gems     int `bun:"gems" json:"gems"`
+	Invitees int `bun:"invitees" json:"invitees"`
+}
+
+type ReferralEarnings struct {
+	Total  int                     `json:"total"`
+	Levels []*ReferralEarningLevel `json:"levels"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "strings"
+
+type SetRegionPayload struct {
+	Region string `json:"region"`
+}
+
+// NormalizeRegion reduces a language tag such as "pt-BR" to its primary subtag, it returns "" for anything unusable
+func NormalizeRegion(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+
+	if len(code) < 2 || len(code) > 3 {
+		return ""
+	}
+	for _, r := range code {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+
+	return code
+}
+
+// LeaderboardRegion is the region the user is ranked in, the display region picked by the user wins over the detected language
+func (user *User) LeaderboardRegion() string {
+	if user.Region != nil && *user.Region != "" {
+		return *user.Region
+	}
+
+	return NormalizeRegion(user.LanguageCode)
+}
@@ -1,21 +1,65 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/uptrace/bun"
 )
 
+const (
+	RewardWalletAny = "any"
+	RewardWalletEVM = "evm"
+	RewardWalletTON = "ton"
+
+	RewardStatusAvailable = "available"
+	RewardStatusClaimed   = "claimed"
+	RewardStatusExpired   = "expired"
+)
+
 type Reward struct {
-	bun.BaseModel `bun:"table:reward"`
-	ID            int                    `bun:"id,pk,autoincrement" json:"id"`
-	Campaign      string                 `bun:"campaign" json:"campaign"`
-	UserID        string                 `bun:"user_id" json:"user_id"`
-	Gem           int                    `bun:"gem" json:"gem"`
-	Star          int                    `bun:"star" json:"star"`
-	Lifeline      int                    `bun:"lifeline" json:"lifeline"`
-	Claimed       bool                   `bun:"claimed" json:"claimed"`
-	Metadata      map[string]interface{} `bun:"metadata,type:jsonb" json:"metadata"`
-	CreatedAt     time.Time              `bun:"created_at,default:current_timestamp" json:"created_at"`
-	UpdatedAt     time.Time              `bun:"updated_at" json:"updated_at"`
+	bun.BaseModel  `bun:"table:reward"`
+	ID             int                    `bun:"id,pk,autoincrement" json:"id"`
+	Campaign       string                 `bun:"campaign" json:"campaign"`
+	UserID         string                 `bun:"user_id" json:"user_id"`
+	Gem            int                    `bun:"gem" json:"gem"`
+	Star           int                    `bun:"star" json:"star"`
+	Lifeline       int                    `bun:"lifeline" json:"lifeline"`
+	Claimed        bool                   `bun:"claimed" json:"claimed"`
+	ClaimedAt      *time.Time             `bun:"claimed_at" json:"claimed_at"`
+	ExpiresAt      *time.Time             `bun:"expires_at" json:"expires_at"`
+	RequiresWallet string                 `bun:"requires_wallet" json:"requires_wallet"`
+	Metadata       map[string]interface{} `bun:"metadata,type:jsonb" json:"metadata"`
+	CreatedAt      time.Time              `bun:"created_at,default:current_timestamp" json:"created_at"`
+	UpdatedAt      time.Time              `bun:"updated_at" json:"updated_at"`
+
+	Description string `bun:"-" json:"description"`
+}
+
+type RewardInbox struct {
+	Rewards []Reward `json:"rewards"`
+	Total   int      `json:"total"`
+}
+
+func (r *Reward) IsExpired() bool {
+	return r.ExpiresAt != nil && r.ExpiresAt.Before(time.Now())
+}
+
+// Describe renders the campaign and its metadata as a text the player can read
+func (r *Reward) Describe() string {
+	if description, ok := r.Metadata["description"].(string); ok && description != "" {
+		return description
+	}
+
+	parts := strings.Split(r.Campaign, ":")
+	if len(parts) >= 3 && parts[0] == "prize" {
+		name := strings.Join(parts[2:], " ")
+		if rank, ok := r.Metadata["rank"].(float64); ok {
+			return fmt.Sprintf("Rank #%d in %s %s", int(rank), parts[1], name)
+		}
+		return fmt.Sprintf("Prize of %s %s", parts[1], name)
+	}
+
+	return strings.ReplaceAll(r.Campaign, "_", " ")
 }
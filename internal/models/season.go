@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	SeasonPeriodWeekly  = "weekly"
+	SeasonPeriodMonthly = "monthly"
+	SeasonPeriodCustom  = "custom"
+
+	SeasonStatusArchiving = "archiving"
+	SeasonStatusArchived  = "archived"
+)
+
+// Season is a finished period of a seasonal leaderboard, its final standings are kept in season_standing
+type Season struct {
+	bun.BaseModel `bun:"table:season"`
+	ID            int64         `bun:"id,pk,autoincrement" json:"id"`
+	Leaderboard   string        `bun:"leaderboard,unique:season_leaderboard_key" json:"leaderboard"`
+	Key           string        `bun:"key,unique:season_leaderboard_key" json:"key"`
+	Period        string        `bun:"period" json:"period"`
+	Timezone      string        `bun:"timezone" json:"timezone"`
+	StartAt       time.Time     `bun:"start_at" json:"start_at"`
+	EndAt         time.Time     `bun:"end_at" json:"end_at"`
+	Status        string        `bun:"status" json:"status"`
+	Participants  int           `bun:"participants" json:"participants"`
+	Prizes        PrizeSchedule `bun:"prizes,type:jsonb" json:"prizes"`
+	ArchivedAt    *time.Time    `bun:"archived_at" json:"archived_at"`
+	CreatedAt     time.Time     `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+type SeasonStanding struct {
+	bun.BaseModel `bun:"table:season_standing"`
+	SeasonID      int64   `bun:"season_id,pk" json:"season_id"`
+	UserID        string  `bun:"user_id,pk" json:"user_id"`
+	Rank          int     `bun:"rank" json:"rank"`
+	Score         float64 `bun:"score" json:"score"`
+}
+
+type SeasonConfig struct {
+	Period       string
+	Location     *time.Location
+	LengthInDays int
+	Anchor       time.Time
+	Prizes       PrizeSchedule
+}
+
+type SeasonResponse struct {
+	Season      *Season            `json:"season"`
+	Leaderboard []*LeaderboardItem `json:"leaderboard"`
+	Me          *LeaderboardItem   `json:"me"`
+}
@@ -20,6 +20,7 @@ type QuestionHistory struct {
 	Correct       *bool      `bun:"correct" json:"correct"`
 	CorrectAnswer *int       `bun:"-" json:"correct_answer"`
 	Checkpoint    *int       `bun:"checkpoint" json:"checkpoint"`
+	TokenNonce    string     `bun:"-" json:"-"`
 
 	Question Question `bun:"-" json:"-"`
 }
@@ -44,6 +45,7 @@ type GameSession struct {
 	CurrentQuestion      *Question               `bun:"-" json:"current_question"`
 	CurrentQuestionScore int                     `bun:"-" json:"current_question_score"`
 	History              map[int]QuestionHistory `bun:"-" json:"history"`
+	QuestionToken        string                  `bun:"-" json:"question_token" msgpack:"-"`
 }
 
 type GameSessionParams struct {
@@ -55,4 +57,6 @@ type UserGameSessionSumary struct {
 	CorrectAnswerCount    int `bun:"correct_answer_count" json:"correct_answer_count"`
 	MaxCorrectAnswerCount int `bun:"max_correct_answer_count" json:"max_correct_answer_count"`
 	SessionCount          int `bun:"session_count" json:"session_count"`
+
+	LastEndedAt *time.Time `bun:"last_ended_at" json:"-"`
 }
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/uptrace/bun"
 )
 
@@ -14,6 +17,18 @@ const (
 	SocialTypeTeletop         SocialType = "teletop"
 )
 
+const (
+	SocialRecurrenceDaily  = "daily"
+	SocialRecurrenceWeekly = "weekly"
+)
+
+// Verifiers a link can declare, a link without one is checked by the verifier of its link type
+const (
+	SocialVerifierTelegram     = "telegram"
+	SocialVerifierNone         = "none"
+	SocialVerifierHTTPCallback = "http_callback"
+)
+
 type SocialTask struct {
 	bun.BaseModel `bun:"table:social_task"`
 	ID            int64   `bun:"id,pk,autoincrement" json:"id"`
@@ -26,7 +41,65 @@ type SocialTask struct {
 	SessionIndex  int     `bun:"session_index" json:"session_index"`
 	Enabled       bool    `bun:"enabled" json:"-"`
 	Links         []Link  `bun:"links,type:jsonb" json:"links"`
-	IsPublic      bool    `bun:"is_public" json:"is_public"` //if is public = false -> it's in arena
+	IsPublic      bool    `bun:"is_public" json:"is_public"`             //if is public = false -> it's in arena
+	PartnerID     *int64  `bun:"partner_id" json:"partner_id,omitempty"` // sponsor notified of the completions
+
+	Recurrence     string     `bun:"recurrence" json:"recurrence,omitempty"` // daily or weekly, a link is then completed once per period
+	StartAt        *time.Time `bun:"start_at" json:"start_at,omitempty"`
+	EndAt          *time.Time `bun:"end_at" json:"end_at,omitempty"`
+	MaxCompletions int        `bun:"max_completions" json:"max_completions,omitempty"` // completions of each link by all users in a period, 0 is unlimited
+
+	// the parameters of the link verifiers by link id, kept out of the links so they are never sent to the clients
+	VerifierParams map[int]map[string]string `bun:"verifier_params,type:jsonb" json:"-"`
+}
+
+// IsOpen tells whether the task can be done at that time
+func (task *SocialTask) IsOpen(now time.Time) bool {
+	if task.StartAt != nil && now.Before(*task.StartAt) {
+		return false
+	}
+	if task.EndAt != nil && !now.Before(*task.EndAt) {
+		return false
+	}
+
+	return true
+}
+
+// Period identifies the recurrence period at that time in UTC, it's empty for a task done once
+func (task *SocialTask) Period(now time.Time) string {
+	now = now.UTC()
+	switch task.Recurrence {
+	case SocialRecurrenceDaily:
+		return now.Format("2006-01-02")
+	case SocialRecurrenceWeekly:
+		year, week := now.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+
+	return ""
+}
+
+// PeriodEnd is when the current period ends, nil for a task done once
+func (task *SocialTask) PeriodEnd(now time.Time) *time.Time {
+	day := now.UTC().Truncate(24 * time.Hour)
+	var end time.Time
+	switch task.Recurrence {
+	case SocialRecurrenceDaily:
+		end = day.AddDate(0, 0, 1)
+	case SocialRecurrenceWeekly:
+		// weeks start on monday
+		end = day.AddDate(0, 0, 7-(int(day.Weekday())+6)%7)
+	default:
+		return nil
+	}
+
+	return &end
+}
+
+// SetLinkPeriod sets the current period of the task on the link
+func (task *SocialTask) SetLinkPeriod(link *Link, now time.Time) {
+	link.Period = task.Period(now)
+	link.PeriodEnd = task.PeriodEnd(now)
 }
 
 type Link struct {
@@ -39,6 +112,45 @@ type Link struct {
 	Gem         int        `json:"star,omitempty"`
 	Description string     `json:"description,omitempty"`
 	Priority    int        `json:"priority,omitempty"`
+	// Verifier and its parameters, e.g. "http_callback" with {"url": "https://partner/check?uid={user_id}", "path": "data.done"}.
+	// The parameters are stored in SocialTask.VerifierParams and only set on the link being verified
+	Verifier       string            `json:"verifier,omitempty"`
+	VerifierParams map[string]string `json:"-"`
+
+	// set when the task is read: the current period of a recurring task and whether the link can't be completed anymore
+	Period    string     `json:"period,omitempty"`
+	PeriodEnd *time.Time `json:"period_end,omitempty"`
+	SoldOut   bool       `json:"sold_out,omitempty"`
+}
+
+// CompletionKey identifies the completion of the link in its period
+func (link *Link) CompletionKey() string {
+	if link.Period == "" {
+		return link.Url
+	}
+
+	return link.Url + "#" + link.Period
+}
+
+// CompletionTTL is how long the completion of the link is kept: until the end of its period, or forever
+func (link *Link) CompletionTTL(now time.Time) time.Duration {
+	if link.PeriodEnd == nil {
+		return 0
+	}
+	if ttl := link.PeriodEnd.Sub(now); ttl > 0 {
+		return ttl
+	}
+
+	return time.Second
+}
+
+// VerifierName is the verifier declared by the link, or its link type
+func (link *Link) VerifierName() string {
+	if link.Verifier != "" {
+		return link.Verifier
+	}
+
+	return string(link.LinkType)
 }
 
 type UserTask struct {
@@ -47,3 +159,28 @@ type UserTask struct {
 	TaskID    int64  `bun:"task_id" json:"task_id"`
 	Completed bool   `bun:"completed" json:"completed"`
 }
+
+// SocialTaskCompletion is a rewarded completion of a task link, once per user and period
+type SocialTaskCompletion struct {
+	bun.BaseModel `bun:"table:social_task_completion"`
+	ID            int64     `bun:"id,pk,autoincrement" json:"id"`
+	TaskID        int64     `bun:"task_id,notnull" json:"task_id"`
+	LinkID        int       `bun:"link_id,notnull" json:"link_id"`
+	UserID        string    `bun:"user_id,notnull" json:"user_id"`
+	Period        string    `bun:"period,notnull,default:''" json:"period"`
+	Gems          int       `bun:"gems,notnull,default:0" json:"gems"`
+	CreatedAt     time.Time `bun:"created_at,default:current_timestamp" json:"created_at"`
+
+	// set by the re-verification of the telegram tasks
+	CheckedAt *time.Time `bun:"checked_at" json:"checked_at,omitempty"`
+	LeftAt    *time.Time `bun:"left_at" json:"left_at,omitempty"`
+	RevokedAt *time.Time `bun:"revoked_at" json:"revoked_at,omitempty"` // the gems were clawed back
+}
+
+// SocialTaskClawback is a completion taken back from a user who left the telegram channel or group
+type SocialTaskClawback struct {
+	UserID string `json:"user_id"`
+	Title  string `json:"title"`
+	Url    string `json:"url"`
+	Gems   int    `json:"gems"`
+}
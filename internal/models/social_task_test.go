@@ -0,0 +1,78 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSocialTaskPeriod(t *testing.T) {
+	tests := []struct {
+		recurrence string
+		now        time.Time
+		period     string
+		end        string
+	}{
+		{"", time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), "", ""},
+		{SocialRecurrenceDaily, time.Date(2026, 10, 19, 23, 59, 59, 0, time.UTC), "2026-10-19", "2026-10-20T00:00:00Z"},
+		// the period is taken in UTC
+		{SocialRecurrenceDaily, time.Date(2026, 10, 20, 1, 0, 0, 0, time.FixedZone("UTC+7", 7*3600)), "2026-10-19", "2026-10-20T00:00:00Z"},
+		// weeks start on monday
+		{SocialRecurrenceWeekly, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), "2026-W43", "2026-10-26T00:00:00Z"},
+		{SocialRecurrenceWeekly, time.Date(2026, 10, 25, 23, 0, 0, 0, time.UTC), "2026-W43", "2026-10-26T00:00:00Z"},
+		// ISO weeks overlap the years
+		{SocialRecurrenceWeekly, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), "2026-W53", "2027-01-04T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		task := &SocialTask{Recurrence: tt.recurrence}
+
+		if got := task.Period(tt.now); got != tt.period {
+			t.Errorf("%q at %s: period = %q, want %q", tt.recurrence, tt.now, got, tt.period)
+		}
+
+		end := task.PeriodEnd(tt.now)
+		if tt.end == "" {
+			if end != nil {
+				t.Errorf("%q at %s: period end = %s, want none", tt.recurrence, tt.now, end)
+			}
+			continue
+		}
+		if end == nil || end.Format(time.RFC3339) != tt.end {
+			t.Errorf("%q at %s: period end = %v, want %s", tt.recurrence, tt.now, end, tt.end)
+		}
+	}
+}
+
+func TestLinkCompletionKey(t *testing.T) {
+	now := time.Date(2026, 10, 19, 18, 0, 0, 0, time.UTC)
+
+	once := &Link{Url: "https://t.me/channel"}
+	(&SocialTask{}).SetLinkPeriod(once, now)
+	if got := once.CompletionKey(); got != "https://t.me/channel" {
+		t.Errorf("completion key = %q", got)
+	}
+	if got := once.CompletionTTL(now); got != 0 {
+		t.Errorf("completion ttl = %s, want forever", got)
+	}
+
+	daily := &Link{Url: "https://t.me/channel"}
+	(&SocialTask{Recurrence: SocialRecurrenceDaily}).SetLinkPeriod(daily, now)
+	if got := daily.CompletionKey(); got != "https://t.me/channel#2026-10-19" {
+		t.Errorf("completion key = %q", got)
+	}
+	if got := daily.CompletionTTL(now); got != 6*time.Hour {
+		t.Errorf("completion ttl = %s, want 6h", got)
+	}
+
+	// the next day is a new completion
+	next := &Link{Url: "https://t.me/channel"}
+	(&SocialTask{Recurrence: SocialRecurrenceDaily}).SetLinkPeriod(next, now.Add(6*time.Hour))
+	if next.CompletionKey() == daily.CompletionKey() {
+		t.Errorf("completion key %q is shared by two days", next.CompletionKey())
+	}
+
+	// a period that just ended is still kept a moment rather than forever
+	if got := daily.CompletionTTL(now.Add(7 * time.Hour)); got != time.Second {
+		t.Errorf("completion ttl after the period = %s, want 1s", got)
+	}
+}
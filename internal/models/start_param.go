@@ -0,0 +1,139 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// A start parameter is a list of "<key>_<value>" segments joined by "__", e.g. "c_summer__a_weekly-cup__src_x".
+// Telegram only allows [A-Za-z0-9_-] up to 64 characters. A parameter without a known key is a plain ref code,
+// which is what the links shared before this format carry
+const (
+	StartParamReferral  = "r"
+	StartParamCampaign  = "c"
+	StartParamArena     = "a"
+	StartParamChallenge = "ch"
+	StartParamShopItem  = "s"
+	StartParamSource    = "src"
+
+	StartParamMaxLength = 64
+
+	StartRouteHome      = "home"
+	StartRouteArena     = "arena"
+	StartRouteChallenge = "challenge"
+	StartRouteShopItem  = "shop_item"
+)
+
+type StartParam struct {
+	Raw       string `json:"raw"`
+	RefCode   string `json:"ref_code,omitempty"`
+	Campaign  string `json:"campaign,omitempty"`
+	Arena     string `json:"arena,omitempty"`
+	Challenge string `json:"challenge,omitempty"`
+	ShopItem  string `json:"shop_item,omitempty"`
+	Source    string `json:"source,omitempty"`
+}
+
+// StartRoute tells the client which screen to open after the login
+type StartRoute struct {
+	Screen string            `json:"screen"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+func validStartParam(raw string) bool {
+	if raw == "" || len(raw) > StartParamMaxLength {
+		return false
+	}
+
+	for _, r := range raw {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseStartParam returns nil for an empty or malformed parameter
+func ParseStartParam(raw string) *StartParam {
+	raw = strings.TrimSpace(raw)
+	if !validStartParam(raw) {
+		return nil
+	}
+
+	param := &StartParam{Raw: raw}
+	for i, segment := range strings.Split(raw, "__") {
+		key, value, ok := strings.Cut(segment, "_")
+		if !ok || value == "" {
+			key = ""
+		}
+
+		switch key {
+		case StartParamReferral:
+			param.RefCode = value
+		case StartParamCampaign:
+			param.Campaign = value
+		case StartParamArena:
+			param.Arena = value
+		case StartParamChallenge:
+			param.Challenge = value
+		case StartParamShopItem:
+			param.ShopItem = value
+		case StartParamSource:
+			param.Source = value
+		default:
+			if i == 0 {
+				return &StartParam{Raw: raw, RefCode: raw}
+			}
+		}
+	}
+
+	return param
+}
+
+// InviteCode is the code crediting an inviter, a campaign code wins over a personal ref code
+func (param *StartParam) InviteCode() string {
+	if param.Campaign != "" {
+		return param.Campaign
+	}
+
+	return param.RefCode
+}
+
+// Route picks the screen opened by the link, the most specific target wins
+func (param *StartParam) Route() *StartRoute {
+	switch {
+	case param.Challenge != "":
+		return &StartRoute{Screen: StartRouteChallenge, Params: map[string]string{"id": param.Challenge}}
+	case param.Arena != "":
+		return &StartRoute{Screen: StartRouteArena, Params: map[string]string{"slug": param.Arena}}
+	case param.ShopItem != "":
+		return &StartRoute{Screen: StartRouteShopItem, Params: map[string]string{"id": param.ShopItem}}
+	}
+
+	return &StartRoute{Screen: StartRouteHome}
+}
+
+// DeepLinkClick counts the clicks on a link per day
+type DeepLinkClick struct {
+	bun.BaseModel `bun:"table:deep_link_click"`
+	Param         string    `bun:"param,pk" json:"param"`
+	Day           time.Time `bun:"day,pk,type:date" json:"day"`
+	Clicks        int       `bun:"clicks,notnull,default:0" json:"clicks"`
+}
+
+type AttributionReportRow struct {
+	Param   string  `bun:"param" json:"param"`
+	Clicks  int     `bun:"clicks" json:"clicks"`
+	Signups int     `bun:"signups" json:"signups"`
+	Touches int     `bun:"touches" json:"touches"`
+	Rate    float64 `bun:"-" json:"signup_rate"`
+}
+
+type AttributionReport struct {
+	From time.Time               `json:"from"`
+	To   time.Time               `json:"to"`
+	Rows []*AttributionReportRow `json:"rows"`
+}
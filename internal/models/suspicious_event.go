@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	SuspiciousEventQuestionTokenMissing  = "question_token_missing"
+	SuspiciousEventQuestionTokenInvalid  = "question_token_invalid"
+	SuspiciousEventQuestionTokenMismatch = "question_token_mismatch"
+	SuspiciousEventQuestionTokenExpired  = "question_token_expired"
+	SuspiciousEventQuestionTokenReused   = "question_token_reused"
+)
+
+// SuspiciousEvent is a signal recorded for anti-cheat review, it does not penalize the user by itself
+type SuspiciousEvent struct {
+	bun.BaseModel `bun:"table:suspicious_event"`
+	ID            int64                  `bun:"id,pk,autoincrement" json:"id"`
+	UserID        string                 `bun:"user_id" json:"user_id"`
+	GameSlug      string                 `bun:"game_slug" json:"game_slug"`
+	SessionID     string                 `bun:"session_id" json:"session_id"`
+	Kind          string                 `bun:"kind" json:"kind"`
+	Metadata      map[string]interface{} `bun:"metadata,type:jsonb" json:"metadata"`
+	CreatedAt     time.Time              `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
@@ -15,6 +15,7 @@ type User struct {
 	LastName              string     `bun:"last_name" json:"last_name"`
 	Username              string     `bun:"username" json:"username"`
 	LanguageCode          string     `bun:"language_code" json:"language_code"`
+	Region                *string    `bun:"region" json:"region"`
 	PhotoURL              string     `bun:"photo_url" json:"photo_url"`
 	CreatedAt             time.Time  `bun:"created_at,default:current_timestamp" json:"created_at"`
 	UpdatedAt             time.Time  `bun:"updated_at" json:"updated_at"`
@@ -30,6 +31,14 @@ type User struct {
 	LifelineBalance       int        `bun:"lifeline_balance" json:"lifeline_balance"`
 	Avatar                *string    `bun:"avatar" json:"avatar"`
 	ChatStatus            *string    `bun:"chat_status" json:"chat_status"`
+	MergedInto            *string    `bun:"merged_into" json:"-"`
+	ModerationStatus      string     `bun:"moderation_status,default:'active'" json:"-"`
+	ModerationReason      string     `bun:"moderation_reason" json:"-"`
+	ModerationExpiresAt   *time.Time `bun:"moderation_expires_at" json:"-"`
+	FirstTouchParam       *string    `bun:"first_touch_param" json:"-"`
+	FirstTouchAt          *time.Time `bun:"first_touch_at" json:"-"`
+	LastTouchParam        *string    `bun:"last_touch_param" json:"-"`
+	LastTouchAt           *time.Time `bun:"last_touch_at" json:"-"`
 
 	Boosts           int      `bun:"-" json:"boosts"`
 	IsWinner         bool     `bun:"-" json:"is_winner"`
@@ -55,6 +64,8 @@ type UserFromAuth struct {
 	Username     string `json:"username"`
 	LanguageCode string `json:"language_code"`
 	PhotoURL     string `json:"photo_url"`
+	Provider     string `json:"provider"` // empty when ID is already an internal user ID
+	StartParam   string `json:"-"`        // signed start_param of the Telegram init data
 }
 
 type Friend struct {
@@ -16,6 +16,7 @@ type UserGem struct {
 }
 
 type TotalGem struct {
-	UserID    string `json:"user_id"`
-	TotalGems int    `json:"total_gems"`
+	UserID       string     `json:"user_id"`
+	TotalGems    int        `json:"total_gems"`
+	LastEarnedAt *time.Time `json:"last_earned_at"`
 }
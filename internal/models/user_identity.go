@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	IdentityProviderLine     = "line"
+	IdentityProviderTelegram = "telegram"
+)
+
+// UserIdentity maps an external login (provider + subject) to an internal user
+type UserIdentity struct {
+	bun.BaseModel `bun:"table:user_identity"`
+	Provider      string    `bun:"provider,pk" json:"provider"`
+	Subject       string    `bun:"subject,pk" json:"subject"`
+	UserID        string    `bun:"user_id" json:"user_id"`
+	Username      string    `bun:"username" json:"username"`
+	CreatedAt     time.Time `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+// UserMerge is the audit record of an admin merge from SourceUserID into TargetUserID
+type UserMerge struct {
+	bun.BaseModel `bun:"table:user_merge"`
+	ID            int64            `bun:"id,pk,autoincrement" json:"id"`
+	SourceUserID  string           `bun:"source_user_id" json:"source_user_id"`
+	TargetUserID  string           `bun:"target_user_id" json:"target_user_id"`
+	Operator      string           `bun:"operator" json:"operator"`
+	Reason        string           `bun:"reason" json:"reason"`
+	Summary       map[string]int64 `bun:"summary,type:jsonb" json:"summary"`
+	CreatedAt     time.Time        `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+type LinkIdentityPayload struct {
+	Provider string `json:"provider"`
+	Token    string `json:"token"`
+}
+
+type MergeUsersPayload struct {
+	SourceUserID string `json:"source_user_id"`
+	TargetUserID string `json:"target_user_id"`
+	Reason       string `json:"reason"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	ModerationStatusActive       = "active"
+	ModerationStatusShadowBanned = "shadow_banned"
+	ModerationStatusBanned       = "banned"
+)
+
+var ModerationStatuses = []string{ModerationStatusActive, ModerationStatusShadowBanned, ModerationStatusBanned}
+
+// UserModeration is the audit trail of every moderation change
+type UserModeration struct {
+	bun.BaseModel  `bun:"table:user_moderation"`
+	ID             int64      `bun:"id,pk,autoincrement" json:"id"`
+	UserID         string     `bun:"user_id" json:"user_id"`
+	Status         string     `bun:"status" json:"status"`
+	PreviousStatus string     `bun:"previous_status" json:"previous_status"`
+	Reason         string     `bun:"reason" json:"reason"`
+	ExpiresAt      *time.Time `bun:"expires_at" json:"expires_at"`
+	Operator       string     `bun:"operator" json:"operator"`
+	CreatedAt      time.Time  `bun:"created_at,default:current_timestamp" json:"created_at"`
+}
+
+type ModerateUserPayload struct {
+	Status          string `json:"status"`
+	Reason          string `json:"reason"`
+	DurationInHours int    `json:"duration_in_hours"`
+}
+
+type UserModerationResponse struct {
+	UserID    string           `json:"user_id"`
+	Status    string           `json:"status"`
+	Reason    string           `json:"reason"`
+	ExpiresAt *time.Time       `json:"expires_at"`
+	History   []UserModeration `json:"history"`
+}
+
+// EffectiveModerationStatus treats an expired moderation as active
+func (user *User) EffectiveModerationStatus() string {
+	if user.ModerationStatus == "" {
+		return ModerationStatusActive
+	}
+
+	if user.ModerationExpiresAt != nil && time.Now().After(*user.ModerationExpiresAt) {
+		return ModerationStatusActive
+	}
+
+	return user.ModerationStatus
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/go-redis/cache/v9"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
 type ReadOnlyCache interface {
@@ -56,6 +57,34 @@ func UseCacheWithRO[T any](ctx context.Context, roCash ReadOnlyCache, cash Cache
 	return v, nil
 }
 
+var flights singleflight.Group
+
+// UseSharedCacheWithRO behaves like UseCacheWithRO but concurrent misses on the same key run the callback only once
+func UseSharedCacheWithRO[T any](ctx context.Context, roCash ReadOnlyCache, cash Cache, key string, ttl time.Duration, callback func() (T, error)) (T, error) {
+	var v T
+	err := roCash.Get(ctx, key, &v)
+	if !errors.Is(err, cache.ErrCacheMiss) {
+		return v, err
+	}
+
+	result, err, _ := flights.Do(key, func() (any, error) {
+		v, err := callback()
+		if err != nil {
+			return nil, err
+		}
+
+		// fire and forget
+		//nolint:errcheck
+		cash.Set(ctx, key, v, ttl)
+		return v, nil
+	})
+	if err != nil {
+		return v, err
+	}
+
+	return result.(T), nil
+}
+
 type CacheRedis struct {
 	instance *cache.Cache
 }
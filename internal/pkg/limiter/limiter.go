@@ -18,6 +18,12 @@ func Skip(ctx context.Context) context.Context {
 	return context.WithValue(ctx, ctxKeySkip, true)
 }
 
+func IsSkipped(ctx context.Context) bool {
+	skip := ctx.Value(ctxKeySkip)
+	v, ok := skip.(bool)
+	return ok && v
+}
+
 var ErrRateLimited = errors.New("rate limited")
 
 type Limiter struct {
@@ -30,19 +36,24 @@ func NewLimiter(rdb redis.UniversalClient) (*Limiter, error) {
 }
 
 func (l *Limiter) Allow(ctx context.Context, key string, limit redis_rate.Limit) error {
-	skip := ctx.Value(ctxKeySkip)
-	if v, ok := skip.(bool); ok && v {
-		return nil
+	_, err := l.AllowWithResult(ctx, key, limit)
+	return err
+}
+
+// AllowWithResult is the same as Allow but also returns the limiter state, the result is nil when the context is skipped
+func (l *Limiter) AllowWithResult(ctx context.Context, key string, limit redis_rate.Limit) (*redis_rate.Result, error) {
+	if IsSkipped(ctx) {
+		return nil, nil
 	}
 
 	res, err := l.limiter.Allow(ctx, key, limit)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if res.Allowed <= 0 {
-		return ErrRateLimited
+		return res, ErrRateLimited
 	}
 
-	return nil
+	return res, nil
 }
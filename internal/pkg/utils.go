@@ -19,3 +19,32 @@ func GetFirstTimeOfCurrentWeek() time.Time {
 
 	return today.Truncate(time.Hour * 168)
 }
+
+// GetSeasonBounds returns the season containing t, weeks start on monday and custom seasons repeat every lengthInDays from anchor
+func GetSeasonBounds(period string, loc *time.Location, lengthInDays int, anchor time.Time, t time.Time) (time.Time, time.Time) {
+	local := t.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	switch period {
+	case "monthly":
+		start := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	case "custom":
+		if lengthInDays <= 0 {
+			lengthInDays = 7
+		}
+		anchor = anchor.In(loc)
+		start := time.Date(anchor.Year(), anchor.Month(), anchor.Day(), 0, 0, 0, 0, loc)
+		for !start.After(today) {
+			start = start.AddDate(0, 0, lengthInDays)
+		}
+		for start.After(local) {
+			start = start.AddDate(0, 0, -lengthInDays)
+		}
+		return start, start.AddDate(0, 0, lengthInDays)
+	default:
+		offset := (int(today.Weekday()) + 6) % 7
+		start := today.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 7)
+	}
+}
@@ -0,0 +1,471 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+const (
+	antiCheatLatencyScore         = 40
+	antiCheatLatencyVarianceScore = 30
+	antiCheatPerfectRunScore      = 30
+	antiCheatIdenticalTimingScore = 40
+	antiCheatReferralClusterScore = 30
+
+	antiCheatMinLatencyAnswers    = 3
+	antiCheatMinVarianceAnswers   = 5
+	antiCheatMaxLatencyStddevInMs = 100
+	antiCheatMinPerfectSessions   = 5
+	antiCheatPerfectRunRatio      = 0.8
+	antiCheatTimingBucketInMs     = 50
+	antiCheatMinTimingAnswers     = 4
+	antiCheatTimingWindow         = 24 * time.Hour
+	antiCheatMinFlaggedInvitees   = 3
+)
+
+type ServiceAntiCheat struct {
+	container          *do.Injector
+	redisDB            redis.UniversalClient
+	postgresDB         *bun.DB
+	readonlyPostgresDB *bun.DB
+	serviceConfig      *ServiceConfig
+}
+
+func NewServiceAntiCheat(container *do.Injector) (*ServiceAntiCheat, error) {
+	redisDB, err := do.InvokeNamed[redis.UniversalClient](container, "redis-db")
+	if err != nil {
+		return nil, err
+	}
+
+	postgresDB, err := do.Invoke[*bun.DB](container)
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyPostgresDB, err := do.InvokeNamed[*bun.DB](container, "db-readonly")
+	if err != nil {
+		return nil, err
+	}
+
+	serviceConfig, err := do.Invoke[*ServiceConfig](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceAntiCheat{container, redisDB, postgresDB, readonlyPostgresDB, serviceConfig}, nil
+}
+
+// RecordSuspiciousEvent never fails the caller, a lost event is only logged
+func (service *ServiceAntiCheat) RecordSuspiciousEvent(ctx context.Context, event *models.SuspiciousEvent) {
+	err := datastore.InsertSuspiciousEvent(ctx, service.postgresDB, event)
+	if err != nil {
+		log.Println("RecordSuspiciousEvent error:", err, "user:", event.UserID, "kind:", event.Kind)
+	}
+}
+
+func (service *ServiceAntiCheat) GetUserSuspiciousEvents(ctx context.Context, userID string, limit int) ([]models.SuspiciousEvent, error) {
+	return datastore.GetUserSuspiciousEvents(ctx, service.readonlyPostgresDB, userID, limit)
+}
+
+// ScoreSession runs the anti-cheat signals on an ended session, users scoring above the threshold are put in the review queue
+func (service *ServiceAntiCheat) ScoreSession(ctx context.Context, user *models.User, game *models.Game, session *models.GameSession) {
+	if user == nil || game == nil || session == nil {
+		return
+	}
+
+	latencies := sessionLatencies(session)
+	signals := []models.AntiCheatSignal{}
+	signals = append(signals, service.latencySignals(ctx, latencies)...)
+
+	if signal := service.perfectRunSignal(ctx, user, game); signal != nil {
+		signals = append(signals, *signal)
+	}
+
+	if signal := service.identicalTimingSignal(ctx, user, game, latencies); signal != nil {
+		signals = append(signals, *signal)
+	}
+
+	if signal := service.referralClusterSignal(ctx, user); signal != nil {
+		signals = append(signals, *signal)
+	}
+
+	score := 0
+	for i := range signals {
+		signals[i].GameSlug = game.Slug
+		signals[i].SessionID = session.LegacyID
+		signals[i].At = time.Now()
+		score += signals[i].Score
+	}
+
+	if score == 0 {
+		return
+	}
+
+	service.RecordSuspiciousEvent(ctx, &models.SuspiciousEvent{
+		UserID:    user.ID,
+		GameSlug:  game.Slug,
+		SessionID: session.LegacyID,
+		Kind:      models.SuspiciousEventSessionScored,
+		Metadata:  map[string]interface{}{"score": score, "signals": signals},
+	})
+
+	threshold, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_ANTI_CHEAT_FLAG_THRESHOLD, DEFAULT_ANTI_CHEAT_FLAG_THRESHOLD)
+	if score < threshold {
+		return
+	}
+
+	actions := service.getAutoActions(ctx)
+	err := datastore.UpsertOpenAntiCheatFlag(ctx, service.postgresDB, &models.AntiCheatFlag{
+		UserID:  user.ID,
+		Score:   score,
+		Signals: signals,
+		Actions: actions,
+	})
+	if err != nil {
+		log.Println("UpsertOpenAntiCheatFlag error:", err, "user:", user.ID)
+		return
+	}
+
+	for _, action := range actions {
+		if action == models.AntiCheatActionShadowBan {
+			service.shadowBan(ctx, user, score)
+			continue
+		}
+
+		if err := redis_store.AddAntiCheatRestriction(ctx, service.redisDB, action, user.ID); err != nil {
+			log.Println("AddAntiCheatRestriction error:", err, "user:", user.ID, "action:", action)
+		}
+	}
+
+	if IsExcludedFromLeaderboards(ctx, service.redisDB, user.ID) {
+		if err := RemoveUserFromLeaderboards(ctx, service.postgresDB, service.redisDB, user.ID); err != nil {
+			log.Println("RemoveUserFromLeaderboards error:", err, "user:", user.ID)
+		}
+	}
+}
+
+func (service *ServiceAntiCheat) shadowBan(ctx context.Context, user *models.User, score int) {
+	if user.EffectiveModerationStatus() != models.ModerationStatusActive {
+		return
+	}
+
+	serviceModeration, err := do.Invoke[*ServiceModeration](service.container)
+	if err != nil {
+		log.Println("shadowBan error:", err, "user:", user.ID)
+		return
+	}
+
+	_, err = serviceModeration.Moderate(ctx, user.ID, &models.ModerateUserPayload{
+		Status: models.ModerationStatusShadowBanned,
+		Reason: fmt.Sprintf("anti-cheat score %d", score),
+	}, MODERATION_OPERATOR_ANTI_CHEAT)
+	if err != nil {
+		log.Println("shadowBan error:", err, "user:", user.ID)
+	}
+}
+
+func (service *ServiceAntiCheat) IsRewardHeld(ctx context.Context, userID string) bool {
+	held, err := redis_store.IsAntiCheatRestricted(ctx, service.redisDB, models.AntiCheatActionHoldRewards, userID)
+	if err != nil {
+		log.Println("IsAntiCheatRestricted error:", err, "user:", userID)
+		return false
+	}
+
+	return held
+}
+
+func (service *ServiceAntiCheat) IsExcludedFromLeaderboards(ctx context.Context, userID string) bool {
+	return IsExcludedFromLeaderboards(ctx, service.redisDB, userID)
+}
+
+func (service *ServiceAntiCheat) getAutoActions(ctx context.Context) []string {
+	value, _ := service.serviceConfig.GetStringConfig(ctx, CONFIG_ANTI_CHEAT_AUTO_ACTIONS, "")
+
+	actions := []string{}
+	for _, action := range strings.Split(value, ",") {
+		action = strings.TrimSpace(action)
+		if slices.Contains(models.AntiCheatActions, action) && !slices.Contains(actions, action) {
+			actions = append(actions, action)
+		}
+	}
+
+	return actions
+}
+
+func (service *ServiceAntiCheat) latencySignals(ctx context.Context, latencies []int64) []models.AntiCheatSignal {
+	signals := []models.AntiCheatSignal{}
+	if len(latencies) < antiCheatMinLatencyAnswers {
+		return signals
+	}
+
+	minLatency, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_ANTI_CHEAT_MIN_LATENCY_IN_MS, DEFAULT_ANTI_CHEAT_MIN_LATENCY_IN_MS)
+
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+	if median < int64(minLatency) {
+		signals = append(signals, models.AntiCheatSignal{
+			Name:   models.AntiCheatSignalLatency,
+			Score:  antiCheatLatencyScore,
+			Detail: fmt.Sprintf("median answer latency %dms below %dms", median, minLatency),
+		})
+	}
+
+	if len(latencies) < antiCheatMinVarianceAnswers {
+		return signals
+	}
+
+	var sum float64
+	for _, latency := range latencies {
+		sum += float64(latency)
+	}
+	mean := sum / float64(len(latencies))
+
+	var variance float64
+	for _, latency := range latencies {
+		variance += math.Pow(float64(latency)-mean, 2)
+	}
+	stddev := math.Sqrt(variance / float64(len(latencies)))
+
+	if stddev < antiCheatMaxLatencyStddevInMs {
+		signals = append(signals, models.AntiCheatSignal{
+			Name:   models.AntiCheatSignalLatency,
+			Score:  antiCheatLatencyVarianceScore,
+			Detail: fmt.Sprintf("answer latency stddev %.0fms over %d answers", stddev, len(latencies)),
+		})
+	}
+
+	return signals
+}
+
+func (service *ServiceAntiCheat) perfectRunSignal(ctx context.Context, user *models.User, game *models.Game) *models.AntiCheatSignal {
+	if len(game.Questions) == 0 {
+		return nil
+	}
+
+	stats, err := datastore.GetUserSessionStats(ctx, service.readonlyPostgresDB, user.ID, game.Slug, time.Now().Add(-antiCheatTimingWindow), len(game.Questions))
+	if err != nil {
+		log.Println("GetUserSessionStats error:", err, "user:", user.ID)
+		return nil
+	}
+
+	if stats.Total < antiCheatMinPerfectSessions || float64(stats.Perfect) < antiCheatPerfectRunRatio*float64(stats.Total) {
+		return nil
+	}
+
+	return &models.AntiCheatSignal{
+		Name:   models.AntiCheatSignalPerfectRun,
+		Score:  antiCheatPerfectRunScore,
+		Detail: fmt.Sprintf("%d perfect runs out of %d sessions in 24h", stats.Perfect, stats.Total),
+	}
+}
+
+func (service *ServiceAntiCheat) identicalTimingSignal(ctx context.Context, user *models.User, game *models.Game, latencies []int64) *models.AntiCheatSignal {
+	if len(latencies) < antiCheatMinTimingAnswers {
+		return nil
+	}
+
+	buckets := make([]string, len(latencies))
+	for i, latency := range latencies {
+		buckets[i] = strconv.FormatInt(latency/antiCheatTimingBucketInMs, 10)
+	}
+	hash := sha256.Sum256([]byte(strings.Join(buckets, ",")))
+
+	members, err := redis_store.AddAntiCheatTiming(ctx, service.redisDB, game.Slug, hex.EncodeToString(hash[:]), user.ID, antiCheatTimingWindow)
+	if err != nil {
+		log.Println("AddAntiCheatTiming error:", err, "user:", user.ID)
+		return nil
+	}
+
+	others := []string{}
+	for _, member := range members {
+		if member != user.ID {
+			others = append(others, member)
+		}
+	}
+
+	if len(others) == 0 {
+		return nil
+	}
+
+	sort.Strings(others)
+	return &models.AntiCheatSignal{
+		Name:   models.AntiCheatSignalIdenticalTiming,
+		Score:  antiCheatIdenticalTimingScore,
+		Detail: fmt.Sprintf("answer timing identical to %s", strings.Join(others, ",")),
+	}
+}
+
+func (service *ServiceAntiCheat) referralClusterSignal(ctx context.Context, user *models.User) *models.AntiCheatSignal {
+	if user.InviterID == nil || *user.InviterID == "" {
+		return nil
+	}
+
+	count, err := datastore.CountFlaggedInvitees(ctx, service.readonlyPostgresDB, *user.InviterID)
+	if err != nil {
+		log.Println("CountFlaggedInvitees error:", err, "inviter:", *user.InviterID)
+		return nil
+	}
+
+	if count < antiCheatMinFlaggedInvitees {
+		return nil
+	}
+
+	return &models.AntiCheatSignal{
+		Name:   models.AntiCheatSignalReferralCluster,
+		Score:  antiCheatReferralClusterScore,
+		Detail: fmt.Sprintf("%d flagged users invited by %s", count, *user.InviterID),
+	}
+}
+
+func sessionLatencies(session *models.GameSession) []int64 {
+	indexes := make([]int, 0, len(session.History))
+	for index := range session.History {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	latencies := []int64{}
+	for _, index := range indexes {
+		history := session.History[index]
+		if history.AnsweredAt == nil || history.StartedAt.IsZero() {
+			continue
+		}
+		latencies = append(latencies, history.AnsweredAt.Sub(history.StartedAt).Milliseconds())
+	}
+
+	return latencies
+}
+
+// IsExcludedFromLeaderboards is shared with the bot, which does not use the container
+func IsExcludedFromLeaderboards(ctx context.Context, redisDB redis.Cmdable, userID string) bool {
+	restricted, err := redis_store.IsAntiCheatRestricted(ctx, redisDB, models.AntiCheatActionExcludeLeaderboard, userID)
+	if err != nil {
+		log.Println("IsAntiCheatRestricted error:", err, "user:", userID)
+		return false
+	}
+
+	return restricted
+}
+
+func RemoveUserFromLeaderboards(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, userID string) error {
+	boards := []string{LEADERBOARD_OVERALL, LEADERBOARD_OVERALL_WEEKLY, LEADERBOARD_REFERRAL}
+
+	games, err := datastore.GetEnabledGames(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, game := range games {
+		boards = append(boards, game.Slug)
+	}
+
+	arenas, err := datastore.GetEnabledArenas(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, arena := range arenas {
+		boards = append(boards, arena.GameSlug, DBKeyArena(arena.Slug))
+	}
+
+	user, err := datastore.FindUserByID(ctx, db, userID)
+	if err == nil && user.LeaderboardRegion() != "" {
+		regional, err := regionalBoards(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, board := range regional {
+			boards = append(boards, RegionalLeaderboardName(board, user.LeaderboardRegion()))
+		}
+	}
+
+	for _, board := range boards {
+		if err := redis_store.RemoveFromLeaderboard(ctx, redisDB, board, userID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RestoreUserLeaderboards puts back the scores computed from the database, arena boards are filled again on the next play
+func RestoreUserLeaderboards(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, userID string) error {
+	user, err := datastore.FindUserByID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+
+	total, err := datastore.GetUserTotalGem(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+
+	seasonConfig := LoadSeasonConfig(ctx, db)
+	thisSeason := GetSeasonStart(seasonConfig, time.Now())
+	weekly, err := datastore.GetUserTotalGemFromTime(ctx, db, userID, &thisSeason)
+	if err != nil {
+		return err
+	}
+
+	lastGemAt, err := datastore.GetUserLastGemAt(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+
+	items := map[string]*models.LeaderboardItem{
+		LEADERBOARD_OVERALL:        {UserId: userID, Score: float64(total), ReachedAt: lastGemAt},
+		LEADERBOARD_OVERALL_WEEKLY: {UserId: userID, Score: float64(weekly), ReachedAt: lastGemAt},
+	}
+	if IsSeasonOver(ctx, redisDB, LEADERBOARD_OVERALL_WEEKLY, seasonConfig) {
+		delete(items, LEADERBOARD_OVERALL_WEEKLY)
+	}
+	if user.TotalInvites > 0 {
+		lastInviteAt, _ := datastore.GetLastReferralValidatedAt(ctx, db, userID)
+		items[LEADERBOARD_REFERRAL] = &models.LeaderboardItem{UserId: userID, Score: float64(user.TotalInvites), ReachedAt: lastInviteAt}
+	}
+
+	games, err := datastore.GetEnabledGames(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, game := range games {
+		sessionSumary, err := datastore.GetUserGameSessionSumary(ctx, db, game.Slug, userID)
+		if err != nil || sessionSumary == nil || sessionSumary.TotalScore == 0 {
+			continue
+		}
+		items[game.Slug] = &models.LeaderboardItem{UserId: userID, Score: float64(sessionSumary.TotalScore), ReachedAt: sessionSumary.LastEndedAt}
+	}
+
+	for board, item := range items {
+		_, err := redis_store.SetLeaderboard(ctx, redisDB, board, item)
+		if err != nil {
+			return err
+		}
+
+		if board == LEADERBOARD_REFERRAL {
+			continue
+		}
+
+		if err := SetRegionalLeaderboard(ctx, redisDB, user.LeaderboardRegion(), board, item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -114,6 +114,10 @@ func (service *ServiceArena) UpdateArenaLeaderboard(ctx context.Context, user *m
 		return errors.New("arena is ended")
 	}
 
+	if IsExcludedFromLeaderboards(ctx, service.redisDB, user.ID) {
+		return nil
+	}
+
 	serviceUserGame, err := do.Invoke[*ServiceUserGame](service.container)
 	if err != nil {
 		return err
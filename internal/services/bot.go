@@ -2,6 +2,9 @@ package services
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io"
@@ -10,6 +13,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -78,6 +83,72 @@ func (bot *Bot) Validate(idToken string) (*models.UserFromAuth, error) {
 		ID:       lineVerifyResponse.Sub,
 		Username: lineVerifyResponse.Name,
 		PhotoURL: lineVerifyResponse.Picture,
+		Provider: models.IdentityProviderLine,
+	}, nil
+}
+
+type TelegramInitUser struct {
+	ID           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code"`
+	IsBot        bool   `json:"is_bot"`
+	IsPremium    bool   `json:"is_premium"`
+	PhotoURL     string `json:"photo_url"`
+}
+
+// ValidateTelegram checks the init data of a Telegram mini app against the bot token,
+// see https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app
+func (bot *Bot) ValidateTelegram(initData string) (*models.UserFromAuth, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return nil, errorx.Wrap(errors.New("invalid telegram init data"), errorx.Authn)
+	}
+
+	hash := values.Get("hash")
+	if hash == "" {
+		return nil, errorx.Wrap(errors.New("missing telegram hash"), errorx.Authn)
+	}
+
+	pairs := []string{}
+	for k := range values {
+		if k == "hash" {
+			continue
+		}
+		pairs = append(pairs, k+"="+values.Get(k))
+	}
+	sort.Strings(pairs)
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(bot.token))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(hash)) {
+		return nil, errorx.Wrap(errors.New("invalid telegram hash"), errorx.Authn)
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil || time.Since(time.Unix(authDate, 0)) > 24*time.Hour {
+		return nil, errorx.Wrap(errors.New("telegram init data expired"), errorx.Authn)
+	}
+
+	var telegramUser TelegramInitUser
+	if err := json.Unmarshal([]byte(values.Get("user")), &telegramUser); err != nil || telegramUser.ID == 0 {
+		return nil, errorx.Wrap(errors.New("invalid telegram user"), errorx.Authn)
+	}
+
+	return &models.UserFromAuth{
+		ID:           strconv.FormatInt(telegramUser.ID, 10),
+		FirstName:    telegramUser.FirstName,
+		LastName:     telegramUser.LastName,
+		Username:     telegramUser.Username,
+		LanguageCode: telegramUser.LanguageCode,
+		IsBot:        telegramUser.IsBot,
+		IsPremium:    telegramUser.IsPremium,
+		PhotoURL:     telegramUser.PhotoURL,
+		Provider:     models.IdentityProviderTelegram,
+		StartParam:   values.Get("start_param"),
 	}, nil
 }
 
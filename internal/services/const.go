@@ -11,6 +11,16 @@ var ErrGameSessionLock = errors.New("session game locked")
 var ErrUserBoostLock = errors.New("user boost locked")
 var ErrUserGameLock = errors.New("user game locked")
 var ErrFullMoonLock = errors.New("full moon locked")
+var ErrUserMergeLock = errors.New("user merge locked")
+var ErrUserBanned = errors.New("user is banned")
+var ErrPrizeDistributionLock = errors.New("prize distribution locked")
+var ErrUserRewardLock = errors.New("user reward locked")
+var ErrInvalidLeaderboardCursor = errors.New("invalid leaderboard cursor")
+var ErrUnknownLeaderboard = errors.New("unknown leaderboard")
+var ErrInvalidRegion = errors.New("invalid region")
+var ErrReferralNotValidated = errors.New("referral is not validated yet")
+var ErrUnknownReferralStatus = errors.New("unknown referral status")
+var ErrUnknownGameRecordWindow = errors.New("unknown record window")
 
 const (
 	CONFIG_SERVER_MODE                    = "SERVER_MODE"
@@ -30,6 +40,31 @@ const (
 	CONFIG_MOON_TIME_PER_RANGE_IN_MINUTES = "MOON_TIME_PER_RANGE_IN_MINUTES"
 	CONFIG_MOON_EXPIRED_TIME_IN_MINUTES   = "MOON_EXPIRED_TIME_IN_MINUTES"
 	CONFIG_MOON_RANDOM_UNIT_IN_MINUTES    = "MOON_RANDOM_UNIT_IN_MINUTES"
+	CONFIG_RATE_LIMIT_POLICIES            = "RATE_LIMIT_POLICIES"
+	CONFIG_IDEMPOTENCY_WINDOW_IN_MINUTES  = "IDEMPOTENCY_WINDOW_IN_MINUTES"
+	CONFIG_QUESTION_TOKEN_ENFORCED        = "QUESTION_TOKEN_ENFORCED"
+	CONFIG_QUESTION_TOKEN_TTL_IN_SECONDS  = "QUESTION_TOKEN_TTL_IN_SECONDS"
+	CONFIG_ANTI_CHEAT_FLAG_THRESHOLD      = "ANTI_CHEAT_FLAG_THRESHOLD"
+	CONFIG_ANTI_CHEAT_AUTO_ACTIONS        = "ANTI_CHEAT_AUTO_ACTIONS"
+	CONFIG_ANTI_CHEAT_MIN_LATENCY_IN_MS   = "ANTI_CHEAT_MIN_LATENCY_IN_MS"
+	CONFIG_SEASON_PERIOD                  = "SEASON_PERIOD"
+	CONFIG_SEASON_TIMEZONE                = "SEASON_TIMEZONE"
+	CONFIG_SEASON_LENGTH_IN_DAYS          = "SEASON_LENGTH_IN_DAYS"
+	CONFIG_SEASON_ANCHOR                  = "SEASON_ANCHOR"
+	CONFIG_SEASON_PRIZES                  = "SEASON_PRIZES"
+	CONFIG_FRIENDS_LEADERBOARD_DEPTH      = "FRIENDS_LEADERBOARD_DEPTH"
+	CONFIG_REFERRAL_COMMISSION_TIERS      = "REFERRAL_COMMISSION_TIERS"
+	CONFIG_REFERRAL_CAP_PER_INVITEE       = "REFERRAL_COMMISSION_CAP_PER_INVITEE"
+	CONFIG_REFERRAL_CAP_PER_PERIOD        = "REFERRAL_COMMISSION_CAP_PER_PERIOD"
+	CONFIG_REFERRAL_PERIOD_IN_HOURS       = "REFERRAL_COMMISSION_PERIOD_IN_HOURS"
+	CONFIG_REFERRAL_MIN_SESSIONS          = "REFERRAL_VALIDATION_MIN_SESSIONS"
+	CONFIG_REFERRAL_MIN_ACTIVE_DAYS       = "REFERRAL_VALIDATION_MIN_ACTIVE_DAYS"
+	CONFIG_REFERRAL_VALIDATION_DAYS       = "REFERRAL_VALIDATION_DEADLINE_IN_DAYS"
+	CONFIG_DEEP_LINK_TELEGRAM_URL         = "DEEP_LINK_TELEGRAM_URL"
+	CONFIG_DEEP_LINK_LINE_URL             = "DEEP_LINK_LINE_URL"
+	CONFIG_SOCIAL_REVERIFY_CLAWBACK       = "SOCIAL_REVERIFY_CLAWBACK"
+	CONFIG_SOCIAL_REVERIFY_GRACE_IN_HOURS = "SOCIAL_REVERIFY_GRACE_IN_HOURS"
+	CONFIG_SOCIAL_REVERIFY_BATCH_SIZE     = "SOCIAL_REVERIFY_BATCH_SIZE"
 
 	SERVER_MODE_DEVELOPMENT = "development"
 	SERVER_MODE_STAGING     = "staging"
@@ -44,6 +79,61 @@ const (
 	OVERALL_LEADERBOARD_DEFAULT_LIMIT        = 20
 	DEFAULT_SESSION_COUNTDOWN_IN_MINUTES     = 10
 	DEFAULT_TIME_REDUCE_PER_BOOST_IN_MINUTES = 5
+	DEFAULT_IDEMPOTENCY_WINDOW_IN_MINUTES    = 24 * 60
+	IDEMPOTENCY_IN_PROGRESS_TTL              = 1 * time.Minute
+	DEFAULT_QUESTION_TOKEN_TTL_IN_SECONDS    = 5 * 60
+	DEFAULT_ANTI_CHEAT_FLAG_THRESHOLD        = 50
+	DEFAULT_ANTI_CHEAT_MIN_LATENCY_IN_MS     = 1200
+	DEFAULT_SEASON_TIMEZONE                  = "UTC"
+	DEFAULT_SEASON_LENGTH_IN_DAYS            = 7
+	SEASON_SNAPSHOT_BATCH_SIZE               = 500
+	SEASON_LIST_DEFAULT_LIMIT                = 10
+	AROUND_ME_DEFAULT_SIZE                   = 5
+	AROUND_ME_MAX_SIZE                       = 25
+	LEADERBOARD_PAGE_DEFAULT_LIMIT           = 20
+	LEADERBOARD_PAGE_MAX_LIMIT               = 100
+	DEFAULT_FRIENDS_LEADERBOARD_DEPTH        = 2
+	FRIENDS_LEADERBOARD_MAX_DEPTH            = 4
+	FRIENDS_LEADERBOARD_MAX_SIZE             = 1000
+	GAME_RECORD_DEFAULT_LIMIT                = 10
+	GAME_RECORD_MAX_LIMIT                    = 50
+	DEFAULT_REFERRAL_COMMISSION_TIERS        = `[{"level":1,"percent":10},{"level":2,"percent":3}]`
+	DEFAULT_REFERRAL_CAP_PER_INVITEE         = 20000
+	DEFAULT_REFERRAL_CAP_PER_PERIOD          = 5000
+	DEFAULT_REFERRAL_PERIOD_IN_HOURS         = 24
+	DEFAULT_REFERRAL_MIN_SESSIONS            = 3
+	DEFAULT_REFERRAL_MIN_ACTIVE_DAYS         = 2
+	DEFAULT_REFERRAL_VALIDATION_DAYS         = 14
+	REFERRAL_VALIDATION_BATCH_SIZE           = 500
+	REFERRAL_STATUS_DEFAULT_LIMIT            = 20
+	REFERRAL_STATUS_MAX_LIMIT                = 100
+	KOL_CAMPAIGN_STATS_MAX_DAYS              = 366
+	ATTRIBUTION_REPORT_DEFAULT_DAYS          = 30
+	ATTRIBUTION_REPORT_DEFAULT_LIMIT         = 100
+	ATTRIBUTION_REPORT_MAX_LIMIT             = 1000
+	DEEP_LINK_VIA_LINE                       = "line"
+	PARTNER_WEBHOOK_MAX_PER_PARTNER          = 5
+	PARTNER_WEBHOOK_MAX_ATTEMPTS             = 8
+	PARTNER_WEBHOOK_BACKOFF_BASE             = 30 * time.Second
+	PARTNER_WEBHOOK_BACKOFF_MAX              = 6 * time.Hour
+	PARTNER_WEBHOOK_TIMEOUT                  = 10 * time.Second
+	PARTNER_WEBHOOK_LEASE                    = 2 * time.Minute
+	PARTNER_WEBHOOK_BATCH_SIZE               = 200
+	PARTNER_WEBHOOK_DELIVERY_DEFAULT_LIMIT   = 20
+	PARTNER_WEBHOOK_DELIVERY_MAX_LIMIT       = 100
+	PARTNER_KEY_PREFIX                       = "pk_"
+	PARTNER_SIGNATURE_TOLERANCE              = 5 * time.Minute
+	PARTNER_STATS_DEFAULT_DAYS               = 30
+	PARTNER_STATS_MAX_DAYS                   = 366
+	PARTNER_STATS_SCORE_BUCKETS              = 10
+	PARTNER_ARENA_REPORT_PARTICIPANTS        = "participants"
+	PARTNER_ARENA_REPORT_SCORES              = "scores"
+	PARTNER_ARENA_REPORT_RETENTION           = "retention"
+	DEFAULT_SOCIAL_REVERIFY_GRACE_IN_HOURS   = 24
+	DEFAULT_SOCIAL_REVERIFY_BATCH_SIZE       = 300
+	SOCIAL_REVERIFY_WINDOW                   = 30 * 24 * time.Hour
+	SOCIAL_REVERIFY_INTERVAL                 = 3 * 24 * time.Hour
+	SOCIAL_REVERIFY_REQUEST_INTERVAL         = 100 * time.Millisecond
 
 	CACHE_TTL_5_SECONDS  = 5 * time.Second
 	CACHE_TTL_15_SECONDS = 15 * time.Second
@@ -54,18 +144,27 @@ const (
 	CACHE_TTL_1_HOUR     = 1 * time.Hour
 	CACHE_TTL_1_DAY      = 24 * time.Hour
 
+	LEADERBOARD_TOP_CACHE_TTL = CACHE_TTL_15_SECONDS
+
 	TELEGRAM_API_BASE_URL = "https://api.telegram.org"
 
 	TELEGRAM_TASK_RATE_LIMIT_PER_MINUTE = 10
 	PARTNER_RATE_LIMIT_PER_MINUTE       = 10000
+	RATE_LIMIT_POLICY_RELOAD_INTERVAL   = 30 * time.Second
 
 	LIFELINES_PER_STAR = 3
 
 	MIN_GEM_TO_CLAIM_REF_BOOST = 16
 
-	KEY_SOCIAL_TASK = "social_task:%s:%s"
+	KEY_SOCIAL_TASK        = "social_task:%s:%s"
+	KEY_SOCIAL_TASK_PERIOD = "social_task:%s:%s:%s"
+	KEY_SOCIAL_CLAWBACK    = "social_task_clawback:%d"
 
 	TELETOP_CATIA_APP_ID = 143
+	TELETOP_VERIFY_URL   = "https://api.teletop.xyz/users/{user_id}/verify/%d"
+
+	SOCIAL_VERIFIER_MAX_RESPONSE_SIZE = 1 << 20
+	SOCIAL_VERIFIER_ENV_PREFIX        = "SOCIAL_VERIFIER_"
 
 	LINE_API_BASE_URL = "https://api.line.me/oauth2/v2.1"
 )
@@ -94,6 +193,10 @@ func LockKeyUserMoon(userID string) string {
 	return fmt.Sprintf("lock:user-moon:%d", userID)
 }
 
+func LockKeyUserMerge(userID string) string {
+	return fmt.Sprintf("lock:user-merge:%s", userID)
+}
+
 func LockKeyFullMoon() string {
 	return "lock:full-moon"
 }
@@ -120,8 +223,36 @@ func DBKeyConfig(key string) string {
 	return fmt.Sprintf("config:%s", strings.ToLower(key))
 }
 
-func DBKeyLeaderboardByUser(name string, userID string, limit int) string {
-	return fmt.Sprintf("leaderboard_by_user:%s:%d:%d", strings.ToLower(name), userID, limit)
+func DBKeySeasons(leaderboard string, limit int) string {
+	return fmt.Sprintf("seasons:%s:%d", leaderboard, limit)
+}
+
+func DBKeySeasonLeaderboardByUser(seasonID int64, userID string, limit int) string {
+	return fmt.Sprintf("season_leaderboard_by_user:%d:%s:%d", seasonID, userID, limit)
+}
+
+func DBKeyLeaderboardTop(name string, limit int) string {
+	return fmt.Sprintf("leaderboard_top:%s:%d", strings.ToLower(name), limit)
+}
+
+func DBKeyLeaderboardShadowBanned() string {
+	return "leaderboard_shadow_banned"
+}
+
+func DBKeyLeaderboardAroundMe(name string, userID string, size int) string {
+	return fmt.Sprintf("leaderboard_by_user:%s:%s:around:%d", strings.ToLower(name), userID, size)
+}
+
+func DBKeyLeaderboardFriends(name string, userID string) string {
+	return fmt.Sprintf("leaderboard_by_user:%s:%s:friends", strings.ToLower(name), userID)
+}
+
+func DBKeyGameRecords(gameSlug string, window string, limit int) string {
+	return fmt.Sprintf("game_records:%s:%s:%d", strings.ToLower(gameSlug), window, limit)
+}
+
+func DBKeyLeaderboardPage(name string, userID string, offset int, limit int) string {
+	return fmt.Sprintf("leaderboard_by_user:%s:%s:page:%d:%d", strings.ToLower(name), userID, offset, limit)
 }
 
 func DBKeyUserGameSessionSumary(gameSlug string, userID string) string {
@@ -220,6 +351,22 @@ func DBKeyPartner(slug string) string {
 	return fmt.Sprintf("partner:%s", slug)
 }
 
+func DBKeyPartnerByID(id int64) string {
+	return fmt.Sprintf("partner:id:%d", id)
+}
+
+func DBKeyPartnerKey(hash string) string {
+	return fmt.Sprintf("partner_key:%s", hash)
+}
+
+func DBKeyPartnerSignature(keyID int64, signature string) string {
+	return fmt.Sprintf("partner_signature:%d:%s", keyID, signature)
+}
+
+func DBKeyEnabledPartners() string {
+	return "partners:enabled"
+}
+
 func DBKeyUserJoined(userID string, refCode string, minGem int) string {
 	return fmt.Sprintf("user_joined:%s:%d:%d", userID, refCode, minGem)
 }
@@ -236,6 +383,10 @@ func LimitKeyUserSocialTask(userID string) string {
 	return fmt.Sprintf("users:social_task:%d", userID)
 }
 
+func LimitKeyRoute(route string, subject string) string {
+	return fmt.Sprintf("limit:route:%s:%s", route, subject)
+}
+
 func LimitKeyParner(slug string) string {
 	return fmt.Sprintf("limit:partner:%s", slug)
 }
@@ -255,3 +406,35 @@ func DBKeyArenaByGameSlug(gameSlug string) string {
 func DBKeyFriendCount(userID string) string {
 	return fmt.Sprintf("friend_count:%d", userID)
 }
+
+func LockKeyUserReward(userID string) string {
+	return fmt.Sprintf("lock:user-reward:%s", userID)
+}
+
+func LockKeyPrizeDistribution(campaign string) string {
+	return fmt.Sprintf("lock:prize-distribution:%s", campaign)
+}
+
+func LockKeyReferralCommission(inviterID string) string {
+	return fmt.Sprintf("lock:referral-commission:%s", inviterID)
+}
+
+func DBKeyReferralEarnings(userID string) string {
+	return fmt.Sprintf("referral_earnings:%s", userID)
+}
+
+func DBKeyKolCampaignByRefCode(refCode string) string {
+	return fmt.Sprintf("kol_campaign_by_ref_code:%s", refCode)
+}
+
+func DBKeyReferralStatus(userID string, status string, limit int, offset int) string {
+	return fmt.Sprintf("referral_status:%s:%s:%d:%d", userID, status, limit, offset)
+}
+
+func DBKeyUserIdentity(provider string, subject string) string {
+	return fmt.Sprintf("user_identity:%s:%s", provider, subject)
+}
+
+func DBKeyUserIdentities(userID string) string {
+	return fmt.Sprintf("user_identities:%s", userID)
+}
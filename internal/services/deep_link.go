@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/models"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+type ServiceDeepLink struct {
+	container          *do.Injector
+	postgresDB         *bun.DB
+	readonlyPostgresDB *bun.DB
+
+	serviceConfig *ServiceConfig
+}
+
+func NewServiceDeepLink(container *do.Injector) (*ServiceDeepLink, error) {
+	postgresDB, err := do.Invoke[*bun.DB](container)
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyPostgresDB, err := do.InvokeNamed[*bun.DB](container, "db-readonly")
+	if err != nil {
+		return nil, err
+	}
+
+	serviceConfig, err := do.Invoke[*ServiceConfig](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceDeepLink{container, postgresDB, readonlyPostgresDB, serviceConfig}, nil
+}
+
+// Touch parses the start parameter of a login and records it as the user's attribution,
+// it returns nil when there is no usable parameter
+func (service *ServiceDeepLink) Touch(ctx context.Context, user *models.User, raw string) *models.StartParam {
+	param := models.ParseStartParam(raw)
+	if param == nil {
+		if raw != "" {
+			log.Println("invalid start param:", raw, "user:", user.ID)
+		}
+		return nil
+	}
+
+	if err := datastore.SetUserTouch(ctx, service.postgresDB, user.ID, param.Raw); err != nil {
+		log.Println("SetUserTouch error:", err, "user:", user.ID)
+	}
+
+	return param
+}
+
+// RecordClick counts a click on a shared link and returns where to send the visitor,
+// via picks the LINE LIFF app over the Telegram mini app
+func (service *ServiceDeepLink) RecordClick(ctx context.Context, raw string, via string) (string, error) {
+	key, query := CONFIG_DEEP_LINK_TELEGRAM_URL, "startapp"
+	if via == DEEP_LINK_VIA_LINE {
+		key, query = CONFIG_DEEP_LINK_LINE_URL, "start_param"
+	}
+
+	target, err := service.serviceConfig.GetStringConfig(ctx, key, "")
+	if err != nil || target == "" {
+		return "", errorx.Wrap(errors.New("deep link target is not configured"), errorx.Service)
+	}
+
+	param := models.ParseStartParam(raw)
+	if param == nil {
+		return target, nil
+	}
+
+	if err := datastore.IncrDeepLinkClick(ctx, service.postgresDB, param.Raw, time.Now().UTC()); err != nil {
+		log.Println("IncrDeepLinkClick error:", err, "param:", param.Raw)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", errorx.Wrap(err, errorx.Service)
+	}
+	values := u.Query()
+	values.Set(query, param.Raw)
+	u.RawQuery = values.Encode()
+
+	return u.String(), nil
+}
+
+// GetAttributionReport compares the clicks of every link with the signups and returning users it brought
+func (service *ServiceDeepLink) GetAttributionReport(ctx context.Context, from *time.Time, to *time.Time, limit int) (*models.AttributionReport, error) {
+	end := time.Now().UTC()
+	if to != nil {
+		end = to.UTC()
+	}
+
+	start := end.AddDate(0, 0, -ATTRIBUTION_REPORT_DEFAULT_DAYS)
+	if from != nil {
+		start = from.UTC()
+	}
+
+	if !end.After(start) {
+		return nil, errorx.Wrap(errors.New("invalid time range"), errorx.Invalid)
+	}
+
+	if limit <= 0 {
+		limit = ATTRIBUTION_REPORT_DEFAULT_LIMIT
+	}
+	if limit > ATTRIBUTION_REPORT_MAX_LIMIT {
+		limit = ATTRIBUTION_REPORT_MAX_LIMIT
+	}
+
+	rows, err := datastore.GetAttributionReport(ctx, service.readonlyPostgresDB, start, end, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		if row.Clicks > 0 {
+			row.Rate = float64(row.Signups) / float64(row.Clicks)
+		}
+	}
+
+	return &models.AttributionReport{From: start, To: end, Rows: rows}, nil
+}
@@ -201,7 +201,7 @@ func (service *ServiceGame) FindOrCreateSession(ctx context.Context, gameSlug st
 		return newSession, nil
 	}
 
-	return currentSession, nil
+	return service.attachQuestionToken(user, currentSession), nil
 }
 
 func (service *ServiceGame) switchToNewSession(ctx context.Context, userGame *models.UserGame, currentSession *models.GameSession) error {
@@ -326,7 +326,7 @@ func (service *ServiceGame) NextQuestion(ctx context.Context, gameID string, use
 
 	if session.QuestionStartedAt != nil {
 		// already given a current question
-		return session, nil
+		return service.attachQuestionToken(user, session), nil
 	}
 
 	// if game is belong to a arena, check all required tasks are completed before start
@@ -339,7 +339,7 @@ func (service *ServiceGame) NextQuestion(ctx context.Context, gameID string, use
 
 		for _, link := range tasks.Links {
 			//if task is not completed, return error
-			if link.Required && !link.Joined {
+			if link.Required && !link.Joined && tasks.IsOpen(time.Now()) {
 				return nil, errorx.Wrap(errors.New("required tasks not completed"), errorx.Validation)
 			}
 		}
@@ -392,11 +392,16 @@ func (service *ServiceGame) NextQuestion(ctx context.Context, gameID string, use
 		QuestionScore: session.CurrentQuestionScore,
 		TotalScore:    session.Score,
 		StartedAt:     now,
+		TokenNonce:    newQuestionTokenNonce(),
 	}
 
 	session.History = history
 	session, err = redis_store.SaveGameSession(ctx, service.redisDB, session)
-	return session, err
+	if err != nil {
+		return nil, err
+	}
+
+	return service.attachQuestionToken(user, session), nil
 }
 
 func (service *ServiceGame) Answer(ctx context.Context, user *models.User, userGame *models.UserGame, gameAnswer models.GameAnswer) (*models.GameSession, error) {
@@ -418,7 +423,9 @@ func (service *ServiceGame) Answer(ctx context.Context, user *models.User, userG
 		return nil, err
 	}
 
-	// TODO check question index
+	if err := service.verifyQuestionToken(ctx, user, session, gameAnswer); err != nil {
+		return nil, err
+	}
 
 	lastStep := session.NextStep - 1
 
@@ -485,6 +492,8 @@ func (service *ServiceGame) Answer(ctx context.Context, user *models.User, userG
 			if err != nil {
 				return nil, errorx.Wrap(errors.New("invalid user"), errorx.Service)
 			}
+
+			service.serviceLeaderboard.RecordExtraSessionWon(ctx, user, userGame.GameSlug)
 		}
 
 		session.CurrentQuestionScore = scoreAfter
@@ -519,6 +528,7 @@ func (service *ServiceGame) Answer(ctx context.Context, user *models.User, userG
 	history.Answer = &answer
 	history.AnsweredAt = &now
 	history.Correct = &correct
+	history.TokenNonce = ""
 
 	if correct {
 		// correct
@@ -649,21 +659,42 @@ func (service *ServiceGame) endGame(ctx context.Context, user *models.User, user
 		return nil, err
 	}
 
-	// update sorted set
-	_, err = redis_store.SetLeaderboard(ctx, service.redisDB, userGame.GameSlug, &models.LeaderboardItem{
-		UserId: userGame.UserID,
-		Score:  float64(sessionSumary.TotalScore),
-	})
-	if err != nil {
-		return nil, err
+	// update sorted set, users excluded by anti-cheat keep their score off the board
+	if !IsExcludedFromLeaderboards(ctx, service.redisDB, userGame.UserID) {
+		item := &models.LeaderboardItem{
+			UserId: userGame.UserID,
+			Score:  float64(sessionSumary.TotalScore),
+		}
+		_, err = redis_store.SetLeaderboard(ctx, service.redisDB, userGame.GameSlug, item)
+		if err != nil {
+			return nil, err
+		}
+
+		err = SetRegionalLeaderboard(ctx, service.redisDB, user.LeaderboardRegion(), userGame.GameSlug, item)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = service.serviceUser.InsertUserGem(ctx, user, currentSession.TotalScore, fmt.Sprintf("quiz:%s:%s", userGame.GameSlug, currentSession.LegacyID))
+	gemAction := fmt.Sprintf("quiz:%s:%s", userGame.GameSlug, currentSession.LegacyID)
+	err = service.serviceUser.InsertUserGem(ctx, user, currentSession.TotalScore, gemAction)
 
 	if err != nil {
 		return nil, err
 	}
 
+	serviceReferral, err := do.Invoke[*ServiceReferral](service.container)
+	if err == nil {
+		go serviceReferral.CreditCommissions(context.WithoutCancel(ctx), user, currentSession.TotalScore, gemAction)
+	}
+
+	serviceAntiCheat, err := do.Invoke[*ServiceAntiCheat](service.container)
+	if err == nil {
+		go serviceAntiCheat.ScoreSession(context.WithoutCancel(ctx), user, game, currentSession)
+	}
+
+	service.serviceLeaderboard.RecordGameSession(ctx, user, game, currentSession)
+
 	// update arena leaderboard if game is belong to an arena
 	if !game.IsPublic {
 		serviceArena, err := do.Invoke[*ServiceArena](service.container)
@@ -675,6 +706,11 @@ func (service *ServiceGame) endGame(ctx context.Context, user *models.User, user
 
 		if arena != nil {
 			_ = serviceArena.UpdateArenaLeaderboard(ctx, user, arena)
+
+			servicePartnerWebhook, err := do.Invoke[*ServicePartnerWebhook](service.container)
+			if err == nil {
+				servicePartnerWebhook.EmitArenaFinished(ctx, user, arena, currentSession)
+			}
 		}
 	}
 
@@ -872,7 +908,7 @@ func (service *ServiceGame) BurnAssistance(ctx context.Context, gameSlug string,
 		return nil, err
 	}
 
-	return session, nil
+	return service.attachQuestionToken(user, session), nil
 }
 
 func (service *ServiceGame) canAnswer(ctx context.Context, user *models.User, userGame *models.UserGame, session *models.GameSession) error {
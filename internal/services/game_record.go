@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+	"millionaire/internal/pkg/caching"
+	"time"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+)
+
+type gameRecordDefinition struct {
+	Name      string
+	Mode      string
+	Ascending bool
+}
+
+var gameRecordDefinitions = []gameRecordDefinition{
+	{models.GameRecordLongestStreak, redis_store.GameRecordModeMax, false},
+	{models.GameRecordMostPlayedSession, redis_store.GameRecordModeIncr, false},
+	{models.GameRecordMostBonusPoint, redis_store.GameRecordModeIncr, false},
+	{models.GameRecordMostMinusPoint, redis_store.GameRecordModeIncr, false},
+	{models.GameRecordFastestPerfectRun, redis_store.GameRecordModeMin, true},
+	{models.GameRecordMostExtraSessions, redis_store.GameRecordModeIncr, false},
+}
+
+// gameRecordBucket returns the key suffix of the window containing t and how long the bucket is kept
+func gameRecordBucket(window string, t time.Time) (string, time.Duration) {
+	t = t.UTC()
+	switch window {
+	case models.GameRecordWindowDaily:
+		return fmt.Sprintf("daily:%s", t.Format("20060102")), 2 * CACHE_TTL_1_DAY
+	case models.GameRecordWindowWeekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("weekly:%d-%02d", year, week), 14 * CACHE_TTL_1_DAY
+	}
+
+	return "", 0
+}
+
+func (service *ServiceLeaderboard) updateGameRecord(ctx context.Context, gameSlug string, record string, mode string, userID string, value float64) {
+	now := time.Now()
+	for _, window := range models.GameRecordWindows {
+		bucket, ttl := gameRecordBucket(window, now)
+		err := redis_store.UpdateGameRecord(ctx, service.redisDB, gameSlug, record, bucket, mode, userID, value, ttl)
+		if err != nil {
+			log.Println("UpdateGameRecord error:", record, err)
+		}
+	}
+}
+
+// RecordGameSession updates the hall of fame of the game with a finished session
+func (service *ServiceLeaderboard) RecordGameSession(ctx context.Context, user *models.User, game *models.Game, session *models.GameSession) {
+	if IsExcludedFromLeaderboards(ctx, service.redisDB, user.ID) {
+		return
+	}
+
+	service.updateGameRecord(ctx, game.Slug, models.GameRecordMostPlayedSession, redis_store.GameRecordModeIncr, user.ID, 1)
+
+	if session.StreakPoint > 0 {
+		service.updateGameRecord(ctx, game.Slug, models.GameRecordLongestStreak, redis_store.GameRecordModeMax, user.ID, float64(session.StreakPoint))
+	}
+
+	// a full run: the last question decides the bonus or the minus points, the same way the export tool counts them
+	n := len(game.Questions)
+	if n >= 2 && len(session.History) == n {
+		last, okLast := session.History[n-1]
+		previous, okPrevious := session.History[n-2]
+		if okLast && okPrevious {
+			delta := last.TotalScore - previous.TotalScore
+			if delta > 0 {
+				service.updateGameRecord(ctx, game.Slug, models.GameRecordMostBonusPoint, redis_store.GameRecordModeIncr, user.ID, float64(delta))
+			} else if delta < 0 {
+				service.updateGameRecord(ctx, game.Slug, models.GameRecordMostMinusPoint, redis_store.GameRecordModeIncr, user.ID, float64(-delta))
+			}
+		}
+	}
+
+	if isPerfectRun(game, session) {
+		duration := session.EndedAt.Sub(*session.StartedAt).Milliseconds()
+		service.updateGameRecord(ctx, game.Slug, models.GameRecordFastestPerfectRun, redis_store.GameRecordModeMin, user.ID, float64(duration))
+	}
+}
+
+// RecordExtraSessionWon counts an extra session won on the prize question
+func (service *ServiceLeaderboard) RecordExtraSessionWon(ctx context.Context, user *models.User, gameSlug string) {
+	if IsExcludedFromLeaderboards(ctx, service.redisDB, user.ID) {
+		return
+	}
+
+	service.updateGameRecord(ctx, gameSlug, models.GameRecordMostExtraSessions, redis_store.GameRecordModeIncr, user.ID, 1)
+}
+
+func isPerfectRun(game *models.Game, session *models.GameSession) bool {
+	if session.StartedAt == nil || session.EndedAt == nil {
+		return false
+	}
+
+	if len(game.Questions) == 0 || len(session.History) != len(game.Questions) {
+		return false
+	}
+
+	for _, history := range session.History {
+		if history.Correct == nil || !*history.Correct {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetGameRecords returns every hall of fame record of the game for the window
+func (service *ServiceLeaderboard) GetGameRecords(ctx context.Context, user *models.User, gameSlug string, window string, limit int) (*models.GameRecords, error) {
+	if window == "" {
+		window = models.GameRecordWindowAllTime
+	}
+
+	valid := false
+	for _, w := range models.GameRecordWindows {
+		if w == window {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, errorx.Wrap(ErrUnknownGameRecordWindow, errorx.Invalid)
+	}
+
+	if limit <= 0 {
+		limit = GAME_RECORD_DEFAULT_LIMIT
+	}
+	if limit > GAME_RECORD_MAX_LIMIT {
+		limit = GAME_RECORD_MAX_LIMIT
+	}
+
+	callback := func() (*models.GameRecords, error) {
+		shadowBanned, err := redis_store.GetShadowBannedUsers(ctx, service.redisDB)
+		if err != nil {
+			log.Println("GetShadowBannedUsers error:", err)
+		}
+
+		bucket, _ := gameRecordBucket(window, time.Now())
+		records := &models.GameRecords{
+			Game:    gameSlug,
+			Window:  window,
+			Records: map[string][]*models.LeaderboardItem{},
+		}
+
+		for _, definition := range gameRecordDefinitions {
+			items, err := redis_store.GetGameRecord(ctx, service.redisDB, gameSlug, definition.Name, bucket, definition.Ascending, limit+len(shadowBanned))
+			if err != nil {
+				return nil, err
+			}
+
+			service.fillProfiles(ctx, items)
+			records.Records[definition.Name] = items
+		}
+
+		return records, nil
+	}
+
+	shared, err := caching.UseSharedCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyGameRecords(gameSlug, window, limit), CACHE_TTL_1_MIN, callback)
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := service.getHiddenUsers(ctx, user)
+	records := &models.GameRecords{
+		Game:    shared.Game,
+		Window:  shared.Window,
+		Records: map[string][]*models.LeaderboardItem{},
+	}
+	for name, items := range shared.Records {
+		records.Records[name] = hideUsers(items, hidden, limit)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/redis/go-redis/v9"
+	"github.com/samber/do"
+)
+
+var ErrIdempotencyKeyReused = errors.New("idempotency key was used with a different request")
+var ErrIdempotencyInProgress = errors.New("a request with this idempotency key is in progress")
+
+type ServiceIdempotency struct {
+	container *do.Injector
+	redisDB   redis.UniversalClient
+
+	serviceConfig *ServiceConfig
+}
+
+func NewServiceIdempotency(container *do.Injector) (*ServiceIdempotency, error) {
+	db, err := do.InvokeNamed[redis.UniversalClient](container, "redis-db")
+	if err != nil {
+		return nil, err
+	}
+
+	serviceConfig, err := do.Invoke[*ServiceConfig](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceIdempotency{container, db, serviceConfig}, nil
+}
+
+// Begin reserves the key for the request fingerprint. A completed record is returned when the request
+// was already handled and must be replayed, nil means the caller owns the key and must call Complete or Release.
+// The reservation is short lived so a request that never finishes doesn't hold the key for the whole window.
+func (service *ServiceIdempotency) Begin(ctx context.Context, scope string, key string, fingerprint string) (*models.IdempotencyRecord, error) {
+	reserved, err := redis_store.ReserveIdempotencyKey(ctx, service.redisDB, scope, key, &models.IdempotencyRecord{
+		Fingerprint: fingerprint,
+	}, IDEMPOTENCY_IN_PROGRESS_TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	if reserved {
+		return nil, nil
+	}
+
+	record, err := redis_store.GetIdempotencyRecord(ctx, service.redisDB, scope, key)
+	if err == redis.Nil {
+		// expired between the two calls
+		return service.Begin(ctx, scope, key, fingerprint)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if record.Fingerprint != fingerprint {
+		return nil, errorx.Wrap(ErrIdempotencyKeyReused, errorx.Validation)
+	}
+
+	if !record.Completed {
+		return nil, errorx.Wrap(ErrIdempotencyInProgress, errorx.Exist)
+	}
+
+	return record, nil
+}
+
+// Complete stores the response for the whole window
+func (service *ServiceIdempotency) Complete(ctx context.Context, scope string, key string, record *models.IdempotencyRecord) error {
+	record.Completed = true
+	return redis_store.SetIdempotencyRecord(ctx, service.redisDB, scope, key, record, service.window(ctx))
+}
+
+// Release frees the key so the client can retry, used when the request failed on our side
+func (service *ServiceIdempotency) Release(ctx context.Context, scope string, key string) error {
+	return redis_store.DeleteIdempotencyRecord(ctx, service.redisDB, scope, key)
+}
+
+func (service *ServiceIdempotency) window(ctx context.Context) time.Duration {
+	minutes, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_IDEMPOTENCY_WINDOW_IN_MINUTES, DEFAULT_IDEMPOTENCY_WINDOW_IN_MINUTES)
+	return time.Duration(minutes) * time.Minute
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+	"millionaire/internal/pkg/caching"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/redis/go-redis/v9"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+// ServiceIdentity keeps the mapping between external logins (LINE, Telegram) and internal users
+type ServiceIdentity struct {
+	container          *do.Injector
+	redisDB            redis.UniversalClient
+	rs                 *redsync.Redsync
+	postgresDB         *bun.DB
+	readonlyPostgresDB *bun.DB
+	cache              caching.Cache
+	readonlyCache      caching.ReadOnlyCache
+
+	bot *Bot
+}
+
+func NewServiceIdentity(container *do.Injector) (*ServiceIdentity, error) {
+	db, err := do.InvokeNamed[redis.UniversalClient](container, "redis-db")
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := do.Invoke[*redsync.Redsync](container)
+	if err != nil {
+		return nil, err
+	}
+
+	postgresDB, err := do.Invoke[*bun.DB](container)
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyPostgresDB, err := do.InvokeNamed[*bun.DB](container, "db-readonly")
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := do.Invoke[caching.Cache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyCache, err := do.Invoke[caching.ReadOnlyCache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	bot, err := do.Invoke[*Bot](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceIdentity{container, db, rs, postgresDB, readonlyPostgresDB, cache, readonlyCache, bot}, nil
+}
+
+func (service *ServiceIdentity) FindIdentity(ctx context.Context, provider string, subject string) (*models.UserIdentity, error) {
+	callback := func() (*models.UserIdentity, error) {
+		return datastore.FindUserIdentity(ctx, service.readonlyPostgresDB, provider, subject)
+	}
+
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyUserIdentity(provider, subject), CACHE_TTL_5_MINS, callback)
+}
+
+// FindIdentityNoCache reads from the write db, used right after an identity is created
+func (service *ServiceIdentity) FindIdentityNoCache(ctx context.Context, provider string, subject string) (*models.UserIdentity, error) {
+	return datastore.FindUserIdentity(ctx, service.postgresDB, provider, subject)
+}
+
+func (service *ServiceIdentity) GetIdentities(ctx context.Context, userID string) ([]models.UserIdentity, error) {
+	callback := func() ([]models.UserIdentity, error) {
+		return datastore.GetUserIdentities(ctx, service.readonlyPostgresDB, userID)
+	}
+
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyUserIdentities(userID), CACHE_TTL_5_MINS, callback)
+}
+
+// ResolveUserID returns the internal user id owning the external login. Logins seen before identities
+// existed are owned by the user with the same id, so the subject itself is returned when there is no mapping.
+func (service *ServiceIdentity) ResolveUserID(ctx context.Context, userAuth *models.UserFromAuth) (string, bool, error) {
+	if userAuth.Provider == "" {
+		return userAuth.ID, true, nil
+	}
+
+	identity, err := service.FindIdentity(ctx, userAuth.Provider, userAuth.ID)
+	if err != nil && err != sql.ErrNoRows && err != redis.Nil {
+		return "", false, err
+	}
+
+	if identity == nil {
+		return userAuth.ID, false, nil
+	}
+
+	return identity.UserID, true, nil
+}
+
+func (service *ServiceIdentity) AttachIdentity(ctx context.Context, user *models.User, provider string, subject string, username string) (*models.UserIdentity, error) {
+	err := datastore.CreateUserIdentity(ctx, service.postgresDB, &models.UserIdentity{
+		Provider: provider,
+		Subject:  subject,
+		UserID:   user.ID,
+		Username: strings.ToLower(username),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	service.clearIdentityCache(ctx, provider, subject, user.ID)
+
+	return service.FindIdentityNoCache(ctx, provider, subject)
+}
+
+// LinkIdentity proves the ownership of another login with its token and attaches it to the current user
+func (service *ServiceIdentity) LinkIdentity(ctx context.Context, user *models.User, payload *models.LinkIdentityPayload) (*models.UserIdentity, error) {
+	if payload == nil || payload.Token == "" {
+		return nil, errorx.Wrap(errors.New("invalid payload"), errorx.Invalid)
+	}
+
+	var userAuth *models.UserFromAuth
+	var err error
+	switch payload.Provider {
+	case models.IdentityProviderLine:
+		userAuth, err = service.bot.Validate(payload.Token)
+	case models.IdentityProviderTelegram:
+		userAuth, err = service.bot.ValidateTelegram(payload.Token)
+	default:
+		return nil, errorx.Wrap(errors.New("unsupported provider"), errorx.Invalid)
+	}
+	if err != nil {
+		return nil, errorx.Wrap(errors.New("cannot verify identity"), errorx.Authn)
+	}
+
+	mutex := service.rs.NewMutex(LockKeyUserMerge(user.ID))
+	if err := mutex.TryLock(); err != nil {
+		return nil, errorx.Wrap(ErrUserMergeLock, errorx.Invalid)
+	}
+	// nolint:errcheck
+	defer mutex.Unlock()
+
+	identity, err := service.FindIdentityNoCache(ctx, userAuth.Provider, userAuth.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if identity == nil {
+		// legacy users were created with the external subject as id, that account must be merged by an admin
+		if userAuth.ID != user.ID {
+			legacy, _ := datastore.FindUserByID(ctx, service.postgresDB, userAuth.ID)
+			if legacy != nil && legacy.MergedInto == nil {
+				return nil, errorx.Wrap(errors.New("identity belongs to another account, ask support to merge the accounts"), errorx.Exist)
+			}
+		}
+
+		return service.AttachIdentity(ctx, user, userAuth.Provider, userAuth.ID, userAuth.Username)
+	}
+
+	if identity.UserID != user.ID {
+		return nil, errorx.Wrap(errors.New("identity belongs to another account, ask support to merge the accounts"), errorx.Exist)
+	}
+
+	return identity, nil
+}
+
+// MergeUsers folds the source account into the target account. The ledger is moved in postgres first,
+// then every leaderboard holding either user is recomputed for the target.
+func (service *ServiceIdentity) MergeUsers(ctx context.Context, payload *models.MergeUsersPayload, operator string) (*models.UserMerge, error) {
+	if payload == nil || payload.SourceUserID == "" || payload.TargetUserID == "" {
+		return nil, errorx.Wrap(errors.New("invalid payload"), errorx.Invalid)
+	}
+
+	if payload.SourceUserID == payload.TargetUserID {
+		return nil, errorx.Wrap(errors.New("cannot merge a user into itself"), errorx.Invalid)
+	}
+
+	for _, userID := range []string{payload.SourceUserID, payload.TargetUserID} {
+		mutex := service.rs.NewMutex(LockKeyUserMerge(userID))
+		if err := mutex.TryLock(); err != nil {
+			return nil, errorx.Wrap(ErrUserMergeLock, errorx.Invalid)
+		}
+		// nolint:errcheck
+		defer mutex.Unlock()
+	}
+
+	merge := &models.UserMerge{
+		SourceUserID: payload.SourceUserID,
+		TargetUserID: payload.TargetUserID,
+		Operator:     operator,
+		Reason:       payload.Reason,
+	}
+
+	err := datastore.MergeUsers(ctx, service.postgresDB, merge)
+	if err == sql.ErrNoRows {
+		return nil, errorx.Wrap(errors.New("user not found"), errorx.NotExist)
+	}
+	if err != nil {
+		return nil, errorx.Wrap(err, errorx.Invalid)
+	}
+
+	log.Println("MergeUsers:", "source:", merge.SourceUserID, "target:", merge.TargetUserID, "operator:", operator, "summary:", merge.Summary)
+
+	// the source is now merged into the target and its identities moved, a login must not read the cached users
+	for _, userID := range []string{merge.SourceUserID, merge.TargetUserID} {
+		if err := service.cache.Delete(ctx, DBKeyUser(userID)); err != nil {
+			log.Println(err)
+		}
+	}
+
+	identities, err := datastore.GetUserIdentities(ctx, service.postgresDB, merge.TargetUserID)
+	if err != nil {
+		log.Println("MergeUsers get identities error:", err)
+	}
+	for _, identity := range identities {
+		service.clearIdentityCache(ctx, identity.Provider, identity.Subject, merge.TargetUserID)
+	}
+
+	if err := service.rebuildLeaderboards(ctx, merge.SourceUserID, merge.TargetUserID); err != nil {
+		log.Println("MergeUsers rebuild leaderboards error:", err)
+	}
+
+	return merge, nil
+}
+
+func (service *ServiceIdentity) rebuildLeaderboards(ctx context.Context, sourceID string, targetID string) error {
+	serviceUser, err := do.Invoke[*ServiceUser](service.container)
+	if err != nil {
+		return err
+	}
+
+	serviceGame, err := do.Invoke[*ServiceGame](service.container)
+	if err != nil {
+		return err
+	}
+
+	serviceArena, err := do.Invoke[*ServiceArena](service.container)
+	if err != nil {
+		return err
+	}
+
+	serviceLeaderboard, err := do.Invoke[*ServiceLeaderboard](service.container)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range []string{sourceID, targetID} {
+		_ = serviceUser.ClearUserGemCache(ctx, userID)
+		_ = serviceUser.DeleteFriendListCaching(ctx, userID)
+		_ = service.cache.Delete(ctx, DBKeyUserWallet(userID))
+		_ = service.cache.Delete(ctx, DBKeyFriendCount(userID))
+		_ = service.cache.Delete(ctx, DBKeyUserAvailableReward(userID))
+		_ = service.cache.Delete(ctx, DBKeyUserIdentities(userID))
+	}
+
+	source, err := datastore.FindUserByID(ctx, service.postgresDB, sourceID)
+	if err != nil {
+		return err
+	}
+
+	target, err := datastore.FindUserByID(ctx, service.postgresDB, targetID)
+	if err != nil {
+		return err
+	}
+
+	// the source leaves its regional boards, the target's regional entries are mirrored with its rebuilt scores
+	if region := source.LeaderboardRegion(); region != "" {
+		boards, err := regionalBoards(ctx, service.postgresDB)
+		if err != nil {
+			return err
+		}
+		for _, board := range boards {
+			if err := redis_store.RemoveFromLeaderboard(ctx, service.redisDB, RegionalLeaderboardName(board, region), sourceID); err != nil {
+				return err
+			}
+		}
+	}
+
+	games, err := serviceGame.GetGames(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, game := range games {
+		_ = service.cache.Delete(ctx, DBKeyUserGame(game.Slug, sourceID))
+		_ = service.cache.Delete(ctx, DBKeyUserGame(game.Slug, targetID))
+		_ = service.cache.Delete(ctx, DBKeyUserGameSessionSumary(game.Slug, sourceID))
+		_ = service.cache.Delete(ctx, DBKeyUserGameSessionSumary(game.Slug, targetID))
+
+		if err := redis_store.RemoveFromLeaderboard(ctx, service.redisDB, game.Slug, sourceID); err != nil {
+			return err
+		}
+
+		sessionSumary, err := datastore.GetUserGameSessionSumary(ctx, service.postgresDB, game.Slug, targetID)
+		if err != nil || sessionSumary == nil || sessionSumary.TotalScore == 0 {
+			continue
+		}
+
+		item, err := redis_store.SetLeaderboard(ctx, service.redisDB, game.Slug, &models.LeaderboardItem{
+			UserId: targetID,
+			Score:  float64(sessionSumary.TotalScore),
+		})
+		if err != nil {
+			return err
+		}
+		if err := SetRegionalLeaderboard(ctx, service.redisDB, target.LeaderboardRegion(), game.Slug, item); err != nil {
+			return err
+		}
+		_ = serviceLeaderboard.ClearLeaderboardCache(ctx, game.Slug)
+	}
+
+	for _, name := range []string{LEADERBOARD_OVERALL, LEADERBOARD_OVERALL_WEEKLY, LEADERBOARD_REFERRAL} {
+		if err := redis_store.RemoveFromLeaderboard(ctx, service.redisDB, name, sourceID); err != nil {
+			return err
+		}
+		_ = serviceLeaderboard.ClearLeaderboardCache(ctx, name)
+	}
+
+	if _, err := serviceLeaderboard.UpdateOverallLeaderboard(ctx, target); err != nil {
+		return err
+	}
+
+	if target.TotalInvites > 0 {
+		_, err = redis_store.SetLeaderboard(ctx, service.redisDB, LEADERBOARD_REFERRAL, &models.LeaderboardItem{
+			UserId: targetID,
+			Score:  float64(target.TotalInvites),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	arenas, err := serviceArena.GetEnabledArenas(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range arenas {
+		arena := arenas[i]
+		// the final ranking of an ended arena is kept as it is, its prizes are computed from it
+		if arena.IsEnded() {
+			continue
+		}
+
+		sourceScore, _ := redis_store.GetScore(ctx, service.redisDB, DBKeyArena(arena.Slug), &models.User{ID: sourceID})
+		targetScore, _ := redis_store.GetScore(ctx, service.redisDB, DBKeyArena(arena.Slug), target)
+		if sourceScore < 0 && targetScore < 0 {
+			continue
+		}
+
+		if err := redis_store.RemoveFromLeaderboard(ctx, service.redisDB, DBKeyArena(arena.Slug), sourceID); err != nil {
+			return err
+		}
+		_ = serviceArena.UpdateArenaLeaderboard(ctx, target, &arena)
+	}
+
+	return nil
+}
+
+func (service *ServiceIdentity) clearIdentityCache(ctx context.Context, provider string, subject string, userID string) {
+	err := service.cache.Delete(ctx, DBKeyUserIdentity(provider, subject))
+	if err != nil {
+		log.Println(err)
+	}
+
+	err = service.cache.Delete(ctx, DBKeyUserIdentities(userID))
+	if err != nil {
+		log.Println(err)
+	}
+}
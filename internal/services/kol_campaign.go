@@ -0,0 +1,378 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/models"
+	"millionaire/internal/pkg/caching"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+type ServiceKolCampaign struct {
+	container     *do.Injector
+	postgresDB    *bun.DB
+	cache         caching.Cache
+	readonlyCache caching.ReadOnlyCache
+
+	serviceUser *ServiceUser
+}
+
+func NewServiceKolCampaign(container *do.Injector) (*ServiceKolCampaign, error) {
+	postgresDB, err := do.Invoke[*bun.DB](container)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := do.Invoke[caching.Cache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyCache, err := do.Invoke[caching.ReadOnlyCache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceUser, err := do.Invoke[*ServiceUser](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceKolCampaign{container, postgresDB, cache, readonlyCache, serviceUser}, nil
+}
+
+// KolCampaignJoinerAction is the user_gem action of the bonus paid to a joiner
+func KolCampaignJoinerAction(campaignID int64) string {
+	return fmt.Sprintf("kol_campaign:%d:joiner", campaignID)
+}
+
+// KolCampaignKolAction is the user_gem action of the bonus paid to the KOL for a validated joiner
+func KolCampaignKolAction(campaignID int64, inviteeID string) string {
+	return fmt.Sprintf("kol_campaign:%d:kol:%s", campaignID, inviteeID)
+}
+
+func (service *ServiceKolCampaign) GetCampaigns(ctx context.Context) ([]models.KolCampaign, error) {
+	return datastore.GetKolCampaigns(ctx, service.postgresDB)
+}
+
+func (service *ServiceKolCampaign) getCampaign(ctx context.Context, slug string) (*models.KolCampaign, error) {
+	campaign, err := datastore.GetKolCampaignBySlug(ctx, service.postgresDB, slug)
+	if err == sql.ErrNoRows {
+		return nil, errorx.Wrap(errors.New("campaign not found"), errorx.NotExist)
+	}
+
+	return campaign, err
+}
+
+func (service *ServiceKolCampaign) CreateCampaign(ctx context.Context, payload *models.KolCampaignPayload) (*models.KolCampaign, error) {
+	campaign := &models.KolCampaign{Slug: strings.TrimSpace(payload.Slug), Enabled: true}
+	if campaign.Slug == "" {
+		return nil, errorx.Wrap(errors.New("slug is required"), errorx.Validation)
+	}
+
+	if err := service.applyPayload(ctx, campaign, payload); err != nil {
+		return nil, err
+	}
+
+	if err := datastore.InsertKolCampaign(ctx, service.postgresDB, campaign); err != nil {
+		return nil, err
+	}
+
+	service.clearCampaignCache(ctx, campaign.RefCodes)
+
+	return campaign, nil
+}
+
+func (service *ServiceKolCampaign) UpdateCampaign(ctx context.Context, slug string, payload *models.KolCampaignPayload) (*models.KolCampaign, error) {
+	campaign, err := service.getCampaign(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := campaign.RefCodes
+	if err := service.applyPayload(ctx, campaign, payload); err != nil {
+		return nil, err
+	}
+
+	if err := datastore.UpdateKolCampaign(ctx, service.postgresDB, campaign); err != nil {
+		return nil, err
+	}
+
+	service.clearCampaignCache(ctx, append(previous, campaign.RefCodes...))
+
+	return campaign, nil
+}
+
+func (service *ServiceKolCampaign) applyPayload(ctx context.Context, campaign *models.KolCampaign, payload *models.KolCampaignPayload) error {
+	if payload.Budget < 0 || payload.JoinerBonus < 0 || payload.KolBonus < 0 {
+		return errorx.Wrap(errors.New("budget and bonuses cannot be negative"), errorx.Validation)
+	}
+
+	if payload.StartAt != nil && payload.EndAt != nil && !payload.EndAt.After(*payload.StartAt) {
+		return errorx.Wrap(errors.New("end_at must be after start_at"), errorx.Validation)
+	}
+
+	if _, err := datastore.FindUserByID(ctx, service.postgresDB, payload.OwnerID); err != nil {
+		return errorx.Wrap(errors.New("owner not found"), errorx.Validation)
+	}
+
+	refCodes := []string{}
+	seen := map[string]bool{}
+	for _, code := range payload.RefCodes {
+		code = strings.TrimSpace(code)
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+
+		// a campaign code must not shadow another campaign, a custom ref code or a user id
+		other, err := datastore.FindKolCampaignByRefCode(ctx, service.postgresDB, code)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if other != nil && other.Slug != campaign.Slug {
+			return errorx.Wrap(fmt.Errorf("ref code %s is used by campaign %s", code, other.Slug), errorx.Validation)
+		}
+
+		if _, err := datastore.GetUserByCustomRefCode(ctx, service.postgresDB, code); err == nil {
+			return errorx.Wrap(fmt.Errorf("ref code %s is used by a user", code), errorx.Validation)
+		}
+
+		refCodes = append(refCodes, code)
+	}
+
+	if len(refCodes) == 0 {
+		return errorx.Wrap(errors.New("at least one ref code is required"), errorx.Validation)
+	}
+
+	campaign.Name = payload.Name
+	campaign.OwnerID = payload.OwnerID
+	campaign.RefCodes = refCodes
+	campaign.StartAt = payload.StartAt
+	campaign.EndAt = payload.EndAt
+	campaign.Budget = payload.Budget
+	campaign.JoinerBonus = payload.JoinerBonus
+	campaign.KolBonus = payload.KolBonus
+	if payload.Enabled != nil {
+		campaign.Enabled = *payload.Enabled
+	}
+
+	return nil
+}
+
+func (service *ServiceKolCampaign) clearCampaignCache(ctx context.Context, refCodes []string) {
+	for _, code := range refCodes {
+		_ = service.cache.Delete(ctx, DBKeyKolCampaignByRefCode(code))
+	}
+}
+
+// FindActiveByRefCode returns the campaign that can still attribute joiners with the ref code, nil otherwise
+func (service *ServiceKolCampaign) FindActiveByRefCode(ctx context.Context, refCode string) *models.KolCampaign {
+	callback := func() (*models.KolCampaign, error) {
+		return datastore.FindKolCampaignByRefCode(ctx, service.postgresDB, refCode)
+	}
+
+	campaign, err := caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyKolCampaignByRefCode(refCode), CACHE_TTL_5_MINS, callback)
+	if err != nil || campaign == nil || !campaign.IsActive(time.Now()) {
+		return nil
+	}
+
+	return campaign
+}
+
+// Attribute records the campaign of a new user and pays the joiner bonus while the budget lasts
+func (service *ServiceKolCampaign) Attribute(ctx context.Context, user *models.User, campaign *models.KolCampaign, refCode string) {
+	inserted, err := datastore.InsertKolCampaignJoin(ctx, service.postgresDB, &models.KolCampaignJoin{
+		UserID:     user.ID,
+		CampaignID: campaign.ID,
+		RefCode:    refCode,
+	})
+	if err != nil || !inserted {
+		if err != nil {
+			log.Println("InsertKolCampaignJoin error:", err, "user:", user.ID, "campaign:", campaign.Slug)
+		}
+		return
+	}
+
+	if campaign.JoinerBonus <= 0 {
+		return
+	}
+
+	credited, err := datastore.CreditKolCampaignBonus(ctx, service.postgresDB, campaign.ID, user.ID, campaign.JoinerBonus, KolCampaignJoinerAction(campaign.ID))
+	if err != nil || !credited {
+		if err != nil {
+			log.Println("CreditKolCampaignBonus error:", err, "user:", user.ID, "campaign:", campaign.Slug)
+		}
+		return
+	}
+
+	if err := service.serviceUser.ClearUserGemCache(ctx, user.ID); err != nil {
+		log.Println(err)
+	}
+
+	serviceLeaderboard, err := do.Invoke[*ServiceLeaderboard](service.container)
+	if err != nil {
+		return
+	}
+
+	if _, err := serviceLeaderboard.UpdateOverallLeaderboard(ctx, user); err != nil {
+		log.Println("UpdateOverallLeaderboard error:", err)
+	}
+}
+
+// PayKolCampaignBonus pays the KOL of the invitee's campaign once the referral is validated,
+// it returns the KOL paid or "" when nothing was paid
+func PayKolCampaignBonus(ctx context.Context, db *bun.DB, inviteeID string) (string, error) {
+	join, err := datastore.GetKolCampaignJoin(ctx, db, inviteeID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	campaign, err := datastore.GetKolCampaignByID(ctx, db, join.CampaignID)
+	if err != nil {
+		return "", err
+	}
+
+	if campaign.KolBonus <= 0 {
+		return "", nil
+	}
+
+	credited, err := datastore.CreditKolCampaignBonus(ctx, db, campaign.ID, campaign.OwnerID, campaign.KolBonus, KolCampaignKolAction(campaign.ID, inviteeID))
+	if err != nil || !credited {
+		return "", err
+	}
+
+	return campaign.OwnerID, nil
+}
+
+func (service *ServiceKolCampaign) GetStats(ctx context.Context, slug string, from *time.Time, to *time.Time) (*models.KolCampaignStats, error) {
+	campaign, err := service.getCampaign(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetKolCampaignStats(ctx, service.postgresDB, campaign, from, to)
+}
+
+// GetKolCampaignStats reports the joins, activations and gems earned by the joiners per day,
+// the range defaults to the campaign window. It is shared with the bot, which does not use the container
+func GetKolCampaignStats(ctx context.Context, db *bun.DB, campaign *models.KolCampaign, from *time.Time, to *time.Time) (*models.KolCampaignStats, error) {
+	now := time.Now().UTC()
+
+	start := campaign.CreatedAt.UTC()
+	if campaign.StartAt != nil {
+		start = campaign.StartAt.UTC()
+	}
+	if from != nil {
+		start = from.UTC()
+	}
+
+	end := now
+	if campaign.EndAt != nil && campaign.EndAt.Before(end) {
+		end = campaign.EndAt.UTC()
+	}
+	if to != nil {
+		end = to.UTC()
+	}
+
+	if !end.After(start) {
+		return nil, errorx.Wrap(errors.New("invalid time range"), errorx.Invalid)
+	}
+	if end.Sub(start) > KOL_CAMPAIGN_STATS_MAX_DAYS*24*time.Hour {
+		return nil, errorx.Wrap(fmt.Errorf("time range is limited to %d days", KOL_CAMPAIGN_STATS_MAX_DAYS), errorx.Invalid)
+	}
+
+	counts, err := datastore.CountKolCampaignJoinsByStatus(ctx, db, campaign.ID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &models.KolCampaignStats{
+		Campaign:  campaign,
+		From:      start,
+		To:        end,
+		Pending:   counts[models.ReferralStatusPending],
+		Activated: counts[models.ReferralStatusValidated],
+		Rejected:  counts[models.ReferralStatusRejected],
+		Days:      []*models.KolCampaignDay{},
+	}
+	for _, count := range counts {
+		stats.Joins += count
+	}
+
+	days := map[string]*models.KolCampaignDay{}
+	for day := start.Truncate(24 * time.Hour); day.Before(end); day = day.AddDate(0, 0, 1) {
+		item := &models.KolCampaignDay{Date: day.Format("2006-01-02")}
+		days[item.Date] = item
+		stats.Days = append(stats.Days, item)
+	}
+
+	joins, err := datastore.GetKolCampaignDailyJoins(ctx, db, campaign.ID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range joins {
+		if day, ok := days[value.Day]; ok {
+			day.Joins = value.Value
+		}
+	}
+
+	activations, err := datastore.GetKolCampaignDailyActivations(ctx, db, campaign.ID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range activations {
+		if day, ok := days[value.Day]; ok {
+			day.Activated = value.Value
+		}
+	}
+
+	gems, err := datastore.GetKolCampaignDailyGems(ctx, db, campaign.ID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range gems {
+		stats.Gems += value.Value
+		if day, ok := days[value.Day]; ok {
+			day.Gems = value.Value
+		}
+	}
+
+	return stats, nil
+}
+
+// KolCampaignStatsCSV renders the daily stats, one row per day
+func KolCampaignStatsCSV(stats *models.KolCampaignStats) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write([]string{"date", "joins", "activated", "gems"}); err != nil {
+		return nil, err
+	}
+
+	for _, day := range stats.Days {
+		err := w.Write([]string{day.Date, strconv.Itoa(day.Joins), strconv.Itoa(day.Activated), strconv.Itoa(day.Gems)})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -2,13 +2,20 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"log"
+	"millionaire/internal/datastore"
 	"millionaire/internal/datastore/redis_store"
 	"millionaire/internal/models"
-	"millionaire/internal/pkg"
 	"millionaire/internal/pkg/caching"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-redsync/redsync/v4"
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
 	"github.com/redis/go-redis/v9"
 	"github.com/samber/do"
 	"github.com/uptrace/bun"
@@ -23,6 +30,8 @@ type ServiceLeaderboard struct {
 	cache         caching.Cache
 	readonlyCache caching.ReadOnlyCache
 
+	readonlyPostgresDB *bun.DB
+
 	serviceUser   *ServiceUser
 	serviceConfig *ServiceConfig
 	serviceSocial *ServiceSocial
@@ -59,6 +68,11 @@ func NewServiceLeaderboard(container *do.Injector) (*ServiceLeaderboard, error)
 		return nil, err
 	}
 
+	readonlyPostgresDB, err := do.InvokeNamed[*bun.DB](container, "db-readonly")
+	if err != nil {
+		return nil, err
+	}
+
 	serviceUser, err := do.Invoke[*ServiceUser](container)
 	if err != nil {
 		return nil, err
@@ -73,7 +87,7 @@ func NewServiceLeaderboard(container *do.Injector) (*ServiceLeaderboard, error)
 		return nil, err
 	}
 
-	return &ServiceLeaderboard{container, db, dbRedisCache, rs, postgresDB, cache, readonlyCache, serviceUser, serviceConfig, serviceSocial}, nil
+	return &ServiceLeaderboard{container, db, dbRedisCache, rs, postgresDB, cache, readonlyCache, readonlyPostgresDB, serviceUser, serviceConfig, serviceSocial}, nil
 }
 
 func (service *ServiceLeaderboard) GetTopReferralLeaderboard(ctx context.Context, user *models.User) (*models.LeaderboardResponse, error) {
@@ -104,19 +118,28 @@ func (service *ServiceLeaderboard) GetArenaLeaderboard(ctx context.Context, aren
 
 func (service *ServiceLeaderboard) ClearLeaderboardCache(ctx context.Context, leaderboardName string) error {
 	caching.DeleteKeys(ctx, service.redisDBCache, fmt.Sprintf("leaderboard_by_user:%s*", leaderboardName))
+	caching.DeleteKeys(ctx, service.redisDBCache, fmt.Sprintf("leaderboard_top:%s:*", strings.ToLower(leaderboardName)))
 	return nil
 }
 
 func (service *ServiceLeaderboard) UpdateOverallLeaderboard(ctx context.Context, user *models.User) (*models.LeaderboardItem, error) {
+	if IsExcludedFromLeaderboards(ctx, service.redisDB, user.ID) {
+		return nil, nil
+	}
+
 	point, err := service.serviceUser.GetUserGemNoCache(ctx, user.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	leaderboard, err := redis_store.SetLeaderboard(ctx, service.redisDB, LEADERBOARD_OVERALL, &models.LeaderboardItem{
+	item := &models.LeaderboardItem{
 		UserId: user.ID,
 		Score:  float64(point),
-	})
+	}
+	leaderboard, err := redis_store.SetLeaderboard(ctx, service.redisDB, LEADERBOARD_OVERALL, item)
+	if err == nil {
+		err = SetRegionalLeaderboard(ctx, service.redisDB, user.LeaderboardRegion(), LEADERBOARD_OVERALL, item)
+	}
 
 	service.updateWeeklyOverallLeaderboard(ctx, user)
 
@@ -129,16 +152,30 @@ func (service *ServiceLeaderboard) UpdateOverallLeaderboard(ctx context.Context,
 }
 
 func (service *ServiceLeaderboard) updateWeeklyOverallLeaderboard(ctx context.Context, user *models.User) (*models.LeaderboardItem, error) {
-	thisWeek := pkg.GetFirstTimeOfCurrentWeek()
-	point, err := service.serviceUser.GetUserGemFromTimeNoCache(ctx, user.ID, &thisWeek)
+	serviceSeason, err := do.Invoke[*ServiceSeason](service.container)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := serviceSeason.GetSeasonConfig(ctx)
+	if IsSeasonOver(ctx, service.redisDB, LEADERBOARD_OVERALL_WEEKLY, cfg) {
+		return nil, nil
+	}
+
+	thisSeason := GetSeasonStart(cfg, time.Now())
+	point, err := service.serviceUser.GetUserGemFromTimeNoCache(ctx, user.ID, &thisSeason)
 	if err != nil {
 		return nil, err
 	}
 
-	leaderboard, err := redis_store.SetLeaderboard(ctx, service.redisDB, LEADERBOARD_OVERALL_WEEKLY, &models.LeaderboardItem{
+	item := &models.LeaderboardItem{
 		UserId: user.ID,
 		Score:  float64(point),
-	})
+	}
+	leaderboard, err := redis_store.SetLeaderboard(ctx, service.redisDB, LEADERBOARD_OVERALL_WEEKLY, item)
+	if err == nil {
+		err = SetRegionalLeaderboard(ctx, service.redisDB, user.LeaderboardRegion(), LEADERBOARD_OVERALL_WEEKLY, item)
+	}
 
 	service.ClearLeaderboardCache(ctx, LEADERBOARD_OVERALL_WEEKLY)
 
@@ -146,61 +183,516 @@ func (service *ServiceLeaderboard) updateWeeklyOverallLeaderboard(ctx context.Co
 }
 
 func (service *ServiceLeaderboard) getLeaderboard(ctx context.Context, user *models.User, leaderboardName string, limit int) (*models.LeaderboardResponse, error) {
+	top, err := service.getTopLeaderboard(ctx, leaderboardName, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the viewer's own rank is looked up per request, a shadow-banned viewer still sees itself
+	hidden := map[string]bool{}
+	hiddenRanks := []int64{}
+	for userID, rank := range top.HiddenRanks {
+		if userID == user.ID {
+			continue
+		}
+
+		hidden[userID] = true
+		hiddenRanks = append(hiddenRanks, rank)
+	}
+	sort.Slice(hiddenRanks, func(i, j int) bool { return hiddenRanks[i] < hiddenRanks[j] })
+
+	me, _, err := service.getMe(ctx, user, leaderboardName, hiddenRanks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.LeaderboardResponse{
+		Leaderboard: hideUsers(top.Items, hidden, limit),
+		Me:          me,
+	}, nil
+}
+
+// leaderboardTop is the part of a board shared by every viewer
+type leaderboardTop struct {
+	Items       []*models.LeaderboardItem
+	HiddenRanks map[string]int64
+}
+
+// getTopLeaderboard returns the enriched top of the board with the ranks of the shadow-banned users,
+// over-fetched by the shadow-banned users so the limit is still filled once they are hidden
+func (service *ServiceLeaderboard) getTopLeaderboard(ctx context.Context, leaderboardName string, limit int) (*leaderboardTop, error) {
+	callback := func() (*leaderboardTop, error) {
+		hidden := service.getHiddenUsers(ctx, nil)
+
+		leaderboard, err := redis_store.GetLeaderboard(ctx, service.redisDB, leaderboardName, limit+len(hidden))
+		if err != nil {
+			return nil, err
+		}
+
+		service.fillProfiles(ctx, leaderboard)
+
+		userIDs := make([]string, 0, len(hidden))
+		for userID := range hidden {
+			userIDs = append(userIDs, userID)
+		}
+
+		hiddenRanks, err := redis_store.GetRanks(ctx, service.redisDB, leaderboardName, userIDs)
+		if err != nil {
+			log.Println("GetRanks error:", err)
+			hiddenRanks = map[string]int64{}
+		}
+
+		return &leaderboardTop{Items: leaderboard, HiddenRanks: hiddenRanks}, nil
+	}
+
+	return caching.UseSharedCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyLeaderboardTop(leaderboardName, limit), LEADERBOARD_TOP_CACHE_TTL, callback)
+}
+
+// ResolveLeaderboardName maps a global board name from the API to its sorted set
+func ResolveLeaderboardName(board string) (string, error) {
+	switch strings.ToLower(board) {
+	case LEADERBOARD_OVERALL, LEADERBOARD_OVERALL_WEEKLY, LEADERBOARD_REFERRAL:
+		return strings.ToLower(board), nil
+	}
+
+	return "", errorx.Wrap(ErrUnknownLeaderboard, errorx.Invalid)
+}
+
+// GetAroundMe returns the size players ranked directly above and below the user
+func (service *ServiceLeaderboard) GetAroundMe(ctx context.Context, user *models.User, leaderboardName string, size int) (*models.LeaderboardResponse, error) {
+	if size <= 0 {
+		size = AROUND_ME_DEFAULT_SIZE
+	}
+	if size > AROUND_ME_MAX_SIZE {
+		size = AROUND_ME_MAX_SIZE
+	}
+
 	callback := func() (*models.LeaderboardResponse, error) {
-		leaderboard, err := redis_store.GetLeaderboard(ctx, service.redisDB, leaderboardName, limit)
+		hidden := service.getHiddenUsers(ctx, user)
+		hiddenRanks := service.getHiddenRanks(ctx, leaderboardName, hidden)
+
+		me, rank, err := service.getMe(ctx, user, leaderboardName, hiddenRanks)
 		if err != nil {
 			return nil, err
 		}
 
-		rank, err := redis_store.GetRank(ctx, service.redisDB, leaderboardName, user)
+		response := &models.LeaderboardResponse{
+			Leaderboard: []*models.LeaderboardItem{},
+			Me:          me,
+		}
+
+		if rank < 0 {
+			return response, nil
+		}
 
-		score := float64(0)
-		if err == redis.Nil {
-			rank = -1
-		} else {
-			score, err = redis_store.GetScore(ctx, service.redisDB, leaderboardName, user)
+		// over-fetch on both sides so hidden users do not shrink the window
+		offset := rank - size - len(hidden)
+		if offset < 0 {
+			offset = 0
 		}
 
-		if err != nil && err != redis.Nil {
+		page, err := redis_store.GetLeaderboardPage(ctx, service.redisDB, leaderboardName, offset, rank-offset+size+len(hidden)+1)
+		if err != nil {
 			return nil, err
 		}
 
-		for _, item := range leaderboard {
-			// censor username
-			u, _ := service.serviceUser.FindUserByID(ctx, item.UserId)
-			if u != nil {
-				if u.Username == "" {
-					item.Username = censorUsername(fmt.Sprintf("%s %s", u.FirstName, u.LastName))
-				} else {
-					item.Username = censorUsername(u.Username)
-				}
+		visible := rerankUsers(page, hidden, hiddenRanks)
 
-				if u.Avatar != nil {
-					item.Avatar = u.Avatar
-				}
+		index := -1
+		for i, item := range visible {
+			if item.UserId == user.ID {
+				index = i
+				break
+			}
+		}
+
+		if index >= 0 {
+			from := index - size
+			if from < 0 {
+				from = 0
+			}
+			to := index + size + 1
+			if to > len(visible) {
+				to = len(visible)
 			}
+			visible = visible[from:to]
+		}
+
+		service.fillProfiles(ctx, visible)
+		response.Leaderboard = visible
+
+		return response, nil
+	}
+
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyLeaderboardAroundMe(leaderboardName, user.ID, size), CACHE_TTL_1_MIN, callback)
+}
+
+// GetLeaderboardPage pages through the whole board, the cursor is an opaque rank offset into the sorted set
+func (service *ServiceLeaderboard) GetLeaderboardPage(ctx context.Context, user *models.User, leaderboardName string, cursor string, limit int) (*models.LeaderboardResponse, error) {
+	if limit <= 0 {
+		limit = LEADERBOARD_PAGE_DEFAULT_LIMIT
+	}
+	if limit > LEADERBOARD_PAGE_MAX_LIMIT {
+		limit = LEADERBOARD_PAGE_MAX_LIMIT
+	}
+
+	offset, err := decodeLeaderboardCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	callback := func() (*models.LeaderboardResponse, error) {
+		hidden := service.getHiddenUsers(ctx, user)
+		hiddenRanks := service.getHiddenRanks(ctx, leaderboardName, hidden)
+
+		num := limit + len(hidden)
+		page, err := redis_store.GetLeaderboardPage(ctx, service.redisDB, leaderboardName, offset, num)
+		if err != nil {
+			return nil, err
+		}
+
+		// only consume as many raw entries as needed so the next cursor resumes right after the last visible one
+		consumed := 0
+		visibleCount := 0
+		for _, item := range page {
+			if visibleCount == limit {
+				break
+			}
+			consumed++
+			if !hidden[item.UserId] {
+				visibleCount++
+			}
+		}
+
+		visible := rerankUsers(page[:consumed], hidden, hiddenRanks)
+		service.fillProfiles(ctx, visible)
+
+		me, _, err := service.getMe(ctx, user, leaderboardName, hiddenRanks)
+		if err != nil {
+			return nil, err
 		}
 
 		response := &models.LeaderboardResponse{
-			Leaderboard: leaderboard,
-			Me: &models.LeaderboardItem{
-				Username: user.Username,
-				UserId:   user.ID,
-				Score:    float64(score),
-				Rank:     int(rank + 1),
-				Avatar:   user.Avatar,
-			},
+			Leaderboard: visible,
+			Me:          me,
 		}
 
-		if user.Username == "" {
-			response.Me.Username = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+		if consumed < len(page) || len(page) == num {
+			response.NextCursor = encodeLeaderboardCursor(offset + consumed)
 		}
 
 		return response, nil
 	}
 
-	// TODO increase TTTL to optimize performance
-	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyLeaderboardByUser(leaderboardName, user.ID, limit), CACHE_TTL_1_MIN, callback)
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyLeaderboardPage(leaderboardName, user.ID, offset, limit), CACHE_TTL_1_MIN, callback)
+}
+
+// GetFriendsLeaderboard ranks the user against its inviter and its invitees down to the configured depth
+func (service *ServiceLeaderboard) GetFriendsLeaderboard(ctx context.Context, user *models.User, leaderboardName string) (*models.LeaderboardResponse, error) {
+	callback := func() (*models.LeaderboardResponse, error) {
+		friendIDs, err := service.getFriendIDs(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+
+		hidden := service.getHiddenUsers(ctx, user)
+		userIDs := []string{user.ID}
+		for _, friendID := range friendIDs {
+			if !hidden[friendID] {
+				userIDs = append(userIDs, friendID)
+			}
+		}
+
+		values, err := redis_store.GetScores(ctx, service.redisDB, leaderboardName, userIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		// ranked by the raw values like the sorted set, so equal scores are ordered by when they were reached
+		ranked := []int{}
+		for i, userID := range userIDs {
+			// friends who never played this board are left out, the viewer always shows up
+			if values[i] == 0 && userID != user.ID {
+				continue
+			}
+			ranked = append(ranked, i)
+		}
+		sort.SliceStable(ranked, func(i, j int) bool { return values[ranked[i]] > values[ranked[j]] })
+
+		leaderboard := []*models.LeaderboardItem{}
+		for _, i := range ranked {
+			score, _ := redis_store.DecodeLeaderboardScore(values[i])
+			leaderboard = append(leaderboard, &models.LeaderboardItem{
+				UserId: userIDs[i],
+				Score:  score,
+			})
+		}
+
+		me := &models.LeaderboardItem{
+			Username: user.Username,
+			UserId:   user.ID,
+			Avatar:   user.Avatar,
+		}
+		if user.Username == "" {
+			me.Username = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+		}
+
+		for i, item := range leaderboard {
+			item.Rank = i + 1
+			if item.UserId == user.ID {
+				me.Score = item.Score
+				me.Rank = item.Rank
+			}
+		}
+
+		service.fillProfiles(ctx, leaderboard)
+
+		return &models.LeaderboardResponse{
+			Leaderboard: leaderboard,
+			Me:          me,
+		}, nil
+	}
+
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyLeaderboardFriends(leaderboardName, user.ID), CACHE_TTL_1_MIN, callback)
+}
+
+// getFriendIDs walks the referral graph: the inviter, then invitees level by level up to the configured depth
+func (service *ServiceLeaderboard) getFriendIDs(ctx context.Context, user *models.User) ([]string, error) {
+	depth, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_FRIENDS_LEADERBOARD_DEPTH, DEFAULT_FRIENDS_LEADERBOARD_DEPTH)
+	if depth <= 0 {
+		depth = DEFAULT_FRIENDS_LEADERBOARD_DEPTH
+	}
+	if depth > FRIENDS_LEADERBOARD_MAX_DEPTH {
+		depth = FRIENDS_LEADERBOARD_MAX_DEPTH
+	}
+
+	seen := map[string]bool{user.ID: true}
+	friendIDs := []string{}
+
+	if user.InviterID != nil && *user.InviterID != "" {
+		seen[*user.InviterID] = true
+		friendIDs = append(friendIDs, *user.InviterID)
+	}
+
+	level := []string{user.ID}
+	for i := 0; i < depth && len(level) > 0 && len(friendIDs) < FRIENDS_LEADERBOARD_MAX_SIZE; i++ {
+		inviteeIDs, err := datastore.GetInviteeIDs(ctx, service.readonlyPostgresDB, level, FRIENDS_LEADERBOARD_MAX_SIZE-len(friendIDs))
+		if err != nil {
+			return nil, err
+		}
+
+		level = []string{}
+		for _, inviteeID := range inviteeIDs {
+			if seen[inviteeID] {
+				continue
+			}
+
+			seen[inviteeID] = true
+			level = append(level, inviteeID)
+			friendIDs = append(friendIDs, inviteeID)
+		}
+	}
+
+	return friendIDs, nil
+}
+
+// getMe returns the viewer's entry with its visible rank along with its raw zero-based rank, -1 when unranked
+func (service *ServiceLeaderboard) getMe(ctx context.Context, user *models.User, leaderboardName string, hiddenRanks []int64) (*models.LeaderboardItem, int, error) {
+	me := &models.LeaderboardItem{
+		Username: user.Username,
+		UserId:   user.ID,
+		Avatar:   user.Avatar,
+	}
+
+	if user.Username == "" {
+		me.Username = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+	}
+
+	rank, err := redis_store.GetRank(ctx, service.redisDB, leaderboardName, user)
+	if err == redis.Nil {
+		return me, -1, nil
+	}
+	if err != nil {
+		return nil, -1, err
+	}
+
+	score, err := redis_store.GetScore(ctx, service.redisDB, leaderboardName, user)
+	if err != nil && err != redis.Nil {
+		return nil, -1, err
+	}
+
+	me.Score = score
+	me.Rank = int(rank) - countHiddenAbove(hiddenRanks, int(rank)) + 1
+
+	return me, int(rank), nil
+}
+
+// getHiddenRanks returns the sorted zero-based ranks of the hidden users on the board
+func (service *ServiceLeaderboard) getHiddenRanks(ctx context.Context, leaderboardName string, hidden map[string]bool) []int64 {
+	ranks := []int64{}
+	if len(hidden) == 0 {
+		return ranks
+	}
+
+	userIDs := make([]string, 0, len(hidden))
+	for userID := range hidden {
+		userIDs = append(userIDs, userID)
+	}
+
+	byUser, err := redis_store.GetRanks(ctx, service.redisDB, leaderboardName, userIDs)
+	if err != nil {
+		log.Println("GetRanks error:", err)
+		return ranks
+	}
+
+	for _, rank := range byUser {
+		ranks = append(ranks, rank)
+	}
+
+	sort.Slice(ranks, func(i, j int) bool { return ranks[i] < ranks[j] })
+
+	return ranks
+}
+
+// countHiddenAbove counts hidden users ranked before the zero-based rank
+func countHiddenAbove(hiddenRanks []int64, rank int) int {
+	return sort.Search(len(hiddenRanks), func(i int) bool { return hiddenRanks[i] >= int64(rank) })
+}
+
+// rerankUsers drops hidden users and shifts the remaining ranks up accordingly
+func rerankUsers(leaderboard []*models.LeaderboardItem, hidden map[string]bool, hiddenRanks []int64) []*models.LeaderboardItem {
+	visible := []*models.LeaderboardItem{}
+	for _, item := range leaderboard {
+		if hidden[item.UserId] {
+			continue
+		}
+
+		item.Rank -= countHiddenAbove(hiddenRanks, item.Rank-1)
+		visible = append(visible, item)
+	}
+
+	return visible
+}
+
+func encodeLeaderboardCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeLeaderboardCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errorx.Wrap(ErrInvalidLeaderboardCursor, errorx.Invalid)
+	}
+
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, errorx.Wrap(ErrInvalidLeaderboardCursor, errorx.Invalid)
+	}
+
+	return offset, nil
+}
+
+func (service *ServiceLeaderboard) fillProfiles(ctx context.Context, leaderboard []*models.LeaderboardItem) {
+	userIDs := make([]string, 0, len(leaderboard))
+	for _, item := range leaderboard {
+		userIDs = append(userIDs, item.UserId)
+	}
+
+	users, err := service.serviceUser.FindUsersByIDs(ctx, userIDs)
+	if err != nil {
+		log.Println("FindUsersByIDs error:", err)
+		return
+	}
+
+	for _, item := range leaderboard {
+		// censor username
+		u := users[item.UserId]
+		if u != nil {
+			if u.Username == "" {
+				item.Username = censorUsername(fmt.Sprintf("%s %s", u.FirstName, u.LastName))
+			} else {
+				item.Username = censorUsername(u.Username)
+			}
+
+			if u.Avatar != nil {
+				item.Avatar = u.Avatar
+			}
+		}
+	}
+}
+
+// getHiddenUsers returns the shadow-banned users that the viewer must not see, a shadow-banned viewer still sees itself
+func (service *ServiceLeaderboard) getHiddenUsers(ctx context.Context, viewer *models.User) map[string]bool {
+	hidden := map[string]bool{}
+
+	users, err := service.getShadowBannedUsers(ctx)
+	if err != nil {
+		log.Println("getShadowBannedUsers error:", err)
+		return hidden
+	}
+
+	for userID, u := range users {
+		if viewer != nil && userID == viewer.ID {
+			continue
+		}
+		if u != nil && u.EffectiveModerationStatus() != models.ModerationStatusShadowBanned {
+			continue
+		}
+
+		hidden[userID] = true
+	}
+
+	return hidden
+}
+
+// getShadowBannedUsers returns the shadow-banned set with the users' moderation, shared by every viewer,
+// a user missing from the database is kept with a nil entry
+func (service *ServiceLeaderboard) getShadowBannedUsers(ctx context.Context) (map[string]*models.User, error) {
+	callback := func() (map[string]*models.User, error) {
+		userIDs, err := redis_store.GetShadowBannedUsers(ctx, service.redisDB)
+		if err != nil {
+			return nil, err
+		}
+
+		// users that cannot be loaded stay hidden
+		found, err := service.serviceUser.FindUsersByIDs(ctx, userIDs)
+		if err != nil {
+			log.Println("FindUsersByIDs error:", err)
+			found = map[string]*models.User{}
+		}
+
+		users := make(map[string]*models.User, len(userIDs))
+		for _, userID := range userIDs {
+			users[userID] = found[userID]
+		}
+
+		return users, nil
+	}
+
+	return caching.UseSharedCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyLeaderboardShadowBanned(), LEADERBOARD_TOP_CACHE_TTL, callback)
+}
+
+// hideUsers drops hidden users from a shared top list, the items are copied so the cached list is left untouched
+func hideUsers(leaderboard []*models.LeaderboardItem, hidden map[string]bool, limit int) []*models.LeaderboardItem {
+	visible := []*models.LeaderboardItem{}
+	for _, item := range leaderboard {
+		if hidden[item.UserId] {
+			continue
+		}
+
+		copied := *item
+		copied.Rank = len(visible) + 1
+		visible = append(visible, &copied)
+		if len(visible) == limit {
+			break
+		}
+	}
+
+	return visible
 }
 
 func censorUsername(username string) string {
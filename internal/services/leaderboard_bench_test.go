@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"millionaire/internal/models"
+
+	"github.com/go-redis/cache/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	benchLeaderboardSize    = 2000
+	benchLeaderboardViewers = 1000
+	benchLeaderboardLimit   = 100
+)
+
+// benchShadowBanned are spread over the top of the board, so hiding them shifts the ranks below
+var benchShadowBanned = []string{"3", "17", "42", "99", "1500"}
+
+// benchRedis answers the commands of a leaderboard read from memory, the client never dials
+type benchRedis struct {
+	commands atomic.Int64
+	zsets    map[string][]redis.Z
+	sets     map[string][]string
+}
+
+func (r *benchRedis) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (r *benchRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		r.process(cmd)
+		return cmd.Err()
+	}
+}
+
+func (r *benchRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			r.process(cmd)
+		}
+		return nil
+	}
+}
+
+func (r *benchRedis) process(cmd redis.Cmder) {
+	r.commands.Add(1)
+
+	args := cmd.Args()
+	key := fmt.Sprint(args[1])
+	zset := r.zsets[key]
+
+	rankOf := func(member string) int {
+		for i, z := range zset {
+			if z.Member == member {
+				return i
+			}
+		}
+		return -1
+	}
+
+	switch cmd.Name() {
+	case "smembers":
+		cmd.(*redis.StringSliceCmd).SetVal(r.sets[key])
+	case "zrevrange":
+		start, _ := strconv.Atoi(fmt.Sprint(args[2]))
+		stop, _ := strconv.Atoi(fmt.Sprint(args[3]))
+		if stop >= len(zset) {
+			stop = len(zset) - 1
+		}
+		cmd.(*redis.ZSliceCmd).SetVal(append([]redis.Z{}, zset[start:stop+1]...))
+	case "zrevrank":
+		rank := rankOf(fmt.Sprint(args[2]))
+		if rank < 0 {
+			cmd.SetErr(redis.Nil)
+			return
+		}
+		cmd.(*redis.IntCmd).SetVal(int64(rank))
+	case "zscore":
+		rank := rankOf(fmt.Sprint(args[2]))
+		if rank < 0 {
+			cmd.SetErr(redis.Nil)
+			return
+		}
+		cmd.(*redis.FloatCmd).SetVal(zset[rank].Score)
+	default:
+		cmd.SetErr(fmt.Errorf("unexpected redis command %s", cmd.Name()))
+	}
+}
+
+// benchCache keeps the values in memory encoded like the redis cache does
+type benchCache struct {
+	mu    sync.Mutex
+	calls atomic.Int64
+	items map[string][]byte
+}
+
+func (c *benchCache) Get(ctx context.Context, key string, target any) error {
+	c.calls.Add(1)
+	c.mu.Lock()
+	b, ok := c.items[key]
+	c.mu.Unlock()
+	if !ok {
+		return cache.ErrCacheMiss
+	}
+
+	return msgpack.Unmarshal(b, target)
+}
+
+func (c *benchCache) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	c.calls.Add(1)
+	b, err := msgpack.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.items[key] = b
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *benchCache) Delete(ctx context.Context, key string) error {
+	c.calls.Add(1)
+	c.mu.Lock()
+	delete(c.items, key)
+	c.mu.Unlock()
+	return nil
+}
+
+var benchQuotedID = regexp.MustCompile(`'([^']*)'`)
+
+// benchDB is a database/sql driver answering every select with the users quoted in the query
+type benchDB struct {
+	queries      atomic.Int64
+	shadowBanned map[string]bool
+}
+
+func (d *benchDB) Connect(ctx context.Context) (driver.Conn, error) { return benchConn{d}, nil }
+func (d *benchDB) Driver() driver.Driver                            { return d }
+func (d *benchDB) Open(name string) (driver.Conn, error)            { return benchConn{d}, nil }
+
+type benchConn struct{ db *benchDB }
+
+func (c benchConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+func (c benchConn) Close() error { return nil }
+func (c benchConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c benchConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.queries.Add(1)
+
+	rows := &benchRows{}
+	for _, match := range benchQuotedID.FindAllStringSubmatch(query, -1) {
+		status := models.ModerationStatusActive
+		if c.db.shadowBanned[match[1]] {
+			status = models.ModerationStatusShadowBanned
+		}
+		rows.values = append(rows.values, []driver.Value{match[1], "player" + match[1], status})
+	}
+	return rows, nil
+}
+
+type benchRows struct {
+	values [][]driver.Value
+}
+
+func (r *benchRows) Columns() []string { return []string{"id", "username", "moderation_status"} }
+func (r *benchRows) Close() error      { return nil }
+
+func (r *benchRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// newBenchServiceLeaderboard wires the real service to the in-memory redis, cache and database
+func newBenchServiceLeaderboard(tb testing.TB) (*ServiceLeaderboard, *benchRedis, *benchCache, *benchDB) {
+	tb.Helper()
+
+	board := make([]redis.Z, 0, benchLeaderboardSize)
+	for i := 0; i < benchLeaderboardSize; i++ {
+		board = append(board, redis.Z{Member: strconv.Itoa(i), Score: float64(benchLeaderboardSize - i)})
+	}
+	fake := &benchRedis{
+		zsets: map[string][]redis.Z{"leaderboard:" + LEADERBOARD_OVERALL: board},
+		sets:  map[string][]string{"moderation:shadow_banned": benchShadowBanned},
+	}
+
+	redisDB := redis.NewClient(&redis.Options{Addr: "bench.invalid:6379"})
+	redisDB.AddHook(fake)
+	tb.Cleanup(func() { redisDB.Close() })
+
+	db := &benchDB{shadowBanned: map[string]bool{}}
+	for _, userID := range benchShadowBanned {
+		db.shadowBanned[userID] = true
+	}
+	sqldb := sql.OpenDB(db)
+	tb.Cleanup(func() { sqldb.Close() })
+
+	c := &benchCache{items: map[string][]byte{}}
+	service := &ServiceLeaderboard{
+		redisDB:       redisDB,
+		cache:         c,
+		readonlyCache: c,
+		serviceUser:   &ServiceUser{readonlyPostgresDB: bun.NewDB(sqldb, pgdialect.New())},
+	}
+
+	return service, fake, c, db
+}
+
+func TestGetLeaderboardLooksUpOnlyTheViewer(t *testing.T) {
+	ctx := context.Background()
+	service, fake, c, db := newBenchServiceLeaderboard(t)
+
+	_, err := service.getLeaderboard(ctx, &models.User{ID: "0"}, LEADERBOARD_OVERALL, benchLeaderboardLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redisBefore, cacheBefore, queriesBefore := fake.commands.Load(), c.calls.Load(), db.queries.Load()
+	res, err := service.getLeaderboard(ctx, &models.User{ID: "50"}, LEADERBOARD_OVERALL, benchLeaderboardLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ZREVRANK and ZSCORE of the viewer
+	if got := fake.commands.Load() - redisBefore; got != 2 {
+		t.Fatalf("warm request sent %d redis commands, want 2", got)
+	}
+	if got := c.calls.Load() - cacheBefore; got != 1 {
+		t.Fatalf("warm request sent %d cache calls, want 1", got)
+	}
+	if got := db.queries.Load() - queriesBefore; got != 0 {
+		t.Fatalf("warm request sent %d queries, want 0", got)
+	}
+
+	if len(res.Leaderboard) != benchLeaderboardLimit {
+		t.Fatalf("leaderboard has %d items, want %d", len(res.Leaderboard), benchLeaderboardLimit)
+	}
+	for i, item := range res.Leaderboard {
+		if item.Rank != i+1 {
+			t.Fatalf("item %d has rank %d", i, item.Rank)
+		}
+		if item.UserId == "3" || item.UserId == "17" || item.UserId == "42" || item.UserId == "99" {
+			t.Fatalf("shadow-banned user %s is listed", item.UserId)
+		}
+		if !strings.HasPrefix(item.Username, "pl*****") {
+			t.Fatalf("item %s has username %q", item.UserId, item.Username)
+		}
+	}
+	// user 50 is ranked 51st with users 3, 17 and 42 hidden above
+	if res.Me.Rank != 48 {
+		t.Fatalf("viewer rank = %d, want 48", res.Me.Rank)
+	}
+}
+
+func TestGetLeaderboardShowsShadowBannedViewerToItself(t *testing.T) {
+	ctx := context.Background()
+	service, _, _, _ := newBenchServiceLeaderboard(t)
+
+	res, err := service.getLeaderboard(ctx, &models.User{ID: "17"}, LEADERBOARD_OVERALL, benchLeaderboardLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// user 17 is ranked 18th with user 3 hidden above
+	if res.Me.Rank != 17 {
+		t.Fatalf("viewer rank = %d, want 17", res.Me.Rank)
+	}
+
+	ids := make([]string, 0, len(res.Leaderboard))
+	for _, item := range res.Leaderboard {
+		ids = append(ids, item.UserId)
+	}
+	if !containsString(ids, "17") {
+		t.Fatal("shadow-banned viewer does not see itself")
+	}
+	if containsString(ids, "3") {
+		t.Fatal("other shadow-banned users are listed")
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// BenchmarkGetLeaderboard serves every viewer once per op from a cold cache and reports the calls sent per op
+func BenchmarkGetLeaderboard(b *testing.B) {
+	ctx := context.Background()
+
+	var redisCommands, cacheCalls, queries int64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		service, fake, c, db := newBenchServiceLeaderboard(b)
+		b.StartTimer()
+
+		for viewer := 0; viewer < benchLeaderboardViewers; viewer++ {
+			_, err := service.getLeaderboard(ctx, &models.User{ID: strconv.Itoa(viewer)}, LEADERBOARD_OVERALL, benchLeaderboardLimit)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		redisCommands += fake.commands.Load()
+		cacheCalls += c.calls.Load()
+		queries += db.queries.Load()
+	}
+
+	b.ReportMetric(float64(redisCommands)/float64(b.N), "redis/op")
+	b.ReportMetric(float64(cacheCalls)/float64(b.N), "cache/op")
+	b.ReportMetric(float64(queries)/float64(b.N), "postgres/op")
+}
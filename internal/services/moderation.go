@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+	"millionaire/internal/pkg/caching"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/redis/go-redis/v9"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+const (
+	MODERATION_OPERATOR_SYSTEM     = "system"
+	MODERATION_OPERATOR_ANTI_CHEAT = "anti-cheat"
+	MODERATION_HISTORY_LIMIT       = 20
+)
+
+type ServiceModeration struct {
+	container          *do.Injector
+	redisDB            redis.UniversalClient
+	postgresDB         *bun.DB
+	readonlyPostgresDB *bun.DB
+	cache              caching.Cache
+}
+
+func NewServiceModeration(container *do.Injector) (*ServiceModeration, error) {
+	redisDB, err := do.InvokeNamed[redis.UniversalClient](container, "redis-db")
+	if err != nil {
+		return nil, err
+	}
+
+	postgresDB, err := do.Invoke[*bun.DB](container)
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyPostgresDB, err := do.InvokeNamed[*bun.DB](container, "db-readonly")
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := do.Invoke[caching.Cache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceModeration{container, redisDB, postgresDB, readonlyPostgresDB, cache}, nil
+}
+
+func (service *ServiceModeration) Moderate(ctx context.Context, userID string, payload *models.ModerateUserPayload, operator string) (*models.UserModeration, error) {
+	if payload == nil || userID == "" {
+		return nil, errorx.Wrap(errors.New("invalid payload"), errorx.Invalid)
+	}
+
+	if !slices.Contains(models.ModerationStatuses, payload.Status) {
+		return nil, errorx.Wrap(errors.New("invalid moderation status"), errorx.Validation)
+	}
+
+	if payload.DurationInHours < 0 {
+		return nil, errorx.Wrap(errors.New("invalid duration"), errorx.Validation)
+	}
+
+	moderation := &models.UserModeration{
+		UserID:   userID,
+		Status:   payload.Status,
+		Reason:   payload.Reason,
+		Operator: operator,
+	}
+	if payload.DurationInHours > 0 && payload.Status != models.ModerationStatusActive {
+		expiresAt := time.Now().Add(time.Duration(payload.DurationInHours) * time.Hour)
+		moderation.ExpiresAt = &expiresAt
+	}
+
+	err := ModerateUser(ctx, service.postgresDB, service.redisDB, moderation)
+	if err == sql.ErrNoRows {
+		return nil, errorx.Wrap(errors.New("user not found"), errorx.NotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	serviceUser, err := do.Invoke[*ServiceUser](service.container)
+	if err == nil {
+		_ = serviceUser.ClearUserCache(ctx, userID)
+	}
+
+	return moderation, nil
+}
+
+func (service *ServiceModeration) GetUserModeration(ctx context.Context, userID string) (*models.UserModerationResponse, error) {
+	user, err := datastore.FindUserByID(ctx, service.readonlyPostgresDB, userID)
+	if err == sql.ErrNoRows {
+		return nil, errorx.Wrap(errors.New("user not found"), errorx.NotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := datastore.GetUserModerations(ctx, service.readonlyPostgresDB, userID, MODERATION_HISTORY_LIMIT)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.UserModerationResponse{
+		UserID:  user.ID,
+		Status:  user.EffectiveModerationStatus(),
+		History: history,
+	}
+	if response.Status != models.ModerationStatusActive {
+		response.Reason = user.ModerationReason
+		response.ExpiresAt = user.ModerationExpiresAt
+	}
+
+	return response, nil
+}
+
+// CheckUser rejects banned users and lifts moderations that have expired
+func (service *ServiceModeration) CheckUser(ctx context.Context, user *models.User) error {
+	if user == nil {
+		return nil
+	}
+
+	status := user.EffectiveModerationStatus()
+	if status == models.ModerationStatusActive && user.ModerationStatus != "" && user.ModerationStatus != models.ModerationStatusActive {
+		_, err := service.Moderate(ctx, user.ID, &models.ModerateUserPayload{
+			Status: models.ModerationStatusActive,
+			Reason: "expired",
+		}, MODERATION_OPERATOR_SYSTEM)
+		if err != nil {
+			log.Println("lift expired moderation error:", err, "user:", user.ID)
+		}
+		return nil
+	}
+
+	if status == models.ModerationStatusBanned {
+		return errorx.Wrap(ErrUserBanned, errorx.Authz)
+	}
+
+	return nil
+}
+
+// ModerateUser is shared with the bot, which does not use the container
+func ModerateUser(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, moderation *models.UserModeration) error {
+	err := datastore.ModerateUser(ctx, db, moderation)
+	if err != nil {
+		return err
+	}
+
+	if moderation.Status == models.ModerationStatusShadowBanned {
+		err = redis_store.AddShadowBannedUser(ctx, redisDB, moderation.UserID)
+	} else {
+		err = redis_store.RemoveShadowBannedUser(ctx, redisDB, moderation.UserID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if moderation.Status == models.ModerationStatusBanned {
+		return RemoveUserFromLeaderboards(ctx, db, redisDB, moderation.UserID)
+	}
+
+	if moderation.PreviousStatus == models.ModerationStatusBanned && !IsExcludedFromLeaderboards(ctx, redisDB, moderation.UserID) {
+		return RestoreUserLeaderboards(ctx, db, redisDB, moderation.UserID)
+	}
+
+	return nil
+}
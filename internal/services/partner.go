@@ -2,8 +2,17 @@ package services
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
 	"millionaire/internal/datastore"
 	"millionaire/internal/interfaces"
 	"millionaire/internal/models"
@@ -22,6 +31,7 @@ type ServicePartner struct {
 	container          *do.Injector
 	redisDB            redis.UniversalClient
 	rs                 *redsync.Redsync
+	postgresDB         *bun.DB
 	readonlyPostgresDB *bun.DB
 	cache              caching.Cache
 	readonlyCache      caching.ReadOnlyCache
@@ -50,6 +60,11 @@ func NewServicePartner(container *do.Injector) (*ServicePartner, error) {
 		return nil, err
 	}
 
+	postgresDB, err := do.Invoke[*bun.DB](container)
+	if err != nil {
+		return nil, err
+	}
+
 	readonlyPostgresDB, err := do.InvokeNamed[*bun.DB](container, "db-readonly")
 	if err != nil {
 		return nil, err
@@ -60,26 +75,268 @@ func NewServicePartner(container *do.Injector) (*ServicePartner, error) {
 		return nil, err
 	}
 
-	return &ServicePartner{container, db, rs, readonlyPostgresDB, cache, readonlyCache, limiter}, nil
+	return &ServicePartner{container, db, rs, postgresDB, readonlyPostgresDB, cache, readonlyCache, limiter}, nil
+}
+
+func hashPartnerKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Authenticate resolves the partner and the key of an X-Api-Key header, revoked or expired keys and disabled
+// partners are rejected
+func (service *ServicePartner) Authenticate(ctx context.Context, apiKey string) (*models.Partner, *models.PartnerKey, error) {
+	hash := hashPartnerKey(apiKey)
+	callback := func() (*models.PartnerKey, error) {
+		return datastore.FindPartnerKeyByHash(ctx, service.readonlyPostgresDB, hash)
+	}
+
+	key, err := caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyPartnerKey(hash), CACHE_TTL_15_MINS, callback)
+	if err != nil || key == nil {
+		return nil, nil, errors.New("wrong api key")
+	}
+
+	if !key.IsActive(time.Now()) {
+		return nil, nil, errors.New("api key is expired")
+	}
+
+	partner, err := service.getPartnerByID(ctx, key.PartnerID)
+	if err != nil || partner == nil || !partner.Enabled {
+		return nil, nil, errors.New("partner is disabled")
+	}
+
+	return partner, key, nil
 }
 
-func (service *ServicePartner) ValidateAPIKey(apiKey string) (*models.Partner, error) {
-	ctx := context.Background()
+// VerifySignature checks the X-Signature of a request: "sha256=" and the hex HMAC-SHA256, keyed by the signing
+// secret of the key, of "<timestamp>\n<method>\n<request uri>\n<hex sha256 of the body>". The timestamp must be
+// within PARTNER_SIGNATURE_TOLERANCE and a signature is only accepted once
+func (service *ServicePartner) VerifySignature(ctx context.Context, key *models.PartnerKey, timestamp string, signature string, method string, uri string, body []byte) error {
+	if key.SigningSecret == "" {
+		return errors.New("the api key cannot sign requests")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > PARTNER_SIGNATURE_TOLERANCE || skew < -PARTNER_SIGNATURE_TOLERANCE {
+		return errors.New("timestamp is out of tolerance")
+	}
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(key.SigningSecret))
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + uri + "\n" + hex.EncodeToString(bodyHash[:])))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("invalid signature")
+	}
+
+	ok, err := service.redisDB.SetNX(ctx, DBKeyPartnerSignature(key.ID, signature), 1, 2*PARTNER_SIGNATURE_TOLERANCE).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("signature was already used")
+	}
+
+	return nil
+}
+
+// Allow throttles the requests of a partner to its own rate, PARTNER_RATE_LIMIT_PER_MINUTE when it has none.
+// Limiter errors other than rate limited let the request through
+func (service *ServicePartner) Allow(ctx context.Context, partner *models.Partner) error {
+	rate := partner.RateLimitPerMinute
+	if rate <= 0 {
+		rate = PARTNER_RATE_LIMIT_PER_MINUTE
+	}
+
+	err := service.limiter.Allow(ctx, LimitKeyParner(partner.Slug), redis_rate.PerMinute(rate))
+	if err != nil {
+		if err.Error() == limiter.ErrRateLimited.Error() {
+			return errorx.Wrap(err, errorx.RateLimiting)
+		}
+		log.Println("partner rate limit error:", err, "partner:", partner.Slug)
+	}
+
+	return nil
+}
+
+func (service *ServicePartner) getPartnerByID(ctx context.Context, id int64) (*models.Partner, error) {
 	callback := func() (*models.Partner, error) {
-		return datastore.FindPartnerByAPIKey(ctx, service.readonlyPostgresDB, apiKey)
+		return datastore.GetPartnerByID(ctx, service.readonlyPostgresDB, id)
 	}
-	partner, err := caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyPartner(apiKey), CACHE_TTL_15_MINS, callback)
+
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyPartnerByID(id), CACHE_TTL_5_MINS, callback)
+}
+
+func (service *ServicePartner) GetPartners(ctx context.Context) ([]models.Partner, error) {
+	return datastore.GetPartners(ctx, service.postgresDB)
+}
+
+func (service *ServicePartner) getPartnerNoCache(ctx context.Context, slug string) (*models.Partner, error) {
+	partner, err := datastore.GetPartner(ctx, service.postgresDB, slug)
+	if err == sql.ErrNoRows {
+		return nil, errorx.Wrap(errors.New("partner not found"), errorx.NotExist)
+	}
+
+	return partner, err
+}
+
+func (service *ServicePartner) clearPartnerCache(ctx context.Context, partner *models.Partner) {
+	_ = service.cache.Delete(ctx, DBKeyPartner(partner.Slug))
+	_ = service.cache.Delete(ctx, DBKeyPartnerByID(partner.ID))
+	_ = service.cache.Delete(ctx, DBKeyEnabledPartners())
+}
+
+func (service *ServicePartner) UpdatePartner(ctx context.Context, slug string, payload *models.PartnerSettingsPayload) (*models.Partner, error) {
+	partner, err := service.getPartnerNoCache(ctx, slug)
 	if err != nil {
 		return nil, err
 	}
 
-	if partner == nil {
-		return nil, errors.New("wrong api key")
+	if payload.RateLimitPerMinute != nil && *payload.RateLimitPerMinute < 0 {
+		return nil, errorx.Wrap(errors.New("rate_limit_per_minute cannot be negative"), errorx.Validation)
 	}
 
+	if payload.Enabled != nil {
+		partner.Enabled = *payload.Enabled
+	}
+	if payload.RefCode != nil {
+		partner.RefCode = payload.RefCode
+		if *payload.RefCode == "" {
+			partner.RefCode = nil
+		}
+	}
+	if payload.RateLimitPerMinute != nil {
+		partner.RateLimitPerMinute = *payload.RateLimitPerMinute
+	}
+	if payload.RequireSignature != nil {
+		partner.RequireSignature = *payload.RequireSignature
+	}
+
+	if err := datastore.UpdatePartnerSettings(ctx, service.postgresDB, partner); err != nil {
+		return nil, err
+	}
+
+	service.clearPartnerCache(ctx, partner)
+
 	return partner, nil
 }
 
+// CreateKey issues a key with its signing secret, both are only returned here
+func (service *ServicePartner) CreateKey(ctx context.Context, slug string, payload *models.PartnerKeyPayload) (*models.PartnerKeyCreated, error) {
+	partner, err := service.getPartnerNoCache(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, scope := range payload.Scopes {
+		if !models.IsPartnerScope(scope) {
+			return nil, errorx.Wrap(fmt.Errorf("unknown scope %s", scope), errorx.Validation)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if len(scopes) == 0 {
+		return nil, errorx.Wrap(errors.New("at least one scope is required"), errorx.Validation)
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		return nil, errorx.Wrap(errors.New("expires_at must be in the future"), errorx.Validation)
+	}
+
+	prefix := PARTNER_KEY_PREFIX + randomHex(4)
+	apiKey := prefix + "_" + randomHex(24)
+	key := &models.PartnerKey{
+		PartnerID:     partner.ID,
+		Name:          payload.Name,
+		Prefix:        prefix,
+		Hash:          hashPartnerKey(apiKey),
+		SigningSecret: randomHex(32),
+		Scopes:        scopes,
+		ExpiresAt:     payload.ExpiresAt,
+	}
+
+	if err := datastore.InsertPartnerKey(ctx, service.postgresDB, key); err != nil {
+		return nil, err
+	}
+
+	return &models.PartnerKeyCreated{PartnerKey: key, Key: apiKey}, nil
+}
+
+func (service *ServicePartner) GetKeys(ctx context.Context, slug string) ([]models.PartnerKey, error) {
+	partner, err := service.getPartnerNoCache(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := datastore.GetPartnerKeys(ctx, service.postgresDB, partner.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		keys[i].SigningSecret = ""
+	}
+
+	return keys, nil
+}
+
+func (service *ServicePartner) getKey(ctx context.Context, slug string, id int64) (*models.PartnerKey, error) {
+	partner, err := service.getPartnerNoCache(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := datastore.GetPartnerKey(ctx, service.postgresDB, partner.ID, id)
+	if err == sql.ErrNoRows {
+		return nil, errorx.Wrap(errors.New("key not found"), errorx.NotExist)
+	}
+
+	return key, err
+}
+
+// ExpireKey sets when a key stops working, the rotation of a key is a new key and an expiry on the old one
+func (service *ServicePartner) ExpireKey(ctx context.Context, slug string, id int64, expiresAt *time.Time) error {
+	key, err := service.getKey(ctx, slug, id)
+	if err != nil {
+		return err
+	}
+
+	if err := datastore.SetPartnerKeyExpiry(ctx, service.postgresDB, key.ID, expiresAt); err != nil {
+		return err
+	}
+
+	return service.cache.Delete(ctx, DBKeyPartnerKey(key.Hash))
+}
+
+func (service *ServicePartner) RevokeKey(ctx context.Context, slug string, id int64) error {
+	key, err := service.getKey(ctx, slug, id)
+	if err != nil {
+		return err
+	}
+
+	if err := datastore.RevokePartnerKey(ctx, service.postgresDB, key.ID, time.Now()); err != nil {
+		return err
+	}
+
+	return service.cache.Delete(ctx, DBKeyPartnerKey(key.Hash))
+}
+
 func (service *ServicePartner) GetPartner(ctx context.Context, slug string) (*models.Partner, error) {
 	callback := func() (*models.Partner, error) {
 		partner, err := datastore.GetPartner(ctx, service.readonlyPostgresDB, slug)
@@ -93,14 +350,7 @@ func (service *ServicePartner) GetPartner(ctx context.Context, slug string) (*mo
 }
 
 func (service *ServicePartner) CheckJoinedUser(ctx context.Context, partner *models.Partner, userID string, refCode string, minGem int) (*models.PartnerResponse, error) {
-	err := service.limiter.Allow(ctx, LimitKeyParner(partner.Slug), redis_rate.PerMinute(PARTNER_RATE_LIMIT_PER_MINUTE))
-	if err != nil {
-		if err.Error() == limiter.ErrRateLimited.Error() {
-			return nil, errorx.Wrap(err, errorx.RateLimiting)
-		}
-		return nil, err
-	}
-
+	// the partner is throttled by Allow in the authentication
 	callback := func() (*models.PartnerResponse, error) {
 		return service.getJoinedUserInfo(ctx, userID, refCode, minGem)
 	}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/redis/go-redis/v9"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+type ServicePartnerStats struct {
+	container          *do.Injector
+	readonlyPostgresDB *bun.DB
+}
+
+func NewServicePartnerStats(container *do.Injector) (*ServicePartnerStats, error) {
+	readonlyPostgresDB, err := do.InvokeNamed[*bun.DB](container, "db-readonly")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServicePartnerStats{container, readonlyPostgresDB}, nil
+}
+
+// partnerStatsRange defaults to the last PARTNER_STATS_DEFAULT_DAYS days
+func partnerStatsRange(from *time.Time, to *time.Time) (time.Time, time.Time, error) {
+	end := time.Now().UTC()
+	if to != nil {
+		end = to.UTC()
+	}
+
+	start := end.AddDate(0, 0, -PARTNER_STATS_DEFAULT_DAYS)
+	if from != nil {
+		start = from.UTC()
+	}
+
+	if !end.After(start) {
+		return start, end, errorx.Wrap(errors.New("invalid time range"), errorx.Invalid)
+	}
+	if end.Sub(start) > PARTNER_STATS_MAX_DAYS*24*time.Hour {
+		return start, end, errorx.Wrap(fmt.Errorf("time range is limited to %d days", PARTNER_STATS_MAX_DAYS), errorx.Invalid)
+	}
+
+	return start, end, nil
+}
+
+// GetTaskStats reports for every task sponsored by the partner the views of the task page, and per link the
+// verification attempts, the users verified and the gems paid
+func (service *ServicePartnerStats) GetTaskStats(ctx context.Context, partner *models.Partner, from *time.Time, to *time.Time) (*models.PartnerTaskStatsReport, error) {
+	start, end, err := partnerStatsRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := datastore.GetSocialTasksByPartner(ctx, service.readonlyPostgresDB, partner.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	taskIDs := make([]int64, 0, len(tasks))
+	for _, task := range tasks {
+		taskIDs = append(taskIDs, task.ID)
+	}
+
+	views, err := datastore.CountSocialTaskViews(ctx, service.readonlyPostgresDB, taskIDs, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.PartnerTaskStatsReport{From: start, To: end, Tasks: []*models.PartnerTaskStats{}}
+	for _, task := range tasks {
+		verifications, err := datastore.GetSocialTaskVerificationStats(ctx, service.readonlyPostgresDB, task.ID, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		byLink := map[int]*models.PartnerTaskLinkStats{}
+		for _, row := range verifications {
+			byLink[row.LinkID] = row
+		}
+
+		actions := make([]string, 0, len(task.Links))
+		for _, link := range task.Links {
+			actions = append(actions, fmt.Sprintf(KEY_SOCIAL_TASK, task.GameSlug, link.Url))
+		}
+
+		gems, err := datastore.SumUserGemsByActions(ctx, service.readonlyPostgresDB, actions, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		stats := &models.PartnerTaskStats{
+			TaskID:   task.ID,
+			GameSlug: task.GameSlug,
+			Title:    task.Title,
+			Views:    views[task.ID],
+			Links:    []*models.PartnerTaskLinkStats{},
+		}
+		for i, link := range task.Links {
+			item, ok := byLink[link.ID]
+			if !ok {
+				item = &models.PartnerTaskLinkStats{LinkID: link.ID}
+			}
+			item.Url = link.Url
+			item.Gems = gems[actions[i]]
+
+			stats.Attempts += item.Attempts
+			stats.Completions += item.Completions
+			stats.Gems += item.Gems
+			stats.Links = append(stats.Links, item)
+		}
+
+		report.Tasks = append(report.Tasks, stats)
+	}
+
+	return report, nil
+}
+
+func (service *ServicePartnerStats) GetArenas(ctx context.Context, partner *models.Partner) ([]models.Arena, error) {
+	return datastore.GetArenasByPartner(ctx, service.readonlyPostgresDB, partner.ID)
+}
+
+// GetArenaStats reports the players of an arena sponsored by the partner from the finished sessions: the players
+// per day, the score distribution and the retention of the players who joined in the range
+func (service *ServicePartnerStats) GetArenaStats(ctx context.Context, partner *models.Partner, slug string, from *time.Time, to *time.Time) (*models.PartnerArenaStats, error) {
+	start, end, err := partnerStatsRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	arena, err := datastore.GetPartnerArena(ctx, service.readonlyPostgresDB, partner.ID, slug)
+	if err == sql.ErrNoRows {
+		return nil, errorx.Wrap(errors.New("arena not found"), errorx.NotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	participants, sessions, err := datastore.CountArenaSessions(ctx, service.readonlyPostgresDB, arena.GameSlug, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := datastore.GetArenaDailyParticipants(ctx, service.readonlyPostgresDB, arena.GameSlug, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	distribution, err := datastore.GetArenaScoreDistribution(ctx, service.readonlyPostgresDB, arena.GameSlug, start, end, PARTNER_STATS_SCORE_BUCKETS)
+	if err != nil {
+		return nil, err
+	}
+
+	retention, err := datastore.GetArenaRetention(ctx, service.readonlyPostgresDB, arena.GameSlug, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	if retention.Participants > 0 {
+		retention.ReturnedRate = float64(retention.Returned) / float64(retention.Participants)
+		retention.Day1Rate = float64(retention.Day1) / float64(retention.Participants)
+		retention.Day7Rate = float64(retention.Day7) / float64(retention.Participants)
+	}
+
+	return &models.PartnerArenaStats{
+		Arena:             arena,
+		From:              start,
+		To:                end,
+		Participants:      participants,
+		Sessions:          sessions,
+		Days:              days,
+		ScoreDistribution: distribution,
+		Retention:         retention,
+	}, nil
+}
+
+// PartnerTaskStatsCSV renders one row per task link, the views are the ones of the whole task
+// FlushSocialTaskViews moves the task views counted in redis to social_task_view, views that fail to be written
+// are put back for the next run
+func FlushSocialTaskViews(ctx context.Context, db *bun.DB, redisDB redis.Cmdable) (int, error) {
+	views, err := redis_store.PopSocialTaskViews(ctx, redisDB)
+
+	flushed := 0
+	for _, view := range views {
+		if errAdd := datastore.AddSocialTaskViews(ctx, db, view); errAdd != nil {
+			if errRestore := redis_store.RestoreSocialTaskView(ctx, redisDB, view); errRestore != nil {
+				return flushed, errRestore
+			}
+			return flushed, errAdd
+		}
+		flushed += view.Views
+	}
+
+	return flushed, err
+}
+
+func PartnerTaskStatsCSV(report *models.PartnerTaskStatsReport) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write([]string{"task_id", "game_slug", "title", "task_views", "link_id", "url", "attempts", "completions", "gems"}); err != nil {
+		return nil, err
+	}
+
+	for _, task := range report.Tasks {
+		for _, link := range task.Links {
+			err := w.Write([]string{
+				strconv.FormatInt(task.TaskID, 10),
+				task.GameSlug,
+				task.Title,
+				strconv.Itoa(task.Views),
+				strconv.Itoa(link.LinkID),
+				link.Url,
+				strconv.Itoa(link.Attempts),
+				strconv.Itoa(link.Completions),
+				strconv.Itoa(link.Gems),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// PartnerArenaStatsCSV renders one of the arena reports: participants per day, scores or retention
+func PartnerArenaStatsCSV(stats *models.PartnerArenaStats, report string) ([]byte, error) {
+	var rows [][]string
+	switch report {
+	case "", PARTNER_ARENA_REPORT_PARTICIPANTS:
+		rows = append(rows, []string{"date", "participants", "new_participants", "sessions"})
+		for _, day := range stats.Days {
+			rows = append(rows, []string{day.Date, strconv.Itoa(day.Participants), strconv.Itoa(day.NewParticipants), strconv.Itoa(day.Sessions)})
+		}
+	case PARTNER_ARENA_REPORT_SCORES:
+		rows = append(rows, []string{"min_score", "max_score", "sessions"})
+		for _, bucket := range stats.ScoreDistribution {
+			rows = append(rows, []string{strconv.Itoa(bucket.MinScore), strconv.Itoa(bucket.MaxScore), strconv.Itoa(bucket.Sessions)})
+		}
+	case PARTNER_ARENA_REPORT_RETENTION:
+		retention := stats.Retention
+		rows = append(rows,
+			[]string{"participants", "returned", "day1", "day7"},
+			[]string{strconv.Itoa(retention.Participants), strconv.Itoa(retention.Returned), strconv.Itoa(retention.Day1), strconv.Itoa(retention.Day7)},
+		)
+	default:
+		return nil, errorx.Wrap(errors.New("unknown report"), errorx.Invalid)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,520 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/models"
+	"millionaire/internal/pkg/caching"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+var partnerWebhookClient = externalHTTPClient(PARTNER_WEBHOOK_TIMEOUT)
+
+type ServicePartnerWebhook struct {
+	container          *do.Injector
+	postgresDB         *bun.DB
+	readonlyPostgresDB *bun.DB
+	cache              caching.Cache
+	readonlyCache      caching.ReadOnlyCache
+}
+
+func NewServicePartnerWebhook(container *do.Injector) (*ServicePartnerWebhook, error) {
+	postgresDB, err := do.Invoke[*bun.DB](container)
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyPostgresDB, err := do.InvokeNamed[*bun.DB](container, "db-readonly")
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := do.Invoke[caching.Cache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyCache, err := do.Invoke[caching.ReadOnlyCache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServicePartnerWebhook{container, postgresDB, readonlyPostgresDB, cache, readonlyCache}, nil
+}
+
+func newPartnerWebhookSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// SignPartnerWebhook is the X-Webhook-Signature of a delivery: the hex HMAC-SHA256 of "<timestamp>.<body>"
+// keyed by the webhook secret
+func SignPartnerWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// parsePartnerWebhookURL accepts an absolute https url whose host only resolves to public addresses
+func parsePartnerWebhookURL(ctx context.Context, raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return nil, errorx.Wrap(errors.New("url must be an absolute https url"), errorx.Validation)
+	}
+
+	ips := []net.IP{}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+		if err != nil || len(addrs) == 0 {
+			return nil, errorx.Wrap(errors.New("url host can't be resolved"), errorx.Validation)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		if !IsPublicIP(ip) {
+			return nil, errorx.Wrap(errors.New("url must point to a public address"), errorx.Validation)
+		}
+	}
+
+	return u, nil
+}
+
+func (service *ServicePartnerWebhook) CreateWebhook(ctx context.Context, partner *models.Partner, payload *models.PartnerWebhookPayload) (*models.PartnerWebhook, error) {
+	u, err := parsePartnerWebhookURL(ctx, payload.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []string{}
+	seen := map[string]bool{}
+	for _, event := range payload.Events {
+		if !models.IsPartnerEvent(event) {
+			return nil, errorx.Wrap(fmt.Errorf("unknown event %s", event), errorx.Validation)
+		}
+		if seen[event] {
+			continue
+		}
+		seen[event] = true
+		events = append(events, event)
+	}
+
+	if len(events) == 0 {
+		return nil, errorx.Wrap(errors.New("at least one event is required"), errorx.Validation)
+	}
+
+	if seen[models.PartnerEventUserGemsReached] && payload.MinGems <= 0 {
+		return nil, errorx.Wrap(errors.New("min_gems is required by user.gems_reached"), errorx.Validation)
+	}
+
+	if (seen[models.PartnerEventUserJoined] || seen[models.PartnerEventUserGemsReached]) && (partner.RefCode == nil || *partner.RefCode == "") {
+		return nil, errorx.Wrap(errors.New("the partner has no ref code"), errorx.Validation)
+	}
+
+	count, err := datastore.CountPartnerWebhooks(ctx, service.postgresDB, partner.ID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= PARTNER_WEBHOOK_MAX_PER_PARTNER {
+		return nil, errorx.Wrap(fmt.Errorf("a partner can register up to %d webhooks", PARTNER_WEBHOOK_MAX_PER_PARTNER), errorx.Validation)
+	}
+
+	webhook := &models.PartnerWebhook{
+		PartnerID: partner.ID,
+		URL:       u.String(),
+		Secret:    newPartnerWebhookSecret(),
+		Events:    events,
+		MinGems:   payload.MinGems,
+		Enabled:   true,
+	}
+
+	if err := datastore.InsertPartnerWebhook(ctx, service.postgresDB, webhook); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (service *ServicePartnerWebhook) GetWebhooks(ctx context.Context, partner *models.Partner) ([]models.PartnerWebhook, error) {
+	webhooks, err := datastore.GetPartnerWebhooks(ctx, service.readonlyPostgresDB, partner.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	return webhooks, nil
+}
+
+func (service *ServicePartnerWebhook) DeleteWebhook(ctx context.Context, partner *models.Partner, id int64) error {
+	deleted, err := datastore.DeletePartnerWebhook(ctx, service.postgresDB, partner.ID, id)
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return errorx.Wrap(errors.New("webhook not found"), errorx.NotExist)
+	}
+
+	return nil
+}
+
+func (service *ServicePartnerWebhook) GetDeliveries(ctx context.Context, partner *models.Partner, webhookID int64, status string, limit int, offset int) ([]models.PartnerWebhookDelivery, error) {
+	switch status {
+	case "", models.PartnerWebhookDeliveryPending, models.PartnerWebhookDeliveryDelivered, models.PartnerWebhookDeliveryDead:
+	default:
+		return nil, errorx.Wrap(errors.New("unknown delivery status"), errorx.Invalid)
+	}
+
+	if limit <= 0 {
+		limit = PARTNER_WEBHOOK_DELIVERY_DEFAULT_LIMIT
+	}
+	if limit > PARTNER_WEBHOOK_DELIVERY_MAX_LIMIT {
+		limit = PARTNER_WEBHOOK_DELIVERY_MAX_LIMIT
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	return datastore.GetPartnerWebhookDeliveries(ctx, service.readonlyPostgresDB, partner.ID, webhookID, status, limit, offset)
+}
+
+// ReplayDelivery sends a delivered or dead delivery again with its original payload
+func (service *ServicePartnerWebhook) ReplayDelivery(ctx context.Context, partner *models.Partner, id int64) error {
+	ok, err := datastore.ReplayPartnerWebhookDelivery(ctx, service.postgresDB, partner.ID, id, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errorx.Wrap(errors.New("delivery not found or still pending"), errorx.NotExist)
+	}
+
+	return nil
+}
+
+// ReplayDeadDeliveries sends every dead delivery of the webhook again and returns how many were queued
+func (service *ServicePartnerWebhook) ReplayDeadDeliveries(ctx context.Context, partner *models.Partner, webhookID int64) (int, error) {
+	_, err := datastore.GetPartnerWebhook(ctx, service.postgresDB, partner.ID, webhookID)
+	if err == sql.ErrNoRows {
+		return 0, errorx.Wrap(errors.New("webhook not found"), errorx.NotExist)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return datastore.ReplayDeadPartnerWebhookDeliveries(ctx, service.postgresDB, partner.ID, webhookID, time.Now())
+}
+
+func (service *ServicePartnerWebhook) getEnabledPartners(ctx context.Context) ([]models.Partner, error) {
+	callback := func() ([]models.Partner, error) {
+		return datastore.GetEnabledPartner(ctx, service.readonlyPostgresDB)
+	}
+
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyEnabledPartners(), CACHE_TTL_5_MINS, callback)
+}
+
+func (service *ServicePartnerWebhook) getPartner(ctx context.Context, id int64) *models.Partner {
+	partners, err := service.getEnabledPartners(ctx)
+	if err != nil {
+		log.Println("getEnabledPartners error:", err)
+		return nil
+	}
+
+	for i := range partners {
+		if partners[i].ID == id {
+			return &partners[i]
+		}
+	}
+
+	return nil
+}
+
+// EmitUserJoined notifies the partner owning the inviter's ref code that the user joined
+func (service *ServicePartnerWebhook) EmitUserJoined(ctx context.Context, user *models.User, inviterID string) {
+	partners, err := service.getEnabledPartners(ctx)
+	if err != nil {
+		log.Println("getEnabledPartners error:", err)
+		return
+	}
+
+	for i := range partners {
+		partner := &partners[i]
+		if partner.RefCode == nil || *partner.RefCode != inviterID {
+			continue
+		}
+
+		data := map[string]any{"user_id": user.ID, "ref_code": inviterID, "joined_at": time.Now().UTC()}
+		key := fmt.Sprintf("%s:%s", models.PartnerEventUserJoined, user.ID)
+		if err := EmitPartnerEvent(ctx, service.postgresDB, partner, models.PartnerEventUserJoined, key, data); err != nil {
+			log.Println("EmitPartnerEvent error:", err, "partner:", partner.Slug, "user:", user.ID)
+		}
+	}
+}
+
+// EmitSocialTaskCompleted notifies the sponsor of the task that the user completed one of its links
+func (service *ServicePartnerWebhook) EmitSocialTaskCompleted(ctx context.Context, user *models.User, task *models.SocialTask, link *models.Link) {
+	if task == nil || task.PartnerID == nil {
+		return
+	}
+
+	partner := service.getPartner(ctx, *task.PartnerID)
+	if partner == nil {
+		return
+	}
+
+	data := map[string]any{"user_id": user.ID, "task_id": task.ID, "game_slug": task.GameSlug, "link_id": link.ID, "link": link.Url, "gems": link.Gem}
+	key := fmt.Sprintf("%s:%d:%d:%s", models.PartnerEventSocialTaskCompleted, task.ID, link.ID, user.ID)
+	if link.Period != "" {
+		// a recurring task is completed once per period
+		data["period"] = link.Period
+		key += ":" + link.Period
+	}
+	if err := EmitPartnerEvent(ctx, service.postgresDB, partner, models.PartnerEventSocialTaskCompleted, key, data); err != nil {
+		log.Println("EmitPartnerEvent error:", err, "partner:", partner.Slug, "user:", user.ID)
+	}
+}
+
+// EmitArenaFinished notifies the sponsor of the arena that the user finished a session in it
+func (service *ServicePartnerWebhook) EmitArenaFinished(ctx context.Context, user *models.User, arena *models.Arena, session *models.GameSession) {
+	if arena == nil || arena.PartnerID == nil || session == nil {
+		return
+	}
+
+	partner := service.getPartner(ctx, *arena.PartnerID)
+	if partner == nil {
+		return
+	}
+
+	data := map[string]any{"user_id": user.ID, "arena": arena.Slug, "session_id": session.LegacyID, "score": session.TotalScore, "ended_at": session.EndedAt}
+	key := fmt.Sprintf("%s:%s:%s", models.PartnerEventArenaFinished, arena.Slug, session.LegacyID)
+	if err := EmitPartnerEvent(ctx, service.postgresDB, partner, models.PartnerEventArenaFinished, key, data); err != nil {
+		log.Println("EmitPartnerEvent error:", err, "partner:", partner.Slug, "user:", user.ID)
+	}
+}
+
+// EmitPartnerEvent queues the event for every webhook of the partner subscribed to it, key identifies the event
+// so emitting it again is a no-op
+func EmitPartnerEvent(ctx context.Context, db bun.IDB, partner *models.Partner, event string, key string, data any) error {
+	webhooks, err := datastore.GetPartnerWebhooksByEvent(ctx, db, partner.ID, event)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+
+	now := time.Now().UTC()
+	payload, err := json.Marshal(&models.PartnerWebhookEvent{ID: key, Event: event, Partner: partner.Slug, OccurredAt: now, Data: data})
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]*models.PartnerWebhookDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		deliveries = append(deliveries, &models.PartnerWebhookDelivery{
+			WebhookID:     webhook.ID,
+			PartnerID:     partner.ID,
+			Event:         event,
+			EventKey:      key,
+			Payload:       payload,
+			Status:        models.PartnerWebhookDeliveryPending,
+			NextAttemptAt: now,
+		})
+	}
+
+	return datastore.InsertPartnerWebhookDeliveries(ctx, db, deliveries)
+}
+
+// ScanPartnerGemMilestones queues user.gems_reached for the users invited through a partner ref code since the
+// webhook was registered whose total gems reached the webhook threshold. Gems are credited from many places,
+// so the milestones are found by the cron job rather than emitted, and returns how many were queued
+func ScanPartnerGemMilestones(ctx context.Context, db *bun.DB) (int, error) {
+	webhooks, err := datastore.GetEnabledPartnerWebhooksByEvent(ctx, db, models.PartnerEventUserGemsReached)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	partners := map[int64]*models.Partner{}
+	for _, webhook := range webhooks {
+		if webhook.MinGems <= 0 {
+			continue
+		}
+
+		partner, ok := partners[webhook.PartnerID]
+		if !ok {
+			partner, err = datastore.GetPartnerByID(ctx, db, webhook.PartnerID)
+			if err != nil {
+				log.Println("GetPartnerByID error:", err, "partner:", webhook.PartnerID)
+				continue
+			}
+			partners[webhook.PartnerID] = partner
+		}
+
+		if partner.RefCode == nil || *partner.RefCode == "" {
+			continue
+		}
+
+		prefix := fmt.Sprintf("%s:%d:", models.PartnerEventUserGemsReached, webhook.MinGems)
+		rows, err := datastore.GetPartnerInviteesReachingGems(ctx, db, webhook.ID, prefix, *partner.RefCode, webhook.CreatedAt, webhook.MinGems, PARTNER_WEBHOOK_BATCH_SIZE)
+		if err != nil {
+			log.Println("GetPartnerInviteesReachingGems error:", err, "webhook:", webhook.ID)
+			continue
+		}
+
+		for _, row := range rows {
+			data := map[string]any{"user_id": row.UserID, "ref_code": *partner.RefCode, "min_gems": webhook.MinGems, "gems": row.TotalGems}
+			payload, err := json.Marshal(&models.PartnerWebhookEvent{ID: prefix + row.UserID, Event: models.PartnerEventUserGemsReached, Partner: partner.Slug, OccurredAt: time.Now().UTC(), Data: data})
+			if err != nil {
+				return queued, err
+			}
+
+			// queued for this webhook only, another webhook of the partner may have another threshold
+			err = datastore.InsertPartnerWebhookDeliveries(ctx, db, []*models.PartnerWebhookDelivery{{
+				WebhookID:     webhook.ID,
+				PartnerID:     partner.ID,
+				Event:         models.PartnerEventUserGemsReached,
+				EventKey:      prefix + row.UserID,
+				Payload:       payload,
+				Status:        models.PartnerWebhookDeliveryPending,
+				NextAttemptAt: time.Now(),
+			}})
+			if err != nil {
+				return queued, err
+			}
+			queued++
+		}
+	}
+
+	return queued, nil
+}
+
+// partnerWebhookBackoff is the wait before the next attempt once attempts have failed, doubling from the base
+func partnerWebhookBackoff(attempts int) time.Duration {
+	backoff := PARTNER_WEBHOOK_BACKOFF_BASE
+	for i := 1; i < attempts && backoff < PARTNER_WEBHOOK_BACKOFF_MAX; i++ {
+		backoff *= 2
+	}
+
+	if backoff > PARTNER_WEBHOOK_BACKOFF_MAX {
+		backoff = PARTNER_WEBHOOK_BACKOFF_MAX
+	}
+
+	return backoff
+}
+
+// partnerWebhookRetry schedules the next attempt of a failed delivery, it's dead once the attempts are used up
+// or its webhook is gone
+func partnerWebhookRetry(attempts int, webhookGone bool, now time.Time) (time.Time, bool) {
+	return now.Add(partnerWebhookBackoff(attempts)), attempts >= PARTNER_WEBHOOK_MAX_ATTEMPTS || webhookGone
+}
+
+// DeliverPartnerWebhooks posts the due deliveries. A 2xx answer delivers it, anything else is retried with an
+// exponential backoff until PARTNER_WEBHOOK_MAX_ATTEMPTS, then the delivery is dead until the partner replays it
+func DeliverPartnerWebhooks(ctx context.Context, db *bun.DB) (int, int, error) {
+	delivered, failed := 0, 0
+	webhooks := map[int64]*models.PartnerWebhook{}
+
+	for {
+		deliveries, err := datastore.ClaimDuePartnerWebhookDeliveries(ctx, db, time.Now(), PARTNER_WEBHOOK_LEASE, PARTNER_WEBHOOK_BATCH_SIZE)
+		if err != nil {
+			return delivered, failed, err
+		}
+
+		for i := range deliveries {
+			delivery := &deliveries[i]
+
+			webhook, ok := webhooks[delivery.WebhookID]
+			if !ok {
+				webhook, err = datastore.GetPartnerWebhook(ctx, db, delivery.PartnerID, delivery.WebhookID)
+				if err != nil && err != sql.ErrNoRows {
+					log.Println("GetPartnerWebhook error:", err, "webhook:", delivery.WebhookID)
+					continue
+				}
+				webhooks[delivery.WebhookID] = webhook
+			}
+
+			responseCode := 0
+			if webhook == nil || !webhook.Enabled {
+				err = errors.New("webhook is disabled")
+			} else {
+				responseCode, err = postPartnerWebhook(ctx, webhook, delivery)
+			}
+
+			now := time.Now()
+			if err == nil {
+				if err := datastore.MarkPartnerWebhookDelivered(ctx, db, delivery.ID, responseCode, now); err != nil {
+					log.Println("MarkPartnerWebhookDelivered error:", err, "delivery:", delivery.ID)
+				}
+				delivered++
+				continue
+			}
+
+			nextAttemptAt, dead := partnerWebhookRetry(delivery.Attempts+1, webhook == nil, now)
+			if err := datastore.MarkPartnerWebhookFailed(ctx, db, delivery.ID, responseCode, err.Error(), nextAttemptAt, dead); err != nil {
+				log.Println("MarkPartnerWebhookFailed error:", err, "delivery:", delivery.ID)
+			}
+			failed++
+		}
+
+		if len(deliveries) < PARTNER_WEBHOOK_BATCH_SIZE {
+			return delivered, failed, nil
+		}
+	}
+}
+
+func postPartnerWebhook(ctx context.Context, webhook *models.PartnerWebhook, delivery *models.PartnerWebhookDelivery) (int, error) {
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", SignPartnerWebhook(webhook.Secret, timestamp, delivery.Payload))
+
+	res, err := partnerWebhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"millionaire/internal/models"
+)
+
+type partnerWebhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newPartnerWebhookSink starts a local https endpoint answering with the status and keeping the requests it got
+func newPartnerWebhookSink(t *testing.T, status int) (*httptest.Server, chan partnerWebhookRequest) {
+	t.Helper()
+
+	requests := make(chan partnerWebhookRequest, 10)
+	sink := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- partnerWebhookRequest{r.Header.Clone(), body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(sink.Close)
+
+	// the sink listens on loopback, which the partner client refuses
+	client := partnerWebhookClient
+	partnerWebhookClient = sink.Client()
+	t.Cleanup(func() { partnerWebhookClient = client })
+
+	return sink, requests
+}
+
+func TestSignPartnerWebhook(t *testing.T) {
+	body := []byte(`{"event":"user.joined"}`)
+
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := SignPartnerWebhook("whsec_test", 1700000000, body); got != want {
+		t.Fatalf("SignPartnerWebhook = %s, want %s", got, want)
+	}
+	if SignPartnerWebhook("whsec_other", 1700000000, body) == want {
+		t.Fatal("signature does not depend on the secret")
+	}
+	if SignPartnerWebhook("whsec_test", 1700000001, body) == want {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+}
+
+func TestPostPartnerWebhook(t *testing.T) {
+	sink, requests := newPartnerWebhookSink(t, http.StatusNoContent)
+
+	webhook := &models.PartnerWebhook{URL: sink.URL + "/hooks", Secret: "whsec_test"}
+	delivery := &models.PartnerWebhookDelivery{ID: 42, Event: models.PartnerEventUserJoined, Payload: []byte(`{"id":"42"}`)}
+
+	code, err := postPartnerWebhook(context.Background(), webhook, delivery)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("postPartnerWebhook = %d, %v", code, err)
+	}
+
+	req := <-requests
+	if string(req.body) != string(delivery.Payload) {
+		t.Fatalf("body = %s", req.body)
+	}
+	if req.header.Get("X-Webhook-Event") != models.PartnerEventUserJoined || req.header.Get("X-Webhook-Delivery") != "42" {
+		t.Fatalf("headers = %v", req.header)
+	}
+
+	timestamp, err := strconv.ParseInt(req.header.Get("X-Webhook-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp: %v", err)
+	}
+	if req.header.Get("X-Webhook-Signature") != SignPartnerWebhook(webhook.Secret, timestamp, req.body) {
+		t.Fatal("signature does not match the body")
+	}
+}
+
+func TestPostPartnerWebhookRejected(t *testing.T) {
+	sink, requests := newPartnerWebhookSink(t, http.StatusInternalServerError)
+
+	webhook := &models.PartnerWebhook{URL: sink.URL, Secret: "whsec_test"}
+	delivery := &models.PartnerWebhookDelivery{ID: 1, Event: models.PartnerEventArenaFinished, Payload: []byte(`{}`)}
+
+	code, err := postPartnerWebhook(context.Background(), webhook, delivery)
+	if err == nil || code != http.StatusInternalServerError {
+		t.Fatalf("postPartnerWebhook = %d, %v", code, err)
+	}
+	<-requests
+}
+
+func TestPartnerWebhookClientRefusesLoopback(t *testing.T) {
+	sink := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer sink.Close()
+
+	webhook := &models.PartnerWebhook{URL: sink.URL, Secret: "whsec_test"}
+	delivery := &models.PartnerWebhookDelivery{ID: 1, Event: models.PartnerEventUserJoined, Payload: []byte(`{}`)}
+
+	_, err := postPartnerWebhook(context.Background(), webhook, delivery)
+	if err == nil || !strings.Contains(err.Error(), "refused to connect") {
+		t.Fatalf("the partner client connected to a loopback address: %v", err)
+	}
+}
+
+func TestPartnerWebhookBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:   PARTNER_WEBHOOK_BACKOFF_BASE,
+		2:   2 * PARTNER_WEBHOOK_BACKOFF_BASE,
+		3:   4 * PARTNER_WEBHOOK_BACKOFF_BASE,
+		100: PARTNER_WEBHOOK_BACKOFF_MAX,
+	}
+
+	for attempts, want := range cases {
+		if got := partnerWebhookBackoff(attempts); got != want {
+			t.Errorf("partnerWebhookBackoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestPartnerWebhookDeadLetter(t *testing.T) {
+	sink, requests := newPartnerWebhookSink(t, http.StatusBadGateway)
+
+	webhook := &models.PartnerWebhook{URL: sink.URL, Secret: "whsec_test"}
+	delivery := &models.PartnerWebhookDelivery{ID: 7, Event: models.PartnerEventUserGemsReached, Payload: []byte(`{}`)}
+	now := time.Now()
+
+	// every attempt fails on the sink until the last one sends the delivery to the dead letters
+	for attempts := 1; attempts <= PARTNER_WEBHOOK_MAX_ATTEMPTS; attempts++ {
+		if _, err := postPartnerWebhook(context.Background(), webhook, delivery); err == nil {
+			t.Fatalf("attempt %d succeeded", attempts)
+		}
+		<-requests
+
+		nextAttemptAt, dead := partnerWebhookRetry(attempts, false, now)
+		if dead != (attempts == PARTNER_WEBHOOK_MAX_ATTEMPTS) {
+			t.Fatalf("attempt %d: dead = %v", attempts, dead)
+		}
+		if !nextAttemptAt.Equal(now.Add(partnerWebhookBackoff(attempts))) {
+			t.Fatalf("attempt %d: next attempt at %s", attempts, nextAttemptAt)
+		}
+	}
+
+	if _, dead := partnerWebhookRetry(1, true, now); !dead {
+		t.Fatal("a delivery of a deleted webhook is retried")
+	}
+}
+
+func TestParsePartnerWebhookURL(t *testing.T) {
+	refused := []string{
+		"http://93.184.216.34/hooks",
+		"https://127.0.0.1/hooks",
+		"https://10.1.2.3/hooks",
+		"https://192.168.0.10/hooks",
+		"https://169.254.169.254/latest/meta-data",
+		"https://100.64.0.1/hooks",
+		"https://[::1]/hooks",
+		"https://[fe80::1]/hooks",
+		"/hooks",
+	}
+	for _, raw := range refused {
+		if _, err := parsePartnerWebhookURL(context.Background(), raw); err == nil {
+			t.Errorf("parsePartnerWebhookURL(%s) accepted", raw)
+		}
+	}
+
+	if _, err := parsePartnerWebhookURL(context.Background(), "https://93.184.216.34/hooks"); err != nil {
+		t.Errorf("parsePartnerWebhookURL refused a public address: %v", err)
+	}
+}
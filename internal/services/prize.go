@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+	"millionaire/internal/pkg/caching"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/redis/go-redis/v9"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+type ServicePrize struct {
+	container  *do.Injector
+	redisDB    redis.UniversalClient
+	rs         *redsync.Redsync
+	postgresDB *bun.DB
+	cache      caching.Cache
+}
+
+func NewServicePrize(container *do.Injector) (*ServicePrize, error) {
+	redisDB, err := do.InvokeNamed[redis.UniversalClient](container, "redis-db")
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := do.Invoke[*redsync.Redsync](container)
+	if err != nil {
+		return nil, err
+	}
+
+	postgresDB, err := do.Invoke[*bun.DB](container)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := do.Invoke[caching.Cache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServicePrize{container, redisDB, rs, postgresDB, cache}, nil
+}
+
+func (service *ServicePrize) SetArenaPrizes(ctx context.Context, slug string, schedule models.PrizeSchedule) (*models.Arena, error) {
+	if err := schedule.Validate(); err != nil {
+		return nil, errorx.Wrap(err, errorx.Validation)
+	}
+
+	arena, err := service.getArena(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	if arena.IsEnded() {
+		return nil, errorx.Wrap(errors.New("arena is ended"), errorx.Invalid)
+	}
+
+	arena.Prizes = schedule
+	if err := datastore.SetArenaPrizes(ctx, service.postgresDB, arena.ID, schedule); err != nil {
+		return nil, err
+	}
+
+	_ = service.cache.Delete(ctx, DBKeyArena(arena.Slug))
+	_ = service.cache.Delete(ctx, DBKeyArenaByGameSlug(arena.GameSlug))
+	_ = service.cache.Delete(ctx, DBKeyArenaList())
+
+	return arena, nil
+}
+
+// PreviewArenaPrizes is the dry-run of the distribution, nothing is written
+func (service *ServicePrize) PreviewArenaPrizes(ctx context.Context, slug string) (*models.PrizeDistribution, error) {
+	arena, err := service.getArena(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	distribution, err := datastore.GetPrizeDistribution(ctx, service.postgresDB, ArenaPrizeCampaign(arena.Slug))
+	if err == nil {
+		return distribution, nil
+	}
+
+	winners, err := ComputeArenaWinners(ctx, service.postgresDB, service.redisDB, arena)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPrizeDistribution(ArenaPrizeCampaign(arena.Slug), models.PrizeSourceArena, arena.Slug, models.PrizeDistributionStatusPreview, winners), nil
+}
+
+func (service *ServicePrize) DistributeArenaPrizes(ctx context.Context, slug string) (*models.PrizeDistribution, error) {
+	arena, err := service.getArena(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	if !arena.IsEnded() {
+		return nil, errorx.Wrap(errors.New("arena is not ended yet"), errorx.Invalid)
+	}
+
+	mutex := service.rs.NewMutex(LockKeyPrizeDistribution(ArenaPrizeCampaign(arena.Slug)))
+	if err := mutex.TryLock(); err != nil {
+		return nil, errorx.Wrap(ErrPrizeDistributionLock, errorx.Invalid)
+	}
+	// nolint:errcheck
+	defer mutex.Unlock()
+
+	distribution, _, err := DistributeArenaPrizes(ctx, service.postgresDB, service.redisDB, arena)
+	if err != nil {
+		return nil, err
+	}
+
+	service.clearWinnerRewardCache(ctx, distribution)
+	return distribution, nil
+}
+
+func (service *ServicePrize) PreviewSeasonPrizes(ctx context.Context, seasonID int64) (*models.PrizeDistribution, error) {
+	season, err := service.getSeason(ctx, seasonID)
+	if err != nil {
+		return nil, err
+	}
+
+	distribution, err := datastore.GetPrizeDistribution(ctx, service.postgresDB, SeasonPrizeCampaign(season))
+	if err == nil {
+		return distribution, nil
+	}
+
+	winners, err := ComputeSeasonWinners(ctx, service.postgresDB, service.redisDB, season)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPrizeDistribution(SeasonPrizeCampaign(season), models.PrizeSourceSeason, strconv.FormatInt(season.ID, 10), models.PrizeDistributionStatusPreview, winners), nil
+}
+
+func (service *ServicePrize) DistributeSeasonPrizes(ctx context.Context, seasonID int64) (*models.PrizeDistribution, error) {
+	season, err := service.getSeason(ctx, seasonID)
+	if err != nil {
+		return nil, err
+	}
+
+	mutex := service.rs.NewMutex(LockKeyPrizeDistribution(SeasonPrizeCampaign(season)))
+	if err := mutex.TryLock(); err != nil {
+		return nil, errorx.Wrap(ErrPrizeDistributionLock, errorx.Invalid)
+	}
+	// nolint:errcheck
+	defer mutex.Unlock()
+
+	distribution, _, err := DistributeSeasonPrizes(ctx, service.postgresDB, service.redisDB, season)
+	if err != nil {
+		return nil, err
+	}
+
+	service.clearWinnerRewardCache(ctx, distribution)
+	return distribution, nil
+}
+
+func (service *ServicePrize) getArena(ctx context.Context, slug string) (*models.Arena, error) {
+	arena, err := datastore.GetArenaBySlug(ctx, service.postgresDB, slug)
+	if err == sql.ErrNoRows {
+		return nil, errorx.Wrap(errors.New("arena not found"), errorx.NotExist)
+	}
+
+	return arena, err
+}
+
+func (service *ServicePrize) getSeason(ctx context.Context, seasonID int64) (*models.Season, error) {
+	season, err := datastore.GetSeason(ctx, service.postgresDB, seasonID)
+	if err == sql.ErrNoRows {
+		return nil, errorx.Wrap(errors.New("season not found"), errorx.NotExist)
+	}
+
+	return season, err
+}
+
+func (service *ServicePrize) clearWinnerRewardCache(ctx context.Context, distribution *models.PrizeDistribution) {
+	for _, winner := range distribution.Winners {
+		if err := service.cache.Delete(ctx, DBKeyUserAvailableReward(winner.UserID)); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func ArenaPrizeCampaign(slug string) string {
+	return fmt.Sprintf("prize:arena:%s", slug)
+}
+
+func SeasonPrizeCampaign(season *models.Season) string {
+	return fmt.Sprintf("prize:season:%s:%s", season.Leaderboard, season.Key)
+}
+
+// ComputeArenaWinners ranks the final arena board, moderated and excluded users do not take a prize rank
+func ComputeArenaWinners(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, arena *models.Arena) ([]models.PrizeWinner, error) {
+	page := func(offset int, limit int) ([]*models.LeaderboardItem, error) {
+		return redis_store.GetLeaderboardPage(ctx, redisDB, DBKeyArena(arena.Slug), offset, limit)
+	}
+
+	return computeWinners(ctx, db, redisDB, arena.Prizes, page)
+}
+
+func ComputeSeasonWinners(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, season *models.Season) ([]models.PrizeWinner, error) {
+	page := func(offset int, limit int) ([]*models.LeaderboardItem, error) {
+		standings, err := datastore.GetSeasonStandings(ctx, db, season.ID, offset, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		items := make([]*models.LeaderboardItem, 0, len(standings))
+		for _, standing := range standings {
+			items = append(items, &models.LeaderboardItem{UserId: standing.UserID, Score: standing.Score, Rank: standing.Rank})
+		}
+		return items, nil
+	}
+
+	return computeWinners(ctx, db, redisDB, season.Prizes, page)
+}
+
+func computeWinners(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, schedule models.PrizeSchedule, page func(offset int, limit int) ([]*models.LeaderboardItem, error)) ([]models.PrizeWinner, error) {
+	winners := []models.PrizeWinner{}
+	maxRank := schedule.MaxRank()
+	if maxRank == 0 {
+		return winners, nil
+	}
+
+	shadowBanned, err := redis_store.GetShadowBannedUsers(ctx, redisDB)
+	if err != nil {
+		return nil, err
+	}
+	hidden := map[string]bool{}
+	for _, userID := range shadowBanned {
+		hidden[userID] = true
+	}
+
+	rank := 0
+	for offset := 0; rank < maxRank; offset += maxRank {
+		items, err := page(offset, maxRank)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if !isPrizeEligible(ctx, db, redisDB, item.UserId, hidden) {
+				continue
+			}
+
+			rank++
+			if tier := schedule.TierForRank(rank); tier != nil {
+				winners = append(winners, models.PrizeWinner{
+					UserID:   item.UserId,
+					Rank:     rank,
+					Score:    item.Score,
+					Gem:      tier.Gem,
+					Star:     tier.Star,
+					Lifeline: tier.Lifeline,
+					Payout:   tier.Payout,
+				})
+			}
+
+			if rank == maxRank {
+				break
+			}
+		}
+
+		if len(items) < maxRank {
+			break
+		}
+	}
+
+	return winners, nil
+}
+
+func isPrizeEligible(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, userID string, hidden map[string]bool) bool {
+	if IsExcludedFromLeaderboards(ctx, redisDB, userID) {
+		return false
+	}
+
+	user, err := datastore.FindUserByID(ctx, db, userID)
+	if err != nil {
+		return false
+	}
+
+	status := user.EffectiveModerationStatus()
+	if status == models.ModerationStatusBanned {
+		return false
+	}
+
+	return !(hidden[userID] && status == models.ModerationStatusShadowBanned)
+}
+
+// DistributeArenaPrizes is shared with the cronjob, the boolean tells if the rewards were created by this call
+func DistributeArenaPrizes(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, arena *models.Arena) (*models.PrizeDistribution, bool, error) {
+	winners, err := ComputeArenaWinners(ctx, db, redisDB, arena)
+	if err != nil {
+		return nil, false, err
+	}
+
+	distribution := newPrizeDistribution(ArenaPrizeCampaign(arena.Slug), models.PrizeSourceArena, arena.Slug, models.PrizeDistributionStatusDistributed, winners)
+	created, err := datastore.DistributePrizes(ctx, db, distribution)
+	return distribution, created, err
+}
+
+func DistributeSeasonPrizes(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, season *models.Season) (*models.PrizeDistribution, bool, error) {
+	winners, err := ComputeSeasonWinners(ctx, db, redisDB, season)
+	if err != nil {
+		return nil, false, err
+	}
+
+	distribution := newPrizeDistribution(SeasonPrizeCampaign(season), models.PrizeSourceSeason, strconv.FormatInt(season.ID, 10), models.PrizeDistributionStatusDistributed, winners)
+	created, err := datastore.DistributePrizes(ctx, db, distribution)
+	return distribution, created, err
+}
+
+func newPrizeDistribution(campaign string, source string, sourceRef string, status string, winners []models.PrizeWinner) *models.PrizeDistribution {
+	return &models.PrizeDistribution{
+		Campaign:  campaign,
+		Source:    source,
+		SourceRef: sourceRef,
+		Status:    status,
+		Winners:   winners,
+		CreatedAt: time.Now(),
+	}
+}
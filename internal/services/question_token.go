@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"millionaire/internal/models"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/samber/do"
+)
+
+var ErrInvalidQuestionToken = errors.New("invalid question token")
+
+// questionTokenClaims binds an answer to the question that was served, the nonce is kept in the session history
+// so a token can only be used once and only for the question it was issued for
+type questionTokenClaims struct {
+	UserID    string `json:"uid"`
+	SessionID string `json:"sid"`
+	Step      int    `json:"step"`
+	IssuedAt  int64  `json:"iat"`
+	Nonce     string `json:"nonce"`
+}
+
+func newQuestionTokenNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (service *ServiceGame) questionTokenSecret() []byte {
+	vs := do.MustInvokeNamed[map[string]string](service.container, "envs")
+	if secret := vs["QUESTION_TOKEN_SECRET"]; secret != "" {
+		return []byte(secret)
+	}
+	return []byte(vs["JWT_SECRET"])
+}
+
+func (service *ServiceGame) signQuestionToken(claims *questionTokenClaims) string {
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, service.questionTokenSecret())
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (service *ServiceGame) parseQuestionToken(token string) (*questionTokenClaims, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+
+	mac := hmac.New(sha256.New, service.questionTokenSecret())
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return nil, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, false
+	}
+
+	var claims questionTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, false
+	}
+
+	return &claims, true
+}
+
+// attachQuestionToken sets the token of the question currently served, the same token is returned until it is answered
+func (service *ServiceGame) attachQuestionToken(user *models.User, session *models.GameSession) *models.GameSession {
+	if session == nil {
+		return session
+	}
+
+	session.QuestionToken = ""
+	if session.QuestionStartedAt == nil || session.EndedAt != nil {
+		return session
+	}
+
+	step := session.NextStep - 1
+	history, ok := session.History[step]
+	if !ok || history.TokenNonce == "" {
+		return session
+	}
+
+	session.QuestionToken = service.signQuestionToken(&questionTokenClaims{
+		UserID:    user.ID,
+		SessionID: session.LegacyID,
+		Step:      step,
+		IssuedAt:  history.StartedAt.Unix(),
+		Nonce:     history.TokenNonce,
+	})
+
+	return session
+}
+
+// verifyQuestionToken checks the token sent with an answer, every rejection is recorded as a suspicious event.
+// With QUESTION_TOKEN_ENFORCED set to 0 the rejections are only recorded, to roll out clients progressively.
+func (service *ServiceGame) verifyQuestionToken(ctx context.Context, user *models.User, session *models.GameSession, gameAnswer models.GameAnswer) error {
+	kind, metadata := service.checkQuestionToken(ctx, user, session, gameAnswer)
+	if kind == "" {
+		return nil
+	}
+
+	serviceAntiCheat, err := do.Invoke[*ServiceAntiCheat](service.container)
+	if err == nil {
+		metadata["question_index"] = gameAnswer.QuestionIndex
+		serviceAntiCheat.RecordSuspiciousEvent(ctx, &models.SuspiciousEvent{
+			UserID:    user.ID,
+			GameSlug:  session.GameSlug,
+			SessionID: session.LegacyID,
+			Kind:      kind,
+			Metadata:  metadata,
+		})
+	}
+
+	enforced, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_QUESTION_TOKEN_ENFORCED, 1)
+	if enforced == 0 {
+		return nil
+	}
+
+	return errorx.Wrap(ErrInvalidQuestionToken, errorx.Validation)
+}
+
+func (service *ServiceGame) checkQuestionToken(ctx context.Context, user *models.User, session *models.GameSession, gameAnswer models.GameAnswer) (string, map[string]interface{}) {
+	metadata := map[string]interface{}{}
+
+	if gameAnswer.Token == "" {
+		return models.SuspiciousEventQuestionTokenMissing, metadata
+	}
+
+	claims, ok := service.parseQuestionToken(gameAnswer.Token)
+	if !ok {
+		return models.SuspiciousEventQuestionTokenInvalid, metadata
+	}
+
+	metadata["token_step"] = claims.Step
+	metadata["token_session_id"] = claims.SessionID
+	metadata["token_issued_at"] = claims.IssuedAt
+
+	if claims.UserID != user.ID || claims.SessionID != session.LegacyID || claims.Step != gameAnswer.QuestionIndex {
+		return models.SuspiciousEventQuestionTokenMismatch, metadata
+	}
+
+	history, ok := session.History[claims.Step]
+	if !ok {
+		return models.SuspiciousEventQuestionTokenMismatch, metadata
+	}
+
+	if history.AnsweredAt != nil || session.QuestionStartedAt == nil || claims.Step != session.NextStep-1 {
+		return models.SuspiciousEventQuestionTokenReused, metadata
+	}
+
+	if history.TokenNonce == "" || history.TokenNonce != claims.Nonce || history.StartedAt.Unix() != claims.IssuedAt {
+		return models.SuspiciousEventQuestionTokenMismatch, metadata
+	}
+
+	ttl, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_QUESTION_TOKEN_TTL_IN_SECONDS, DEFAULT_QUESTION_TOKEN_TTL_IN_SECONDS)
+	if time.Since(time.Unix(claims.IssuedAt, 0)) > time.Duration(ttl)*time.Second {
+		return models.SuspiciousEventQuestionTokenExpired, metadata
+	}
+
+	return "", metadata
+}
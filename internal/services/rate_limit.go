@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/interfaces"
+	"millionaire/internal/models"
+
+	"github.com/go-redis/redis_rate/v10"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+// defaultRateLimitPolicies are used until they are overridden by CONFIG_RATE_LIMIT_POLICIES,
+// a policy with rate 0 in the config disables the route throttling
+var defaultRateLimitPolicies = map[string]models.RateLimitPolicy{
+	"POST /api/v1/game/:game/current_session/answer": {Rate: 60, Period: "1m", Key: models.RateLimitKeyUser},
+	"GET /api/v1/game/:game/next":                    {Rate: 60, Period: "1m", Key: models.RateLimitKeyUser},
+	"POST /api/v1/moon/spin":                         {Rate: 10, Period: "1m", Key: models.RateLimitKeyUser},
+	"POST /api/v1/user/freebies/claim/:action":       {Rate: 10, Period: "1m", Key: models.RateLimitKeyUser},
+	"GET /api/v1/user/me":                            {Rate: 30, Period: "1m", Key: models.RateLimitKeyIP},
+	"GET /api/v1/3rd/verify-user":                    {Rate: PARTNER_RATE_LIMIT_PER_MINUTE, Period: "1m", Key: models.RateLimitKeyPartner},
+	"GET /l/:param":                                  {Rate: 30, Period: "1m", Key: models.RateLimitKeyIP},
+}
+
+type ServiceRateLimit struct {
+	container          *do.Injector
+	readonlyPostgresDB *bun.DB
+	limiter            interfaces.Limiter
+
+	mu       sync.RWMutex
+	raw      string
+	policies map[string]models.RateLimitPolicy
+	loadedAt time.Time
+}
+
+func NewServiceRateLimit(container *do.Injector) (*ServiceRateLimit, error) {
+	readonlyPostgresDB, err := do.InvokeNamed[*bun.DB](container, "db-readonly")
+	if err != nil {
+		return nil, err
+	}
+
+	limiter, err := do.Invoke[interfaces.Limiter](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceRateLimit{
+		container:          container,
+		readonlyPostgresDB: readonlyPostgresDB,
+		limiter:            limiter,
+		policies:           defaultRateLimitPolicies,
+	}, nil
+}
+
+// GetPolicy returns the policy of a route. Policies are kept in memory and reloaded from the config table
+// every RATE_LIMIT_POLICY_RELOAD_INTERVAL so a change is applied without restarting the api.
+func (service *ServiceRateLimit) GetPolicy(ctx context.Context, method string, path string) (*models.RateLimitPolicy, bool) {
+	service.reload(ctx)
+
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+
+	policy, ok := service.policies[method+" "+path]
+	if !ok || policy.Rate <= 0 {
+		return nil, false
+	}
+
+	return &policy, true
+}
+
+func (service *ServiceRateLimit) Allow(ctx context.Context, route string, subject string, policy *models.RateLimitPolicy) (*redis_rate.Result, error) {
+	return service.limiter.AllowWithResult(ctx, LimitKeyRoute(route, subject), policy.Limit())
+}
+
+func (service *ServiceRateLimit) reload(ctx context.Context) {
+	service.mu.RLock()
+	fresh := time.Since(service.loadedAt) < RATE_LIMIT_POLICY_RELOAD_INTERVAL
+	service.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if time.Since(service.loadedAt) < RATE_LIMIT_POLICY_RELOAD_INTERVAL {
+		return
+	}
+	// keep serving the previous policies if the config table is unreachable
+	service.loadedAt = time.Now()
+
+	config, err := datastore.GetConfigByKey(ctx, service.readonlyPostgresDB, CONFIG_RATE_LIMIT_POLICIES)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("rate limit policies reload error:", err)
+			return
+		}
+		config = &models.Config{}
+	}
+
+	if config.Value == service.raw {
+		return
+	}
+
+	overrides := map[string]models.RateLimitPolicy{}
+	if config.Value != "" {
+		if err := json.Unmarshal([]byte(config.Value), &overrides); err != nil {
+			log.Println("rate limit policies parse error:", err)
+			return
+		}
+	}
+
+	policies := make(map[string]models.RateLimitPolicy, len(defaultRateLimitPolicies)+len(overrides))
+	for route, policy := range defaultRateLimitPolicies {
+		policies[route] = policy
+	}
+	for route, policy := range overrides {
+		policies[route] = policy
+	}
+
+	service.raw = config.Value
+	service.policies = policies
+}
@@ -0,0 +1,435 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+	"millionaire/internal/pkg/caching"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/redis/go-redis/v9"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+type ServiceReferral struct {
+	container     *do.Injector
+	redisDB       redis.UniversalClient
+	rs            *redsync.Redsync
+	postgresDB    *bun.DB
+	cache         caching.Cache
+	readonlyCache caching.ReadOnlyCache
+
+	serviceUser   *ServiceUser
+	serviceConfig *ServiceConfig
+}
+
+func NewServiceReferral(container *do.Injector) (*ServiceReferral, error) {
+	redisDB, err := do.InvokeNamed[redis.UniversalClient](container, "redis-db")
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := do.Invoke[*redsync.Redsync](container)
+	if err != nil {
+		return nil, err
+	}
+
+	postgresDB, err := do.Invoke[*bun.DB](container)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := do.Invoke[caching.Cache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyCache, err := do.Invoke[caching.ReadOnlyCache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceUser, err := do.Invoke[*ServiceUser](container)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceConfig, err := do.Invoke[*ServiceConfig](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceReferral{container, redisDB, rs, postgresDB, cache, readonlyCache, serviceUser, serviceConfig}, nil
+}
+
+func (service *ServiceReferral) getCommissionTiers(ctx context.Context) models.ReferralCommissionTiers {
+	raw, _ := service.serviceConfig.GetStringConfig(ctx, CONFIG_REFERRAL_COMMISSION_TIERS, DEFAULT_REFERRAL_COMMISSION_TIERS)
+
+	var tiers models.ReferralCommissionTiers
+	if err := json.Unmarshal([]byte(raw), &tiers); err != nil || tiers.Validate() != nil {
+		log.Println("invalid referral commission tiers:", raw)
+		//nolint:errcheck
+		json.Unmarshal([]byte(DEFAULT_REFERRAL_COMMISSION_TIERS), &tiers)
+	}
+
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Level < tiers[j].Level })
+
+	return tiers
+}
+
+// CreditCommissions pays every configured level of inviters above the invitee a share of the quiz gems
+// earned by sourceAction, each payment is recorded once per inviter and source
+func (service *ServiceReferral) CreditCommissions(ctx context.Context, invitee *models.User, gems int, sourceAction string) {
+	if gems <= 0 || invitee.InviterID == nil {
+		return
+	}
+
+	// gems earned while flagged by anti-cheat do not pay the referral chain
+	serviceAntiCheat, err := do.Invoke[*ServiceAntiCheat](service.container)
+	if err == nil && serviceAntiCheat.IsRewardHeld(ctx, invitee.ID) {
+		return
+	}
+
+	// commissions from a referral that is not validated yet are held, a rejected referral pays nothing
+	status := models.ReferralCommissionStatusPaid
+	validation, err := datastore.GetReferralValidation(ctx, service.postgresDB, invitee.ID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("GetReferralValidation error:", err, "invitee:", invitee.ID)
+		return
+	}
+	if validation != nil {
+		switch validation.Status {
+		case models.ReferralStatusRejected:
+			return
+		case models.ReferralStatusPending:
+			status = models.ReferralCommissionStatusPending
+		}
+	}
+
+	tiers := service.getCommissionTiers(ctx)
+
+	current := invitee
+	level := 0
+	for _, tier := range tiers {
+		// climb the chain up to the tier level
+		for level < tier.Level {
+			if current.InviterID == nil || *current.InviterID == "" {
+				return
+			}
+
+			inviter, err := service.serviceUser.FindUserByID(ctx, *current.InviterID)
+			if err != nil {
+				log.Println("referral commission inviter lookup error:", err)
+				return
+			}
+
+			current = inviter
+			level++
+		}
+
+		if current.ID == invitee.ID || current.EffectiveModerationStatus() == models.ModerationStatusBanned {
+			continue
+		}
+
+		amount := int(math.Floor(float64(gems) * tier.Percent / 100))
+		if amount <= 0 {
+			continue
+		}
+
+		if err := service.creditCommission(ctx, current, invitee, tier.Level, gems, amount, sourceAction, status); err != nil {
+			log.Println("referral commission error:", err, "inviter:", current.ID, "invitee:", invitee.ID)
+		}
+	}
+}
+
+func (service *ServiceReferral) creditCommission(ctx context.Context, inviter *models.User, invitee *models.User, level int, sourceGems int, amount int, sourceAction string, status string) error {
+	mutex := service.rs.NewMutex(LockKeyReferralCommission(inviter.ID))
+	if err := mutex.LockContext(ctx); err != nil {
+		return err
+	}
+	// nolint:errcheck
+	defer mutex.Unlock()
+
+	capPerInvitee, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_REFERRAL_CAP_PER_INVITEE, DEFAULT_REFERRAL_CAP_PER_INVITEE)
+	if capPerInvitee > 0 {
+		paid, err := datastore.SumReferralCommissionByInvitee(ctx, service.postgresDB, inviter.ID, invitee.ID)
+		if err != nil {
+			return err
+		}
+		amount = min(amount, capPerInvitee-paid)
+	}
+
+	capPerPeriod, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_REFERRAL_CAP_PER_PERIOD, DEFAULT_REFERRAL_CAP_PER_PERIOD)
+	if capPerPeriod > 0 {
+		periodInHours, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_REFERRAL_PERIOD_IN_HOURS, DEFAULT_REFERRAL_PERIOD_IN_HOURS)
+		paid, err := datastore.SumReferralCommissionFromTime(ctx, service.postgresDB, inviter.ID, time.Now().Add(-time.Duration(periodInHours)*time.Hour))
+		if err != nil {
+			return err
+		}
+		amount = min(amount, capPerPeriod-paid)
+	}
+
+	if amount <= 0 {
+		return nil
+	}
+
+	commission := &models.ReferralCommission{
+		InviterID:    inviter.ID,
+		InviteeID:    invitee.ID,
+		Level:        level,
+		SourceAction: sourceAction,
+		SourceGems:   sourceGems,
+		Gems:         amount,
+		Status:       status,
+	}
+
+	inserted, err := datastore.InsertReferralCommission(ctx, service.postgresDB, commission)
+	if err != nil || !inserted || status != models.ReferralCommissionStatusPaid {
+		return err
+	}
+
+	serviceLeaderboard, err := do.Invoke[*ServiceLeaderboard](service.container)
+	if err != nil {
+		return err
+	}
+
+	if _, err := serviceLeaderboard.UpdateOverallLeaderboard(ctx, inviter); err != nil {
+		log.Println("UpdateOverallLeaderboard error:", err)
+	}
+
+	if err := service.serviceUser.ClearUserGemCache(ctx, inviter.ID); err != nil {
+		log.Println(err)
+	}
+
+	_ = service.cache.Delete(ctx, DBKeyReferralEarnings(inviter.ID))
+
+	return nil
+}
+
+// GetEarnings breaks down the commissions earned by the user per referral level
+func (service *ServiceReferral) GetEarnings(ctx context.Context, user *models.User) (*models.ReferralEarnings, error) {
+	callback := func() (*models.ReferralEarnings, error) {
+		levels, err := datastore.GetReferralEarningsByLevel(ctx, service.postgresDB, user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		earnings := &models.ReferralEarnings{Levels: levels}
+		for _, level := range levels {
+			earnings.Total += level.Gems
+		}
+
+		return earnings, nil
+	}
+
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyReferralEarnings(user.ID), CACHE_TTL_5_MINS, callback)
+}
+
+// GetStatusBreakdown counts the invited friends per validation status and lists them, optionally filtered by status
+func (service *ServiceReferral) GetStatusBreakdown(ctx context.Context, user *models.User, status string, limit int, offset int) (*models.ReferralStatusBreakdown, error) {
+	switch status {
+	case "", models.ReferralStatusPending, models.ReferralStatusValidated, models.ReferralStatusRejected:
+	default:
+		return nil, errorx.Wrap(ErrUnknownReferralStatus, errorx.Invalid)
+	}
+
+	if limit <= 0 {
+		limit = REFERRAL_STATUS_DEFAULT_LIMIT
+	}
+	if limit > REFERRAL_STATUS_MAX_LIMIT {
+		limit = REFERRAL_STATUS_MAX_LIMIT
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	callback := func() (*models.ReferralStatusBreakdown, error) {
+		counts, err := datastore.CountReferralsByStatus(ctx, service.postgresDB, user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		friends, err := datastore.GetReferralFriends(ctx, service.postgresDB, user.ID, status, limit, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		return &models.ReferralStatusBreakdown{
+			Pending:   counts[models.ReferralStatusPending],
+			Validated: counts[models.ReferralStatusValidated],
+			Rejected:  counts[models.ReferralStatusRejected],
+			Friends:   friends,
+		}, nil
+	}
+
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyReferralStatus(user.ID, status, limit, offset), CACHE_TTL_1_MIN, callback)
+}
+
+type referralValidationConfig struct {
+	minSessions   int
+	minActiveDays int
+	minGems       int
+	deadline      time.Duration
+}
+
+func loadReferralValidationConfig(ctx context.Context, db *bun.DB) referralValidationConfig {
+	value := func(key string, defaultValue int) int {
+		config, err := datastore.GetConfigByKey(ctx, db, key)
+		if err != nil || config.Value == "" {
+			return defaultValue
+		}
+
+		v, err := strconv.Atoi(config.Value)
+		if err != nil {
+			return defaultValue
+		}
+		return v
+	}
+
+	return referralValidationConfig{
+		minSessions:   value(CONFIG_REFERRAL_MIN_SESSIONS, DEFAULT_REFERRAL_MIN_SESSIONS),
+		minActiveDays: value(CONFIG_REFERRAL_MIN_ACTIVE_DAYS, DEFAULT_REFERRAL_MIN_ACTIVE_DAYS),
+		minGems:       value(CONFIG_MIN_GEM_TO_CLAIM_REF_BOOST, MIN_GEM_TO_CLAIM_REF_BOOST),
+		deadline:      time.Duration(value(CONFIG_REFERRAL_VALIDATION_DAYS, DEFAULT_REFERRAL_VALIDATION_DAYS)) * CACHE_TTL_1_DAY,
+	}
+}
+
+// ValidateReferrals releases the referrals whose invitee played enough and voids the ones that were flagged,
+// banned or stayed inactive past the deadline. It is run by the cron, which does not use the container
+func ValidateReferrals(ctx context.Context, db *bun.DB, redisDB redis.Cmdable) (int, int, error) {
+	config := loadReferralValidationConfig(ctx, db)
+
+	validated, rejected := 0, 0
+	var after *models.ReferralValidation
+	for {
+		validations, err := datastore.GetPendingReferralValidations(ctx, db, after, REFERRAL_VALIDATION_BATCH_SIZE)
+		if err != nil {
+			return validated, rejected, err
+		}
+
+		for i := range validations {
+			validation := &validations[i]
+			decision, reason, err := decideReferral(ctx, db, redisDB, config, validation)
+			if err != nil {
+				log.Println("decideReferral error:", err, "invitee:", validation.InviteeID)
+				continue
+			}
+
+			switch decision {
+			case models.ReferralStatusValidated:
+				if err := releaseReferral(ctx, db, redisDB, validation); err != nil {
+					log.Println("releaseReferral error:", err, "invitee:", validation.InviteeID)
+					continue
+				}
+				validated++
+			case models.ReferralStatusRejected:
+				ok, err := datastore.RejectReferral(ctx, db, validation.InviteeID, reason)
+				if err != nil {
+					log.Println("RejectReferral error:", err, "invitee:", validation.InviteeID)
+					continue
+				}
+				if ok {
+					rejected++
+				}
+			}
+		}
+
+		if len(validations) < REFERRAL_VALIDATION_BATCH_SIZE {
+			return validated, rejected, nil
+		}
+		after = &validations[len(validations)-1]
+	}
+}
+
+// decideReferral returns the new status of the referral and the reason of a rejection, pending keeps it waiting
+func decideReferral(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, config referralValidationConfig, validation *models.ReferralValidation) (string, string, error) {
+	expired := time.Since(validation.CreatedAt) > config.deadline
+
+	invitee, err := datastore.FindUserByID(ctx, db, validation.InviteeID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if invitee.EffectiveModerationStatus() == models.ModerationStatusBanned {
+		return models.ReferralStatusRejected, models.ReferralRejectedBanned, nil
+	}
+
+	flag, err := datastore.GetLatestAntiCheatFlag(ctx, db, invitee.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return "", "", err
+	}
+	if flag != nil && flag.Status == models.AntiCheatFlagStatusConfirmed {
+		return models.ReferralStatusRejected, models.ReferralRejectedFlagged, nil
+	}
+
+	held, err := redis_store.IsAntiCheatRestricted(ctx, redisDB, models.AntiCheatActionHoldRewards, invitee.ID)
+	if err != nil {
+		return "", "", err
+	}
+	// an open flag waits for the review, unless the referral runs out of time
+	if held || (flag != nil && flag.Status == models.AntiCheatFlagStatusOpen) {
+		if expired {
+			return models.ReferralStatusRejected, models.ReferralRejectedFlagged, nil
+		}
+		return models.ReferralStatusPending, "", nil
+	}
+
+	activity, err := datastore.GetReferralActivity(ctx, db, invitee.ID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if activity.Sessions >= config.minSessions && activity.ActiveDays >= config.minActiveDays && activity.Gems >= config.minGems {
+		return models.ReferralStatusValidated, "", nil
+	}
+
+	if expired {
+		return models.ReferralStatusRejected, models.ReferralRejectedInactive, nil
+	}
+
+	return models.ReferralStatusPending, "", nil
+}
+
+// releaseReferral credits the invite, the held commissions and the KOL campaign bonus, then refreshes the boards of every inviter paid
+func releaseReferral(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, validation *models.ReferralValidation) error {
+	commissions, err := datastore.ValidateReferral(ctx, db, validation.InviteeID)
+	if err != nil || commissions == nil {
+		return err
+	}
+
+	inviters := map[string]bool{validation.InviterID: true}
+	for _, commission := range commissions {
+		inviters[commission.InviterID] = true
+	}
+
+	kolID, err := PayKolCampaignBonus(ctx, db, validation.InviteeID)
+	if err != nil {
+		log.Println("PayKolCampaignBonus error:", err, "invitee:", validation.InviteeID)
+	}
+	if kolID != "" {
+		inviters[kolID] = true
+	}
+
+	for inviterID := range inviters {
+		if IsExcludedFromLeaderboards(ctx, redisDB, inviterID) {
+			continue
+		}
+
+		if err := RestoreUserLeaderboards(ctx, db, redisDB, inviterID); err != nil {
+			log.Println("RestoreUserLeaderboards error:", err, "user:", inviterID)
+		}
+	}
+
+	return nil
+}
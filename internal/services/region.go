@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"millionaire/internal/datastore"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+	"strings"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/redis/go-redis/v9"
+	"github.com/uptrace/bun"
+)
+
+// RegionalLeaderboardName is the sorted set holding the region's slice of a board
+func RegionalLeaderboardName(leaderboard string, region string) string {
+	return fmt.Sprintf("%s:region:%s", strings.ToLower(leaderboard), region)
+}
+
+// SetRegionalLeaderboard mirrors a global board entry into the user's regional board
+func SetRegionalLeaderboard(ctx context.Context, redisDB redis.Cmdable, region string, leaderboard string, item *models.LeaderboardItem) error {
+	if region == "" {
+		return nil
+	}
+
+	if _, err := redis_store.SetLeaderboard(ctx, redisDB, RegionalLeaderboardName(leaderboard, region), item); err != nil {
+		return err
+	}
+
+	return redis_store.AddLeaderboardRegion(ctx, redisDB, region)
+}
+
+// regionalBoards lists the global boards that have regional copies
+func regionalBoards(ctx context.Context, db *bun.DB) ([]string, error) {
+	boards := []string{LEADERBOARD_OVERALL, LEADERBOARD_OVERALL_WEEKLY}
+
+	games, err := datastore.GetEnabledGames(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for _, game := range games {
+		boards = append(boards, game.Slug)
+	}
+
+	return boards, nil
+}
+
+// MoveRegionalLeaderboards takes the user's scores out of the old region and copies the global scores into the new one
+func MoveRegionalLeaderboards(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, userID string, from string, to string) error {
+	if from == to {
+		return nil
+	}
+
+	boards, err := regionalBoards(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, board := range boards {
+		if from != "" {
+			if err := redis_store.RemoveFromLeaderboard(ctx, redisDB, RegionalLeaderboardName(board, from), userID); err != nil {
+				return err
+			}
+		}
+
+		if to == "" {
+			continue
+		}
+
+		item, err := redis_store.GetLeaderboardItem(ctx, redisDB, board, userID)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = SetRegionalLeaderboard(ctx, redisDB, to, board, item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetUserRegion stores the display region picked by the user, an empty region falls back to the detected language
+func (service *ServiceLeaderboard) SetUserRegion(ctx context.Context, user *models.User, region string) (*models.User, error) {
+	var override *string
+	if region != "" {
+		normalized := models.NormalizeRegion(region)
+		if normalized == "" {
+			return nil, errorx.Wrap(ErrInvalidRegion, errorx.Validation)
+		}
+		override = &normalized
+	}
+
+	previous := user.LeaderboardRegion()
+
+	if err := datastore.SetUserRegion(ctx, service.postgresDB, user.ID, override); err != nil {
+		return nil, err
+	}
+
+	// nolint:errcheck
+	service.cache.Delete(ctx, DBKeyUser(user.ID))
+
+	user.Region = override
+	if !IsExcludedFromLeaderboards(ctx, service.redisDB, user.ID) {
+		if err := MoveRegionalLeaderboards(ctx, service.postgresDB, service.redisDB, user.ID, previous, user.LeaderboardRegion()); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// GetRegionalLeaderboard returns the board restricted to one region, defaulting to the viewer's own region
+func (service *ServiceLeaderboard) GetRegionalLeaderboard(ctx context.Context, user *models.User, leaderboardName string, region string) (*models.LeaderboardResponse, error) {
+	if region == "" {
+		region = user.LeaderboardRegion()
+	} else {
+		region = models.NormalizeRegion(region)
+	}
+
+	if region == "" {
+		return nil, errorx.Wrap(ErrInvalidRegion, errorx.Invalid)
+	}
+
+	if leaderboardName == LEADERBOARD_REFERRAL {
+		return nil, errorx.Wrap(ErrUnknownLeaderboard, errorx.Invalid)
+	}
+
+	limit := 0
+	switch leaderboardName {
+	case LEADERBOARD_OVERALL, LEADERBOARD_OVERALL_WEEKLY:
+		limit, _ = service.serviceConfig.GetIntConfig(ctx, CONFIG_OVERALL_LEADERBOARD_LIMIT, OVERALL_LEADERBOARD_DEFAULT_LIMIT)
+	default:
+		limit, _ = service.serviceConfig.GetIntConfig(ctx, CONFIG_GAME_LEADERBOARD_LIMIT, GAME_LEADERBOARD_DEFAULT_LIMIT)
+	}
+
+	return service.getLeaderboard(ctx, user, RegionalLeaderboardName(leaderboardName, region), limit)
+}
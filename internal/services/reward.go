@@ -3,11 +3,15 @@ package services
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"log"
 	"millionaire/internal/datastore"
 	"millionaire/internal/models"
 	"millionaire/internal/pkg/caching"
 
 	"github.com/go-redsync/redsync/v4"
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
 	"github.com/redis/go-redis/v9"
 	"github.com/samber/do"
 	"github.com/uptrace/bun"
@@ -70,17 +74,196 @@ func (service *ServiceReward) GetAvailableRewardByUserID(ctx context.Context, us
 			return nil, nil
 		}
 
+		for i := range rewards {
+			rewards[i].Description = rewards[i].Describe()
+		}
+
 		return rewards, err
 	}
 
 	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyUserAvailableReward(userID), CACHE_TTL_5_MINS, callback)
 }
 
-func (service *ServiceReward) ClaimReward(ctx context.Context, rewardID int) error {
-	// TODO: add lock
-	// TODO: check ownership of reward
-	// TODO: update user assets
-	return datastore.ClaimReward(ctx, service.postgresDB, rewardID)
+func (service *ServiceReward) GetUserRewards(ctx context.Context, userID string, status string, page int, limit int) (*models.RewardInbox, error) {
+	rewards, total, err := datastore.GetUserRewardsPaging(ctx, service.readonlyPostgresDB, userID, status, limit, page*limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rewards {
+		rewards[i].Description = rewards[i].Describe()
+	}
+
+	return &models.RewardInbox{Rewards: rewards, Total: total}, nil
+}
+
+func (service *ServiceReward) ClaimReward(ctx context.Context, user *models.User, rewardID int) (*models.Reward, error) {
+	mutex := service.rs.NewMutex(LockKeyUserReward(user.ID))
+	if err := mutex.TryLock(); err != nil {
+		return nil, errorx.Wrap(ErrUserRewardLock, errorx.Invalid)
+	}
+	// nolint:errcheck
+	defer mutex.Unlock()
+
+	if err := service.checkRewardHold(ctx, user); err != nil {
+		return nil, err
+	}
+
+	reward, err := datastore.GetReward(ctx, service.postgresDB, rewardID)
+	if err == sql.ErrNoRows || (err == nil && reward.UserID != user.ID) {
+		return nil, errorx.Wrap(errors.New("reward not found"), errorx.NotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if reward.Claimed {
+		return nil, errorx.Wrap(errors.New("reward already claimed"), errorx.Exist)
+	}
+
+	if reward.IsExpired() {
+		return nil, errorx.Wrap(errors.New("reward expired"), errorx.Validation)
+	}
+
+	if err := service.checkWalletRequirement(ctx, user, reward); err != nil {
+		return nil, err
+	}
+
+	claimed, err := service.claim(ctx, user, rewardID)
+	if err != nil {
+		return nil, err
+	}
+
+	service.afterClaim(ctx, user, []models.Reward{*claimed})
+	return claimed, nil
+}
+
+// ClaimAllRewards claims every available reward, rewards waiting for a wallet stay in the inbox
+func (service *ServiceReward) ClaimAllRewards(ctx context.Context, user *models.User) ([]models.Reward, error) {
+	mutex := service.rs.NewMutex(LockKeyUserReward(user.ID))
+	if err := mutex.TryLock(); err != nil {
+		return nil, errorx.Wrap(ErrUserRewardLock, errorx.Invalid)
+	}
+	// nolint:errcheck
+	defer mutex.Unlock()
+
+	if err := service.checkRewardHold(ctx, user); err != nil {
+		return nil, err
+	}
+
+	rewards, err := datastore.GetAvaiableRewardByUserID(ctx, service.postgresDB, user.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	claimedRewards := []models.Reward{}
+	for _, reward := range rewards {
+		if err := service.checkWalletRequirement(ctx, user, &reward); err != nil {
+			continue
+		}
+
+		claimed, err := service.claim(ctx, user, reward.ID)
+		if err != nil {
+			log.Println("ClaimAllRewards error:", err, "user:", user.ID, "reward:", reward.ID)
+			continue
+		}
+		claimedRewards = append(claimedRewards, *claimed)
+	}
+
+	service.afterClaim(ctx, user, claimedRewards)
+	return claimedRewards, nil
+}
+
+func (service *ServiceReward) claim(ctx context.Context, user *models.User, rewardID int) (*models.Reward, error) {
+	reward, err := datastore.ClaimUserReward(ctx, service.postgresDB, user.ID, rewardID)
+	if err == sql.ErrNoRows {
+		return nil, errorx.Wrap(errors.New("reward is not claimable"), errorx.Invalid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reward.Description = reward.Describe()
+	return reward, nil
+}
+
+func (service *ServiceReward) checkRewardHold(ctx context.Context, user *models.User) error {
+	serviceAntiCheat, err := do.Invoke[*ServiceAntiCheat](service.container)
+	if err != nil {
+		return err
+	}
+
+	if serviceAntiCheat.IsRewardHeld(ctx, user.ID) {
+		return errorx.Wrap(errors.New("rewards are on hold"), errorx.Authz)
+	}
+
+	return nil
+}
+
+func (service *ServiceReward) checkWalletRequirement(ctx context.Context, user *models.User, reward *models.Reward) error {
+	if reward.RequiresWallet == "" {
+		return nil
+	}
+
+	serviceUser, err := do.Invoke[*ServiceUser](service.container)
+	if err != nil {
+		return err
+	}
+
+	wallet, _ := serviceUser.FindUserWalletByUserID(ctx, user.ID)
+	hasEVM := wallet != nil && wallet.EVMWallet != nil && *wallet.EVMWallet != ""
+	hasTON := wallet != nil && wallet.TONWallet != nil && *wallet.TONWallet != ""
+
+	connected := hasEVM || hasTON
+	switch reward.RequiresWallet {
+	case models.RewardWalletEVM:
+		connected = hasEVM
+	case models.RewardWalletTON:
+		connected = hasTON
+	}
+
+	if !connected {
+		return errorx.Wrap(fmt.Errorf("connect a %s wallet to claim this reward", reward.RequiresWallet), errorx.Validation)
+	}
+
+	return nil
+}
+
+func (service *ServiceReward) afterClaim(ctx context.Context, user *models.User, rewards []models.Reward) {
+	if len(rewards) == 0 {
+		return
+	}
+
+	_ = service.ClearUserAvailableRewardCache(ctx, user.ID)
+
+	serviceUser, err := do.Invoke[*ServiceUser](service.container)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	_ = serviceUser.ClearUserGemCache(ctx, user.ID)
+	// the lifelines and the boosts of the rewards are on the cached user
+	_ = serviceUser.ClearUserCache(ctx, user.ID)
+
+	gems := 0
+	for _, reward := range rewards {
+		gems += reward.Gem
+	}
+
+	if gems == 0 {
+		return
+	}
+
+	serviceLeaderboard, err := do.Invoke[*ServiceLeaderboard](service.container)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if _, err := serviceLeaderboard.UpdateOverallLeaderboard(ctx, user); err != nil {
+		log.Println("UpdateOverallLeaderboard error:", err, "user:", user.ID)
+	}
 }
 
 func (service *ServiceReward) ClearUserAvailableRewardCache(ctx context.Context, userID string) error {
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+	"millionaire/internal/pkg"
+	"millionaire/internal/pkg/caching"
+
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/redis/go-redis/v9"
+	"github.com/samber/do"
+	"github.com/uptrace/bun"
+)
+
+type ServiceSeason struct {
+	container          *do.Injector
+	redisDB            redis.UniversalClient
+	readonlyPostgresDB *bun.DB
+	cache              caching.Cache
+	readonlyCache      caching.ReadOnlyCache
+	serviceConfig      *ServiceConfig
+}
+
+func NewServiceSeason(container *do.Injector) (*ServiceSeason, error) {
+	redisDB, err := do.InvokeNamed[redis.UniversalClient](container, "redis-db")
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyPostgresDB, err := do.InvokeNamed[*bun.DB](container, "db-readonly")
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := do.Invoke[caching.Cache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	readonlyCache, err := do.Invoke[caching.ReadOnlyCache](container)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceConfig, err := do.Invoke[*ServiceConfig](container)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceSeason{container, redisDB, readonlyPostgresDB, cache, readonlyCache, serviceConfig}, nil
+}
+
+func (service *ServiceSeason) GetSeasonConfig(ctx context.Context) *models.SeasonConfig {
+	period, _ := service.serviceConfig.GetStringConfig(ctx, CONFIG_SEASON_PERIOD, models.SeasonPeriodWeekly)
+	timezone, _ := service.serviceConfig.GetStringConfig(ctx, CONFIG_SEASON_TIMEZONE, DEFAULT_SEASON_TIMEZONE)
+	lengthInDays, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_SEASON_LENGTH_IN_DAYS, DEFAULT_SEASON_LENGTH_IN_DAYS)
+	anchor, _ := service.serviceConfig.GetStringConfig(ctx, CONFIG_SEASON_ANCHOR, "")
+	prizes, _ := service.serviceConfig.GetStringConfig(ctx, CONFIG_SEASON_PRIZES, "")
+
+	return newSeasonConfig(period, timezone, lengthInDays, anchor, prizes)
+}
+
+func (service *ServiceSeason) GetSeasons(ctx context.Context, limit int) ([]models.Season, error) {
+	if limit <= 0 || limit > 100 {
+		limit = SEASON_LIST_DEFAULT_LIMIT
+	}
+
+	callback := func() ([]models.Season, error) {
+		return datastore.GetArchivedSeasons(ctx, service.readonlyPostgresDB, LEADERBOARD_OVERALL_WEEKLY, limit)
+	}
+
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeySeasons(LEADERBOARD_OVERALL_WEEKLY, limit), CACHE_TTL_5_MINS, callback)
+}
+
+// GetPreviousSeasonLeaderboard answers "my rank last week" with the last archived season
+func (service *ServiceSeason) GetPreviousSeasonLeaderboard(ctx context.Context, user *models.User) (*models.SeasonResponse, error) {
+	seasons, err := service.GetSeasons(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(seasons) == 0 {
+		return nil, errorx.Wrap(errors.New("no previous season"), errorx.NotExist)
+	}
+
+	return service.GetSeasonLeaderboard(ctx, user, seasons[0].ID)
+}
+
+func (service *ServiceSeason) GetSeasonLeaderboard(ctx context.Context, user *models.User, seasonID int64) (*models.SeasonResponse, error) {
+	serviceLeaderboard, err := do.Invoke[*ServiceLeaderboard](service.container)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_OVERALL_LEADERBOARD_LIMIT, OVERALL_LEADERBOARD_DEFAULT_LIMIT)
+
+	callback := func() (*models.SeasonResponse, error) {
+		season, err := datastore.GetSeason(ctx, service.readonlyPostgresDB, seasonID)
+		if err == sql.ErrNoRows {
+			return nil, errorx.Wrap(errors.New("season not found"), errorx.NotExist)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		hidden := serviceLeaderboard.getHiddenUsers(ctx, user)
+
+		standings, err := datastore.GetSeasonStandings(ctx, service.readonlyPostgresDB, seasonID, 0, limit+len(hidden))
+		if err != nil {
+			return nil, err
+		}
+
+		leaderboard := []*models.LeaderboardItem{}
+		for _, standing := range standings {
+			leaderboard = append(leaderboard, &models.LeaderboardItem{
+				UserId: standing.UserID,
+				Score:  standing.Score,
+				Rank:   standing.Rank,
+			})
+		}
+		leaderboard = hideUsers(leaderboard, hidden, limit)
+		serviceLeaderboard.fillProfiles(ctx, leaderboard)
+
+		me := &models.LeaderboardItem{
+			Username: user.Username,
+			UserId:   user.ID,
+			Avatar:   user.Avatar,
+		}
+		if user.Username == "" {
+			me.Username = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+		}
+
+		standing, err := datastore.GetSeasonStanding(ctx, service.readonlyPostgresDB, seasonID, user.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if standing != nil {
+			me.Score = standing.Score
+			me.Rank = standing.Rank
+			for userID := range hidden {
+				hiddenStanding, err := datastore.GetSeasonStanding(ctx, service.readonlyPostgresDB, seasonID, userID)
+				if err == nil && hiddenStanding.Rank < standing.Rank {
+					me.Rank--
+				}
+			}
+		}
+
+		return &models.SeasonResponse{Season: season, Leaderboard: leaderboard, Me: me}, nil
+	}
+
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeySeasonLeaderboardByUser(seasonID, user.ID, limit), CACHE_TTL_5_MINS, callback)
+}
+
+// LoadSeasonConfig reads the season settings without the container, for the cronjob and the bot
+func LoadSeasonConfig(ctx context.Context, db *bun.DB) *models.SeasonConfig {
+	value := func(key string, defaultValue string) string {
+		config, err := datastore.GetConfigByKey(ctx, db, key)
+		if err != nil || config.Value == "" {
+			return defaultValue
+		}
+		return config.Value
+	}
+
+	lengthInDays, err := strconv.Atoi(value(CONFIG_SEASON_LENGTH_IN_DAYS, ""))
+	if err != nil {
+		lengthInDays = DEFAULT_SEASON_LENGTH_IN_DAYS
+	}
+
+	return newSeasonConfig(
+		value(CONFIG_SEASON_PERIOD, models.SeasonPeriodWeekly),
+		value(CONFIG_SEASON_TIMEZONE, DEFAULT_SEASON_TIMEZONE),
+		lengthInDays,
+		value(CONFIG_SEASON_ANCHOR, ""),
+		value(CONFIG_SEASON_PRIZES, ""),
+	)
+}
+
+func newSeasonConfig(period string, timezone string, lengthInDays int, anchor string, prizes string) *models.SeasonConfig {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		log.Println("invalid season timezone:", timezone, err)
+		loc = time.UTC
+	}
+
+	anchorTime, err := time.ParseInLocation(time.DateOnly, anchor, loc)
+	if err != nil {
+		anchorTime = time.Date(2024, time.January, 1, 0, 0, 0, 0, loc)
+	}
+
+	var schedule models.PrizeSchedule
+	if prizes != "" {
+		if err := json.Unmarshal([]byte(prizes), &schedule); err != nil {
+			log.Println("invalid season prizes:", err)
+			schedule = nil
+		} else if err := schedule.Validate(); err != nil {
+			log.Println("invalid season prizes:", err)
+			schedule = nil
+		}
+	}
+
+	return &models.SeasonConfig{
+		Period:       period,
+		Location:     loc,
+		LengthInDays: lengthInDays,
+		Anchor:       anchorTime,
+		Prizes:       schedule,
+	}
+}
+
+func GetSeasonStart(cfg *models.SeasonConfig, t time.Time) time.Time {
+	start, _ := pkg.GetSeasonBounds(cfg.Period, cfg.Location, cfg.LengthInDays, cfg.Anchor, t)
+	return start
+}
+
+// GetSeasonEnd is when the season running at that time ends, the boundary the rollover runs at
+func GetSeasonEnd(cfg *models.SeasonConfig, t time.Time) time.Time {
+	_, end := pkg.GetSeasonBounds(cfg.Period, cfg.Location, cfg.LengthInDays, cfg.Anchor, t)
+	return end
+}
+
+// IsSeasonOver tells whether the live sorted set still holds a finished season. It is left as is until the rollover
+// archives it, the scores of the new season are loaded once it's cleared
+func IsSeasonOver(ctx context.Context, redisDB redis.Cmdable, leaderboard string, cfg *models.SeasonConfig) bool {
+	liveStart, err := redis_store.GetSeasonStart(ctx, redisDB, leaderboard)
+	if err != nil || liveStart.IsZero() {
+		return false
+	}
+
+	return liveStart.Before(GetSeasonStart(cfg, time.Now()))
+}
+
+func seasonKey(cfg *models.SeasonConfig, start time.Time) string {
+	start = start.In(cfg.Location)
+	switch cfg.Period {
+	case models.SeasonPeriodMonthly:
+		return start.Format("2006-01")
+	case models.SeasonPeriodCustom:
+		return start.Format(time.DateOnly)
+	default:
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+}
+
+// RolloverSeason archives the standings of the live sorted set once its season is over and opens the next one.
+// It is safe to run repeatedly, an interrupted rollover is resumed on the next run.
+func RolloverSeason(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, leaderboard string, cfg *models.SeasonConfig) (*models.Season, error) {
+	now := time.Now()
+	currentStart := GetSeasonStart(cfg, now)
+
+	liveStart, err := redis_store.GetSeasonStart(ctx, redisDB, leaderboard)
+	if err != nil {
+		return nil, err
+	}
+
+	if liveStart.IsZero() {
+		return nil, redis_store.SetSeasonStart(ctx, redisDB, leaderboard, currentStart)
+	}
+
+	if !liveStart.Before(currentStart) {
+		return nil, nil
+	}
+
+	_, end := pkg.GetSeasonBounds(cfg.Period, cfg.Location, cfg.LengthInDays, cfg.Anchor, liveStart)
+	season := &models.Season{
+		Leaderboard: leaderboard,
+		Key:         seasonKey(cfg, liveStart),
+		Period:      cfg.Period,
+		Timezone:    cfg.Location.String(),
+		StartAt:     liveStart,
+		EndAt:       end,
+		Status:      models.SeasonStatusArchiving,
+		Prizes:      cfg.Prizes,
+	}
+	if err := datastore.InsertSeason(ctx, db, season); err != nil {
+		return nil, err
+	}
+
+	participants := 0
+	for offset := 0; ; offset += SEASON_SNAPSHOT_BATCH_SIZE {
+		items, err := redis_store.GetLeaderboardPage(ctx, redisDB, leaderboard, offset, SEASON_SNAPSHOT_BATCH_SIZE)
+		if err != nil {
+			return nil, err
+		}
+
+		standings := make([]models.SeasonStanding, 0, len(items))
+		for _, item := range items {
+			standings = append(standings, models.SeasonStanding{
+				SeasonID: season.ID,
+				UserID:   item.UserId,
+				Rank:     item.Rank,
+				Score:    item.Score,
+			})
+		}
+
+		if err := datastore.InsertSeasonStandings(ctx, db, standings); err != nil {
+			return nil, err
+		}
+
+		participants += len(items)
+		if len(items) < SEASON_SNAPSHOT_BATCH_SIZE {
+			break
+		}
+	}
+
+	if err := redis_store.ClearLeaderboard(ctx, redisDB, leaderboard); err != nil {
+		return nil, err
+	}
+
+	regions, err := redis_store.GetLeaderboardRegions(ctx, redisDB)
+	if err != nil {
+		return nil, err
+	}
+	for _, region := range regions {
+		if err := redis_store.ClearLeaderboard(ctx, redisDB, RegionalLeaderboardName(leaderboard, region)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := redis_store.SetSeasonStart(ctx, redisDB, leaderboard, currentStart); err != nil {
+		return nil, err
+	}
+
+	if err := datastore.ArchiveSeason(ctx, db, season.ID, participants); err != nil {
+		return nil, err
+	}
+
+	season.Status = models.SeasonStatusArchived
+	season.Participants = participants
+	return season, nil
+}
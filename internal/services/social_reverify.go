@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"millionaire/internal/datastore"
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uptrace/bun"
+)
+
+type socialReverifyConfig struct {
+	clawback  bool
+	grace     time.Duration
+	batchSize int
+}
+
+func loadSocialReverifyConfig(ctx context.Context, db *bun.DB) socialReverifyConfig {
+	value := func(key string, defaultValue int) int {
+		config, err := datastore.GetConfigByKey(ctx, db, key)
+		if err != nil || config.Value == "" {
+			return defaultValue
+		}
+
+		v, err := strconv.Atoi(config.Value)
+		if err != nil {
+			return defaultValue
+		}
+		return v
+	}
+
+	clawback := false
+	config, err := datastore.GetConfigByKey(ctx, db, CONFIG_SOCIAL_REVERIFY_CLAWBACK)
+	if err == nil {
+		clawback, _ = strconv.ParseBool(config.Value)
+	}
+
+	return socialReverifyConfig{
+		clawback:  clawback,
+		grace:     time.Duration(value(CONFIG_SOCIAL_REVERIFY_GRACE_IN_HOURS, DEFAULT_SOCIAL_REVERIFY_GRACE_IN_HOURS)) * time.Hour,
+		batchSize: value(CONFIG_SOCIAL_REVERIFY_BATCH_SIZE, DEFAULT_SOCIAL_REVERIFY_BATCH_SIZE),
+	}
+}
+
+// ReverifyTelegramTasks checks again with getChatMember that the users who completed a telegram channel or group task
+// are still members, at most a batch of calls per run. A user out of the chat is checked again after the grace period,
+// then the completion is clawed back when it's enabled: a negative gem entry and the task shown as not done, a link
+// clawed back is not paid again. It returns the users checked, the users out of the chat and the clawbacks to notify.
+// It is run by the cron, which does not use the container
+func ReverifyTelegramTasks(ctx context.Context, db *bun.DB, redisDB redis.Cmdable) (int, int, []models.SocialTaskClawback, error) {
+	config := loadSocialReverifyConfig(ctx, db)
+	now := time.Now().UTC()
+
+	verifiers := []string{models.SocialVerifierTelegram, string(models.SocialTypeTelegramChannel), string(models.SocialTypeTelegramGroup)}
+	completions, err := datastore.GetSocialTaskCompletionsToReverify(ctx, db, verifiers, now.Add(-SOCIAL_REVERIFY_WINDOW), now.Add(-SOCIAL_REVERIFY_INTERVAL), now.Add(-config.grace), config.batchSize)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	client := (&ServiceHTTP{}).httpClient(0)
+	tasks := map[int64]*models.SocialTask{}
+	checked, left := 0, 0
+	clawbacks := []models.SocialTaskClawback{}
+	for i := range completions {
+		completion := &completions[i]
+
+		task, link, slug := reverifiedTaskLink(ctx, db, tasks, completion)
+		// only the telegram users of a public chat can be checked
+		if _, err := strconv.ParseInt(completion.UserID, 10, 64); err != nil || slug == "" {
+			if err := datastore.MarkSocialTaskCompletionChecked(ctx, db, completion.ID, true); err != nil {
+				log.Println("MarkSocialTaskCompletionChecked error:", err, "completion:", completion.ID)
+			}
+			continue
+		}
+
+		if checked > 0 {
+			time.Sleep(SOCIAL_REVERIFY_REQUEST_INTERVAL)
+		}
+
+		member, err := getTelegramChatMember(ctx, client, TELEGRAM_API_BASE_URL, slug, completion.UserID)
+		var telegramErr *TelegramRespError
+		if errors.As(err, &telegramErr) && telegramErr.ErrorCode == http.StatusTooManyRequests {
+			// the rest waits for the next run
+			return checked, left, clawbacks, err
+		}
+		checked++
+		if err != nil || member == nil {
+			log.Println("getChatMember error:", err, "user:", completion.UserID, "chat:", slug)
+			// not retried before the next check, a user already out of the chat stays so
+			if err := datastore.MarkSocialTaskCompletionChecked(ctx, db, completion.ID, completion.LeftAt == nil); err != nil {
+				log.Println("MarkSocialTaskCompletionChecked error:", err, "completion:", completion.ID)
+			}
+			continue
+		}
+
+		isMember := IsTelegramMemberStatus(member.Status)
+		if !isMember {
+			left++
+		}
+
+		if isMember || completion.LeftAt == nil || !config.clawback {
+			if err := datastore.MarkSocialTaskCompletionChecked(ctx, db, completion.ID, isMember); err != nil {
+				log.Println("MarkSocialTaskCompletionChecked error:", err, "completion:", completion.ID)
+			}
+			continue
+		}
+
+		revoked, err := clawbackSocialTask(ctx, db, redisDB, link, completion)
+		if err != nil {
+			log.Println("clawbackSocialTask error:", err, "completion:", completion.ID)
+			continue
+		}
+		if revoked {
+			clawbacks = append(clawbacks, models.SocialTaskClawback{UserID: completion.UserID, Title: task.Title, Url: link.Url, Gems: completion.Gems})
+		}
+	}
+
+	return checked, left, clawbacks, nil
+}
+
+// reverifiedTaskLink finds the task link of the completion and its chat, the chat is empty when it can't be checked
+func reverifiedTaskLink(ctx context.Context, db *bun.DB, tasks map[int64]*models.SocialTask, completion *models.SocialTaskCompletion) (*models.SocialTask, *models.Link, string) {
+	task, ok := tasks[completion.TaskID]
+	if !ok {
+		var err error
+		task, err = datastore.GetSocialTaskByID(ctx, db, completion.TaskID)
+		if err != nil {
+			log.Println("GetSocialTaskByID error:", err, "task:", completion.TaskID)
+		}
+		tasks[completion.TaskID] = task
+	}
+	if task == nil {
+		return nil, nil, ""
+	}
+
+	for _, link := range task.Links {
+		if link.ID != completion.LinkID {
+			continue
+		}
+
+		link.Period = completion.Period
+		slug, err := telegramChatSlug(link.Url)
+		if err != nil {
+			return task, &link, ""
+		}
+		return task, &link, slug
+	}
+
+	return task, nil, ""
+}
+
+func clawbackSocialTask(ctx context.Context, db *bun.DB, redisDB redis.Cmdable, link *models.Link, completion *models.SocialTaskCompletion) (bool, error) {
+	revoked, err := datastore.ClawbackSocialTaskCompletion(ctx, db, completion, fmt.Sprintf(KEY_SOCIAL_CLAWBACK, completion.ID))
+	if err != nil || !revoked {
+		return false, err
+	}
+
+	// the task is shown as not done again, the cached task lists catch up when they expire
+	if err := redis_store.DelJoinSocial(ctx, redisDB, completion.UserID, link.CompletionKey()); err != nil {
+		log.Println("DelJoinSocial error:", err, "user:", completion.UserID)
+	}
+
+	if !IsExcludedFromLeaderboards(ctx, redisDB, completion.UserID) {
+		if err := RestoreUserLeaderboards(ctx, db, redisDB, completion.UserID); err != nil {
+			log.Println("RestoreUserLeaderboards error:", err, "user:", completion.UserID)
+		}
+	}
+
+	return true, nil
+}
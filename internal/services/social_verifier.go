@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"millionaire/internal/datastore/redis_store"
+	"millionaire/internal/models"
+
+	"github.com/go-redis/redis_rate/v10"
+	"github.com/hiendaovinh/toolkit/pkg/errorx"
+	"github.com/hiendaovinh/toolkit/pkg/limiter"
+	"github.com/redis/go-redis/v9"
+)
+
+// SocialVerifier tells whether the user completed a social task link
+type SocialVerifier interface {
+	Verify(ctx context.Context, user *models.User, link *models.Link) (bool, error)
+}
+
+type SocialVerifierFunc func(ctx context.Context, user *models.User, link *models.Link) (bool, error)
+
+func (f SocialVerifierFunc) Verify(ctx context.Context, user *models.User, link *models.Link) (bool, error) {
+	return f(ctx, user, link)
+}
+
+// SocialVerifierRegistry resolves the verifier of a link by the name it declares or by its link type
+type SocialVerifierRegistry struct {
+	verifiers map[string]SocialVerifier
+}
+
+func NewSocialVerifierRegistry() *SocialVerifierRegistry {
+	return &SocialVerifierRegistry{map[string]SocialVerifier{}}
+}
+
+func (registry *SocialVerifierRegistry) Register(verifier SocialVerifier, names ...string) {
+	for _, name := range names {
+		registry.verifiers[name] = verifier
+	}
+}
+
+func (registry *SocialVerifierRegistry) Get(link *models.Link) (SocialVerifier, error) {
+	verifier, ok := registry.verifiers[link.VerifierName()]
+	if !ok {
+		return nil, errorx.Wrap(errors.New("invalid link type"), errorx.Invalid)
+	}
+
+	return verifier, nil
+}
+
+func (service *ServiceSocial) registerVerifiers() {
+	service.verifiers.Register(SocialVerifierFunc(func(ctx context.Context, user *models.User, link *models.Link) (bool, error) {
+		return service.VerifyJoinTelegram(ctx, user.ID, link)
+	}), models.SocialVerifierTelegram, string(models.SocialTypeTelegramChannel), string(models.SocialTypeTelegramGroup))
+
+	service.verifiers.Register(SocialVerifierFunc(func(ctx context.Context, user *models.User, link *models.Link) (bool, error) {
+		return service.VerifySocialLinkWithoutChecking(ctx, user.ID, link)
+	}), models.SocialVerifierNone, string(models.SocialTypeTwitter), string(models.SocialTypeTelegramApp))
+
+	service.verifiers.Register(&httpCallbackVerifier{service, nil}, models.SocialVerifierHTTPCallback)
+
+	// teletop is a callback answering {"success": true} once the app was added
+	service.verifiers.Register(&httpCallbackVerifier{service, map[string]string{
+		"url":  fmt.Sprintf(TELETOP_VERIFY_URL, TELETOP_CATIA_APP_ID),
+		"path": "success",
+	}}, string(models.SocialTypeTeletop))
+}
+
+var socialVerifierClient = externalHTTPClient(10 * time.Second)
+
+// httpCallbackVerifier asks a partner whether the user completed the link. The parameters of the link, over the defaults:
+//   - url: the https endpoint, {user_id} and {username} are replaced by the escaped values of the user
+//   - path: the dot separated path of the answer in the JSON response, e.g. "data.items.0.done"
+//   - expect: the expected value at the path, any truthy value is accepted when it's empty
+//   - header, header_env: a header sent with the value of the environment variable, for the partner credentials,
+//     the variable must start with SOCIAL_VERIFIER_
+type httpCallbackVerifier struct {
+	service  *ServiceSocial
+	defaults map[string]string
+}
+
+func (verifier *httpCallbackVerifier) param(link *models.Link, key string) string {
+	if value, ok := link.VerifierParams[key]; ok {
+		return value
+	}
+
+	return verifier.defaults[key]
+}
+
+func (verifier *httpCallbackVerifier) Verify(ctx context.Context, user *models.User, link *models.Link) (bool, error) {
+	service := verifier.service
+
+	verify, err := redis_store.GetJoinSocial(ctx, service.redisDB, user.ID, link.CompletionKey())
+	if err == nil {
+		return verify, nil
+	}
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	endpoint, path := verifier.param(link, "url"), verifier.param(link, "path")
+	if endpoint == "" || path == "" {
+		return false, errorx.Wrap(errors.New("verifier is not configured"), errorx.Service)
+	}
+
+	err = service.limiter.Allow(ctx, LimitKeyUserSocialTask(user.ID), redis_rate.PerMinute(TELEGRAM_TASK_RATE_LIMIT_PER_MINUTE))
+	if err != nil {
+		if err.Error() == limiter.ErrRateLimited.Error() {
+			return false, errorx.Wrap(err, errorx.RateLimiting)
+		}
+		return false, err
+	}
+
+	endpoint = strings.NewReplacer(
+		"{user_id}", url.PathEscape(user.ID),
+		"{username}", url.PathEscape(user.Username),
+	).Replace(endpoint)
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return false, errorx.Wrap(fmt.Errorf("invalid verifier url: %s", endpoint), errorx.Service)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if header := verifier.param(link, "header"); header != "" {
+		// only the variables set aside for the verifiers can be sent
+		env := verifier.param(link, "header_env")
+		if !strings.HasPrefix(env, SOCIAL_VERIFIER_ENV_PREFIX) {
+			return false, errorx.Wrap(fmt.Errorf("verifier header_env must start with %s", SOCIAL_VERIFIER_ENV_PREFIX), errorx.Service)
+		}
+		req.Header.Set(header, os.Getenv(env))
+	}
+
+	resp, err := socialVerifierClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, fmt.Errorf("verifier responded %d: %s", resp.StatusCode, u.Host)
+	}
+
+	var body any
+	err = json.NewDecoder(io.LimitReader(resp.Body, SOCIAL_VERIFIER_MAX_RESPONSE_SIZE)).Decode(&body)
+	if err != nil {
+		return false, err
+	}
+
+	value, ok := lookupJSONPath(body, path)
+	if !ok || !matchJSONValue(value, verifier.param(link, "expect")) {
+		return false, nil
+	}
+
+	err = redis_store.SetJoinSocial(ctx, service.redisDB, user.ID, link.CompletionKey(), link.CompletionTTL(time.Now()))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// lookupJSONPath walks a decoded JSON value by object keys and array indexes
+func lookupJSONPath(value any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]any:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			value = node[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+func matchJSONValue(value any, expect string) bool {
+	if expect != "" {
+		switch v := value.(type) {
+		case string:
+			return v == expect
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64) == expect
+		case bool:
+			return strconv.FormatBool(v) == expect
+		}
+		return false
+	}
+
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != "" && v != "0" && !strings.EqualFold(v, "false")
+	}
+
+	return false
+}
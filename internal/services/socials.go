@@ -2,10 +2,10 @@ package services
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"millionaire/internal/datastore"
 	"millionaire/internal/interfaces"
 	"millionaire/internal/models"
@@ -13,8 +13,10 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"time"
 
 	"github.com/go-redis/redis_rate/v10"
+	"github.com/gojek/heimdall/v7/httpclient"
 	"github.com/hiendaovinh/toolkit/pkg/errorx"
 	"github.com/hiendaovinh/toolkit/pkg/limiter"
 
@@ -37,6 +39,7 @@ type ServiceSocial struct {
 	readonlyPostgresDB *bun.DB
 	limiter            interfaces.Limiter
 	baseURL            string
+	verifiers          *SocialVerifierRegistry
 }
 
 func NewServiceSocial(container *do.Injector) (*ServiceSocial, error) {
@@ -70,7 +73,10 @@ func NewServiceSocial(container *do.Injector) (*ServiceSocial, error) {
 		return nil, err
 	}
 
-	return &ServiceSocial{&ServiceHTTP{}, container, db, cache, readOnlyCache, postgresDB, readonlyPostgresDB, limiter, TELEGRAM_API_BASE_URL}, nil
+	service := &ServiceSocial{&ServiceHTTP{}, container, db, cache, readOnlyCache, postgresDB, readonlyPostgresDB, limiter, TELEGRAM_API_BASE_URL, NewSocialVerifierRegistry()}
+	service.registerVerifiers()
+
+	return service, nil
 }
 
 func (service *ServiceSocial) GetTasks(ctx context.Context, gameSlug string) (*models.SocialTask, error) {
@@ -87,6 +93,7 @@ func (service *ServiceSocial) GetTasks(ctx context.Context, gameSlug string) (*m
 }
 
 func (service *ServiceSocial) GetUserTasks(ctx context.Context, userID string, gameSlug string) (*models.SocialTask, error) {
+	now := time.Now().UTC()
 	callback := func() (*models.SocialTask, error) {
 		tasks, err := service.GetTasks(ctx, gameSlug)
 		if err != nil {
@@ -97,7 +104,8 @@ func (service *ServiceSocial) GetUserTasks(ctx context.Context, userID string, g
 			return nil, errorx.Wrap(errors.New("task not found"), errorx.NotExist)
 		}
 		for index, link := range tasks.Links {
-			valid, _ := service.IsJoinSocial(ctx, userID, link.Url)
+			tasks.SetLinkPeriod(&link, now)
+			valid, _ := service.IsJoinSocial(ctx, userID, link.CompletionKey())
 			link.Joined = valid
 			tasks.Links[index] = link
 		}
@@ -105,7 +113,44 @@ func (service *ServiceSocial) GetUserTasks(ctx context.Context, userID string, g
 		return tasks, nil
 	}
 
-	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyUserSocialTasks(userID, gameSlug), CACHE_TTL_1_DAY, callback)
+	// the completions of a recurring task are reset with its period
+	ttl := CACHE_TTL_1_DAY
+	if task, err := service.GetTasks(ctx, gameSlug); err == nil && task != nil {
+		if end := task.PeriodEnd(now); end != nil && end.Sub(now) < ttl {
+			ttl = end.Sub(now)
+		}
+	}
+
+	tasks, err := caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyUserSocialTasks(userID, gameSlug), ttl, callback)
+	if err == nil && tasks != nil && tasks.PartnerID != nil {
+		go service.recordTaskView(context.WithoutCancel(ctx), tasks.ID)
+	}
+
+	return tasks, err
+}
+
+// recordTaskView counts a view of a sponsored task for the partner stats
+func (service *ServiceSocial) recordTaskView(ctx context.Context, taskID int64) {
+	if err := redis_store.IncrSocialTaskView(ctx, service.redisDB, taskID, time.Now().UTC()); err != nil {
+		log.Println("IncrSocialTaskView error:", err, "task:", taskID)
+	}
+}
+
+// recordTaskVerification keeps every check of a sponsored task link for the partner stats
+func (service *ServiceSocial) recordTaskVerification(ctx context.Context, task *models.SocialTask, link *models.Link, userID string, verified bool) {
+	if task == nil || task.PartnerID == nil {
+		return
+	}
+
+	err := datastore.InsertSocialTaskVerification(ctx, service.db, &models.SocialTaskVerification{
+		TaskID:   task.ID,
+		LinkID:   link.ID,
+		UserID:   userID,
+		Verified: verified,
+	})
+	if err != nil {
+		log.Println("InsertSocialTaskVerification error:", err, "task:", task.ID, "user:", userID)
+	}
 }
 
 func (service *ServiceSocial) GetAvailableSocialTasks(ctx context.Context) ([]models.SocialTask, error) {
@@ -116,6 +161,7 @@ func (service *ServiceSocial) GetAvailableSocialTasks(ctx context.Context) ([]mo
 	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyAllSocialTasks(), CACHE_TTL_5_MINS, callback)
 }
 
+// GetAvailableSocialTasksByUser returns the open tasks with a link the user can still complete in the current period
 func (service *ServiceSocial) GetAvailableSocialTasksByUser(ctx context.Context, userID string) ([]models.SocialTask, error) {
 	callback := func() ([]models.SocialTask, error) {
 		tasks, err := service.GetAvailableSocialTasks(ctx)
@@ -123,46 +169,140 @@ func (service *ServiceSocial) GetAvailableSocialTasksByUser(ctx context.Context,
 			return nil, err
 		}
 
-		for index, task := range tasks {
+		now := time.Now().UTC()
+		completions, err := service.countCompletions(ctx, tasks, now)
+		if err != nil {
+			return nil, err
+		}
+
+		available := []models.SocialTask{}
+		for _, task := range tasks {
+			if !task.IsOpen(now) {
+				continue
+			}
+
+			doable := false
 			for linkIndex, link := range task.Links {
-				valid, _ := service.IsJoinSocial(ctx, userID, link.Url)
-				task.Links[linkIndex].Joined = valid
+				task.SetLinkPeriod(&link, now)
+				link.Joined, _ = service.IsJoinSocial(ctx, userID, link.CompletionKey())
+				link.SoldOut = task.MaxCompletions > 0 && completions[task.ID][link.ID] >= task.MaxCompletions
+				if !link.Joined && !link.SoldOut {
+					doable = true
+				}
+				task.Links[linkIndex] = link
+			}
+
+			if doable {
+				available = append(available, task)
 			}
-			tasks[index] = task
 		}
 
-		return tasks, nil
+		return available, nil
 	}
 	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyUserSocialTask(userID), CACHE_TTL_5_MINS, callback)
 }
 
+// countCompletions counts the completions in the current period of the tasks limited by a max
+func (service *ServiceSocial) countCompletions(ctx context.Context, tasks []models.SocialTask, now time.Time) (map[int64]map[int]int, error) {
+	periods := map[int64]string{}
+	for _, task := range tasks {
+		if task.MaxCompletions > 0 {
+			periods[task.ID] = task.Period(now)
+		}
+	}
+
+	return datastore.CountSocialTaskCompletions(ctx, service.readonlyPostgresDB, periods)
+}
+
+func socialTaskAction(gameSlug string, link *models.Link) string {
+	if link.Period == "" {
+		return fmt.Sprintf(KEY_SOCIAL_TASK, gameSlug, link.Url)
+	}
+
+	return fmt.Sprintf(KEY_SOCIAL_TASK_PERIOD, gameSlug, link.Url, link.Period)
+}
+
 func (service *ServiceSocial) VerifySocialTask(ctx context.Context, user *models.User, gameSlug string, linkID int) (bool, error) {
 	link, err := service.GetSocialLink(ctx, linkID, gameSlug)
 	if err != nil {
 		return false, err
 	}
+
+	task, err := service.GetTasks(ctx, gameSlug)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	if !task.IsOpen(now) {
+		return false, errorx.Wrap(errors.New("task is not available"), errorx.Validation)
+	}
+	task.SetLinkPeriod(link, now)
+
 	callback := func() (bool, error) {
+		if task.MaxCompletions > 0 {
+			completions, err := service.countCompletions(ctx, []models.SocialTask{*task}, now)
+			if err != nil {
+				return false, err
+			}
+			if completions[task.ID][link.ID] >= task.MaxCompletions {
+				return false, errorx.Wrap(errors.New("task is fully claimed"), errorx.Validation)
+			}
+		}
+
 		joined, err := service.checkSocialLink(ctx, user, link)
+		service.recordTaskVerification(ctx, task, link, user.ID, err == nil && joined)
 		if err != nil {
 			return false, err
 		}
 
 		if joined {
-			serviceUser, err := do.Invoke[*ServiceUser](service.container)
+			credited, err := datastore.CompleteSocialTask(ctx, service.db, task, &models.SocialTaskCompletion{
+				TaskID: task.ID,
+				LinkID: link.ID,
+				UserID: user.ID,
+				Period: link.Period,
+				Gems:   link.Gem,
+			}, socialTaskAction(gameSlug, link))
+			if errors.Is(err, datastore.ErrSocialTaskFullyClaimed) {
+				// the last completions were taken meanwhile, the link stays to do
+				if err := redis_store.DelJoinSocial(ctx, service.redisDB, user.ID, link.CompletionKey()); err != nil {
+					log.Println("DelJoinSocial error:", err, "user:", user.ID)
+				}
+				return false, errorx.Wrap(err, errorx.Validation)
+			}
 			if err != nil {
 				return joined, err
 			}
-			_, err = serviceUser.GetUserGemByAction(ctx, user.ID, fmt.Sprintf(KEY_SOCIAL_TASK, gameSlug, link.Url))
-			if err != nil && err != sql.ErrNoRows {
+
+			err = service.cache.Delete(ctx, DBKeyUserSocialTasks(user.ID, gameSlug))
+			err = service.cache.Delete(ctx, DBKeyUserSocialTask(user.ID))
+
+			if !credited {
+				return joined, nil
+			}
+
+			serviceUser, err := do.Invoke[*ServiceUser](service.container)
+			if err != nil {
 				return joined, err
 			}
+			if err := serviceUser.ClearUserGemCache(ctx, user.ID); err != nil {
+				log.Println(err)
+			}
 
-			err = serviceUser.InsertUserGem(ctx, user, link.Gem, fmt.Sprintf(KEY_SOCIAL_TASK, gameSlug, link.Url))
+			serviceLeaderboard, err := do.Invoke[*ServiceLeaderboard](service.container)
 			if err != nil {
 				return joined, err
 			}
-			err = service.cache.Delete(ctx, DBKeyUserSocialTasks(user.ID, gameSlug))
-			err = service.cache.Delete(ctx, DBKeyUserSocialTask(user.ID))
+			_, err = serviceLeaderboard.UpdateOverallLeaderboard(ctx, user)
+			if err != nil {
+				return joined, err
+			}
+
+			servicePartnerWebhook, err := do.Invoke[*ServicePartnerWebhook](service.container)
+			if err == nil {
+				servicePartnerWebhook.EmitSocialTaskCompleted(ctx, user, task, link)
+			}
 
 			serviceArena, err := do.Invoke[*ServiceArena](service.container)
 			if err != nil || serviceArena == nil {
@@ -179,29 +319,20 @@ func (service *ServiceSocial) VerifySocialTask(ctx context.Context, user *models
 		return false, errorx.Wrap(errors.New("unverified"), errorx.Invalid)
 	}
 
-	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyUserSocialTaskVerify(user.ID, gameSlug, link.Url), CACHE_TTL_15_MINS, callback)
+	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyUserSocialTaskVerify(user.ID, gameSlug, link.CompletionKey()), CACHE_TTL_15_MINS, callback)
 }
 
 func (service *ServiceSocial) checkSocialLink(ctx context.Context, user *models.User, link *models.Link) (bool, error) {
-	joined := false
-
-	var err error
-	if link.LinkType == models.SocialTypeTelegramChannel || link.LinkType == models.SocialTypeTelegramGroup {
-		joined, err = service.VerifyJoinTelegram(ctx, user.ID, link.Url)
-	} else if link.LinkType == models.SocialTypeTwitter || link.LinkType == models.SocialTypeTelegramApp {
-		joined, err = service.VerifySocialLinkWithoutChecking(ctx, user.ID, link.Url)
-	} else if link.LinkType == models.SocialTypeTeletop {
-		joined, err = service.VerifyAddedTeletop(ctx, user.ID, link.Url)
-		fmt.Print(joined, err)
-	} else {
-		err = errorx.Wrap(errors.New("invalid link type"), errorx.Invalid)
+	verifier, err := service.verifiers.Get(link)
+	if err != nil {
+		return false, err
 	}
 
-	return joined, err
+	return verifier.Verify(ctx, user, link)
 }
 
-func (service *ServiceSocial) VerifyJoinTelegram(ctx context.Context, userID string, socialLink string) (bool, error) {
-	verify, err := redis_store.GetJoinSocial(ctx, service.redisDB, userID, socialLink)
+func (service *ServiceSocial) VerifyJoinTelegram(ctx context.Context, userID string, link *models.Link) (bool, error) {
+	verify, err := redis_store.GetJoinSocial(ctx, service.redisDB, userID, link.CompletionKey())
 	if err == nil {
 		return verify, nil
 	}
@@ -217,26 +348,21 @@ func (service *ServiceSocial) VerifyJoinTelegram(ctx context.Context, userID str
 		return false, err
 	}
 
-	matches := reTelegramLink.FindStringSubmatch(socialLink)
-	if len(matches) != 6 {
-		return false, errors.New("invalid subject")
+	slug, err := telegramChatSlug(link.Url)
+	if err != nil {
+		return false, err
 	}
-	slug := matches[5]
 
 	telegramUserChannel, err := service.apiUserChannel(ctx, userID, slug)
 	if err != nil || telegramUserChannel == nil {
-		return false, fmt.Errorf("%w: unable to get user channel (%d: %s)", err, userID, slug)
+		return false, fmt.Errorf("%w: unable to get user channel (%s: %s)", err, userID, slug)
 	}
 
-	if telegramUserChannel.Status != "member" &&
-		telegramUserChannel.Status != "admin" &&
-		telegramUserChannel.Status != "restricted" &&
-		telegramUserChannel.Status != "creator" &&
-		telegramUserChannel.Status != "administrator" {
+	if !IsTelegramMemberStatus(telegramUserChannel.Status) {
 		return false, nil
 	}
 
-	err = redis_store.SetJoinSocial(ctx, service.redisDB, userID, socialLink)
+	err = redis_store.SetJoinSocial(ctx, service.redisDB, userID, link.CompletionKey(), link.CompletionTTL(time.Now()))
 	if err != nil {
 		return false, err
 	}
@@ -248,12 +374,12 @@ func (service *ServiceSocial) IsJoinSocial(ctx context.Context, userID string, s
 	return redis_store.GetJoinSocial(ctx, service.redisDB, userID, socialLink)
 }
 
-func (service *ServiceSocial) VerifySocialLinkWithoutChecking(ctx context.Context, userID string, socialLink string) (bool, error) {
-	joined, _ := service.IsJoinSocial(ctx, userID, socialLink)
+func (service *ServiceSocial) VerifySocialLinkWithoutChecking(ctx context.Context, userID string, link *models.Link) (bool, error) {
+	joined, _ := service.IsJoinSocial(ctx, userID, link.CompletionKey())
 	if joined {
 		return true, nil
 	}
-	err := redis_store.SetJoinSocial(ctx, service.redisDB, userID, socialLink)
+	err := redis_store.SetJoinSocial(ctx, service.redisDB, userID, link.CompletionKey(), link.CompletionTTL(time.Now()))
 	if err != nil {
 		return false, err
 	}
@@ -278,6 +404,7 @@ func (service *ServiceSocial) GetSocialLink(ctx context.Context, linkID int, gam
 
 		for _, link := range task.Links {
 			if link.ID == linkID {
+				link.VerifierParams = task.VerifierParams[link.ID]
 				return &link, nil
 			}
 		}
@@ -289,68 +416,50 @@ func (service *ServiceSocial) GetSocialLink(ctx context.Context, linkID int, gam
 }
 
 func (service *ServiceSocial) apiUserChannel(ctx context.Context, userID string, channel string) (*TelegramUserChannel, error) {
-	resp, err := service.httpClient(0).Get(
-		fmt.Sprintf("%s/bot%s/getChatMember?chat_id=@%s&user_id=%d", service.baseURL, os.Getenv("BOT_TOKEN"), channel, userID),
-		http.Header{},
-	)
+	return getTelegramChatMember(ctx, service.httpClient(0), service.baseURL, channel, userID)
+}
+
+func getTelegramChatMember(ctx context.Context, client *httpclient.Client, baseURL string, channel string, userID string) (*TelegramUserChannel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/bot%s/getChatMember?chat_id=@%s&user_id=%s", baseURL, os.Getenv("BOT_TOKEN"), channel, userID), nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var body TelegramUserChannelResp
-	err = json.NewDecoder(resp.Body).Decode(&body)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	return body.Result, nil
-}
-
-func (service *ServiceSocial) VerifyAddedTeletop(ctx context.Context, userID string, url string) (bool, error) {
-	//getjoin social and set join social
-	verify, err := redis_store.GetJoinSocial(ctx, service.redisDB, userID, url)
-	if err == nil {
-		return verify, nil
-	}
-	if err != nil && err != redis.Nil {
-		return false, err
-	}
-
-	added, err := service.apiTeletopAddedTask(userID)
+	var body TelegramUserChannelResp
+	err = json.NewDecoder(resp.Body).Decode(&body)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	if added {
-		err = redis_store.SetJoinSocial(ctx, service.redisDB, userID, url)
-		if err != nil {
-			return false, err
-		}
-
-		return true, nil
+	if !body.OK && body.TelegramRespError != nil {
+		return nil, body.TelegramRespError
 	}
 
-	return false, nil
+	return body.Result, nil
 }
 
-func (service *ServiceSocial) apiTeletopAddedTask(userID string) (bool, error) {
-	resp, err := service.httpClient(0).Get(
-		fmt.Sprintf("https://api.teletop.xyz/users/%d/verify/%d", userID, TELETOP_CATIA_APP_ID),
-		http.Header{},
-	)
-	if err != nil {
-		return false, err
+// telegramChatSlug is the public username of the channel or group of a link
+func telegramChatSlug(socialLink string) (string, error) {
+	matches := reTelegramLink.FindStringSubmatch(socialLink)
+	if len(matches) != 6 {
+		return "", errors.New("invalid subject")
 	}
-	defer resp.Body.Close()
 
-	var body TeletopResponse
-	err = json.NewDecoder(resp.Body).Decode(&body)
-	if err != nil {
-		return false, err
-	}
+	return matches[5], nil
+}
 
-	return body.Success, nil
+func IsTelegramMemberStatus(status string) bool {
+	return status == "member" ||
+		status == "admin" ||
+		status == "restricted" ||
+		status == "creator" ||
+		status == "administrator"
 }
 
 var reTelegramLink = regexp.MustCompile(`^(?:|(https?:\/\/)?(|www)[.]?((t|telegram)\.me)\/)([a-zA-Z0-9_+-]+)$`)
@@ -361,6 +470,10 @@ type TelegramRespError struct {
 	Description string `json:"description"`
 }
 
+func (err *TelegramRespError) Error() string {
+	return fmt.Sprintf("telegram error %d: %s", err.ErrorCode, err.Description)
+}
+
 type TelegramUserChannelResp struct {
 	*TelegramRespError
 	OK     bool                 `json:"ok"`
@@ -377,7 +490,3 @@ type TelegramUserChannel struct {
 	} `json:"user"`
 	Status string `json:"status"`
 }
-
-type TeletopResponse struct {
-	Success bool `json:"success"`
-}
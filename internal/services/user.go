@@ -117,7 +117,38 @@ func (service *ServiceUser) FindOrCreateUser(ctx context.Context, userAuth *mode
 	if userAuth == nil {
 		return nil, errors.New("userAuth is nil")
 	}
-	user, _ := service.FindUserByID(ctx, userAuth.ID)
+
+	serviceIdentity, err := do.Invoke[*ServiceIdentity](service.container)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, hasIdentity, err := serviceIdentity.ResolveUserID(ctx, userAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	user, _ := service.FindUserByID(ctx, userID)
+
+	// merged accounts keep working with their old logins and tokens
+	if user != nil && user.MergedInto != nil {
+		user, err = service.FindUserByID(ctx, *user.MergedInto)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if user != nil && !hasIdentity {
+		_, err = serviceIdentity.AttachIdentity(ctx, user, userAuth.Provider, userAuth.ID, userAuth.Username)
+		if err != nil {
+			log.Println("AttachIdentity error:", err, "user:", user.ID, "provider:", userAuth.Provider)
+		}
+	}
+
+	// profile of a linked account follows the login it was created with
+	if user != nil && userAuth.Provider != "" && user.ID != userAuth.ID {
+		return user, nil
+	}
 
 	if user != nil {
 		if (user.Username != strings.ToLower(userAuth.Username)) ||
@@ -149,11 +180,18 @@ func (service *ServiceUser) FindOrCreateUser(ctx context.Context, userAuth *mode
 	}
 
 	log.Println("Create new user:", "user:", newUser.ID, "username:", newUser.Username)
-	user, err := datastore.CreateUser(ctx, service.postgresDB, newUser)
+	user, err = datastore.CreateUser(ctx, service.postgresDB, newUser)
 	if err != nil {
 		return nil, err
 	}
 
+	if userAuth.Provider != "" {
+		_, err = serviceIdentity.AttachIdentity(ctx, user, userAuth.Provider, userAuth.ID, userAuth.Username)
+		if err != nil {
+			log.Println("AttachIdentity error:", err, "user:", user.ID, "provider:", userAuth.Provider)
+		}
+	}
+
 	user.IsNewUser = true
 
 	//create new user freebie - 3 total
@@ -179,6 +217,21 @@ func (service *ServiceUser) FindUserByID(ctx context.Context, userID string) (*m
 	return caching.UseCacheWithRO(ctx, service.readonlyCache, service.cache, DBKeyUser(userID), CACHE_TTL_5_MINS, callback)
 }
 
+// FindUsersByIDs loads many users in a single query, keyed by user id
+func (service *ServiceUser) FindUsersByIDs(ctx context.Context, userIDs []string) (map[string]*models.User, error) {
+	users, err := datastore.FindUsersByIDs(ctx, service.readonlyPostgresDB, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]*models.User, len(users))
+	for _, user := range users {
+		results[user.ID] = user
+	}
+
+	return results, nil
+}
+
 func (service *ServiceUser) FindUserByIDNoCache(ctx context.Context, userID string) (*models.User, error) {
 	return datastore.FindUserByID(ctx, service.readonlyPostgresDB, userID)
 }
@@ -242,10 +295,7 @@ func (service *ServiceUser) AddReferenceCode(ctx context.Context, user *models.U
 	// 	}
 	// }()
 
-	_, err = redis_store.SetLeaderboard(ctx, service.redisDB, LEADERBOARD_REFERRAL, &models.LeaderboardItem{
-		UserId: inviter.ID,
-		Score:  float64(inviter.TotalInvites + 1),
-	})
+	// the invite is credited on the referral leaderboard once the validator accepts it
 
 	err = service.cache.Delete(ctx, DBKeyUser(user.ID))
 	if err != nil {
@@ -257,6 +307,10 @@ func (service *ServiceUser) AddReferenceCode(ctx context.Context, user *models.U
 		log.Println(err)
 	}
 
+	if servicePartnerWebhook, err := do.Invoke[*ServicePartnerWebhook](service.container); err == nil {
+		servicePartnerWebhook.EmitUserJoined(ctx, user, inviter.ID)
+	}
+
 	log.Println("AddReferenceCode updated:", "user:", user.ID, "username:", user.Username, "inviterID:", inviter.ID)
 
 	return err
@@ -322,8 +376,19 @@ func (service *ServiceUser) Me(ctx context.Context, user *models.User, refCode s
 				return me, nil
 			}
 
-			// refcode is userID
-			inviter, err := service.GetUserIdByRefCode(ctx, refCode)
+			// a KOL campaign code credits the campaign owner, any other refcode is a custom code or a userID
+			var campaign *models.KolCampaign
+			serviceKolCampaign, err := do.Invoke[*ServiceKolCampaign](service.container)
+			if err == nil {
+				campaign = serviceKolCampaign.FindActiveByRefCode(ctx, refCode)
+			}
+
+			var inviter *models.User
+			if campaign != nil {
+				inviter, _ = service.FindUserByID(ctx, campaign.OwnerID)
+			} else {
+				inviter, _ = service.GetUserIdByRefCode(ctx, refCode)
+			}
 
 			if inviter == nil {
 				log.Println("AddReferenceCode abort: cannot parse refcode", "user:", me.ID, "username:", me.Username, "refCode:", refCode)
@@ -333,6 +398,8 @@ func (service *ServiceUser) Me(ctx context.Context, user *models.User, refCode s
 			err = service.AddReferenceCode(ctx, me, inviter)
 			if err != nil {
 				log.Println("AddReferenceCode error:", err, "user:", me.ID, "username:", me.Username, "refCode:", refCode)
+			} else if campaign != nil {
+				serviceKolCampaign.Attribute(ctx, me, campaign, refCode)
 			}
 		} else if refCode != "" {
 			log.Println("AddReferenceCode abort: user is not new user", "user:", me.ID, "username:", me.Username, "refCode:", refCode)
@@ -359,12 +426,6 @@ func (service *ServiceUser) Me(ctx context.Context, user *models.User, refCode s
 
 	if rewards != nil {
 		me.AvailableRewards = rewards
-
-		// TODO claim all reward, used for aethir campaign only, remove later
-		for _, r := range rewards {
-			service.serviceReward.ClaimReward(ctx, r.ID)
-		}
-		service.serviceReward.ClearUserAvailableRewardCache(ctx, user.ID)
 	}
 	if me != nil && user.IsNewUser {
 		me.IsNewUser = user.IsNewUser
@@ -446,15 +507,14 @@ func (service *ServiceUser) ClaimUserBoost(ctx context.Context, source string, u
 	// 	return errors.New("invalid source")
 	// }
 
-	gem, err := service.GetUserGem(ctx, inviteeId)
-	if err != nil {
+	// the boost is released once the referral validator has seen enough activity from the friend
+	validation, err := datastore.GetReferralValidation(ctx, service.postgresDB, inviteeId)
+	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
 
-	minScore, _ := service.serviceConfig.GetIntConfig(ctx, CONFIG_MIN_GEM_TO_CLAIM_REF_BOOST, MIN_GEM_TO_CLAIM_REF_BOOST)
-
-	if gem < minScore {
-		return errors.New("Your friend must have at least 16 gems to claim the boost")
+	if validation == nil || validation.InviterID != userID || validation.Status != models.ReferralStatusValidated {
+		return errorx.Wrap(ErrReferralNotValidated, errorx.Invalid)
 	}
 
 	// check if user already have a boost